@@ -2,16 +2,28 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
 	"time"
 
+	"github.com/bluequbit/faas/deamon/executor"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -21,12 +33,38 @@ var (
 	baseURL string
 	// API key for authentication
 	apiKey string
+	// Output format for command results
+	outputFormat string
+	// Config profile selected with --profile
+	profileName string
+	// Selected profile that isn't in the config file, if any
+	missingProfile string
+)
+
+// Environment variables that set the API URL and key, taking precedence
+// over the config file but not over the flags
+const (
+	envAPIURL = "SKYSCALE_API_URL"
+	envAPIKey = "SKYSCALE_API_KEY"
+)
+
+// Build metadata, set with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "skyscale",
 	Short: "Skyscale - Serverless Function Management",
 	Long:  `Skyscale is a lightweight serverless function management platform powered by Firecracker`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if missingProfile != "" {
+			fmt.Printf("❌ Error: profile '%s' not found in config\n", missingProfile)
+			os.Exit(1)
+		}
+	},
 }
 
 func init() {
@@ -36,6 +74,8 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.skyscale.yaml)")
 	rootCmd.PersistentFlags().StringVar(&baseURL, "api-url", "http://localhost:8080", "API URL for the Skyscale control plane")
 	rootCmd.PersistentFlags().StringVar(&apiKey, "api-key", "", "API key for authentication")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "table", "Output format: table, json or yaml")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "config profile to use (default is the active profile set with 'config use')")
 
 	// Bind flags to viper config
 	viper.BindPFlag("api_url", rootCmd.PersistentFlags().Lookup("api-url"))
@@ -48,17 +88,68 @@ func init() {
 	rootCmd.AddCommand(logsCmd)
 	rootCmd.AddCommand(generateAPIKeyCmd)
 	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(whoamiCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(deleteCmd)
+	rootCmd.AddCommand(disableCmd)
+	rootCmd.AddCommand(enableCmd)
+	rootCmd.AddCommand(cloneCmd)
+	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(describeCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(vmsCmd)
+	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(purgeCmd)
+
+	configCmd.AddCommand(configUseCmd)
 
 	// Add flags for generate-api-key command
 	generateAPIKeyCmd.Flags().String("user-id", "cli-user", "User ID for the API key")
 	generateAPIKeyCmd.Flags().StringSlice("roles", []string{"user"}, "Roles for the API key")
 	generateAPIKeyCmd.Flags().Int64("expires-in", 86400, "Expiration time in seconds (default: 24 hours)")
 
+	initCmd.Flags().String("runtime", "python3.9", "Runtime for the function")
+	initCmd.Flags().Bool("with-lib", false, "Scaffold an example lib/ package")
+
+	deployCmd.Flags().Bool("skip-validation", false, "Skip the deploy-time entry point check")
+
+	deleteCmd.Flags().String("label", "", "Delete all functions matching a label selector (key=value[,key=value])")
+	deleteCmd.Flags().Bool("yes", false, "Skip the confirmation prompt for label deletes")
+	deleteCmd.Flags().Bool("by-id", false, "Treat the argument as a function ID instead of a name")
+
+	disableCmd.Flags().Bool("by-id", false, "Treat the argument as a function ID instead of a name")
+	enableCmd.Flags().Bool("by-id", false, "Treat the argument as a function ID instead of a name")
+	cloneCmd.Flags().Bool("by-id", false, "Treat the source argument as a function ID instead of a name")
+
 	invokeCmd.Flags().String("input", "", "JSON input for the function")
 	invokeCmd.Flags().String("input-file", "", "Path to a JSON file containing input for the function")
+	invokeCmd.Flags().Bool("async", false, "Queue the invocation and print where to fetch its result instead of waiting")
+	invokeCmd.Flags().Bool("by-id", false, "Treat the argument as a function ID instead of a name")
+	invokeCmd.Flags().Int("timeout", 0, "Override the function's timeout in seconds for this invocation")
+	invokeCmd.Flags().Bool("ignore-errors", false, "Exit 0 even when the function fails")
+	invokeCmd.Flags().StringToString("tag", nil, "Tag the execution with key=value pairs, e.g. --tag batch=xyz (repeatable)")
+
+	logsCmd.Flags().Bool("by-id", false, "Treat the argument as a function ID instead of a name")
+	logsCmd.Flags().String("since", "24h", "Only show executions started after this duration ago or RFC 3339 time; 0 shows all")
+	logsCmd.Flags().String("until", "", "Only show executions started before this duration ago or RFC 3339 time")
+
+	describeCmd.Flags().Bool("by-id", false, "Treat the argument as a function ID instead of a name")
+
+	runCmd.Flags().String("input", "", "JSON input for the function")
+	runCmd.Flags().String("input-file", "", "Path to a JSON file containing input for the function")
+	runCmd.Flags().Int("timeout", 30, "Execution timeout in seconds")
+
+	vmsCmd.Flags().Bool("verbose", false, "Also show the daemon version and runtimes of each VM")
+
+	purgeCmd.Flags().Bool("yes", false, "Skip the confirmation prompt")
 }
 
-// initConfig reads in config file and ENV variables if set
+// initConfig reads in config file and ENV variables if set. The API URL
+// and key come from, in order of precedence: the --api-url/--api-key flags,
+// the SKYSCALE_API_URL/SKYSCALE_API_KEY environment variables, the profile
+// selected with --profile or 'config use', the top-level keys of the config
+// file and the flag defaults.
 func initConfig() {
 	if cfgFile != "" {
 		// Use config file from the flag
@@ -76,41 +167,110 @@ func initConfig() {
 		viper.SetConfigName(".skyscale")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// Read in environment variables prefixed with SKYSCALE_
+	viper.SetEnvPrefix("skyscale")
+	viper.AutomaticEnv()
+	viper.BindEnv("api_url", envAPIURL)
+	viper.BindEnv("api_key", envAPIKey)
+
+	// Read in the config file if there is one. Viper resolves each key from
+	// the flag if it was given, then the environment, then the file and
+	// finally the flag's default.
+	viper.ReadInConfig()
+	baseURL = viper.GetString("api_url")
+	apiKey = viper.GetString("api_key")
+
+	profile := activeProfile()
+	if profile == "" {
+		return
+	}
+	if !viper.IsSet(profileKey(profile)) {
+		// Only config commands, which can create profiles, may run without it
+		missingProfile = profile
+		return
+	}
+	if !explicitlySet("api-url", envAPIURL) && viper.IsSet(profileKey(profile, "api_url")) {
+		baseURL = viper.GetString(profileKey(profile, "api_url"))
+	}
+	if !explicitlySet("api-key", envAPIKey) && viper.IsSet(profileKey(profile, "api_key")) {
+		apiKey = viper.GetString(profileKey(profile, "api_key"))
+	}
+}
 
-	// If a config file is found, read it in
-	if err := viper.ReadInConfig(); err == nil {
-		// Get values from config
-		if viper.IsSet("api_url") {
-			baseURL = viper.GetString("api_url")
-		}
-		if viper.IsSet("api_key") {
-			apiKey = viper.GetString("api_key")
-		}
+// explicitlySet reports whether a setting was given with its flag or its
+// environment variable, either of which overrides the profile
+func explicitlySet(flag, env string) bool {
+	return rootCmd.PersistentFlags().Changed(flag) || os.Getenv(env) != ""
+}
+
+// activeProfile returns the profile selected with --profile, or else the
+// one made active with 'config use'
+func activeProfile() string {
+	if profileName != "" {
+		return profileName
+	}
+	return viper.GetString("current_profile")
+}
+
+// profileKey returns the config key of a profile or one of its fields
+func profileKey(profile string, field ...string) string {
+	return strings.Join(append([]string{"profiles", profile}, field...), ".")
+}
+
+// configFilePath returns the config file to write: --config or $HOME/.skyscale.yaml
+func configFilePath() (string, error) {
+	if cfgFile != "" {
+		return cfgFile, nil
 	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".skyscale.yaml"), nil
+}
+
+// saveConfig sets the given keys in the config file, keeping its other
+// contents, and returns the file's path. Unlike the global viper instance it
+// never writes values that only came from flags.
+func saveConfig(values map[string]any) (string, error) {
+	configPath, err := configFilePath()
+	if err != nil {
+		return "", err
+	}
+
+	v := viper.New()
+	v.SetConfigFile(configPath)
+	if err := v.ReadInConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	for key, value := range values {
+		v.Set(key, value)
+	}
+	return configPath, v.WriteConfigAs(configPath)
 }
 
 // configCmd represents the config command
 var configCmd = &cobra.Command{
 	Use:   "config",
 	Short: "Manage Skyscale configuration",
-	Long:  `Configure API URL and authentication for Skyscale CLI.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		// Save configuration
-		home, err := homedir.Dir()
-		if err != nil {
-			fmt.Printf("❌ Error: %v\n", err)
-			os.Exit(1)
-		}
-
-		configPath := filepath.Join(home, ".skyscale.yaml")
+	Long: `Configure API URL and authentication for Skyscale CLI.
 
+With --profile the values are saved to that named profile instead, e.g. one
+each for dev, staging and prod; switch between them with 'config use'.`,
+	// Unlike other commands, config may name a profile that doesn't exist yet
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
 		// Set values
-		viper.Set("api_url", baseURL)
-		viper.Set("api_key", apiKey)
+		values := map[string]any{"api_url": baseURL, "api_key": apiKey}
+		if profileName != "" {
+			values = map[string]any{
+				profileKey(profileName, "api_url"): baseURL,
+				profileKey(profileName, "api_key"): apiKey,
+			}
+		}
 
 		// Write config file
-		err = viper.WriteConfigAs(configPath)
+		configPath, err := saveConfig(values)
 		if err != nil {
 			fmt.Printf("❌ Error saving config: %v\n", err)
 			os.Exit(1)
@@ -126,13 +286,42 @@ var configCmd = &cobra.Command{
 	},
 }
 
+// configUseCmd makes a profile the active one
+var configUseCmd = &cobra.Command{
+	Use:   "use [profile]",
+	Short: "Make a config profile the active one",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		profile := args[0]
+		if !viper.IsSet(profileKey(profile)) {
+			fmt.Printf("❌ Error: profile '%s' not found, create it with 'skyscale config --profile %s'\n", profile, profile)
+			os.Exit(1)
+		}
+
+		if _, err := saveConfig(map[string]any{"current_profile": profile}); err != nil {
+			fmt.Printf("❌ Error saving config: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("✅ Switched to profile '%s'\n", profile)
+	},
+}
+
 var initCmd = &cobra.Command{
 	Use:   "init [function_name]",
 	Short: "Initialize a new function project",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		functionName := args[0]
-		err := initializeFunction(functionName)
+		runtime, _ := cmd.Flags().GetString("runtime")
+		withLib, _ := cmd.Flags().GetBool("with-lib")
+
+		if err := validateRuntime(runtime); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		err := initializeFunction(functionName, runtime, withLib)
 		if err != nil {
 			fmt.Printf("❌ Error initializing function: %v\n", err)
 			os.Exit(1)
@@ -141,7 +330,40 @@ var initCmd = &cobra.Command{
 	},
 }
 
-func initializeFunction(functionName string) error {
+// validateRuntime checks the runtime against the runtimes supported by the
+// control plane. If the control plane can't be reached the check is skipped.
+func validateRuntime(runtime string) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(baseURL + "/api/runtimes")
+	if err != nil {
+		fmt.Printf("⚠️  Could not reach control plane to validate runtime: %v\n", err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("⚠️  Could not validate runtime, status: %s\n", resp.Status)
+		return nil
+	}
+
+	var runtimes []map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&runtimes); err != nil {
+		return fmt.Errorf("failed to parse runtimes: %v", err)
+	}
+
+	var names []string
+	for _, rt := range runtimes {
+		name, _ := rt["name"].(string)
+		if name == runtime {
+			return nil
+		}
+		names = append(names, name)
+	}
+
+	return fmt.Errorf("unsupported runtime %q, supported runtimes: %s", runtime, strings.Join(names, ", "))
+}
+
+func initializeFunction(functionName, runtime string, withLib bool) error {
 	// Define structure
 	dirs := []string{
 		functionName,
@@ -154,10 +376,25 @@ func initializeFunction(functionName string) error {
 `,
 		filepath.Join(functionName, "requirements.txt"): `# Add your dependencies here`,
 		filepath.Join(functionName, "skyscale.yaml"): `name: ` + functionName + `
-runtime: python3.9
+runtime: ` + runtime + `
 entrypoint: handler.handler`,
 	}
 
+	if withLib {
+		dirs = append(dirs, filepath.Join(functionName, "lib"))
+		files[filepath.Join(functionName, "handler.py")] = `from lib.helpers import greeting
+
+
+def handler(event, context):
+    """Skyscale function entry point"""
+    return {"message": greeting("` + functionName + `")}
+`
+		files[filepath.Join(functionName, "lib", "__init__.py")] = ``
+		files[filepath.Join(functionName, "lib", "helpers.py")] = `def greeting(name):
+    return "Hello from " + name + "!"
+`
+	}
+
 	// Create directories
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -181,7 +418,8 @@ var deployCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		functionName := args[0]
-		err := deployFunction(functionName)
+		skipValidation, _ := cmd.Flags().GetBool("skip-validation")
+		err := deployFunction(functionName, skipValidation)
 		if err != nil {
 			fmt.Printf("❌ Error deploying function: %v\n", err)
 			os.Exit(1)
@@ -192,6 +430,11 @@ var deployCmd = &cobra.Command{
 
 // makeAuthenticatedRequest makes an HTTP request with authentication headers
 func makeAuthenticatedRequest(method, url string, body []byte) (*http.Response, error) {
+	return makeAuthenticatedRequestWithContentType(method, url, "application/json", body)
+}
+
+// makeAuthenticatedRequestWithContentType makes an authenticated HTTP request with the given content type
+func makeAuthenticatedRequestWithContentType(method, url, contentType string, body []byte) (*http.Response, error) {
 	// Create a new request
 	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
 	if err != nil {
@@ -199,19 +442,44 @@ func makeAuthenticatedRequest(method, url string, body []byte) (*http.Response,
 	}
 
 	// Set headers
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
 
-	// Add authentication if API key is provided
+	return doAuthenticatedRequest(req)
+}
+
+// makeCompressedRequest makes an authenticated HTTP request with a
+// gzip-compressed body
+func makeCompressedRequest(method, url, contentType string, body []byte) (*http.Response, error) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, url, &compressed)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Encoding", "gzip")
+
+	return doAuthenticatedRequest(req)
+}
+
+// doAuthenticatedRequest adds the API key, if any, to req and sends it
+func doAuthenticatedRequest(req *http.Request) (*http.Response, error) {
 	if apiKey != "" {
 		req.Header.Set("Authorization", "Bearer "+apiKey)
 	}
 
-	// Make the request
 	client := &http.Client{}
 	return client.Do(req)
 }
 
-func deployFunction(functionName string) error {
+func deployFunction(functionName string, skipValidation bool) error {
 	// Define the function directory
 	functionDir := filepath.Join(functionName)
 	// Read the handler.py file
@@ -235,15 +503,46 @@ func deployFunction(functionName string) error {
 		return fmt.Errorf("failed to read skyscale.yaml: %v", err)
 	}
 
-	// Prepare the function data
+	spec, err := parseFunctionSpec(config)
+	if err != nil {
+		return err
+	}
+
+	// Prepare the function data. The runtime, entry point, memory and timeout
+	// are read from skyscale.yaml by the control plane.
 	data := map[string]any{
 		"name":         functionName,
-		"runtime":      "python3.9", // Default runtime, could be extracted from config
 		"code":         string(handlerCode),
 		"requirements": string(requirements),
 		"config":       string(config),
-		"memory":       256, // Default values
-		"timeout":      30,  // Default values
+	}
+	if len(spec.Labels) > 0 {
+		data["labels"] = spec.Labels
+	}
+	if spec.EntryPoint != "" {
+		data["entry_point"] = spec.EntryPoint
+	}
+	if spec.Scratch {
+		data["scratch"] = true
+	}
+	if spec.Cacheable {
+		data["cacheable"] = true
+		data["cache_ttl"] = spec.CacheTTL
+	}
+	if spec.LogDestination != "" {
+		data["log_destination"] = spec.LogDestination
+	}
+	if spec.Retry != nil {
+		data["retry"] = spec.Retry
+	}
+	if skipValidation {
+		data["skip_validation"] = true
+	}
+
+	// Collect helper modules and declared data files
+	extraFiles, err := collectFunctionFiles(functionDir, spec.Files)
+	if err != nil {
+		return err
 	}
 
 	// Convert data to JSON
@@ -252,13 +551,22 @@ func deployFunction(functionName string) error {
 		return err
 	}
 
-	// Send POST request to the server using the correct API endpoint with authentication
-	resp, err := makeAuthenticatedRequest("POST", baseURL+"/api/functions", jsonData)
+	var resp *http.Response
+	if len(extraFiles) == 0 {
+		// Send POST request to the server using the correct API endpoint with authentication
+		resp, err = makeCompressedRequest("POST", baseURL+"/api/functions", "application/json", jsonData)
+	} else {
+		resp, err = uploadFunction(jsonData, functionDir, extraFiles)
+	}
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("function '%s' already exists", functionName)
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		var errResponse map[string]any
 		if err := json.NewDecoder(resp.Body).Decode(&errResponse); err == nil {
@@ -272,215 +580,861 @@ func deployFunction(functionName string) error {
 	return nil
 }
 
-// InvokeRequest represents a request to invoke a function
-type InvokeRequest struct {
-	Input   map[string]interface{} `json:"input"`
-	Context map[string]interface{} `json:"context,omitempty"`
-	Sync    bool                   `json:"sync"`
+// defaultFunctionFiles are the files sent as dedicated fields rather than as extra files
+var defaultFunctionFiles = map[string]bool{
+	"handler.py":       true,
+	"requirements.txt": true,
+	"skyscale.yaml":    true,
 }
 
-var invokeCmd = &cobra.Command{
-	Use:   "invoke [function_name]",
-	Short: "Invoke a deployed function",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		functionName := args[0]
+// functionSpec holds the skyscale.yaml settings used by deploy
+type functionSpec struct {
+	Runtime    string            `yaml:"runtime"`
+	EntryPoint string            `yaml:"entrypoint"`
+	Files      []string          `yaml:"files"`
+	Labels     map[string]string `yaml:"labels"`
+	Scratch    bool              `yaml:"scratch"`
+	Cacheable  bool              `yaml:"cacheable"`
+	CacheTTL   int               `yaml:"cache_ttl"`
+	// LogDestination is an http(s) URL or file:// path the control plane
+	// forwards the function's stderr to
+	LogDestination string `yaml:"log_destination"`
+	// Retry is the retry policy of asynchronous invokes
+	Retry *retrySpec `yaml:"retry"`
+}
 
-		// Get input from flag or file
-		inputJSON, _ := cmd.Flags().GetString("input")
-		inputFile, _ := cmd.Flags().GetString("input-file")
+// retrySpec is the retry policy in skyscale.yaml
+type retrySpec struct {
+	MaxAttempts    int      `yaml:"max_attempts" json:"max_attempts"`
+	BackoffSeconds int      `yaml:"backoff_seconds" json:"backoff_seconds"`
+	RetryOn        []string `yaml:"retry_on" json:"retry_on,omitempty"`
+}
 
-		// Parse input data
-		input := map[string]any{}
+// parseFunctionSpec parses the contents of skyscale.yaml
+func parseFunctionSpec(config []byte) (*functionSpec, error) {
+	var spec functionSpec
+	if err := yaml.Unmarshal(config, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse skyscale.yaml: %v", err)
+	}
+	return &spec, nil
+}
 
-		if inputFile != "" {
-			// Read from file
-			data, err := os.ReadFile(inputFile)
-			if err != nil {
-				fmt.Printf("❌ Error reading input file: %v\n", err)
-				os.Exit(1)
-			}
+// collectFunctionFiles returns the slash-separated paths, relative to the
+// function directory, of every .py file and of the declared data files.
+// The default files are excluded.
+func collectFunctionFiles(functionDir string, declared []string) ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+	add := func(rel string) {
+		rel = filepath.ToSlash(rel)
+		if defaultFunctionFiles[rel] || seen[rel] {
+			return
+		}
+		seen[rel] = true
+		files = append(files, rel)
+	}
 
-			if err := json.Unmarshal(data, &input); err != nil {
-				fmt.Printf("❌ Error parsing input JSON from file: %v\n", err)
-				os.Exit(1)
-			}
-		} else if inputJSON != "" {
-			// Parse JSON string
-			if err := json.Unmarshal([]byte(inputJSON), &input); err != nil {
-				fmt.Printf("❌ Error parsing input JSON: %v\n", err)
-				os.Exit(1)
+	err := filepath.WalkDir(functionDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != functionDir && (strings.HasPrefix(name, ".") || name == "venv" || name == "__pycache__") {
+				return filepath.SkipDir
 			}
+			return nil
 		}
-
-		err := invokeFunction(functionName, input)
+		if filepath.Ext(path) != ".py" {
+			return nil
+		}
+		rel, err := filepath.Rel(functionDir, path)
 		if err != nil {
-			fmt.Printf("❌ Error invoking function: %v\n", err)
-			os.Exit(1)
+			return err
 		}
-	},
-}
-
-func invokeFunction(functionName string, input map[string]any) error {
-	// Prepare the invoke data with proper context
-	context := map[string]any{
-		"function_name": functionName,
-		"invoked_at":    time.Now().Format(time.RFC3339),
-		"client":        "skyscale-cli",
+		add(rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan function directory: %v", err)
 	}
 
-	req := InvokeRequest{
-		Input:   input,   // Use event instead of input
-		Context: context, // Add proper context
-		Sync:    true,    // Synchronous invocation
+	for _, pattern := range declared {
+		matches, err := filepath.Glob(filepath.Join(functionDir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid file pattern %q: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("declared file %q not found", pattern)
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(functionDir, match)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				return nil, fmt.Errorf("declared file %q is outside the function directory", pattern)
+			}
+			add(rel)
+		}
 	}
 
-	// Convert data to JSON
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
+	return files, nil
+}
 
-	// Send POST request to the invoke endpoint with authentication
-	resp, err := makeAuthenticatedRequest(
-		"POST",
-		baseURL+"/api/functions/name/"+functionName+"/invoke",
-		jsonData,
-	)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+// uploadFunction registers a function using the multipart upload endpoint,
+// sending the default files alongside the given extra files
+func uploadFunction(metadata []byte, functionDir string, extraFiles []string) (*http.Response, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
 
-	if resp.StatusCode != http.StatusOK {
-		var errResponse map[string]any
-		if err := json.NewDecoder(resp.Body).Decode(&errResponse); err == nil {
-			if errMsg, ok := errResponse["error"].(string); ok {
-				return fmt.Errorf("failed to invoke function: %s", errMsg)
-			}
-		}
-		return fmt.Errorf("failed to invoke function, status: %s", resp.Status)
+	if err := writer.WriteField("metadata", string(metadata)); err != nil {
+		return nil, err
 	}
 
-	// Parse and print the response
-	var result map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return fmt.Errorf("failed to parse response: %v", err)
+	for _, rel := range extraFiles {
+		content, err := os.ReadFile(filepath.Join(functionDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", rel, err)
+		}
+		part, err := writer.CreateFormFile("files/"+rel, rel)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(content); err != nil {
+			return nil, err
+		}
 	}
 
-	// Pretty print the result
-	fmt.Println("Function Result:")
-	outputJSON, err := json.MarshalIndent(result, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to format result: %v", err)
+	if err := writer.Close(); err != nil {
+		return nil, err
 	}
-	fmt.Println(string(outputJSON))
 
-	return nil
+	return makeCompressedRequest("POST", baseURL+"/api/functions/upload", writer.FormDataContentType(), body.Bytes())
 }
 
-var logsCmd = &cobra.Command{
-	Use:   "logs [function_name]",
-	Short: "Retrieve function logs",
-	Args:  cobra.ExactArgs(1),
+var deleteCmd = &cobra.Command{
+	Use:   "delete [function_name]",
+	Short: "Delete a function, or all functions matching --label",
+	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		functionName := args[0]
-		err := getLogs(functionName)
+		label, _ := cmd.Flags().GetString("label")
+		yes, _ := cmd.Flags().GetBool("yes")
+		byID, _ := cmd.Flags().GetBool("by-id")
+
+		if (label == "") == (len(args) == 0) {
+			fmt.Println("❌ Error: specify either a function name or --label")
+			os.Exit(1)
+		}
+
+		if label == "" {
+			if err := deleteFunction(args[0], byID); err != nil {
+				fmt.Printf("❌ Error deleting function: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("✅ Function '%s' deleted successfully.\n", args[0])
+			return
+		}
+
+		if !yes {
+			fmt.Printf("Delete all functions matching '%s'? [y/N]: ", label)
+			var answer string
+			fmt.Scanln(&answer)
+			if answer != "y" && answer != "Y" {
+				fmt.Println("Aborted.")
+				return
+			}
+		}
+
+		deleted, err := deleteFunctionsByLabel(label)
 		if err != nil {
-			fmt.Printf("❌ Error retrieving logs: %v\n", err)
+			fmt.Printf("❌ Error deleting functions: %v\n", err)
 			os.Exit(1)
 		}
+		fmt.Printf("✅ Deleted %d function(s).\n", len(deleted))
+		for _, id := range deleted {
+			fmt.Printf("  %s\n", id)
+		}
 	},
 }
 
-func getLogs(functionName string) error {
-	// First, get the function ID by name
-	req, err := http.NewRequest("GET", baseURL+"/api/functions/name/"+functionName, nil)
+func deleteFunction(function string, byID bool) error {
+	functionID, err := resolveFunctionID(function, byID)
 	if err != nil {
 		return err
 	}
 
-	// Add authentication if API key is provided
-	if apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-	}
-
-	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := makeAuthenticatedRequest("DELETE", baseURL+"/api/functions/"+url.PathEscape(functionID), nil)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("function not found: %s", resp.Status)
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete function: %s", strings.TrimSpace(string(body)))
 	}
 
-	var function map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&function); err != nil {
-		return fmt.Errorf("failed to parse response: %v", err)
-	}
+	return nil
+}
 
-	functionID, ok := function["id"].(string)
-	if !ok {
-		return fmt.Errorf("invalid function response, missing ID")
-	}
+func deleteFunctionsByLabel(selector string) ([]string, error) {
+	query := url.Values{}
+	query.Set("label", selector)
+	query.Set("confirm", "true")
 
-	// Then, get the executions for that function with authentication
-	req, err = http.NewRequest("GET", baseURL+"/api/executions/function/"+functionID, nil)
+	resp, err := makeAuthenticatedRequest("DELETE", baseURL+"/api/functions?"+query.Encode(), nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	// Add authentication if API key is provided
-	if apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+apiKey)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(body)))
 	}
 
-	// Make the request
-	resp, err = client.Do(req)
-	if err != nil {
-		return err
+	var result struct {
+		Deleted []string `json:"deleted"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	return result.Deleted, nil
+}
+
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete all functions, executions and VMs (test and dev mode control planes only)",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		yes, _ := cmd.Flags().GetBool("yes")
+		if !yes {
+			fmt.Print("Delete ALL functions, executions and VMs on " + baseURL + "? [y/N]: ")
+			var answer string
+			fmt.Scanln(&answer)
+			if answer != "y" && answer != "Y" {
+				fmt.Println("Aborted.")
+				return
+			}
+		}
+
+		resp, err := makeAuthenticatedRequest("POST", baseURL+"/api/admin/purge?confirm=true", nil)
+		if err != nil {
+			fmt.Printf("❌ Error purging: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			fmt.Printf("❌ Error purging: %s\n", strings.TrimSpace(string(body)))
+			os.Exit(1)
+		}
+
+		var result map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fmt.Printf("❌ Error: failed to parse response: %v\n", err)
+			os.Exit(1)
+		}
+		err = printOutput(result, func(w io.Writer) {
+			fmt.Fprintln(w, "✅ Purged all state.")
+			printFields(w, result, "terminated_vms", "functions", "executions", "vms", "schedules", "artifacts", "dead_letters")
+		})
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var disableCmd = &cobra.Command{
+	Use:   "disable [function_name]",
+	Short: "Stop a function from being invoked without deleting it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		byID, _ := cmd.Flags().GetBool("by-id")
+		if err := setFunctionDisabled(args[0], byID, true); err != nil {
+			fmt.Printf("❌ Error disabling function: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Function '%s' disabled.\n", args[0])
+	},
+}
+
+var enableCmd = &cobra.Command{
+	Use:   "enable [function_name]",
+	Short: "Allow a disabled function to be invoked again",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		byID, _ := cmd.Flags().GetBool("by-id")
+		if err := setFunctionDisabled(args[0], byID, false); err != nil {
+			fmt.Printf("❌ Error enabling function: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Function '%s' enabled.\n", args[0])
+	},
+}
+
+// setFunctionDisabled disables or re-enables invokes of a function
+func setFunctionDisabled(function string, byID, disabled bool) error {
+	functionID, err := resolveFunctionID(function, byID)
+	if err != nil {
+		return err
+	}
+
+	action := "enable"
+	if disabled {
+		action = "disable"
+	}
+	resp, err := makeAuthenticatedRequest("POST", baseURL+"/api/functions/"+url.PathEscape(functionID)+"/"+action, nil)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to retrieve logs: %s", resp.Status)
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to %s function: %s", action, strings.TrimSpace(string(body)))
 	}
 
-	var executions []map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&executions); err != nil {
+	return nil
+}
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone [source_function] [new_function]",
+	Short: "Create a new function with the code and settings of an existing one",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		byID, _ := cmd.Flags().GetBool("by-id")
+		function, err := cloneFunction(args[0], args[1], byID)
+		if err != nil {
+			fmt.Printf("❌ Error cloning function: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Function '%s' cloned to '%s' (ID: %v).\n", args[0], args[1], function["id"])
+	},
+}
+
+// cloneFunction clones a function under a new name and returns the new
+// function's metadata
+func cloneFunction(source, name string, byID bool) (map[string]any, error) {
+	functionID, err := resolveFunctionID(source, byID)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := makeAuthenticatedRequest("POST", baseURL+"/api/functions/"+url.PathEscape(functionID)+"/clone", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to clone function: %s", strings.TrimSpace(string(body)))
+	}
+
+	var function map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&function); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+	return function, nil
+}
+
+// InvokeRequest represents a request to invoke a function
+type InvokeRequest struct {
+	Input   map[string]interface{} `json:"input"`
+	Context map[string]interface{} `json:"context,omitempty"`
+	Sync    bool                   `json:"sync"`
+	Timeout int                    `json:"timeout,omitempty"`
+	Tags    map[string]string      `json:"tags,omitempty"`
+}
+
+var invokeCmd = &cobra.Command{
+	Use:   "invoke [function_name]",
+	Short: "Invoke a deployed function",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functionName := args[0]
+
+		input, err := readInput(cmd)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		async, _ := cmd.Flags().GetBool("async")
+		byID, _ := cmd.Flags().GetBool("by-id")
+		timeout, _ := cmd.Flags().GetInt("timeout")
+		ignoreErrors, _ := cmd.Flags().GetBool("ignore-errors")
+		tags, _ := cmd.Flags().GetStringToString("tag")
+		err = invokeFunction(functionName, input, async, byID, timeout, tags)
+		if errors.Is(err, errFunctionFailed) {
+			if ignoreErrors {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(exitFunctionFailed)
+		}
+		if err != nil {
+			fmt.Printf("❌ Error invoking function: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// errFunctionFailed is returned by invokeFunction when the invocation went
+// through but the function itself failed
+var errFunctionFailed = errors.New("function failed")
+
+// exitFunctionFailed is the exit status of invoke when the function failed,
+// telling it apart from an invocation that couldn't be made (status 1)
+const exitFunctionFailed = 2
+
+// readInput parses the function input from the --input or --input-file flag
+func readInput(cmd *cobra.Command) (map[string]any, error) {
+	// Get input from flag or file
+	inputJSON, _ := cmd.Flags().GetString("input")
+	inputFile, _ := cmd.Flags().GetString("input-file")
+
+	// Parse input data
+	input := map[string]any{}
+
+	if inputFile != "" {
+		// Read from file
+		data, err := os.ReadFile(inputFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input file: %v", err)
+		}
+
+		if err := json.Unmarshal(data, &input); err != nil {
+			return nil, fmt.Errorf("failed to parse input JSON from file: %v", err)
+		}
+	} else if inputJSON != "" {
+		// Parse JSON string
+		if err := json.Unmarshal([]byte(inputJSON), &input); err != nil {
+			return nil, fmt.Errorf("failed to parse input JSON: %v", err)
+		}
+	}
+
+	return input, nil
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run [function_name]",
+	Short: "Run a function locally without deploying it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functionName := args[0]
+
+		input, err := readInput(cmd)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		timeout, _ := cmd.Flags().GetInt("timeout")
+		if err := runLocally(functionName, input, timeout); err != nil {
+			fmt.Printf("❌ Error running function: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// runLocally executes a function from its project directory in a local
+// subprocess, using the same executor as the daemon inside each VM
+func runLocally(functionName string, input map[string]any, timeout int) error {
+	functionDir := filepath.Join(functionName)
+
+	handlerCode, err := os.ReadFile(filepath.Join(functionDir, "handler.py"))
+	if err != nil {
+		return fmt.Errorf("failed to read handler.py: %v", err)
+	}
+	requirements, err := os.ReadFile(filepath.Join(functionDir, "requirements.txt"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read requirements.txt: %v", err)
+	}
+	config, err := os.ReadFile(filepath.Join(functionDir, "skyscale.yaml"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read skyscale.yaml: %v", err)
+	}
+
+	spec, err := parseFunctionSpec(config)
+	if err != nil {
+		return err
+	}
+
+	extraFiles, err := collectFunctionFiles(functionDir, spec.Files)
+	if err != nil {
+		return err
+	}
+	files := make(map[string]string, len(extraFiles))
+	for _, rel := range extraFiles {
+		content, err := os.ReadFile(filepath.Join(functionDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", rel, err)
+		}
+		files[rel] = string(content)
+	}
+
+	runtime := spec.Runtime
+	if runtime == "" {
+		runtime = "python3"
+	}
+
+	requestID := fmt.Sprintf("local-%d", time.Now().UnixNano())
+	payload := &executor.FunctionPayload{
+		FunctionID:   "local",
+		Name:         functionName,
+		Code:         string(handlerCode),
+		Requirements: string(requirements),
+		Config:       string(config),
+		Files:        files,
+		Runtime:      runtime,
+		EntryPoint:   spec.EntryPoint,
+		Scratch:      spec.Scratch,
+		RequestID:    requestID,
+		Timeout:      timeout,
+		Version:      "local",
+		Event:        input,
+		Context: map[string]any{
+			"function_name":     functionName,
+			"function_version":  "local",
+			"request_id":        requestID,
+			"remaining_time_ms": timeout * 1000,
+		},
+	}
+
+	baseDir := filepath.Join(os.TempDir(), "skyscale-run")
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return fmt.Errorf("failed to create working directory: %v", err)
+	}
+
+	// Stream the function's stderr as its logs and keep executor chatter quiet
+	runner := executor.New(baseDir, executor.DefaultMaxOutputBytes)
+	runner.Stderr = os.Stderr
+	runner.Logger = log.New(io.Discard, "", 0)
+
+	result := runner.Execute(payload)
+
+	fmt.Printf("Duration: %d ms\n", result.Duration)
+	if len(result.Output) > 0 {
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, result.Output, "", "  "); err == nil {
+			fmt.Printf("Output:\n%s\n", pretty.String())
+		} else {
+			fmt.Printf("Output: %s\n", result.Output)
+		}
+	}
+	if result.Truncated {
+		fmt.Println("⚠️  Output was truncated")
+	}
+	if result.ErrorMessage != "" {
+		return fmt.Errorf("%s", result.ErrorMessage)
+	}
+
+	return nil
+}
+
+// describeErrorType explains an execution error category reported by the control plane
+func describeErrorType(errorType string) string {
+	switch errorType {
+	case "setup_error":
+		return "the function's requirements could not be installed, check requirements.txt"
+	case "import_error":
+		return "the handler module or one of its imports could not be loaded, check requirements.txt"
+	case "exception":
+		return "the handler raised an uncaught exception"
+	case "serialization_error":
+		return "the handler returned a value that can't be encoded as JSON"
+	case "oom":
+		return "the function ran out of memory, check its memory limit"
+	case "timeout":
+		return "the function ran longer than its timeout"
+	case "exit":
+		return "the function process exited unexpectedly"
+	default:
+		return "the execution failed"
+	}
+}
+
+func invokeFunction(function string, input map[string]any, async, byID bool, timeout int, tags map[string]string) error {
+	// Prepare the invoke data with proper context
+	context := map[string]any{
+		"invoked_at": time.Now().Format(time.RFC3339),
+		"client":     "skyscale-cli",
+	}
+	if byID {
+		context["function_id"] = function
+	} else {
+		context["function_name"] = function
+	}
+
+	req := InvokeRequest{
+		Input:   input,   // Use event instead of input
+		Context: context, // Add proper context
+		Sync:    !async,
+		Timeout: timeout,
+		Tags:    tags,
+	}
+
+	// Convert data to JSON
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	// Send POST request to the invoke endpoint with authentication
+	resp, err := makeAuthenticatedRequest(
+		"POST",
+		baseURL+functionPath(function, byID)+"/invoke",
+		jsonData,
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The invoke deadline passed before the function finished
+	if resp.StatusCode == http.StatusGatewayTimeout {
+		var result map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if err := resultError(result); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("%w: timed out", errFunctionFailed)
+	}
+
+	// The platform couldn't run the function. Invokes that failed before
+	// the execution started report "error", failed executions
+	// "error_message".
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		var errResponse map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&errResponse); err == nil {
+			if errMsg, ok := errResponse["error"].(string); ok {
+				return fmt.Errorf("failed to invoke function: %s", errMsg)
+			}
+			if errMsg, ok := errResponse["error_message"].(string); ok {
+				return fmt.Errorf("failed to invoke function: %s", errMsg)
+			}
+		}
+		return fmt.Errorf("failed to invoke function, status: %s", resp.Status)
+	}
+
+	if resp.StatusCode == http.StatusAccepted {
+		var accepted map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&accepted); err != nil {
+			return fmt.Errorf("failed to parse response: %v", err)
+		}
+		fmt.Printf("✅ Invocation queued (request ID: %v)\n", accepted["request_id"])
+		fmt.Printf("Result: %s%s\n", baseURL, resp.Header.Get("Location"))
+		return nil
+	}
+
+	// Parse and print the response
+	var result map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return fmt.Errorf("failed to parse response: %v", err)
 	}
 
-	if len(executions) == 0 {
-		fmt.Println("No executions found for this function.")
+	// Pretty print the result
+	err = printOutput(result, func(w io.Writer) {
+		fmt.Fprintln(w, "Function Result:")
+		outputJSON, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Fprintln(w, string(outputJSON))
+	})
+	if err != nil {
+		return err
+	}
+
+	return resultError(result)
+}
+
+// resultError returns an error wrapping errFunctionFailed when a synchronous
+// result reports that the function failed, explaining categorized failures
+func resultError(result map[string]any) error {
+	message, _ := result["error_message"].(string)
+	statusCode, _ := result["status_code"].(float64)
+	if message == "" && (statusCode == 0 || statusCode == http.StatusOK) {
 		return nil
 	}
+	if message == "" {
+		message = fmt.Sprintf("status code %v", statusCode)
+	}
 
-	// Display the logs
-	fmt.Printf("Logs for function '%s':\n\n", functionName)
-	for i, execution := range executions {
-		requestID, _ := execution["request_id"].(string)
-		statusCode, _ := execution["status_code"].(float64)
-		output, _ := execution["output"].(string)
-		errorMsg, _ := execution["error_message"].(string)
-		duration, _ := execution["duration_ms"].(float64)
+	if errorType, _ := result["error_type"].(string); errorType != "" {
+		return fmt.Errorf("%w (%s): %s: %s", errFunctionFailed, errorType, describeErrorType(errorType), message)
+	}
+	return fmt.Errorf("%w: %s", errFunctionFailed, message)
+}
 
-		fmt.Printf("Execution #%d (ID: %s)\n", i+1, requestID)
-		fmt.Printf("Status: %d\n", int(statusCode))
-		fmt.Printf("Duration: %.2f ms\n", duration)
+var logsCmd = &cobra.Command{
+	Use:   "logs [function_name]",
+	Short: "Retrieve function logs",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		functionName := args[0]
+		byID, _ := cmd.Flags().GetBool("by-id")
+		sinceFlag, _ := cmd.Flags().GetString("since")
+		untilFlag, _ := cmd.Flags().GetString("until")
 
-		if errorMsg != "" {
-			fmt.Printf("Error: %s\n", errorMsg)
+		now := time.Now()
+		since, err := parseTimeFlag(sinceFlag, now)
+		if err != nil {
+			fmt.Printf("❌ Error: invalid --since: %v\n", err)
+			os.Exit(1)
+		}
+		until, err := parseTimeFlag(untilFlag, now)
+		if err != nil {
+			fmt.Printf("❌ Error: invalid --until: %v\n", err)
+			os.Exit(1)
+		}
+
+		err = getLogs(functionName, byID, since, until)
+		if err != nil {
+			fmt.Printf("❌ Error retrieving logs: %v\n", err)
+			os.Exit(1)
 		}
+	},
+}
 
-		fmt.Printf("Output:\n%s\n\n", output)
-		fmt.Println("---")
+// parseTimeFlag parses a --since/--until value, either a duration before now
+// or an RFC 3339 time. Empty and "0" mean no bound and return the zero time.
+func parseTimeFlag(value string, now time.Time) (time.Time, error) {
+	if value == "" || value == "0" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
 	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a duration such as 1h or an RFC 3339 time, got %q", value)
+	}
+	return t, nil
+}
 
-	return nil
+// functionPath returns the API path of a function given its name or, with
+// byID, its ID
+func functionPath(function string, byID bool) string {
+	if byID {
+		return "/api/functions/" + url.PathEscape(function)
+	}
+	return "/api/functions/name/" + url.PathEscape(function)
+}
+
+// resolveFunctionID returns the ID of a function given its name or, with
+// byID, the ID itself without a lookup
+func resolveFunctionID(function string, byID bool) (string, error) {
+	if byID {
+		return function, nil
+	}
+	return getFunctionID(function)
+}
+
+// getFunctionID looks up the ID of a function by name
+func getFunctionID(functionName string) (string, error) {
+	resp, err := makeAuthenticatedRequest("GET", baseURL+"/api/functions/name/"+functionName, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("function not found: %s", resp.Status)
+	}
+
+	var function map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&function); err != nil {
+		return "", fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	functionID, ok := function["id"].(string)
+	if !ok {
+		return "", fmt.Errorf("invalid function response, missing ID")
+	}
+
+	return functionID, nil
+}
+
+func getLogs(functionName string, byID bool, since, until time.Time) error {
+	// First, get the function ID by name
+	functionID, err := resolveFunctionID(functionName, byID)
+	if err != nil {
+		return err
+	}
+
+	// Then, get the executions for that function within the window
+	query := url.Values{}
+	if !since.IsZero() {
+		query.Set("since", since.UTC().Format(time.RFC3339))
+	}
+	if !until.IsZero() {
+		query.Set("until", until.UTC().Format(time.RFC3339))
+	}
+	listURL := baseURL + "/api/executions/function/" + url.PathEscape(functionID)
+	if len(query) > 0 {
+		listURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", listURL, nil)
+	if err != nil {
+		return err
+	}
+
+	// Add authentication if API key is provided
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	// Make the request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to retrieve logs: %s", resp.Status)
+	}
+
+	var executions []map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&executions); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	return printOutput(executions, func(w io.Writer) {
+		if len(executions) == 0 {
+			fmt.Fprintln(w, "No executions found for this function.")
+			return
+		}
+
+		// Display the logs
+		fmt.Fprintf(w, "Logs for function '%s':\n\n", functionName)
+		for i, execution := range executions {
+			fmt.Fprintf(w, "Execution #%d (ID: %v)\n", i+1, execution["ID"])
+			fmt.Fprintf(w, "Status: %v\n", execution["Status"])
+			fmt.Fprintf(w, "Duration: %v ms\n", execution["Duration"])
+			if reason, _ := execution["ReasonCode"].(string); reason != "" {
+				fmt.Fprintf(w, "Reason: %s\n", reason)
+			}
+
+			if errorMsg, _ := execution["Error"].(string); errorMsg != "" {
+				if errorType, _ := execution["ErrorType"].(string); errorType != "" {
+					fmt.Fprintf(w, "Error (%s): %s\n", errorType, errorMsg)
+				} else {
+					fmt.Fprintf(w, "Error: %s\n", errorMsg)
+				}
+			}
+
+			fmt.Fprintf(w, "Output:\n%v\n\n", execution["Logs"])
+			fmt.Fprintln(w, "---")
+		}
+	})
 }
 
 var generateAPIKeyCmd = &cobra.Command{
@@ -546,6 +1500,471 @@ func generateAPIKey(userID string, roles []string, expiresIn int64) (string, err
 	return result["api_key"].(string), nil
 }
 
+// printOutput writes an API object in the selected output format. JSON and
+// YAML emit the object as returned by the API; table calls render to print
+// the human-readable form.
+func printOutput(v any, render func(w io.Writer)) error {
+	switch outputFormat {
+	case "table", "":
+		render(os.Stdout)
+		return nil
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("unsupported output format %q, use table, json or yaml", outputFormat)
+	}
+}
+
+// getJSON sends an authenticated GET request to path and decodes the JSON response into v
+func getJSON(path string, v any) error {
+	resp, err := makeAuthenticatedRequest("GET", baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request failed with status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+	return nil
+}
+
+// printFields prints the given keys of an object as aligned "key: value" lines, skipping missing keys
+func printFields(w io.Writer, object map[string]any, keys ...string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, key := range keys {
+		if value, ok := object[key]; ok && value != nil && value != "" {
+			fmt.Fprintf(tw, "%s:\t%v\n", key, value)
+		}
+	}
+	tw.Flush()
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List deployed functions",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var functions []map[string]any
+		if err := getJSON("/api/functions", &functions); err != nil {
+			fmt.Printf("❌ Error listing functions: %v\n", err)
+			os.Exit(1)
+		}
+
+		err := printOutput(functions, func(w io.Writer) {
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tRUNTIME\tVERSION\tSTATUS\tID")
+			for _, f := range functions {
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", f["name"], f["runtime"], f["version"], f["status"], f["id"])
+			}
+			tw.Flush()
+		})
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var describeCmd = &cobra.Command{
+	Use:   "describe [function_name]",
+	Short: "Show details of a deployed function",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		byID, _ := cmd.Flags().GetBool("by-id")
+		var function map[string]any
+		if err := getJSON(functionPath(args[0], byID), &function); err != nil {
+			fmt.Printf("❌ Error describing function: %v\n", err)
+			os.Exit(1)
+		}
+
+		// A function that was never invoked has no last execution
+		var last map[string]any
+		if getJSON(fmt.Sprintf("/api/functions/%v/last-execution", function["id"]), &last) == nil {
+			function["last_execution"] = last
+		}
+
+		err := printOutput(function, func(w io.Writer) {
+			printFields(w, function, "name", "id", "runtime", "entry_point", "version", "status", "memory", "effective_memory", "cpu", "timeout", "scratch", "cacheable", "cache_ttl", "log_destination", "retry", "created_at", "updated_at", "labels", "environment")
+			if last != nil {
+				fmt.Fprintln(w, "\nLast execution:")
+				printFields(w, last, "ID", "Status", "StartTime", "Duration", "ErrorType", "Error")
+			}
+			if function["status"] == "corrupt" {
+				fmt.Fprintln(w, "\n⚠️  The stored code of this function is incomplete; update it with new code, or delete and deploy it again")
+			}
+		})
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status [execution_id]",
+	Short: "Show the status of an execution",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var execution map[string]any
+		if err := getJSON("/api/executions/"+args[0], &execution); err != nil {
+			fmt.Printf("❌ Error getting execution status: %v\n", err)
+			os.Exit(1)
+		}
+
+		err := printOutput(execution, func(w io.Writer) {
+			printFields(w, execution, "ID", "FunctionID", "Version", "Status", "QueuedAt", "StartTime", "EndTime", "Duration", "ErrorType", "ReasonCode", "Error", "Logs")
+		})
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var vmsCmd = &cobra.Command{
+	Use:   "vms",
+	Short: "List VMs",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		verbose, _ := cmd.Flags().GetBool("verbose")
+
+		var vms []map[string]any
+		if err := getJSON("/api/vms", &vms); err != nil {
+			fmt.Printf("❌ Error listing VMs: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Daemon info is fetched per VM; an unreachable daemon doesn't fail the listing
+		if verbose {
+			for _, vm := range vms {
+				var info map[string]any
+				if err := getJSON(fmt.Sprintf("/api/vms/%v/info", vm["ID"]), &info); err != nil {
+					vm["daemon_error"] = err.Error()
+					continue
+				}
+				vm["daemon_info"] = info
+			}
+		}
+
+		err := printOutput(vms, func(w io.Writer) {
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			if verbose {
+				fmt.Fprintln(tw, "ID\tSTATUS\tIP\tWARM\tHOST\tDAEMON\tRUNTIMES")
+			} else {
+				fmt.Fprintln(tw, "ID\tSTATUS\tIP\tWARM\tHOST")
+			}
+			for _, vm := range vms {
+				host := vm["HostID"]
+				if host == nil || host == "" {
+					host = "local"
+				}
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v", vm["ID"], vm["Status"], vm["IP"], vm["IsWarm"], host)
+				if verbose {
+					daemon, runtimes := "unreachable", "-"
+					if info, ok := vm["daemon_info"].(map[string]any); ok {
+						daemon = fmt.Sprint(info["version"])
+						if list, ok := info["runtimes"].([]any); ok {
+							names := make([]string, len(list))
+							for i, name := range list {
+								names[i] = fmt.Sprint(name)
+							}
+							runtimes = strings.Join(names, ",")
+						}
+					}
+					fmt.Fprintf(tw, "\t%s\t%s", daemon, runtimes)
+				}
+				fmt.Fprintln(tw)
+			}
+			tw.Flush()
+		})
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Show the CLI version and the version of the control plane",
+	Run: func(cmd *cobra.Command, args []string) {
+		info := map[string]any{
+			"client": map[string]any{"version": version, "commit": commit, "build_date": buildDate},
+		}
+
+		var server map[string]any
+		serverErr := getJSON("/api/version", &server)
+		if serverErr == nil {
+			info["server"] = server
+		} else {
+			info["server_error"] = serverErr.Error()
+		}
+
+		err := printOutput(info, func(w io.Writer) {
+			fmt.Fprintf(w, "Client: %s\n", describeBuild(info["client"]))
+			if serverErr != nil {
+				fmt.Fprintf(w, "Server: unavailable (%v)\n", serverErr)
+				return
+			}
+			fmt.Fprintf(w, "Server: %s\n", describeBuild(server["control_plane"]))
+			if daemon, ok := server["daemon"]; ok {
+				fmt.Fprintf(w, "Daemon: %s\n", describeBuild(daemon))
+			} else {
+				fmt.Fprintf(w, "Daemon: unavailable (%v)\n", server["daemon_error"])
+			}
+		})
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// describeBuild formats a build info object as "version (commit, built date)"
+func describeBuild(v any) string {
+	build, _ := v.(map[string]any)
+	description := fmt.Sprint(build["version"])
+	var details []string
+	if commit, _ := build["commit"].(string); commit != "" {
+		details = append(details, commit)
+	}
+	if date, _ := build["build_date"].(string); date != "" {
+		details = append(details, "built "+date)
+	}
+	if len(details) > 0 {
+		description += " (" + strings.Join(details, ", ") + ")"
+	}
+	return description
+}
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show the identity of the configured API key",
+	Run: func(cmd *cobra.Command, args []string) {
+		identity, err := whoami()
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("✅ Authenticated as %v\n", identity["user_id"])
+		if roles, ok := identity["roles"].([]any); ok && len(roles) > 0 {
+			names := make([]string, len(roles))
+			for i, role := range roles {
+				names[i] = fmt.Sprint(role)
+			}
+			fmt.Printf("Roles: %s\n", strings.Join(names, ", "))
+		}
+		if expiresAt, ok := identity["expires_at"].(string); ok {
+			fmt.Printf("Expires at: %s\n", expiresAt)
+		}
+	},
+}
+
+func whoami() (map[string]any, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("no API key configured, set one with --api-key or 'skyscale config'")
+	}
+
+	resp, err := makeAuthenticatedRequest("GET", baseURL+"/api/auth/whoami", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API key rejected: %s", strings.TrimSpace(string(body)))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to validate API key, status: %s", resp.Status)
+	}
+
+	var identity map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&identity); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	return identity, nil
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the CLI configuration and the health of the control plane",
+	Long: `Check that the control plane at the configured API URL is reachable, that
+the API key is accepted, and that the control plane is ready to run functions,
+with a hint on how to fix each failing check`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		checks := runDoctor()
+
+		err := printOutput(checks, func(w io.Writer) {
+			for _, check := range checks {
+				fmt.Fprintf(w, "%s %s", doctorSymbols[check.Status], check.Name)
+				if check.Detail != "" {
+					fmt.Fprintf(w, ": %s", check.Detail)
+				}
+				fmt.Fprintln(w)
+				if check.Hint != "" && check.Status != doctorPass {
+					fmt.Fprintf(w, "   → %s\n", check.Hint)
+				}
+			}
+		})
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, check := range checks {
+			if check.Status == doctorFail {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+// Outcomes of a doctor check. Warnings are failed checks the control plane
+// can run without.
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+	doctorSkip = "skip"
+)
+
+var doctorSymbols = map[string]string{
+	doctorPass: "✅",
+	doctorWarn: "⚠️ ",
+	doctorFail: "❌",
+	doctorSkip: "⏭️ ",
+}
+
+// readinessHints tells how to fix each of the control plane's readiness checks
+var readinessHints = map[string]string{
+	"database":  "check that the control plane's FAAS_DATA_DIR exists and is writable, and see its logs",
+	"redis":     "start Redis on the control plane host to share cached results; results are cached in memory until then",
+	"warm_pool": "check FAAS_FIRECRACKER_BIN, FAAS_VM_KERNEL_PATH and FAAS_VM_ROOTFS_PATH on the control plane host, and see its logs for VM boot errors",
+	"daemon":    "check that the rootfs image runs the daemon at boot, and see the VM console output under FAAS_VM_STORAGE_DIR",
+}
+
+// doctorCheck is one line of the doctor checklist
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// runDoctor runs the doctor checks against the configured control plane.
+// The checks after connectivity are skipped when it is unreachable.
+func runDoctor() []doctorCheck {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	connectivity := doctorCheck{Name: "control plane", Status: doctorPass, Detail: "reachable at " + baseURL}
+	resp, err := client.Get(baseURL + "/api/health")
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("health check returned %s", resp.Status)
+		}
+	}
+	if err != nil {
+		connectivity.Status = doctorFail
+		connectivity.Detail = err.Error()
+		connectivity.Hint = "check that the control plane is running and that --api-url, SKYSCALE_API_URL or the config file points at it"
+		return []doctorCheck{
+			connectivity,
+			{Name: "API key", Status: doctorSkip, Detail: "control plane unreachable"},
+			{Name: "readiness", Status: doctorSkip, Detail: "control plane unreachable"},
+		}
+	}
+	checks := []doctorCheck{connectivity}
+
+	key := doctorCheck{Name: "API key", Status: doctorPass}
+	if identity, err := whoami(); err != nil {
+		key.Status = doctorFail
+		key.Detail = err.Error()
+		key.Hint = "generate a key with 'skyscale generate-api-key' and set it with --api-key, SKYSCALE_API_KEY or 'skyscale config'"
+	} else {
+		key.Detail = fmt.Sprintf("authenticated as %v", identity["user_id"])
+	}
+	checks = append(checks, key)
+
+	return append(checks, readinessChecks(client)...)
+}
+
+// readinessChecks turns the control plane's /ready response into doctor
+// checks, one per subsystem in name order
+func readinessChecks(client *http.Client) []doctorCheck {
+	failed := func(detail string) []doctorCheck {
+		return []doctorCheck{{Name: "readiness", Status: doctorFail, Detail: detail, Hint: "see the control plane logs"}}
+	}
+
+	resp, err := client.Get(baseURL + "/ready")
+	if err != nil {
+		return failed(err.Error())
+	}
+	defer resp.Body.Close()
+
+	// /ready answers 503 with the same body when a required check fails
+	var readiness struct {
+		Ready  bool `json:"ready"`
+		Checks map[string]struct {
+			OK       bool   `json:"ok"`
+			Optional bool   `json:"optional"`
+			Detail   string `json:"detail"`
+			Error    string `json:"error"`
+		} `json:"checks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&readiness); err != nil {
+		return failed(fmt.Sprintf("unexpected response with status %s: %v", resp.Status, err))
+	}
+
+	names := make([]string, 0, len(readiness.Checks))
+	for name := range readiness.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	checks := make([]doctorCheck, 0, len(names))
+	for _, name := range names {
+		result := readiness.Checks[name]
+		check := doctorCheck{Name: name, Status: doctorPass, Detail: result.Detail}
+		if !result.OK {
+			check.Status = doctorFail
+			if result.Optional {
+				check.Status = doctorWarn
+			}
+			if result.Error != "" && check.Detail != "" {
+				check.Detail += ": " + result.Error
+			} else if result.Error != "" {
+				check.Detail = result.Error
+			}
+			check.Hint = readinessHints[name]
+			if check.Hint == "" {
+				check.Hint = "see the control plane logs"
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
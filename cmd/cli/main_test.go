@@ -0,0 +1,685 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// chdirTemp changes into a fresh temporary directory for the rest of the test
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+// writeProject writes the files of a function project named name
+func writeProject(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		path := filepath.Join(name, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// captureStdout returns what f prints to stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		var out strings.Builder
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			out.Write(buf[:n])
+			if err != nil {
+				break
+			}
+		}
+		done <- out.String()
+	}()
+	f()
+	w.Close()
+	return <-done
+}
+
+// testServer is a mock control plane that records the requests it receives
+type testServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string // Method and path of each request
+}
+
+// newTestServer starts a mock control plane serving handler and points the
+// CLI at it for the rest of the test
+func newTestServer(t *testing.T, handler http.HandlerFunc) *testServer {
+	t.Helper()
+	server := &testServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		server.requests = append(server.requests, r.Method+" "+r.URL.Path)
+		server.mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	url, key := baseURL, apiKey
+	t.Cleanup(func() { baseURL, apiKey = url, key })
+	baseURL, apiKey = server.URL, "test-key"
+	return server
+}
+
+// received returns the requests the server received
+func (s *testServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func requirePython(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+}
+
+func TestRunLocally(t *testing.T) {
+	requirePython(t)
+	chdirTemp(t)
+	writeProject(t, "hello", map[string]string{
+		"handler.py":      "from lib.greeting import greet\n\ndef handler(event, context):\n    return {\"message\": greet(event[\"name\"]), \"function\": context.function_name}\n",
+		"lib/greeting.py": "def greet(name):\n    return 'Hello, ' + name\n",
+		"skyscale.yaml":   "name: hello\nruntime: python3\nfiles:\n  - lib/greeting.py\n",
+	})
+
+	var err error
+	out := captureStdout(t, func() {
+		err = runLocally("hello", map[string]any{"name": "world"}, 10)
+	})
+	if err != nil {
+		t.Fatalf("runLocally: %v", err)
+	}
+	if !strings.Contains(out, `"message": "Hello, world"`) || !strings.Contains(out, `"function": "hello"`) {
+		t.Errorf("output = %s", out)
+	}
+}
+
+func TestRunLocallyFunctionError(t *testing.T) {
+	requirePython(t)
+	chdirTemp(t)
+	writeProject(t, "broken", map[string]string{
+		"handler.py": "def handler(event, context):\n    raise ValueError('bad input')\n",
+	})
+
+	var err error
+	out := captureStdout(t, func() {
+		err = runLocally("broken", nil, 10)
+	})
+	if err == nil {
+		t.Error("runLocally() succeeded for a handler that raises")
+	}
+	if !strings.Contains(out, "bad input") || !strings.Contains(out, `"type": "exception"`) {
+		t.Errorf("output = %s, want the handler's exception", out)
+	}
+}
+
+func TestRunLocallyMissingHandler(t *testing.T) {
+	chdirTemp(t)
+	if err := runLocally("missing", nil, 10); err == nil || !strings.Contains(err.Error(), "handler.py") {
+		t.Errorf("runLocally() error = %v, want a missing handler.py", err)
+	}
+}
+
+func TestPrintOutput(t *testing.T) {
+	defer func(format string) { outputFormat = format }(outputFormat)
+	function := map[string]any{"id": "fn-1", "name": "hello", "memory": 128}
+	render := func(w io.Writer) { printFields(w, function, "name", "id", "missing") }
+
+	tests := []struct {
+		format string
+		decode func([]byte, any) error
+	}{
+		{"json", json.Unmarshal},
+		{"yaml", yaml.Unmarshal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			outputFormat = tt.format
+			var err error
+			out := captureStdout(t, func() { err = printOutput(function, render) })
+			if err != nil {
+				t.Fatalf("printOutput: %v", err)
+			}
+			// The object is emitted as it came from the API
+			var decoded map[string]any
+			if err := tt.decode([]byte(out), &decoded); err != nil {
+				t.Fatalf("output isn't valid %s: %v\n%s", tt.format, err, out)
+			}
+			if decoded["id"] != "fn-1" || decoded["name"] != "hello" || fmt.Sprint(decoded["memory"]) != "128" {
+				t.Errorf("decoded %v, want %v", decoded, function)
+			}
+		})
+	}
+
+	t.Run("table", func(t *testing.T) {
+		outputFormat = "table"
+		out := captureStdout(t, func() {
+			if err := printOutput(function, render); err != nil {
+				t.Errorf("printOutput: %v", err)
+			}
+		})
+		if out != "name:  hello\nid:    fn-1\n" {
+			t.Errorf("table output = %q", out)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		outputFormat = "xml"
+		if err := printOutput(function, render); err == nil {
+			t.Error("printOutput accepted an unsupported format")
+		}
+	})
+}
+
+func TestFunctionPath(t *testing.T) {
+	tests := []struct {
+		function string
+		byID     bool
+		want     string
+	}{
+		{"hello", false, "/api/functions/name/hello"},
+		{"3f2a-91", true, "/api/functions/3f2a-91"},
+		{"a/b", true, "/api/functions/a%2Fb"},
+	}
+	for _, tt := range tests {
+		if got := functionPath(tt.function, tt.byID); got != tt.want {
+			t.Errorf("functionPath(%q, %v) = %q, want %q", tt.function, tt.byID, got, tt.want)
+		}
+	}
+}
+
+func TestCommandsByID(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/invoke"):
+			w.Header().Set("Location", "/api/executions/req-1/result")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"request_id": "req-1"}`))
+		case strings.HasPrefix(r.URL.Path, "/api/executions/function/"):
+			w.Write([]byte(`[]`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	})
+
+	captureStdout(t, func() {
+		if err := invokeFunction("fn-1", map[string]any{}, true, true, 0, nil); err != nil {
+			t.Errorf("invokeFunction: %v", err)
+		}
+		if err := getLogs("fn-1", true, time.Time{}, time.Time{}); err != nil {
+			t.Errorf("getLogs: %v", err)
+		}
+		if err := deleteFunction("fn-1", true); err != nil {
+			t.Errorf("deleteFunction: %v", err)
+		}
+	})
+
+	want := []string{
+		"POST /api/functions/fn-1/invoke",
+		"GET /api/executions/function/fn-1",
+		"DELETE /api/functions/fn-1",
+	}
+	got := server.received()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests = %q, want %q without name lookups", got, want)
+	}
+}
+
+func TestParseTimeFlag(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "", want: time.Time{}},
+		{value: "0", want: time.Time{}},
+		{value: "1h", want: now.Add(-time.Hour)},
+		{value: "90m", want: now.Add(-90 * time.Minute)},
+		{value: "2024-04-30T08:00:00Z", want: time.Date(2024, 4, 30, 8, 0, 0, 0, time.UTC)},
+		{value: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseTimeFlag(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTimeFlag(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseTimeFlag(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogsSendsTimeWindow(t *testing.T) {
+	var query string
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`[]`))
+	})
+
+	since := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+	until := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	captureStdout(t, func() {
+		if err := getLogs("fn-1", true, since, until); err != nil {
+			t.Errorf("getLogs: %v", err)
+		}
+	})
+	if want := "since=2024-05-01T11%3A00%3A00Z&until=2024-05-01T12%3A00%3A00Z"; query != want {
+		t.Errorf("query = %q, want %q", query, want)
+	}
+}
+
+// envHelperArgs holds the newline-separated arguments TestHelperProcess runs
+// the CLI with
+const envHelperArgs = "SKYSCALE_TEST_HELPER_ARGS"
+
+// TestHelperProcess runs the CLI in a child process started by runCLI, so
+// tests can observe its exit status
+func TestHelperProcess(t *testing.T) {
+	args := os.Getenv(envHelperArgs)
+	if args == "" {
+		return
+	}
+	rootCmd.SetArgs(strings.Split(args, "\n"))
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// runCLI runs the CLI with args against the mock control plane in a child
+// process and returns its exit status and standard error
+func runCLI(t *testing.T, server *testServer, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(),
+		envHelperArgs+"="+strings.Join(args, "\n"),
+		envAPIURL+"="+server.URL,
+		envAPIKey+"=test-key",
+		"HOME="+t.TempDir(),
+	)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), stderr.String()
+	}
+	if err != nil {
+		t.Fatalf("failed to run the CLI: %v", err)
+	}
+	return 0, stderr.String()
+}
+
+func TestInvokeFailedFunctionExitsNonZero(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"request_id": "req-1", "status_code": 500, "error_message": "boom", "error_type": "exception"}`))
+	})
+
+	status, stderr := runCLI(t, server, "invoke", "fn-1", "--by-id")
+	if status != exitFunctionFailed {
+		t.Errorf("exit status = %d, want %d", status, exitFunctionFailed)
+	}
+	if !strings.Contains(stderr, "boom") {
+		t.Errorf("stderr = %q, want the function's error", stderr)
+	}
+
+	if status, _ := runCLI(t, server, "invoke", "fn-1", "--by-id", "--ignore-errors"); status != 0 {
+		t.Errorf("exit status with --ignore-errors = %d, want 0", status)
+	}
+}
+
+func TestInvokeSucceededFunctionExitsZero(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"request_id": "req-1", "status_code": 200, "output": {"ok": true}}`))
+	})
+	if status, stderr := runCLI(t, server, "invoke", "fn-1", "--by-id"); status != 0 {
+		t.Errorf("exit status = %d, want 0: %s", status, stderr)
+	}
+}
+
+func TestResultError(t *testing.T) {
+	tests := []struct {
+		name   string
+		result map[string]any
+		failed bool
+	}{
+		{"success", map[string]any{"status_code": float64(200)}, false},
+		{"no status code", map[string]any{"output": "hi"}, false},
+		{"error message", map[string]any{"status_code": float64(200), "error_message": "boom"}, true},
+		{"failure status code", map[string]any{"status_code": float64(500)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := resultError(tt.result)
+			if failed := errors.Is(err, errFunctionFailed); failed != tt.failed {
+				t.Errorf("resultError() = %v, want failed %v", err, tt.failed)
+			}
+		})
+	}
+}
+
+func TestDeleteByNameLooksUpID(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "fn-1"}`))
+	})
+
+	if err := deleteFunction("hello", false); err != nil {
+		t.Fatalf("deleteFunction: %v", err)
+	}
+	want := []string{"GET /api/functions/name/hello", "DELETE /api/functions/fn-1"}
+	if got := server.received(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+}
+
+// profilesConfig is a config file with a dev and a prod profile, dev active
+const profilesConfig = `api_url: http://default:8080
+current_profile: dev
+profiles:
+  dev:
+    api_url: http://dev:8080
+    api_key: dev-key
+  prod:
+    api_url: http://prod:8080
+    api_key: prod-key
+`
+
+// loadConfig loads config as the CLI's config file, as if it ran with the
+// given flags, restoring the CLI's settings once the test ends
+func loadConfig(t *testing.T, config string, flags map[string]string) string {
+	t.Helper()
+	t.Setenv(envAPIURL, "")
+	t.Setenv(envAPIKey, "")
+	path := filepath.Join(t.TempDir(), ".skyscale.yaml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reset := func() {
+		viper.Reset()
+		viper.BindPFlag("api_url", rootCmd.PersistentFlags().Lookup("api-url"))
+		viper.BindPFlag("api_key", rootCmd.PersistentFlags().Lookup("api-key"))
+		rootCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+			f.Value.Set(f.DefValue)
+			f.Changed = false
+		})
+		missingProfile = ""
+	}
+	reset()
+	t.Cleanup(reset)
+
+	flags["config"] = path
+	for name, value := range flags {
+		if err := rootCmd.PersistentFlags().Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	initConfig()
+	return path
+}
+
+func TestConfigProfilePrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string // SKYSCALE_API_URL
+		flags   map[string]string
+		wantURL string
+		wantKey string
+	}{
+		{"active profile", "", map[string]string{}, "http://dev:8080", "dev-key"},
+		{"profile flag over the active profile", "", map[string]string{"profile": "prod"}, "http://prod:8080", "prod-key"},
+		{"url flag over the profile", "", map[string]string{"profile": "prod", "api-url": "http://flag:8080"}, "http://flag:8080", "prod-key"},
+		{"environment over the profile", "http://env:8080", map[string]string{}, "http://env:8080", "dev-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadConfig(t, profilesConfig, tt.flags)
+			if tt.env != "" {
+				t.Setenv(envAPIURL, tt.env)
+				initConfig()
+			}
+			if baseURL != tt.wantURL || apiKey != tt.wantKey {
+				t.Errorf("API URL %q with key %q, want %q with %q", baseURL, apiKey, tt.wantURL, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestAPIKeyFromEnvironment(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		flags   map[string]string
+		wantKey string
+	}{
+		{"environment over the config file", "api_url: http://file:8080\napi_key: file-key\n", map[string]string{}, "env-key"},
+		{"environment over the profile", profilesConfig, map[string]string{}, "env-key"},
+		{"flag over the environment", profilesConfig, map[string]string{"api-key": "flag-key"}, "flag-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadConfig(t, tt.config, tt.flags)
+			t.Setenv(envAPIKey, "env-key")
+			initConfig()
+			if apiKey != tt.wantKey {
+				t.Errorf("API key %q, want %q", apiKey, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestAPIKeyFromEnvironmentAuthenticatesRequests(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	loadConfig(t, "api_key: file-key\n", map[string]string{})
+	t.Setenv(envAPIURL, server.URL)
+	t.Setenv(envAPIKey, "env-key")
+	initConfig()
+
+	resp, err := makeAuthenticatedRequest("GET", baseURL+"/functions", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if authorization != "Bearer env-key" {
+		t.Errorf("Authorization = %q, want the key from %s", authorization, envAPIKey)
+	}
+}
+
+func TestConfigWithoutProfiles(t *testing.T) {
+	loadConfig(t, "api_url: http://default:8080\napi_key: key\n", map[string]string{})
+	if baseURL != "http://default:8080" || apiKey != "key" {
+		t.Errorf("API URL %q with key %q, want the top-level settings", baseURL, apiKey)
+	}
+}
+
+func TestConfigMissingProfile(t *testing.T) {
+	loadConfig(t, profilesConfig, map[string]string{"profile": "staging"})
+	if missingProfile != "staging" {
+		t.Errorf("missing profile = %q, want staging", missingProfile)
+	}
+}
+
+func TestConfigUseSwitchesProfile(t *testing.T) {
+	path := loadConfig(t, profilesConfig, map[string]string{})
+	captureStdout(t, func() { configUseCmd.Run(configUseCmd, []string{"prod"}) })
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadConfig(t, string(data), map[string]string{})
+	if baseURL != "http://prod:8080" || apiKey != "prod-key" {
+		t.Errorf("API URL %q with key %q after switching, want the prod profile", baseURL, apiKey)
+	}
+}
+
+func TestCloneFunction(t *testing.T) {
+	var body map[string]string
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			json.NewDecoder(r.Body).Decode(&body)
+			w.Write([]byte(`{"id": "fn-2", "name": "hello-b"}`))
+			return
+		}
+		w.Write([]byte(`{"id": "fn-1"}`))
+	})
+
+	function, err := cloneFunction("hello", "hello-b", false)
+	if err != nil {
+		t.Fatalf("cloneFunction: %v", err)
+	}
+	if function["id"] != "fn-2" || body["name"] != "hello-b" {
+		t.Errorf("clone = %v requested with %v, want fn-2 named hello-b", function, body)
+	}
+	want := []string{"GET /api/functions/name/hello", "POST /api/functions/fn-1/clone"}
+	if got := server.received(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+}
+
+func TestCloneFunctionNameTaken(t *testing.T) {
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Function 'taken' already exists", http.StatusConflict)
+	})
+	if _, err := cloneFunction("fn-1", "taken", true); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("cloneFunction error = %v, want the conflict reported", err)
+	}
+}
+
+// doctorStatuses returns the status of each doctor check by name
+func doctorStatuses(checks []doctorCheck) map[string]string {
+	statuses := make(map[string]string, len(checks))
+	for _, check := range checks {
+		statuses[check.Name] = check.Status
+	}
+	return statuses
+}
+
+func TestDoctorMixedHealth(t *testing.T) {
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/health":
+			w.Write([]byte(`{"status": "ok"}`))
+		case "/api/auth/whoami":
+			w.Write([]byte(`{"user_id": "admin"}`))
+		case "/ready":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"ready": false, "checks": {
+				"database": {"ok": true},
+				"warm_pool": {"ok": true, "detail": "3 warm VMs"},
+				"daemon": {"ok": false, "error": "connection refused"},
+				"redis": {"ok": false, "optional": true, "error": "dial tcp: connection refused"}
+			}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	checks := runDoctor()
+	want := map[string]string{
+		"control plane": doctorPass,
+		"API key":       doctorPass,
+		"daemon":        doctorFail,
+		"database":      doctorPass,
+		"redis":         doctorWarn,
+		"warm_pool":     doctorPass,
+	}
+	if got := doctorStatuses(checks); len(got) != len(want) {
+		t.Fatalf("checks = %v, want %v", got, want)
+	}
+	for _, check := range checks {
+		if check.Status != want[check.Name] {
+			t.Errorf("check %s = %s, want %s", check.Name, check.Status, want[check.Name])
+		}
+		if hinted := check.Hint != ""; hinted != (check.Status != doctorPass) {
+			t.Errorf("check %s with status %s has hint %q", check.Name, check.Status, check.Hint)
+		}
+		if check.Name == "daemon" && (check.Detail != "connection refused" || check.Hint != readinessHints["daemon"]) {
+			t.Errorf("daemon check = %+v, want its error and remediation hint", check)
+		}
+		if check.Name == "API key" && !strings.Contains(check.Detail, "admin") {
+			t.Errorf("API key check = %+v, want the authenticated user", check)
+		}
+	}
+}
+
+func TestDoctorRejectedKey(t *testing.T) {
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/auth/whoami":
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+		case "/ready":
+			w.Write([]byte(`{"ready": true, "checks": {"database": {"ok": true}}}`))
+		default:
+			w.Write([]byte(`{"status": "ok"}`))
+		}
+	})
+
+	got := doctorStatuses(runDoctor())
+	if got["API key"] != doctorFail || got["control plane"] != doctorPass || got["database"] != doctorPass {
+		t.Errorf("checks = %v, want only the API key failing", got)
+	}
+}
+
+func TestDoctorUnreachable(t *testing.T) {
+	server := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	server.Close()
+
+	got := doctorStatuses(runDoctor())
+	want := map[string]string{"control plane": doctorFail, "API key": doctorSkip, "readiness": doctorSkip}
+	if len(got) != len(want) || got["control plane"] != want["control plane"] || got["API key"] != want["API key"] || got["readiness"] != want["readiness"] {
+		t.Errorf("checks = %v, want %v", got, want)
+	}
+}
@@ -3,4 +3,4 @@
 set -xe
 
 export GIN_MODE=release
-go build -tags netgo -ldflags '-extldflags "-static"' -o daemon 
+go build -tags netgo -ldflags "-extldflags -static -X main.version=${VERSION:-$(git describe --tags --always 2>/dev/null || echo dev)}" -o daemon 
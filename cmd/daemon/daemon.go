@@ -2,18 +2,22 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/bluequbit/faas/deamon/executor"
 )
 
 const (
@@ -23,41 +27,44 @@ const (
 	codeDir         = "/tmp/faas/code"
 	logDir          = "/var/log/faas"
 
+	// Identity (the control plane passes both on the kernel command line)
+	envVMID       = "VM_ID"
+	envVMIP       = "VM_IP"
+	kernelCmdline = "/proc/cmdline"
+
+	// Shared data (the control plane passes the device on the kernel command line)
+	dataDeviceParam = "FAAS_DATA_DEVICE"
+	dataMountPath   = "/mnt/data"
+
 	// Endpoints
 	functionEndpoint = "/api/functions"
 	resultEndpoint   = "/api/results"
+	chunkEndpoint    = "/api/results/chunks"
 	registerEndpoint = "/api/vms/register"
-)
-
-// FunctionPayload represents the code and metadata to be executed
-type FunctionPayload struct {
-	FunctionID   string                 `json:"function_id"`
-	Name         string                 `json:"name"`
-	Code         string                 `json:"code"`         // Function code
-	Requirements string                 `json:"requirements"` // Python requirements
-	Config       string                 `json:"config"`       // Function configuration
-	Runtime      string                 `json:"runtime"`      // e.g., "python3.9"
-	EntryPoint   string                 `json:"entry_point"`  // e.g., "handler.handler"
-	Environment  map[string]string      `json:"environment"`  // Environment variables
-	RequestID    string                 `json:"request_id"`   // Unique ID for this execution request
-	Timeout      int                    `json:"timeout"`      // Execution timeout in seconds
-	Memory       int                    `json:"memory"`       // Memory limit in MB
-	Version      string                 `json:"version"`      // Function version
-	Input        map[string]interface{} `json:"input"`        // Legacy input parameter (for backward compatibility)
-	Event        map[string]interface{} `json:"event"`        // Lambda-style event parameter
-	Context      map[string]interface{} `json:"context"`      // Lambda-style context parameter
-}
 
-// ExecutionResult represents the result of function execution
-type ExecutionResult struct {
-	RequestID    string `json:"request_id"`
-	FunctionID   string `json:"function_id"`
-	StatusCode   int    `json:"status_code"`
-	Output       string `json:"output"`
-	ErrorMessage string `json:"error_message,omitempty"`
-	Duration     int64  `json:"duration_ms"`
-	MemoryUsage  int64  `json:"memory_usage_kb,omitempty"`
-}
+	// Registration retry backoff
+	registerInitialBackoff = time.Second
+	registerMaxBackoff     = 30 * time.Second
+
+	// Limits
+	envMaxOutputBytes   = "FAAS_MAX_OUTPUT_BYTES"   // Cap on captured stdout/stderr
+	envPipMaxRetries    = "FAAS_PIP_MAX_RETRIES"    // Retries for a failed ensurepip/pip install
+	envMaxArtifactBytes = "FAAS_MAX_ARTIFACT_BYTES" // Cap on the total size of returned artifacts
+	envMaxScratchBytes  = "FAAS_MAX_SCRATCH_BYTES"  // Cap on the size of a function's scratch directory
+
+	// TLS (optional; plaintext HTTP is used when no certificate is configured)
+	envTLSCert = "FAAS_TLS_CERT" // Server certificate presented to the control plane
+	envTLSKey  = "FAAS_TLS_KEY"  // Private key for the server certificate
+	envTLSCA   = "FAAS_TLS_CA"   // CA used to authenticate the control plane's client certificate
+
+	// Result reports (optional; the control plane puts the secret in the VM's metadata service)
+	envResultSecret    = "FAAS_RESULT_SECRET" // Shared secret presented when reporting results, outside VMs
+	resultSecretHeader = "X-Result-Secret"
+	metadataURL        = "http://169.254.169.254/skyscale" // The daemon's entry in the metadata service (MMDS)
+	metadataTimeout    = 10 * time.Second                  // How long to wait for the metadata service at startup
+	envResultWorkers   = "FAAS_RESULT_WORKERS"             // Results delivered to the control plane at once
+	envResultBuffer    = "FAAS_RESULT_BUFFER"              // Results waiting for delivery before executions block
+)
 
 // VMInfo contains information about this VM instance
 type VMInfo struct {
@@ -67,8 +74,40 @@ type VMInfo struct {
 	Status      string `json:"status"`
 }
 
+// DaemonInfo describes this daemon build and its configuration
+type DaemonInfo struct {
+	Version   string           `json:"version"`
+	VMID      string           `json:"vm_id"`
+	Runtimes  []string         `json:"runtimes"`
+	Limits    map[string]int64 `json:"limits"`
+	StartedAt time.Time        `json:"started_at"`
+	// DataDir is where the read-only data drive is mounted, if there is one
+	DataDir string `json:"data_dir,omitempty"`
+	// ResultDelivery reports on the results sent to the control plane
+	ResultDelivery ResultDeliveryStats `json:"result_delivery"`
+}
+
+// version is the daemon build version, set with -ldflags "-X main.version=..."
+var version = "dev"
+
+var startedAt = time.Now()
+
 var vmInfo VMInfo
 var httpClient *http.Client
+var functionExecutor *executor.Executor
+var results *resultSender
+
+// inVM is set when the daemon runs in a VM booted by the control plane,
+// which passes the VM's identity on the kernel command line
+var inVM bool
+
+// resultSecret is presented on result reports, or empty if they aren't
+// authenticated
+var resultSecret string
+
+// tlsCAPool holds the CA configured via FAAS_TLS_CA, or nil. When set, the
+// control plane must present a client certificate signed by it to /execute.
+var tlsCAPool *x509.CertPool
 
 func init() {
 	// Create necessary directories
@@ -77,41 +116,183 @@ func init() {
 
 	// Initialize VM info
 	hostname, _ := os.Hostname()
+	params := kernelParams(kernelCmdline)
+	inVM = params[envVMID] != ""
 	vmInfo = VMInfo{
-		VMID:        os.Getenv("VM_ID"),
-		IPAddress:   os.Getenv("VM_IP"),
+		VMID:        identity(params, envVMID),
+		IPAddress:   identity(params, envVMIP),
 		MachineName: hostname,
 		Status:      "ready",
 	}
 
+	// Configure the executor and its output limit
+	maxOutputBytes := executor.DefaultMaxOutputBytes
+	if limit := os.Getenv(envMaxOutputBytes); limit != "" {
+		if val, err := strconv.Atoi(limit); err == nil && val > 0 {
+			maxOutputBytes = val
+		}
+	}
+	functionExecutor = executor.New(codeDir, maxOutputBytes)
+	if retries := os.Getenv(envPipMaxRetries); retries != "" {
+		if val, err := strconv.Atoi(retries); err == nil && val >= 0 {
+			functionExecutor.InstallRetries = val
+		}
+	}
+	if limit := os.Getenv(envMaxArtifactBytes); limit != "" {
+		if val, err := strconv.ParseInt(limit, 10, 64); err == nil && val >= 0 {
+			functionExecutor.MaxArtifactBytes = val
+		}
+	}
+	if limit := os.Getenv(envMaxScratchBytes); limit != "" {
+		if val, err := strconv.ParseInt(limit, 10, 64); err == nil && val >= 0 {
+			functionExecutor.MaxScratchBytes = val
+		}
+	}
+
+	// Stream the items of generator handlers to the control plane
+	functionExecutor.OnChunk = func(payload *executor.FunctionPayload, seq int, data json.RawMessage) {
+		chunk := &executor.Chunk{RequestID: payload.RequestID, FunctionID: payload.FunctionID, Seq: seq, Data: data}
+		if err := sendChunk(httpClient, chunk); err != nil {
+			log.Printf("Error sending chunk %d of request %s: %v", seq, payload.RequestID, err)
+		}
+	}
+
 	// Set up logging
 	logFile, err := os.OpenFile(filepath.Join(logDir, "daemon.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err == nil {
 		log.SetOutput(io.MultiWriter(os.Stdout, logFile))
 	}
 
+	// Mount the read-only data drive, if the VM has one
+	if device := params[dataDeviceParam]; device != "" {
+		if err := mountDataDrive(device); err != nil {
+			log.Printf("Failed to mount data drive %s: %v", device, err)
+		} else {
+			functionExecutor.DataDir = dataMountPath
+		}
+	}
+
+	// Scratch directories are VM-local; drop any left on the disk image
+	if err := functionExecutor.ResetScratch(); err != nil {
+		log.Printf("Failed to clear scratch directories: %v", err)
+	}
+
+	// Load the CA used for mutual TLS with the control plane
+	if caPath := os.Getenv(envTLSCA); caPath != "" {
+		caPEM, err := os.ReadFile(caPath)
+		if err != nil {
+			log.Fatalf("Failed to read CA certificate: %v", err)
+		}
+		tlsCAPool = x509.NewCertPool()
+		if !tlsCAPool.AppendCertsFromPEM(caPEM) {
+			log.Fatalf("No valid certificates found in %s", caPath)
+		}
+	}
+
 	// Configure HTTP client
+	clientTLS := &tls.Config{
+		InsecureSkipVerify: true, // For development only
+	}
+	if tlsCAPool != nil {
+		// Verify the control plane against the shared CA
+		clientTLS = &tls.Config{RootCAs: tlsCAPool}
+	}
 	httpClient = &http.Client{
 		Timeout: 30 * time.Second,
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true, // For development only
-			},
+			TLSClientConfig: clientTLS,
 		},
 	}
+
+	// Deliver results from a bounded pool of workers
+	workers, buffer := defaultResultWorkers, defaultResultBuffer
+	if value := os.Getenv(envResultWorkers); value != "" {
+		if val, err := strconv.Atoi(value); err == nil && val > 0 {
+			workers = val
+		}
+	}
+	if value := os.Getenv(envResultBuffer); value != "" {
+		if val, err := strconv.Atoi(value); err == nil && val >= 0 {
+			buffer = val
+		}
+	}
+	results = newResultSender(httpClient, workers, buffer, sendResult)
+}
+
+// kernelParams returns the name=value parameters on the kernel command line
+// at path, or nil if it can't be read
+func kernelParams(path string) map[string]string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	params := make(map[string]string)
+	for _, field := range strings.Fields(string(data)) {
+		if name, value, ok := strings.Cut(field, "="); ok {
+			params[name] = value
+		}
+	}
+	return params
+}
+
+// mountDataDrive mounts the ext4 image on device read-only at dataMountPath
+func mountDataDrive(device string) error {
+	if err := os.MkdirAll(dataMountPath, 0755); err != nil {
+		return err
+	}
+	return syscall.Mount(device, dataMountPath, "ext4", syscall.MS_RDONLY, "")
+}
+
+// identity returns an identity setting from the kernel command line, where
+// the control plane puts it, falling back to the environment. init doesn't
+// reliably pass kernel parameters on to services, so they're read directly.
+func identity(params map[string]string, name string) string {
+	if value := params[name]; value != "" {
+		return value
+	}
+	return os.Getenv(name)
 }
 
 func main() {
 	log.Printf("Starting FaaS daemon on %s (ID: %s)", vmInfo.MachineName, vmInfo.VMID)
 
-	// Register VM with control plane
-	// if err := registerVM(); err != nil {
-	// 	log.Fatalf("Failed to register VM with control plane: %v", err)
-	// }
+	// Read the result secret before answering health checks; the control
+	// plane removes it from the metadata service once the daemon is ready
+	source := ""
+	if inVM {
+		source = metadataURL
+	}
+	resultSecret = loadResultSecret(source, metadataTimeout)
+
+	// Register VM with control plane in the background, retrying until it answers
+	go registerVM()
 
 	// Set up HTTP server for receiving function execution requests
 	http.HandleFunc("/execute", handleExecuteRequest)
 	http.HandleFunc("/health", handleHealthCheck)
+	http.HandleFunc("/validate", handleValidateRequest)
+	http.HandleFunc("/info", handleInfoRequest)
+	http.HandleFunc("/warmup", handleWarmupRequest)
+
+	// Start HTTPS server if a certificate is configured
+	certFile, keyFile := os.Getenv(envTLSCert), os.Getenv(envTLSKey)
+	if certFile != "" && keyFile != "" {
+		server := &http.Server{
+			Addr: ":" + daemonPort,
+			TLSConfig: &tls.Config{
+				ClientCAs: tlsCAPool,
+				// Health checks come without a client certificate; /execute
+				// enforces one itself when a CA is configured
+				ClientAuth: tls.VerifyClientCertIfGiven,
+				MinVersion: tls.VersionTLS12,
+			},
+		}
+		log.Printf("Starting HTTPS server on port %s", daemonPort)
+		if err := server.ListenAndServeTLS(certFile, keyFile); err != nil {
+			log.Fatalf("Failed to start HTTPS server: %v", err)
+		}
+		return
+	}
 
 	// Start HTTP server
 	log.Printf("Starting HTTP server on port %s", daemonPort)
@@ -120,12 +301,43 @@ func main() {
 	}
 }
 
+// authenticateControlPlane checks that the request carries a client
+// certificate signed by the configured CA. With no CA configured every
+// request is accepted.
+func authenticateControlPlane(r *http.Request) bool {
+	if tlsCAPool == nil {
+		return true
+	}
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
 // handleHealthCheck handles health check requests
 func handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
+// handleInfoRequest reports the daemon version, supported runtimes and limits
+func handleInfoRequest(w http.ResponseWriter, r *http.Request) {
+	info := DaemonInfo{
+		Version:  version,
+		VMID:     vmInfo.VMID,
+		Runtimes: executor.SupportedRuntimes,
+		DataDir:  functionExecutor.DataDir,
+		Limits: map[string]int64{
+			"max_output_bytes":   int64(functionExecutor.MaxOutputBytes),
+			"max_artifact_bytes": functionExecutor.MaxArtifactBytes,
+			"max_scratch_bytes":  functionExecutor.MaxScratchBytes,
+			"pip_max_retries":    int64(functionExecutor.InstallRetries),
+		},
+		StartedAt:      startedAt,
+		ResultDelivery: results.Stats(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
 // handleExecuteRequest handles function execution requests
 func handleExecuteRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -133,8 +345,13 @@ func handleExecuteRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authenticateControlPlane(r) {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+
 	// Parse request body
-	var payload FunctionPayload
+	var payload executor.FunctionPayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
 		return
@@ -148,10 +365,10 @@ func handleExecuteRequest(w http.ResponseWriter, r *http.Request) {
 	// Execute the function asynchronously
 	go func() {
 		// Execute the function
-		result := executeFunction(&payload)
+		result := functionExecutor.Execute(&payload)
 
 		// Send the result back to the control plane
-		if err := sendResult(httpClient, result); err != nil {
+		if err := <-results.deliver(result); err != nil {
 			log.Printf("Error sending result: %v", err)
 		}
 
@@ -169,297 +386,212 @@ func handleExecuteRequest(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Function execution started"))
 }
 
-// reportVMStatus reports the current VM status to the control plane
-func reportVMStatus() error {
-	data, err := json.Marshal(vmInfo)
-	if err != nil {
-		return fmt.Errorf("error marshaling VM info: %v", err)
+// handleValidateRequest checks a function's entry point without executing it.
+// It responds with {"valid": bool, "error": string}; probe failures unrelated
+// to the function itself return 500.
+func handleValidateRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	resp, err := httpClient.Post(
-		fmt.Sprintf("%s%s", controlPlaneURL, registerEndpoint),
-		"application/json",
-		bytes.NewBuffer(data),
-	)
-	if err != nil {
-		return err
+	if !authenticateControlPlane(r) {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	var payload executor.FunctionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
 	}
 
-	return nil
-}
-
-// executeFunction prepares and executes the function code
-func executeFunction(payload *FunctionPayload) *ExecutionResult {
-	startTime := time.Now()
-	result := &ExecutionResult{
-		RequestID:  payload.RequestID,
-		FunctionID: payload.FunctionID,
-		StatusCode: 500, // Default to error
+	response := map[string]interface{}{"valid": true}
+	if err := functionExecutor.ValidateEntryPoint(&payload); err != nil {
+		if !errors.Is(err, executor.ErrInvalidEntryPoint) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response = map[string]interface{}{"valid": false, "error": err.Error()}
 	}
 
-	log.Printf("Starting execution of function %s (ID: %s)", payload.Name, payload.RequestID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	// Create a directory for this execution
-	execDir := filepath.Join(codeDir, payload.RequestID)
-	if err := os.MkdirAll(execDir, 0755); err != nil {
-		result.ErrorMessage = fmt.Sprintf("Failed to create execution directory: %v", err)
-		return result
+// handleWarmupRequest builds the virtual environment for a function's
+// requirements without running it, responding once it is ready with the
+// install duration
+func handleWarmupRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	defer os.RemoveAll(execDir) // Clean up after execution
 
-	// Write function code and requirements
-	if err := prepareFunction(payload, execDir); err != nil {
-		result.ErrorMessage = fmt.Sprintf("Failed to prepare function: %v", err)
-		return result
+	if !authenticateControlPlane(r) {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
 	}
 
-	// Execute the function
-	output, err := runFunction(payload, execDir)
-	duration := time.Since(startTime).Milliseconds()
+	var payload executor.FunctionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
 
-	result.Duration = duration
+	result, err := functionExecutor.Warmup(&payload)
 	if err != nil {
-		result.ErrorMessage = fmt.Sprintf("Execution error: %v", err)
-		result.Output = output // Include any partial output
-		log.Printf("Function execution failed: %v", err)
-	} else {
-		result.StatusCode = 200
-		result.Output = output
-		log.Printf("Function execution completed successfully in %d ms", duration)
+		log.Printf("Warmup of function %s failed: %v", payload.Name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	log.Printf("Warmed up function %s in %d ms (cached: %t)", payload.Name, result.Duration, result.Cached)
 
-	// Track memory usage if available
-	// This is a placeholder - in a real implementation, you would measure actual memory usage
-	result.MemoryUsage = 0
-
-	return result
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
 
-// prepareFunction writes the function code and requirements to disk
-func prepareFunction(payload *FunctionPayload, execDir string) error {
-	// Write handler.py
-	if err := os.WriteFile(filepath.Join(execDir, "handler.py"), []byte(payload.Code), 0644); err != nil {
-		return fmt.Errorf("failed to write handler.py: %v", err)
-	}
-
-	// Write requirements.txt
-	if err := os.WriteFile(filepath.Join(execDir, "requirements.txt"), []byte(payload.Requirements), 0644); err != nil {
-		return fmt.Errorf("failed to write requirements.txt: %v", err)
-	}
-
-	// Write config file
-	if err := os.WriteFile(filepath.Join(execDir, "faas.yaml"), []byte(payload.Config), 0644); err != nil {
-		return fmt.Errorf("failed to write faas.yaml: %v", err)
+// registerVM reports this VM to the control plane, retrying with backoff
+// until the registration is acknowledged
+func registerVM() {
+	if vmInfo.VMID == "" {
+		log.Printf("VM_ID is not set, skipping registration with the control plane")
+		return
 	}
 
-	// Install requirements if any
-	if payload.Requirements != "" {
-		// Create a virtual environment
-		venvPath := filepath.Join(execDir, "venv")
-		createVenvCmd := exec.Command("python3", "-m", "venv", venvPath)
-		createVenvCmd.Dir = execDir
-		if output, err := createVenvCmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to create virtual environment: %v, output: %s", err, output)
-		}
-
-		// Ensure pip is installed using the venv's Python interpreter
-		pythonPath := filepath.Join(venvPath, "bin", "python")
-		ensurepipCmd := exec.Command(pythonPath, "-m", "ensurepip", "--default-pip")
-		ensurepipCmd.Dir = execDir
-		if output, err := ensurepipCmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to ensure pip is installed: %v, output: %s", err, output)
+	backoff := registerInitialBackoff
+	for {
+		err := reportVMStatus()
+		if err == nil {
+			log.Printf("Registered VM %s with control plane", vmInfo.VMID)
+			return
 		}
 
-		// Install requirements in the virtual environment
-		pipPath := filepath.Join(venvPath, "bin", "pip")
-		cmd := exec.Command(pipPath, "install", "-r", filepath.Join(execDir, "requirements.txt"))
-		cmd.Dir = execDir
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to install requirements: %v, output: %s", err, output)
+		log.Printf("Failed to register VM with control plane, retrying in %v: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > registerMaxBackoff {
+			backoff = registerMaxBackoff
 		}
 	}
-
-	return nil
 }
 
-// runFunction executes the function with the specified runtime
-func runFunction(payload *FunctionPayload, execDir string) (string, error) {
-	var cmd *exec.Cmd
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(payload.Timeout)*time.Second)
-	defer cancel()
-
-	switch payload.Runtime {
-	case "python3", "python3.9", "python3.10":
-		// Parse entry point (format: "file.function")
-		entryPoint := "handler.handler"
-		if payload.EntryPoint != "" {
-			entryPoint = payload.EntryPoint
-		}
-
-		parts := strings.Split(entryPoint, ".")
-		if len(parts) != 2 {
-			return "", fmt.Errorf("invalid entry point format: %s", entryPoint)
-		}
-
-		file, function := parts[0], parts[1]
-
-		// Use Event if available, or fall back to Input for backward compatibility
-		event := payload.Event
-		if event == nil && payload.Input != nil {
-			event = payload.Input
-		} else if event == nil {
-			event = make(map[string]interface{})
-		}
-
-		// Generate event and context JSON
-		eventJSON, err := json.Marshal(event)
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal event: %v", err)
-		}
+// reportVMStatus reports the current VM status to the control plane
+func reportVMStatus() error {
+	data, err := json.Marshal(vmInfo)
+	if err != nil {
+		return fmt.Errorf("error marshaling VM info: %v", err)
+	}
 
-		contextJSON, err := json.Marshal(payload.Context)
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal context: %v", err)
-		}
+	return postResult(httpClient, registerEndpoint, data)
+}
 
-		// Create Python script to execute the function with event and context
-		executorCode := fmt.Sprintf(`
-import sys
-import json
-import traceback
-import os
-import time
-import %s
-
-# Create Context class to emulate Lambda Context
-class LambdaContext:
-    def __init__(self, context_dict):
-        for key, value in context_dict.items():
-            setattr(self, key, value)
-        self._start_time = time.time() * 1000  # Current time in milliseconds
-    
-    def get_remaining_time_in_millis(self):
-        elapsed = (time.time() * 1000) - self._start_time
-        return max(0, self.remaining_time_ms - elapsed)
-
-try:
-    # Set up environment variables
-    %s
-    
-    # Parse event and context
-    event = json.loads('''%s''')
-    context_dict = json.loads('''%s''')
-    context = LambdaContext(context_dict)
-    
-    # Execute function with event and context arguments
-    result = %s.%s(event, context)
-    
-    # Convert result to JSON string if not already a string
-    if not isinstance(result, str):
-        result = json.dumps(result)
-    
-    print(result)
-    sys.exit(0)
-except Exception as e:
-    error_msg = str(e)
-    print(json.dumps({
-        "error": error_msg,
-        "traceback": traceback.format_exc()
-    }))
-    sys.exit(1)
-`, file, generateEnvSetup(payload.Environment), string(eventJSON), string(contextJSON), file, function)
-
-		// Write executor script
-		if err := os.WriteFile(filepath.Join(execDir, "executor.py"), []byte(executorCode), 0644); err != nil {
-			return "", fmt.Errorf("failed to write executor.py: %v", err)
-		}
+// sendResult sends the execution result back to the control plane
+func sendResult(client *http.Client, result *executor.ExecutionResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("error marshaling result: %v", err)
+	}
 
-		// Determine which Python interpreter to use
-		pythonInterpreter := "python3"
-		if payload.Requirements != "" {
-			// Use the virtual environment's Python interpreter if we created one
-			venvPath := filepath.Join(execDir, "venv")
-			pythonInterpreter = filepath.Join(venvPath, "bin", "python")
-		}
+	log.Printf("Sending execution result for request ID: %s", result.RequestID)
 
-		// Execute the function
-		cmd = exec.CommandContext(ctx, pythonInterpreter, filepath.Join(execDir, "executor.py"))
-	default:
-		return "", fmt.Errorf("unsupported runtime: %s", payload.Runtime)
+	if err := postResult(client, resultEndpoint, data); err != nil {
+		return err
 	}
 
-	// Set working directory
-	cmd.Dir = execDir
-
-	// Capture output
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	log.Printf("Result sent successfully for request ID: %s", result.RequestID)
+	return nil
+}
 
-	// Run the command
-	err := cmd.Run()
-	output := stdout.String()
+// sendChunk sends an item yielded by a generator handler to the control
+// plane, which streams it to the invoking client
+func sendChunk(client *http.Client, chunk *executor.Chunk) error {
+	data, err := json.Marshal(chunk)
 	if err != nil {
-		log.Printf("Execution failed: %v, output: %s, stderr: %s", err, output, stderr.String())
-		return output, fmt.Errorf("execution failed: %v, stderr: %s", err, stderr.String())
+		return fmt.Errorf("error marshaling chunk: %v", err)
 	}
-	log.Printf("Execution succeeded: %s", output)
-	return output, nil
+	return postResult(client, chunkEndpoint, data)
 }
 
-// generateEnvSetup generates Python code to set environment variables
-func generateEnvSetup(env map[string]string) string {
-	if len(env) == 0 {
-		return "pass"
+// postResult posts a report, such as a result or the VM's status, to the
+// control plane endpoint, presenting the result secret if one is configured
+func postResult(client *http.Client, endpoint string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s%s", controlPlaneURL, endpoint), bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if resultSecret != "" {
+		req.Header.Set(resultSecretHeader, resultSecret)
 	}
 
-	var lines []string
-	for k, v := range env {
-		lines = append(lines, fmt.Sprintf("os.environ['%s'] = '%s'", k, v))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	return "import os\n" + strings.Join(lines, "\n")
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
 }
 
-// sendResult sends the execution result back to the control plane
-func sendResult(client *http.Client, result *ExecutionResult) error {
-	// Try to parse the output as JSON if it's not empty
-	if result.Output != "" && result.StatusCode == 200 {
-		// Check if the output is already valid JSON
-		var parsedOutput interface{}
-		err := json.Unmarshal([]byte(result.Output), &parsedOutput)
-		if err != nil {
-			// If it's not valid JSON, wrap it in a simple JSON structure
-			result.Output = fmt.Sprintf(`{"result": %q}`, result.Output)
+// loadResultSecret returns the secret to present on result reports. It is
+// read from the metadata service at url, retrying until timeout while the
+// network comes up, or from the environment when url is empty, e.g. for the
+// test host daemon. The variable is removed from the environment either way
+// so functions, which inherit the daemon's environment, can't read it.
+func loadResultSecret(url string, timeout time.Duration) string {
+	secret := os.Getenv(envResultSecret)
+	os.Unsetenv(envResultSecret)
+	if url == "" {
+		return secret
+	}
+
+	client := &http.Client{Timeout: time.Second}
+	deadline := time.Now().Add(timeout)
+	for {
+		secret, err := fetchResultSecret(client, url)
+		if err == nil {
+			return secret
+		}
+		if time.Now().After(deadline) {
+			log.Printf("Failed to read the result secret from the metadata service, results will be rejected if the control plane requires one: %v", err)
+			return ""
 		}
+		time.Sleep(200 * time.Millisecond)
 	}
+}
 
-	data, err := json.Marshal(result)
+// fetchResultSecret reads the result secret from the metadata service. A
+// missing entry means result reports aren't authenticated.
+func fetchResultSecret(client *http.Client, url string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return fmt.Errorf("error marshaling result: %v", err)
+		return "", err
 	}
+	req.Header.Set("Accept", "application/json")
 
-	log.Printf("Sending execution result for request ID: %s", result.RequestID)
-
-	resp, err := client.Post(
-		fmt.Sprintf("%s%s", controlPlaneURL, resultEndpoint),
-		"application/json",
-		bytes.NewBuffer(data),
-	)
+	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", nil
+	default:
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	log.Printf("Result sent successfully for request ID: %s", result.RequestID)
-	return nil
+	var metadata struct {
+		ResultSecret string `json:"result_secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return "", fmt.Errorf("invalid metadata: %v", err)
+	}
+	return metadata.ResultSecret, nil
 }
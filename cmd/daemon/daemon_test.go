@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadResultSecretFromMetadata(t *testing.T) {
+	t.Setenv(envResultSecret, "")
+	mmds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/skyscale" || r.Header.Get("Accept") != "application/json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"result_secret": "s3cret"}`))
+	}))
+	defer mmds.Close()
+
+	if secret := loadResultSecret(mmds.URL+"/skyscale", time.Second); secret != "s3cret" {
+		t.Errorf("secret = %q, want s3cret", secret)
+	}
+}
+
+func TestLoadResultSecretNotConfigured(t *testing.T) {
+	t.Setenv(envResultSecret, "")
+	mmds := httptest.NewServer(http.NotFoundHandler())
+	defer mmds.Close()
+
+	if secret := loadResultSecret(mmds.URL+"/skyscale", time.Second); secret != "" {
+		t.Errorf("secret = %q, want none", secret)
+	}
+}
+
+func TestLoadResultSecretRetries(t *testing.T) {
+	t.Setenv(envResultSecret, "")
+	attempts := 0
+	mmds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"result_secret": "s3cret"}`))
+	}))
+	defer mmds.Close()
+
+	if secret := loadResultSecret(mmds.URL+"/skyscale", 5*time.Second); secret != "s3cret" {
+		t.Errorf("secret = %q after %d attempts, want s3cret", secret, attempts)
+	}
+}
+
+func TestLoadResultSecretFromEnvironment(t *testing.T) {
+	t.Setenv(envResultSecret, "s3cret")
+
+	if secret := loadResultSecret("", time.Second); secret != "s3cret" {
+		t.Errorf("secret = %q, want s3cret", secret)
+	}
+	// Functions inherit the daemon's environment
+	if value, ok := os.LookupEnv(envResultSecret); ok {
+		t.Errorf("%s is still set to %q", envResultSecret, value)
+	}
+}
+
+func TestAuthenticateControlPlane(t *testing.T) {
+	defer func(pool *x509.CertPool) { tlsCAPool = pool }(tlsCAPool)
+	req := httptest.NewRequest(http.MethodPost, "/execute", nil)
+
+	tlsCAPool = nil
+	if !authenticateControlPlane(req) {
+		t.Error("rejected a request with no CA configured")
+	}
+
+	tlsCAPool = x509.NewCertPool()
+	if authenticateControlPlane(req) {
+		t.Error("accepted a plaintext request with a CA configured")
+	}
+	req.TLS = &tls.ConnectionState{}
+	if authenticateControlPlane(req) {
+		t.Error("accepted a request without a verified client certificate")
+	}
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{{}}}
+	if !authenticateControlPlane(req) {
+		t.Error("rejected a request with a verified client certificate")
+	}
+}
+
+// useControlPlane sends the daemon's requests to the control plane at server,
+// whatever host they are addressed to
+func useControlPlane(t *testing.T, server *httptest.Server) {
+	t.Helper()
+	client := httpClient
+	t.Cleanup(func() { httpClient = client })
+	httpClient = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+			},
+		},
+	}
+}
+
+func TestRegisterVMRetriesUntilAcknowledged(t *testing.T) {
+	defer func(info VMInfo, secret string) { vmInfo, resultSecret = info, secret }(vmInfo, resultSecret)
+	vmInfo = VMInfo{VMID: "vm-1", IPAddress: "172.16.0.2", Status: "ready"}
+	resultSecret = "s3cret"
+
+	var mu sync.Mutex
+	var registrations []VMInfo
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != registerEndpoint || r.Header.Get(resultSecretHeader) != "s3cret" {
+			http.NotFound(w, r)
+			return
+		}
+		var info VMInfo
+		json.NewDecoder(r.Body).Decode(&info)
+		registrations = append(registrations, info)
+		// The control plane isn't ready for the first attempt
+		if len(registrations) == 1 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("VM registered"))
+	}))
+	defer controlPlane.Close()
+	useControlPlane(t, controlPlane)
+
+	done := make(chan struct{})
+	go func() {
+		registerVM()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("registration didn't finish after the control plane came up")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(registrations) != 2 {
+		t.Fatalf("control plane received %d registrations, want 2", len(registrations))
+	}
+	if registrations[1] != vmInfo {
+		t.Errorf("registered %+v, want %+v", registrations[1], vmInfo)
+	}
+}
+
+func TestRegisterVMWithoutID(t *testing.T) {
+	defer func(info VMInfo) { vmInfo = info }(vmInfo)
+	vmInfo = VMInfo{}
+
+	calls := 0
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))
+	defer controlPlane.Close()
+	useControlPlane(t, controlPlane)
+
+	registerVM()
+	if calls != 0 {
+		t.Errorf("a daemon without a VM ID registered %d times", calls)
+	}
+}
+
+func TestIdentityFromKernelCmdline(t *testing.T) {
+	cmdline := filepath.Join(t.TempDir(), "cmdline")
+	if err := os.WriteFile(cmdline, []byte("console=ttyS0 reboot=k ip=172.16.0.2::172.16.0.1:255.255.255.0::eth0:off VM_ID=vm-0001 VM_IP=172.16.0.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envVMID, "vm-env")
+	t.Setenv(envVMIP, "10.0.0.9")
+
+	params := kernelParams(cmdline)
+	if id := identity(params, envVMID); id != "vm-0001" {
+		t.Errorf("VM ID = %q, want vm-0001 from the kernel command line", id)
+	}
+	if ip := identity(params, envVMIP); ip != "172.16.0.2" {
+		t.Errorf("VM IP = %q, want 172.16.0.2 from the kernel command line", ip)
+	}
+}
+
+func TestIdentityFallsBackToEnvironment(t *testing.T) {
+	t.Setenv(envVMID, "vm-env")
+	t.Setenv(envVMIP, "10.0.0.9")
+
+	params := kernelParams(filepath.Join(t.TempDir(), "missing"))
+	if id, ip := identity(params, envVMID), identity(params, envVMIP); id != "vm-env" || ip != "10.0.0.9" {
+		t.Errorf("identity = %q, %q; want vm-env and 10.0.0.9 from the environment", id, ip)
+	}
+}
@@ -0,0 +1,61 @@
+package executor
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// OutputDirName is the directory, relative to the execution directory, whose
+// files are returned as artifacts. Handlers find it in $OUTPUT_DIR.
+const OutputDirName = "output"
+
+// DefaultMaxArtifactBytes is the default cap on the total size of artifacts
+const DefaultMaxArtifactBytes = 10 * 1024 * 1024
+
+// collectArtifacts reads the regular files under dir, keyed by slash-separated
+// relative path. Files are taken in lexical order until the next one would
+// exceed limit bytes in total; the flag reports whether any were left out.
+func collectArtifacts(dir string, limit int64) (map[string][]byte, bool, error) {
+	var artifacts map[string][]byte
+	var total int64
+	truncated := false
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if total+info.Size() > limit {
+			truncated = true
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if artifacts == nil {
+			artifacts = make(map[string][]byte)
+		}
+		artifacts[filepath.ToSlash(rel)] = data
+		total += int64(len(data))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return artifacts, truncated, err
+}
@@ -0,0 +1,607 @@
+// Package executor runs function code the same way inside a VM and locally.
+//
+// It writes the function's code, requirements and files into a per-request
+// directory, sets up a virtual environment when requirements are given, and
+// invokes the handler through a generated executor script using the
+// Lambda-style event/context contract.
+
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultMaxOutputBytes is the default cap on captured stdout/stderr
+const DefaultMaxOutputBytes = 256 * 1024
+
+// Categories of execution failures reported in ExecutionResult.ErrorType
+const (
+	ErrorTypeSetup         = "setup_error"         // The code or requirements could not be installed
+	ErrorTypeImport        = "import_error"        // The handler module or one of its imports failed to load
+	ErrorTypeException     = "exception"           // The handler raised an uncaught exception
+	ErrorTypeSerialization = "serialization_error" // The handler returned a value that can't be encoded as JSON
+	ErrorTypeOOM           = "oom"                 // The handler ran out of memory or was killed by the OOM killer
+	ErrorTypeTimeout       = "timeout"             // The handler ran past the function timeout
+	ErrorTypeExit          = "exit"                // The process exited with a nonzero status without reporting an error
+)
+
+// Reason codes reported in ExecutionResult.ReasonCode, telling how the
+// function process ended when it didn't exit cleanly
+const (
+	ReasonTimeout     = "TIMEOUT"      // Killed after running past the function timeout
+	ReasonOOM         = "OOM"          // Exceeded the function's memory limit
+	ReasonExitNonzero = "EXIT_NONZERO" // Exited with a nonzero status
+)
+
+// reasonCode maps an execution failure category to its reason code. Setup
+// errors happen before the function process starts and have none.
+func reasonCode(errorType string) string {
+	switch errorType {
+	case "", ErrorTypeSetup:
+		return ""
+	case ErrorTypeTimeout:
+		return ReasonTimeout
+	case ErrorTypeOOM:
+		return ReasonOOM
+	default:
+		return ReasonExitNonzero
+	}
+}
+
+// ExecutionError is a failed execution together with its ErrorType category
+type ExecutionError struct {
+	Type string
+	Err  error
+}
+
+func (e *ExecutionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExecutionError) Unwrap() error {
+	return e.Err
+}
+
+// Executor executes functions in subprocesses
+type Executor struct {
+	// BaseDir is the directory under which per-request directories are created
+	BaseDir string
+	// MaxOutputBytes caps the captured stdout and stderr of each execution
+	MaxOutputBytes int
+	// MaxArtifactBytes caps the total size of the artifacts collected from
+	// an execution's output directory
+	MaxArtifactBytes int64
+	// MaxScratchBytes caps the size of a function's scratch directory; one
+	// that grows past it is cleared after the execution
+	MaxScratchBytes int64
+	// DataDir, if set, is where the VM's read-only data drive is mounted;
+	// handlers find it in the DATA_DIR environment variable
+	DataDir string
+	// InstallRetries is how many times a failed ensurepip or pip install is
+	// retried; InstallBackoff is the delay before the first retry
+	InstallRetries int
+	InstallBackoff time.Duration
+	// Stderr, if set, receives a copy of the function's stderr as it runs
+	Stderr io.Writer
+	// OnChunk, if set, receives each item a generator handler yields as
+	// soon as it is yielded, numbered from 0
+	OnChunk func(payload *FunctionPayload, seq int, data json.RawMessage)
+	// Logger receives progress messages
+	Logger *log.Logger
+}
+
+// New creates an executor that works under baseDir and logs to the standard logger
+func New(baseDir string, maxOutputBytes int) *Executor {
+	return &Executor{
+		BaseDir:          baseDir,
+		MaxOutputBytes:   maxOutputBytes,
+		MaxArtifactBytes: DefaultMaxArtifactBytes,
+		MaxScratchBytes:  DefaultMaxScratchBytes,
+		InstallRetries:   DefaultInstallRetries,
+		InstallBackoff:   DefaultInstallBackoff,
+		Logger:           log.Default(),
+	}
+}
+
+// FunctionPayload represents the code and metadata to be executed
+type FunctionPayload struct {
+	FunctionID   string                 `json:"function_id"`
+	Name         string                 `json:"name"`
+	Code         string                 `json:"code"`         // Function code
+	Requirements string                 `json:"requirements"` // Python requirements
+	Config       string                 `json:"config"`       // Function configuration
+	Runtime      string                 `json:"runtime"`      // e.g., "python3.9"
+	EntryPoint   string                 `json:"entry_point"`  // e.g., "handler.handler"
+	Environment  map[string]string      `json:"environment"`  // Environment variables
+	RequestID    string                 `json:"request_id"`   // Unique ID for this execution request
+	Timeout      int                    `json:"timeout"`      // Execution timeout in seconds
+	Memory       int                    `json:"memory"`       // Memory limit in MB
+	Version      string                 `json:"version"`      // Function version
+	Files        map[string]string      `json:"files"`        // Additional files keyed by relative path
+	Input        map[string]interface{} `json:"input"`        // Legacy input parameter (for backward compatibility)
+	Event        map[string]interface{} `json:"event"`        // Lambda-style event parameter
+	Context      map[string]interface{} `json:"context"`      // Lambda-style context parameter
+	Scratch      bool                   `json:"scratch"`      // Give the handler a SCRATCH_DIR kept between executions
+}
+
+// ExecutionResult represents the result of function execution.
+// It mirrors types.ExecutionResult in the control plane and must stay in sync with it.
+type ExecutionResult struct {
+	RequestID    string          `json:"request_id"`
+	FunctionID   string          `json:"function_id"`
+	StatusCode   int             `json:"status_code"`
+	Output       json.RawMessage `json:"output,omitempty"` // JSON value returned by the function
+	ErrorMessage string          `json:"error_message,omitempty"`
+	ErrorType    string          `json:"error_type,omitempty"`  // One of the ErrorType* categories
+	ReasonCode   string          `json:"reason_code,omitempty"` // One of the Reason* codes
+	Duration     int64           `json:"duration_ms"`
+	SetupMs      int64           `json:"setup_ms"`   // Time spent writing the code and installing dependencies
+	HandlerMs    int64           `json:"handler_ms"` // Time the handler process ran
+	MemoryUsage  int64           `json:"memory_usage_kb,omitempty"`
+	Logs         string          `json:"logs,omitempty"`             // The function's stderr
+	Truncated    bool            `json:"output_truncated,omitempty"` // Set when stdout or stderr exceeded the output limit
+	// Artifacts holds files the handler wrote to OUTPUT_DIR, keyed by relative path
+	Artifacts          map[string][]byte `json:"artifacts,omitempty"`
+	ArtifactsTruncated bool              `json:"artifacts_truncated,omitempty"` // Set when artifacts exceeded the size cap
+}
+
+// limitedBuffer is an io.Writer that keeps at most limit bytes and silently
+// discards the rest, remembering that truncation happened
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// Write implements io.Writer. It never returns an error so the child process
+// is not killed by a broken pipe when it exceeds the limit.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.limit - b.buf.Len()
+	if remaining <= 0 {
+		b.truncated = b.truncated || len(p) > 0
+		return len(p), nil
+	}
+	if len(p) > remaining {
+		b.buf.Write(p[:remaining])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// String returns the captured output, with a marker appended if it was truncated
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + fmt.Sprintf("\n...[output truncated: exceeded %d bytes]", b.limit)
+	}
+	return b.buf.String()
+}
+
+// Execute prepares and executes the function code in a fresh directory
+// under BaseDir, which is removed once the execution finishes
+func (e *Executor) Execute(payload *FunctionPayload) *ExecutionResult {
+	startTime := time.Now()
+	result := &ExecutionResult{
+		RequestID:  payload.RequestID,
+		FunctionID: payload.FunctionID,
+		StatusCode: 500, // Default to error
+	}
+
+	e.Logger.Printf("Starting execution of function %s (ID: %s)", payload.Name, payload.RequestID)
+
+	rt, err := LookupRuntime(payload.Runtime)
+	if err != nil {
+		result.ErrorMessage = fmt.Sprintf("Execution error: %v", err)
+		return result
+	}
+
+	// Create a directory for this execution
+	execDir := filepath.Join(e.BaseDir, payload.RequestID)
+	if err := os.MkdirAll(execDir, 0755); err != nil {
+		result.ErrorMessage = fmt.Sprintf("Failed to create execution directory: %v", err)
+		return result
+	}
+	defer os.RemoveAll(execDir) // Clean up after execution
+
+	// Write function code and requirements
+	setupStart := time.Now()
+	pythonInterpreter, err := e.prepare(payload, rt, execDir)
+	result.SetupMs = time.Since(setupStart).Milliseconds()
+	if err != nil {
+		result.ErrorMessage = fmt.Sprintf("Failed to prepare function: %v", err)
+		result.ErrorType = ErrorTypeSetup
+		return result
+	}
+
+	// Set up the scratch directory of functions that asked for one
+	var scratchDir string
+	if payload.Scratch {
+		if scratchDir, err = e.scratchDir(payload); err != nil {
+			result.ErrorMessage = fmt.Sprintf("Failed to prepare function: %v", err)
+			result.ErrorType = ErrorTypeSetup
+			return result
+		}
+	}
+
+	// Execute the function
+	handlerStart := time.Now()
+	output, logs, truncated, err := e.run(payload, rt, execDir, pythonInterpreter, scratchDir)
+	result.HandlerMs = time.Since(handlerStart).Milliseconds()
+	if scratchDir != "" {
+		e.trimScratch(scratchDir)
+	}
+	duration := time.Since(startTime).Milliseconds()
+
+	result.Duration = duration
+	result.Logs = logs
+	result.Truncated = truncated
+	if err != nil {
+		result.ErrorMessage = fmt.Sprintf("Execution error: %v", err)
+		var execErr *ExecutionError
+		if errors.As(err, &execErr) {
+			result.ErrorType = execErr.Type
+		}
+		result.ReasonCode = reasonCode(result.ErrorType)
+		result.Output = outputToJSON(output) // Include any partial output
+		e.Logger.Printf("Function execution failed: %v", err)
+	} else {
+		result.StatusCode = 200
+		result.Output = outputToJSON(output)
+		e.Logger.Printf("Function execution completed successfully in %d ms", duration)
+	}
+
+	// Collect artifacts even from failed runs, they may help debugging
+	artifacts, artifactsTruncated, err := collectArtifacts(filepath.Join(execDir, OutputDirName), e.MaxArtifactBytes)
+	if err != nil {
+		e.Logger.Printf("Failed to collect artifacts: %v", err)
+	}
+	if artifactsTruncated {
+		e.Logger.Printf("Artifacts of request %s exceeded %d bytes and were truncated", payload.RequestID, e.MaxArtifactBytes)
+	}
+	result.Artifacts = artifacts
+	result.ArtifactsTruncated = artifactsTruncated
+
+	// Track memory usage if available
+	// This is a placeholder - in a real implementation, you would measure actual memory usage
+	result.MemoryUsage = 0
+
+	return result
+}
+
+// prepare writes the function code and requirements to disk and returns the
+// Python interpreter to run the handler with
+func (e *Executor) prepare(payload *FunctionPayload, rt *Runtime, execDir string) (string, error) {
+	if err := writeFunctionFiles(payload, rt, execDir); err != nil {
+		return "", err
+	}
+
+	// Create the directory the handler writes artifacts to
+	if err := os.MkdirAll(filepath.Join(execDir, OutputDirName), 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	// Install requirements into a cached virtual environment
+	venvPath, cached, err := e.venv(payload)
+	if err != nil {
+		return "", err
+	}
+	if venvPath == "" {
+		return "python3", nil
+	}
+	if cached {
+		e.Logger.Printf("Reusing virtual environment %s", filepath.Base(venvPath))
+	}
+	return filepath.Join(venvPath, "bin", "python"), nil
+}
+
+// writeFunctionFiles writes the function's code, requirements, config and
+// additional files into execDir, the code going to the runtime's handler file
+func writeFunctionFiles(payload *FunctionPayload, rt *Runtime, execDir string) error {
+	// Write the handler file
+	if err := os.WriteFile(filepath.Join(execDir, rt.HandlerFile), []byte(payload.Code), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", rt.HandlerFile, err)
+	}
+
+	// Write requirements.txt
+	if err := os.WriteFile(filepath.Join(execDir, "requirements.txt"), []byte(payload.Requirements), 0644); err != nil {
+		return fmt.Errorf("failed to write requirements.txt: %v", err)
+	}
+
+	// Write config file
+	if err := os.WriteFile(filepath.Join(execDir, "faas.yaml"), []byte(payload.Config), 0644); err != nil {
+		return fmt.Errorf("failed to write faas.yaml: %v", err)
+	}
+
+	// Write additional source and data files
+	for name, content := range payload.Files {
+		path := filepath.Join(execDir, filepath.FromSlash(name))
+		if rel, err := filepath.Rel(execDir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid file path: %s", name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// run executes the function with the given runtime, using the given Python
+// interpreter for Python runtimes. It returns the function's stdout and
+// stderr; the flag reports whether either had to be truncated.
+func (e *Executor) run(payload *FunctionPayload, rt *Runtime, execDir, pythonInterpreter, scratchDir string) (string, string, bool, error) {
+	var cmd *exec.Cmd
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(payload.Timeout)*time.Second)
+	defer cancel()
+
+	switch rt.Language {
+	case languagePython:
+		// Parse entry point (format: "file.function")
+		entryPoint := rt.entryPoint(payload)
+
+		parts := strings.Split(entryPoint, ".")
+		if len(parts) != 2 {
+			return "", "", false, fmt.Errorf("invalid entry point format: %s", entryPoint)
+		}
+
+		file, function := parts[0], parts[1]
+
+		// Use Event if available, or fall back to Input for backward compatibility
+		event := payload.Event
+		if event == nil && payload.Input != nil {
+			event = payload.Input
+		} else if event == nil {
+			event = make(map[string]interface{})
+		}
+
+		// Generate event and context JSON
+		eventJSON, err := json.Marshal(event)
+		if err != nil {
+			return "", "", false, fmt.Errorf("failed to marshal event: %v", err)
+		}
+
+		contextJSON, err := json.Marshal(handlerContext(payload))
+		if err != nil {
+			return "", "", false, fmt.Errorf("failed to marshal context: %v", err)
+		}
+
+		// Create Python script to execute the function with event and context
+		executorCode := fmt.Sprintf(`
+import sys
+import json
+import traceback
+import os
+import time
+import inspect
+
+
+# Apply the function's memory limit before any handler code runs
+%s
+
+
+def fail(error_type, e, with_traceback=True):
+    print(json.dumps({
+        "error": str(e),
+        "type": error_type,
+        "traceback": traceback.format_exc() if with_traceback else None
+    }))
+    sys.exit(1)
+
+
+# Load the handler module, telling missing dependencies apart from handler errors
+try:
+    import %s
+except ImportError as e:
+    fail("import_error", e)
+except MemoryError as e:
+    fail("oom", "out of memory")
+except Exception as e:
+    fail("exception", e)
+
+# Create Context class to emulate Lambda Context
+class LambdaContext:
+    def __init__(self, context_dict):
+        for key, value in context_dict.items():
+            setattr(self, key, value)
+        self._start_time = time.time() * 1000  # Current time in milliseconds
+    
+    def get_remaining_time_in_millis(self):
+        elapsed = (time.time() * 1000) - self._start_time
+        return max(0, int(self.remaining_time_ms - elapsed))
+
+try:
+    # Parse event and context
+    event = json.loads('''%s''')
+    context_dict = json.loads('''%s''')
+    context = LambdaContext(context_dict)
+    
+    # Execute function with event and context arguments
+    result = %s.%s(event, context)
+
+    # Stream the items of generator handlers as they are yielded; the
+    # execution's result is the list of all items
+    if inspect.isgenerator(result):
+        items = []
+        for item in result:
+            try:
+                line = json.dumps(item)
+            except (TypeError, ValueError) as e:
+                fail("serialization_error", "yielded value not JSON-serializable: " + type(item).__name__, False)
+            items.append(item)
+            print("\x1e" + line, flush=True)
+        result = items
+except MemoryError as e:
+    fail("oom", "out of memory")
+except Exception as e:
+    fail("exception", e)
+
+# Convert result to JSON string if not already a string
+if not isinstance(result, str):
+    try:
+        result = json.dumps(result)
+    except (TypeError, ValueError) as e:
+        # The encoder's traceback says nothing about the handler, so leave it out
+        fail("serialization_error", "return value not JSON-serializable: " + type(result).__name__, False)
+
+print(result)
+sys.exit(0)
+`, generateMemoryLimit(payload.Memory), file, string(eventJSON), string(contextJSON), file, function)
+
+		// Write executor script
+		if err := os.WriteFile(filepath.Join(execDir, "executor.py"), []byte(executorCode), 0644); err != nil {
+			return "", "", false, fmt.Errorf("failed to write executor.py: %v", err)
+		}
+
+		// Execute the function
+		cmd = exec.CommandContext(ctx, pythonInterpreter, filepath.Join(execDir, "executor.py"))
+	default:
+		return "", "", false, fmt.Errorf("%w: %s", ErrUnsupportedRuntime, rt.Name)
+	}
+
+	// Set working directory, the function's environment and where the
+	// handler writes artifacts. The platform's variables come last so the
+	// function's environment can't override them.
+	cmd.Dir = execDir
+	cmd.Env = functionEnv(payload.Environment)
+	cmd.Env = append(cmd.Env, "OUTPUT_DIR="+filepath.Join(execDir, OutputDirName))
+	if scratchDir != "" {
+		cmd.Env = append(cmd.Env, "SCRATCH_DIR="+scratchDir)
+	}
+	if e.DataDir != "" {
+		cmd.Env = append(cmd.Env, "DATA_DIR="+e.DataDir)
+	}
+
+	// Capture output, bounded so a chatty function can't exhaust memory
+	stdout := &limitedBuffer{limit: e.MaxOutputBytes}
+	stderr := &limitedBuffer{limit: e.MaxOutputBytes}
+	cmd.Stdout = &chunkWriter{
+		out:   stdout,
+		limit: e.MaxOutputBytes,
+		onChunk: func(seq int, data json.RawMessage) {
+			if e.OnChunk != nil {
+				e.OnChunk(payload, seq, data)
+			}
+		},
+	}
+	cmd.Stderr = stderr
+	if e.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderr, e.Stderr)
+	}
+
+	// Run the command
+	err := cmd.Run()
+	output := stdout.String()
+	truncated := stdout.truncated || stderr.truncated
+	if truncated {
+		e.Logger.Printf("Output of request %s exceeded %d bytes and was truncated", payload.RequestID, e.MaxOutputBytes)
+	}
+	if err != nil {
+		e.Logger.Printf("Execution failed: %v, output: %s, stderr: %s", err, output, stderr.String())
+		return output, stderr.String(), truncated, &ExecutionError{
+			Type: failureType(ctx, output, cmd.ProcessState),
+			Err:  fmt.Errorf("execution failed: %v, stderr: %s", err, stderr.String()),
+		}
+	}
+	e.Logger.Printf("Execution succeeded: %s", output)
+	return output, stderr.String(), truncated, nil
+}
+
+// handlerContext returns the fields of the Lambda-style context object passed
+// to the handler: payload.Context, with the fields the object relies on taken
+// from the payload where the caller left them out
+func handlerContext(payload *FunctionPayload) map[string]interface{} {
+	fields := make(map[string]interface{}, len(payload.Context)+5)
+	for key, value := range payload.Context {
+		fields[key] = value
+	}
+
+	defaults := map[string]interface{}{
+		"request_id":        payload.RequestID,
+		"function_name":     payload.Name,
+		"function_version":  payload.Version,
+		"memory_limit_mb":   payload.Memory,
+		"remaining_time_ms": payload.Timeout * 1000,
+	}
+	for key, value := range defaults {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// failureType categorizes a failed run. Errors caught by the executor script
+// are reported as its last line of output; anything else is a timeout, a
+// kill by the kernel OOM killer or an unexpected exit.
+func failureType(ctx context.Context, output string, processState *os.ProcessState) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrorTypeTimeout
+	}
+	if processState != nil {
+		if status, ok := processState.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
+			return ErrorTypeOOM
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	var reported struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal([]byte(lines[len(lines)-1]), &reported) == nil {
+		switch reported.Type {
+		case ErrorTypeImport, ErrorTypeException, ErrorTypeSerialization, ErrorTypeOOM:
+			return reported.Type
+		}
+	}
+	return ErrorTypeExit
+}
+
+// generateMemoryLimit generates Python code capping the process address
+// space at memoryMB, so allocations past the limit raise MemoryError.
+// A zero limit leaves the process bounded only by the VM's memory.
+func generateMemoryLimit(memoryMB int) string {
+	if memoryMB <= 0 {
+		return "pass"
+	}
+	limit := int64(memoryMB) << 20
+	return fmt.Sprintf("import resource\nresource.setrlimit(resource.RLIMIT_AS, (%d, %d))", limit, limit)
+}
+
+// functionEnv returns the environment of a function process: the daemon's
+// own environment with the function's variables added or overriding it
+func functionEnv(env map[string]string) []string {
+	vars := os.Environ()
+	for name, value := range env {
+		vars = append(vars, name+"="+value)
+	}
+	return vars
+}
+
+// outputToJSON converts the function's stdout into a JSON output value,
+// wrapping anything that isn't valid JSON in a {"result": ...} object
+func outputToJSON(output string) json.RawMessage {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return nil
+	}
+	if json.Valid([]byte(output)) {
+		return json.RawMessage(output)
+	}
+	wrapped, _ := json.Marshal(map[string]string{"result": output})
+	return wrapped
+}
@@ -0,0 +1,492 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// newTestExecutor returns an executor working in a temporary directory
+func newTestExecutor(t *testing.T) *Executor {
+	t.Helper()
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+	e := New(t.TempDir(), 1<<20)
+	e.Logger = log.New(io.Discard, "", 0)
+	return e
+}
+
+// envHandler returns the environment variables named in the event
+const envHandler = `
+import os
+
+def handler(event, context):
+    return {name: os.environ.get(name) for name in event["names"]}
+`
+
+func TestExecuteEnvironment(t *testing.T) {
+	e := newTestExecutor(t)
+
+	env := map[string]string{
+		"DEBUG":  "1",
+		"QUOTED": `it's "quoted"`,
+		"LINES":  "first\nsecond'''\nthird",
+		// The platform's variables can't be overridden
+		"OUTPUT_DIR": "/elsewhere",
+	}
+	result := e.Execute(&FunctionPayload{
+		FunctionID:  "f",
+		RequestID:   "env",
+		Runtime:     "python3",
+		Code:        envHandler,
+		Environment: env,
+		Timeout:     30,
+		Event:       map[string]interface{}{"names": []string{"DEBUG", "QUOTED", "LINES", "OUTPUT_DIR"}},
+	})
+	if result.StatusCode != 200 {
+		t.Fatalf("execution failed: %s (%s)\n%s", result.ErrorMessage, result.ErrorType, result.Logs)
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal(result.Output, &got); err != nil {
+		t.Fatalf("invalid output %s: %v", result.Output, err)
+	}
+	for _, name := range []string{"DEBUG", "QUOTED", "LINES"} {
+		if got[name] != env[name] {
+			t.Errorf("%s = %q, want %q", name, got[name], env[name])
+		}
+	}
+	if got["OUTPUT_DIR"] == env["OUTPUT_DIR"] {
+		t.Errorf("OUTPUT_DIR was overridden by the function's environment")
+	}
+}
+
+func TestExecuteWithoutEnvironment(t *testing.T) {
+	e := newTestExecutor(t)
+
+	result := e.Execute(&FunctionPayload{
+		FunctionID: "f",
+		RequestID:  "no-env",
+		Runtime:    "python3",
+		Code:       envHandler,
+		Timeout:    30,
+		Event:      map[string]interface{}{"names": []string{"DEBUG"}},
+	})
+	if result.StatusCode != 200 {
+		t.Fatalf("execution failed: %s (%s)\n%s", result.ErrorMessage, result.ErrorType, result.Logs)
+	}
+	if string(result.Output) != `{"DEBUG": null}` {
+		t.Errorf("output = %s, want DEBUG unset", result.Output)
+	}
+}
+
+func TestExecuteDataDir(t *testing.T) {
+	for _, dataDir := range []string{"", "/mnt/data"} {
+		e := newTestExecutor(t)
+		e.DataDir = dataDir
+
+		result := e.Execute(&FunctionPayload{
+			FunctionID: "f",
+			RequestID:  "data-dir",
+			Runtime:    "python3",
+			Code:       envHandler,
+			Timeout:    30,
+			Event:      map[string]interface{}{"names": []string{"DATA_DIR"}},
+		})
+		if result.StatusCode != 200 {
+			t.Fatalf("execution failed: %s (%s)\n%s", result.ErrorMessage, result.ErrorType, result.Logs)
+		}
+		var got map[string]*string
+		if err := json.Unmarshal(result.Output, &got); err != nil {
+			t.Fatalf("invalid output %s: %v", result.Output, err)
+		}
+		switch {
+		case dataDir == "" && got["DATA_DIR"] != nil:
+			t.Errorf("DATA_DIR = %q without a data drive", *got["DATA_DIR"])
+		case dataDir != "" && (got["DATA_DIR"] == nil || *got["DATA_DIR"] != dataDir):
+			t.Errorf("DATA_DIR = %v, want %s", got["DATA_DIR"], dataDir)
+		}
+	}
+}
+
+// chattyHandler writes more than the output limit to stderr and returns a
+// value larger than it
+const chattyHandler = `
+import sys
+
+def handler(event, context):
+    sys.stderr.write("e" * event["size"])
+    return "o" * event["size"]
+`
+
+func TestExecuteOutputLimit(t *testing.T) {
+	e := newTestExecutor(t)
+	e.MaxOutputBytes = 1024
+
+	result := e.Execute(&FunctionPayload{
+		FunctionID: "f",
+		RequestID:  "chatty",
+		Runtime:    "python3",
+		Code:       chattyHandler,
+		Timeout:    30,
+		Event:      map[string]interface{}{"size": 64 * 1024},
+	})
+	if !result.Truncated {
+		t.Fatalf("output wasn't reported as truncated: %s (%s)", result.ErrorMessage, result.ErrorType)
+	}
+	marker := 100 // Room for the truncation marker
+	if len(result.Logs) > e.MaxOutputBytes+marker {
+		t.Errorf("kept %d bytes of stderr, want at most %d", len(result.Logs), e.MaxOutputBytes)
+	}
+	if len(result.Output) > 2*(e.MaxOutputBytes+marker) {
+		t.Errorf("kept %d bytes of output, want about %d", len(result.Output), e.MaxOutputBytes)
+	}
+	if !strings.Contains(result.Logs, "output truncated") {
+		t.Errorf("stderr has no truncation marker: %q", result.Logs[len(result.Logs)-60:])
+	}
+}
+
+func TestExecuteOutputWithinLimit(t *testing.T) {
+	e := newTestExecutor(t)
+	e.MaxOutputBytes = 1024
+
+	result := e.Execute(&FunctionPayload{
+		FunctionID: "f",
+		RequestID:  "quiet",
+		Runtime:    "python3",
+		Code:       chattyHandler,
+		Timeout:    30,
+		Event:      map[string]interface{}{"size": 10},
+	})
+	if result.StatusCode != 200 || result.Truncated {
+		t.Fatalf("got status %d, truncated %v: %s", result.StatusCode, result.Truncated, result.ErrorMessage)
+	}
+	if string(result.Output) != `{"result":"oooooooooo"}` || result.Logs != "eeeeeeeeee" {
+		t.Errorf("output = %s, logs = %q", result.Output, result.Logs)
+	}
+}
+
+func TestExecuteNonSerializableResult(t *testing.T) {
+	e := newTestExecutor(t)
+	result := e.Execute(&FunctionPayload{
+		FunctionID: "f",
+		RequestID:  "req-1",
+		Runtime:    "python3",
+		Code:       "class Point:\n    pass\n\ndef handler(event, context):\n    return Point()\n",
+		Timeout:    30,
+	})
+	if result.StatusCode == 200 {
+		t.Fatalf("execution succeeded with output %s", result.Output)
+	}
+	report := result.ErrorMessage + string(result.Output)
+	if !strings.Contains(report, "return value not JSON-serializable: Point") {
+		t.Errorf("error = %s, want the return value's type named", report)
+	}
+	// The encoder's traceback is left out
+	if strings.Contains(report, "json/encoder.py") {
+		t.Errorf("error includes the encoder traceback: %s", report)
+	}
+}
+
+func TestExecuteReasonCodes(t *testing.T) {
+	e := newTestExecutor(t)
+
+	tests := []struct {
+		name    string
+		code    string
+		files   map[string]string
+		memory  int
+		timeout int
+		want    string
+	}{
+		{
+			name:    "timeout",
+			code:    "import time\n\ndef handler(event, context):\n    time.sleep(30)\n",
+			timeout: 1,
+			want:    ReasonTimeout,
+		},
+		{
+			name:   "out of memory",
+			code:   "def handler(event, context):\n    return len(bytearray(512 * 1024 * 1024))\n",
+			memory: 128,
+			want:   ReasonOOM,
+		},
+		{
+			name: "nonzero exit",
+			code: "import os\n\ndef handler(event, context):\n    os._exit(3)\n",
+			want: ReasonExitNonzero,
+		},
+		{
+			name: "uncaught exception",
+			code: "def handler(event, context):\n    raise ValueError('boom')\n",
+			want: ReasonExitNonzero,
+		},
+		{
+			name:  "setup failure has none",
+			code:  "def handler(event, context):\n    return 1\n",
+			files: map[string]string{"../escape.txt": "x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = 30
+			}
+			result := e.Execute(&FunctionPayload{
+				FunctionID: "f",
+				RequestID:  strings.ReplaceAll(tt.name, " ", "-"),
+				Runtime:    "python3",
+				Code:       tt.code,
+				Files:      tt.files,
+				Memory:     tt.memory,
+				Timeout:    timeout,
+			})
+			if result.StatusCode == 200 {
+				t.Fatalf("execution succeeded with output %s", result.Output)
+			}
+			if result.ReasonCode != tt.want {
+				t.Errorf("reason code = %q, want %q: %s", result.ReasonCode, tt.want, result.ErrorMessage)
+			}
+		})
+	}
+}
+
+func TestFailureTypeKilled(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	// A process killed without running past its timeout was OOM-killed
+	cmd := exec.Command("sh", "-c", "kill -9 $$")
+	if err := cmd.Run(); err == nil {
+		t.Fatal("killed process exited cleanly")
+	}
+	if got := failureType(context.Background(), "", cmd.ProcessState); got != ErrorTypeOOM {
+		t.Errorf("failureType() = %q, want %q", got, ErrorTypeOOM)
+	}
+}
+
+func TestExecuteErrorTypes(t *testing.T) {
+	e := newTestExecutor(t)
+
+	tests := []struct {
+		name    string
+		code    string
+		files   map[string]string
+		timeout int
+		want    string
+	}{
+		{
+			name: "missing dependency",
+			code: "import not_a_real_module\n\ndef handler(event, context):\n    return 1\n",
+			want: ErrorTypeImport,
+		},
+		{
+			name: "uncaught exception",
+			code: "def handler(event, context):\n    raise ValueError('boom')\n",
+			want: ErrorTypeException,
+		},
+		{
+			name: "non-serializable return value",
+			code: "def handler(event, context):\n    return {1, 2}\n",
+			want: ErrorTypeSerialization,
+		},
+		{
+			name:    "timeout",
+			code:    "import time\n\ndef handler(event, context):\n    time.sleep(30)\n",
+			timeout: 1,
+			want:    ErrorTypeTimeout,
+		},
+		{
+			name: "nonzero exit",
+			code: "import os\n\ndef handler(event, context):\n    os._exit(3)\n",
+			want: ErrorTypeExit,
+		},
+		{
+			name:  "setup failure",
+			code:  "def handler(event, context):\n    return 1\n",
+			files: map[string]string{"../escape.txt": "x"},
+			want:  ErrorTypeSetup,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = 30
+			}
+			result := e.Execute(&FunctionPayload{
+				FunctionID: "f",
+				RequestID:  strings.ReplaceAll(tt.name, " ", "-"),
+				Runtime:    "python3",
+				Code:       tt.code,
+				Files:      tt.files,
+				Timeout:    timeout,
+			})
+			if result.StatusCode == 200 {
+				t.Fatalf("execution succeeded with output %s", result.Output)
+			}
+			if result.ErrorType != tt.want {
+				t.Errorf("error type = %q, want %q: %s", result.ErrorType, tt.want, result.ErrorMessage)
+			}
+		})
+	}
+}
+
+// artifactHandler writes a report of the given size to OUTPUT_DIR
+const artifactHandler = `
+import os
+
+def handler(event, context):
+    with open(os.path.join(os.environ["OUTPUT_DIR"], "report.txt"), "w") as f:
+        f.write("r" * event["size"])
+    return "done"
+`
+
+func TestExecuteArtifacts(t *testing.T) {
+	e := newTestExecutor(t)
+
+	result := e.Execute(&FunctionPayload{
+		FunctionID: "f",
+		RequestID:  "artifact",
+		Runtime:    "python3",
+		Code:       artifactHandler,
+		Timeout:    30,
+		Event:      map[string]interface{}{"size": 5},
+	})
+	if result.StatusCode != 200 {
+		t.Fatalf("execution failed: %s (%s)\n%s", result.ErrorMessage, result.ErrorType, result.Logs)
+	}
+	if len(result.Artifacts) != 1 || string(result.Artifacts["report.txt"]) != "rrrrr" {
+		t.Errorf("artifacts = %q, want report.txt", result.Artifacts)
+	}
+	if result.ArtifactsTruncated {
+		t.Error("artifacts reported as truncated")
+	}
+}
+
+func TestExecuteArtifactsOverCap(t *testing.T) {
+	e := newTestExecutor(t)
+	e.MaxArtifactBytes = 1024
+
+	result := e.Execute(&FunctionPayload{
+		FunctionID: "f",
+		RequestID:  "large-artifact",
+		Runtime:    "python3",
+		Code:       artifactHandler,
+		Timeout:    30,
+		Event:      map[string]interface{}{"size": 4096},
+	})
+	if result.StatusCode != 200 {
+		t.Fatalf("execution failed: %s (%s)\n%s", result.ErrorMessage, result.ErrorType, result.Logs)
+	}
+	if len(result.Artifacts) != 0 || !result.ArtifactsTruncated {
+		t.Errorf("got %d artifacts, truncated %v; want none and truncated", len(result.Artifacts), result.ArtifactsTruncated)
+	}
+}
+
+func TestExecuteWithoutArtifacts(t *testing.T) {
+	e := newTestExecutor(t)
+
+	result := e.Execute(&FunctionPayload{
+		FunctionID: "f",
+		RequestID:  "no-artifacts",
+		Runtime:    "python3",
+		Code:       envHandler,
+		Timeout:    30,
+		Event:      map[string]interface{}{"names": []string{}},
+	})
+	if result.StatusCode != 200 {
+		t.Fatalf("execution failed: %s (%s)", result.ErrorMessage, result.ErrorType)
+	}
+	if result.Artifacts != nil {
+		t.Errorf("artifacts = %q, want none", result.Artifacts)
+	}
+}
+
+func TestExecuteTimingPhases(t *testing.T) {
+	e := newTestExecutor(t)
+	result := e.Execute(&FunctionPayload{
+		FunctionID: "f",
+		RequestID:  "req-1",
+		Runtime:    "python3",
+		Code:       "import time\n\ndef handler(event, context):\n    time.sleep(0.2)\n    return {}\n",
+		Timeout:    30,
+	})
+	if result.StatusCode != 200 {
+		t.Fatalf("execution failed: %s (%s)\n%s", result.ErrorMessage, result.ErrorType, result.Logs)
+	}
+	if result.HandlerMs < 200 {
+		t.Errorf("handler ran for %dms, want at least the 200ms it slept", result.HandlerMs)
+	}
+	if result.SetupMs+result.HandlerMs > result.Duration {
+		t.Errorf("setup of %dms and handler of %dms exceed the duration of %dms", result.SetupMs, result.HandlerMs, result.Duration)
+	}
+}
+
+// contextHandler returns the context it was called with
+const contextHandler = `
+def handler(event, context):
+    return {
+        "remaining": context.get_remaining_time_in_millis(),
+        "request_id": context.request_id,
+        "function_name": context.function_name,
+        "function_version": context.function_version,
+        "memory_limit_mb": context.memory_limit_mb,
+    }
+`
+
+func TestExecuteHandlerContext(t *testing.T) {
+	e := newTestExecutor(t)
+
+	tests := []struct {
+		name          string
+		context       map[string]interface{}
+		wantRemaining int // Upper bound of the remaining time
+	}{
+		{name: "without a context", wantRemaining: 30000},
+		{name: "partial context", context: map[string]interface{}{"remaining_time_ms": 5000}, wantRemaining: 5000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := e.Execute(&FunctionPayload{
+				FunctionID: "f",
+				Name:       "greeter",
+				Version:    "1.2.0",
+				RequestID:  "ctx-" + strings.ReplaceAll(tt.name, " ", "-"),
+				Runtime:    "python3",
+				Code:       contextHandler,
+				Memory:     256,
+				Timeout:    30,
+				Context:    tt.context,
+			})
+			if result.StatusCode != 200 {
+				t.Fatalf("execution failed: %s (%s)\n%s", result.ErrorMessage, result.ErrorType, result.Logs)
+			}
+
+			var got struct {
+				Remaining       json.Number `json:"remaining"`
+				RequestID       string      `json:"request_id"`
+				FunctionName    string      `json:"function_name"`
+				FunctionVersion string      `json:"function_version"`
+				MemoryLimitMB   int         `json:"memory_limit_mb"`
+			}
+			if err := json.Unmarshal(result.Output, &got); err != nil {
+				t.Fatalf("invalid output %s: %v", result.Output, err)
+			}
+			remaining, err := got.Remaining.Int64()
+			if err != nil || remaining <= 0 || remaining > int64(tt.wantRemaining) {
+				t.Errorf("remaining time = %s, want whole milliseconds up to %d", got.Remaining, tt.wantRemaining)
+			}
+			if got.RequestID != "ctx-"+strings.ReplaceAll(tt.name, " ", "-") || got.FunctionName != "greeter" ||
+				got.FunctionVersion != "1.2.0" || got.MemoryLimitMB != 256 {
+				t.Errorf("context = %+v, want the payload's request, function and memory", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package executor
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultInstallRetries is the default number of times a failed ensurepip
+	// or pip install step is retried
+	DefaultInstallRetries = 3
+	// DefaultInstallBackoff is the delay before the first retry; it doubles
+	// after each attempt
+	DefaultInstallBackoff = time.Second
+)
+
+// Function environment variables naming the package indexes pip installs
+// requirements from. Values may reference other environment variables of the
+// function as ${NAME}, so credentials can be injected separately from the URL.
+const (
+	EnvPipIndexURL      = "PIP_INDEX_URL"       // Replaces PyPI as the main index
+	EnvPipExtraIndexURL = "PIP_EXTRA_INDEX_URL" // Space-separated list of additional indexes
+)
+
+// pipIndexArgs builds the pip install arguments for the package indexes set
+// in the function environment. It also returns the credentials embedded in
+// the URLs, which must not appear in logs or error messages.
+func pipIndexArgs(env map[string]string) (args, secrets []string, err error) {
+	expand := func(value string) string {
+		return os.Expand(value, func(name string) string { return env[name] })
+	}
+
+	add := func(flag, variable, raw string) error {
+		index, err := url.Parse(raw)
+		if err != nil || (index.Scheme != "http" && index.Scheme != "https") || index.Host == "" {
+			return fmt.Errorf("invalid %s: must be an http or https URL", variable)
+		}
+		if index.User != nil {
+			if password, ok := index.User.Password(); ok && password != "" {
+				secrets = append(secrets, password)
+			} else if name := index.User.Username(); name != "" {
+				// A lone user name is usually a token
+				secrets = append(secrets, name)
+			}
+		}
+		args = append(args, flag, raw)
+		return nil
+	}
+
+	if value := strings.TrimSpace(expand(env[EnvPipIndexURL])); value != "" {
+		if err := add("--index-url", EnvPipIndexURL, value); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, value := range strings.Fields(expand(env[EnvPipExtraIndexURL])) {
+		if err := add("--extra-index-url", EnvPipExtraIndexURL, value); err != nil {
+			return nil, nil, err
+		}
+	}
+	return args, secrets, nil
+}
+
+// redact replaces every secret in s with "****"
+func redact(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "****")
+		// pip and net/url print credentials percent-encoded
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			s = strings.ReplaceAll(s, escaped, "****")
+		}
+	}
+	return s
+}
+
+// permanentPipErrors are pip messages for failures that retrying cannot fix,
+// such as a package or version that doesn't exist
+var permanentPipErrors = []string{
+	"No matching distribution found",
+	"Could not find a version that satisfies",
+	"ResolutionImpossible",
+	"Invalid requirement",
+	"Cannot install",
+}
+
+// isPermanentInstallError reports whether output contains a pip error that
+// won't go away on retry
+func isPermanentInstallError(output []byte) bool {
+	for _, msg := range permanentPipErrors {
+		if strings.Contains(string(output), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// runInstallStep runs the command built by newCmd, retrying up to
+// InstallRetries times with exponential backoff unless the failure is
+// permanent. The returned error carries the output of the last attempt with
+// any of the given secrets redacted.
+func (e *Executor) runInstallStep(step string, newCmd func() *exec.Cmd, secrets ...string) error {
+	backoff := e.InstallBackoff
+	for attempt := 0; ; attempt++ {
+		output, err := newCmd().CombinedOutput()
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= e.InstallRetries || isPermanentInstallError(output) {
+			return fmt.Errorf("failed to %s after %d attempt(s): %s, output: %s", step, attempt+1, redact(err.Error(), secrets), redact(string(output), secrets))
+		}
+
+		e.Logger.Printf("Failed to %s (attempt %d), retrying in %v: %s", step, attempt+1, backoff, redact(err.Error(), secrets))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
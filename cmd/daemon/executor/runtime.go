@@ -0,0 +1,74 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedRuntime is returned for payloads whose runtime has no descriptor
+var ErrUnsupportedRuntime = errors.New("unsupported runtime")
+
+// Languages a runtime can be implemented in
+const (
+	languagePython = "python"
+)
+
+// Runtime describes how functions of a runtime are laid out and started
+type Runtime struct {
+	// Name is the runtime name functions are registered with
+	Name string
+	// Language selects how the function is started
+	Language string
+	// HandlerFile is the file the function's code is written to
+	HandlerFile string
+	// DefaultEntryPoint is used when a function doesn't name an entry point
+	DefaultEntryPoint string
+}
+
+// pythonRuntime describes a Python runtime named name
+func pythonRuntime(name string) Runtime {
+	return Runtime{
+		Name:              name,
+		Language:          languagePython,
+		HandlerFile:       "handler.py",
+		DefaultEntryPoint: "handler.handler",
+	}
+}
+
+// runtimes lists the runtime descriptors, in the order they are advertised
+var runtimes = []Runtime{
+	pythonRuntime("python3"),
+	pythonRuntime("python3.9"),
+	pythonRuntime("python3.10"),
+}
+
+// SupportedRuntimes lists the runtimes Execute can run
+var SupportedRuntimes = runtimeNames()
+
+// runtimeNames returns the names of all runtime descriptors
+func runtimeNames() []string {
+	names := make([]string, len(runtimes))
+	for i, rt := range runtimes {
+		names[i] = rt.Name
+	}
+	return names
+}
+
+// LookupRuntime returns the descriptor of the named runtime
+func LookupRuntime(name string) (*Runtime, error) {
+	for i := range runtimes {
+		if runtimes[i].Name == name {
+			return &runtimes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedRuntime, name)
+}
+
+// entryPoint returns the payload's entry point, or the runtime's default if
+// the payload doesn't name one
+func (rt *Runtime) entryPoint(payload *FunctionPayload) string {
+	if payload.EntryPoint != "" {
+		return payload.EntryPoint
+	}
+	return rt.DefaultEntryPoint
+}
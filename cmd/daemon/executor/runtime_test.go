@@ -0,0 +1,72 @@
+package executor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupRuntime(t *testing.T) {
+	for _, name := range SupportedRuntimes {
+		rt, err := LookupRuntime(name)
+		if err != nil {
+			t.Fatalf("LookupRuntime(%q): %v", name, err)
+		}
+		if rt.HandlerFile != "handler.py" || rt.DefaultEntryPoint != "handler.handler" {
+			t.Errorf("%s writes %s with entry point %s, want handler.py and handler.handler", name, rt.HandlerFile, rt.DefaultEntryPoint)
+		}
+	}
+	if _, err := LookupRuntime("cobol"); !errors.Is(err, ErrUnsupportedRuntime) {
+		t.Errorf("LookupRuntime(cobol) error = %v, want %v", err, ErrUnsupportedRuntime)
+	}
+}
+
+func TestWriteFunctionFilesUsesHandlerFile(t *testing.T) {
+	runtimes := []Runtime{
+		pythonRuntime("python3"),
+		{Name: "nodejs18", Language: "node", HandlerFile: "handler.js", DefaultEntryPoint: "handler.handler"},
+	}
+	for _, rt := range runtimes {
+		t.Run(rt.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := writeFunctionFiles(&FunctionPayload{Code: "code"}, &rt, dir); err != nil {
+				t.Fatalf("writeFunctionFiles: %v", err)
+			}
+			if data, err := os.ReadFile(filepath.Join(dir, rt.HandlerFile)); err != nil || string(data) != "code" {
+				t.Errorf("%s = %q, %v; want the function's code", rt.HandlerFile, data, err)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if filepath.Ext(entry.Name()) != filepath.Ext(rt.HandlerFile) || entry.Name() == rt.HandlerFile {
+					continue
+				}
+				t.Errorf("wrote %s besides the handler file %s", entry.Name(), rt.HandlerFile)
+			}
+		})
+	}
+}
+
+func TestRuntimeEntryPoint(t *testing.T) {
+	rt := Runtime{DefaultEntryPoint: "index.main"}
+	if got := rt.entryPoint(&FunctionPayload{}); got != "index.main" {
+		t.Errorf("entryPoint() = %q without one in the payload, want the default index.main", got)
+	}
+	if got := rt.entryPoint(&FunctionPayload{EntryPoint: "app.run"}); got != "app.run" {
+		t.Errorf("entryPoint() = %q, want the payload's app.run", got)
+	}
+}
+
+func TestExecuteUnsupportedRuntime(t *testing.T) {
+	e := New(t.TempDir(), 1<<20)
+	result := e.Execute(&FunctionPayload{RequestID: "req", Runtime: "cobol", Code: "code"})
+	if result.StatusCode == 200 {
+		t.Fatal("an unsupported runtime executed")
+	}
+	if _, err := os.Stat(filepath.Join(e.BaseDir, "req")); !os.IsNotExist(err) {
+		t.Errorf("an execution directory was created for an unsupported runtime: %v", err)
+	}
+}
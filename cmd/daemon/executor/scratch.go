@@ -0,0 +1,60 @@
+package executor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ScratchDirName is the subdirectory of BaseDir holding the scratch
+// directories of functions that opted into one
+const ScratchDirName = "scratch"
+
+// DefaultMaxScratchBytes is the default cap on the size of a function's
+// scratch directory
+const DefaultMaxScratchBytes = 256 * 1024 * 1024
+
+// scratchDir returns the scratch directory of the payload's function,
+// creating it if needed. Unlike the execution directory it is kept between
+// executions, so handlers can cache downloads on the VM.
+func (e *Executor) scratchDir(payload *FunctionPayload) (string, error) {
+	id := payload.FunctionID
+	if id == "" || id == "." || id == ".." || id != filepath.Base(id) {
+		return "", fmt.Errorf("invalid function ID %q for a scratch directory", id)
+	}
+	dir := filepath.Join(e.BaseDir, ScratchDirName, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create scratch directory: %v", err)
+	}
+	return dir, nil
+}
+
+// trimScratch empties a scratch directory that has grown past
+// MaxScratchBytes, so one function can't fill the VM's disk
+func (e *Executor) trimScratch(dir string) {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	if size <= e.MaxScratchBytes {
+		return
+	}
+
+	e.Logger.Printf("Scratch directory %s holds %d bytes, over the %d byte cap; clearing it", dir, size, e.MaxScratchBytes)
+	if err := os.RemoveAll(dir); err != nil {
+		e.Logger.Printf("Failed to clear scratch directory %s: %v", dir, err)
+	}
+}
+
+// ResetScratch removes all scratch directories. The daemon calls it at
+// startup so a VM never sees scratch data left on its disk by a previous one.
+func (e *Executor) ResetScratch() error {
+	return os.RemoveAll(filepath.Join(e.BaseDir, ScratchDirName))
+}
@@ -0,0 +1,104 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// counterHandler counts its executions in a file in the scratch directory,
+// padding the file to the size given in the event. It returns 0 without a
+// scratch directory.
+const counterHandler = `
+import os
+
+def handler(event, context):
+    scratch = os.environ.get("SCRATCH_DIR")
+    if scratch is None:
+        return 0
+    path = os.path.join(scratch, "count")
+    count = 0
+    if os.path.exists(path):
+        with open(path) as f:
+            count = int(f.read().strip() or 0)
+    count += 1
+    with open(path, "w") as f:
+        f.write(str(count).ljust(event.get("pad", 0)))
+    return count
+`
+
+// countExecution runs counterHandler for function id and returns its output
+func countExecution(t *testing.T, e *Executor, id string, scratch bool, pad int) string {
+	t.Helper()
+	result := e.Execute(&FunctionPayload{
+		FunctionID: id,
+		RequestID:  fmt.Sprintf("%s-%d", id, pad),
+		Runtime:    "python3",
+		Code:       counterHandler,
+		Timeout:    30,
+		Scratch:    scratch,
+		Event:      map[string]interface{}{"pad": pad},
+	})
+	if result.StatusCode != 200 {
+		t.Fatalf("execution failed: %s (%s)\n%s", result.ErrorMessage, result.ErrorType, result.Logs)
+	}
+	return strings.TrimSpace(string(result.Output))
+}
+
+func TestScratchPersistsBetweenExecutions(t *testing.T) {
+	e := newTestExecutor(t)
+
+	if got := countExecution(t, e, "f", true, 0); got != "1" {
+		t.Fatalf("first execution = %s, want 1", got)
+	}
+	if got := countExecution(t, e, "f", true, 0); got != "2" {
+		t.Errorf("second execution = %s, want 2 from the data the first left", got)
+	}
+	// Each function has its own scratch directory
+	if got := countExecution(t, e, "g", true, 0); got != "1" {
+		t.Errorf("another function's execution = %s, want 1", got)
+	}
+}
+
+func TestScratchIsOptIn(t *testing.T) {
+	e := newTestExecutor(t)
+	if got := countExecution(t, e, "f", false, 0); got != "0" {
+		t.Errorf("execution = %s, want no SCRATCH_DIR", got)
+	}
+	if _, err := os.Stat(filepath.Join(e.BaseDir, ScratchDirName)); !os.IsNotExist(err) {
+		t.Errorf("a scratch directory was created without opting in: %v", err)
+	}
+}
+
+func TestScratchClearedOverCap(t *testing.T) {
+	e := newTestExecutor(t)
+	e.MaxScratchBytes = 1024
+
+	countExecution(t, e, "f", true, 2048)
+	if got := countExecution(t, e, "f", true, 0); got != "1" {
+		t.Errorf("execution after exceeding the cap = %s, want 1 from a cleared directory", got)
+	}
+}
+
+func TestResetScratch(t *testing.T) {
+	e := newTestExecutor(t)
+	countExecution(t, e, "f", true, 0)
+
+	if err := e.ResetScratch(); err != nil {
+		t.Fatalf("ResetScratch: %v", err)
+	}
+	if got := countExecution(t, e, "f", true, 0); got != "1" {
+		t.Errorf("execution after a reset = %s, want 1", got)
+	}
+}
+
+func TestScratchDirRejectsPaths(t *testing.T) {
+	e := New(t.TempDir(), 1<<20)
+	for _, id := range []string{"", ".", "..", "../escape", "a/b"} {
+		if dir, err := e.scratchDir(&FunctionPayload{FunctionID: id}); err == nil {
+			t.Errorf("scratchDir(%q) = %s, want an error", id, dir)
+		}
+	}
+}
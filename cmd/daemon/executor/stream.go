@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// chunkMarker starts the stdout lines on which the executor script reports
+// the items a generator handler yields
+const chunkMarker = '\x1e'
+
+// Chunk is an item yielded by a generator handler, reported while the
+// handler runs. It mirrors types.StreamChunk in the control plane.
+type Chunk struct {
+	RequestID  string          `json:"request_id"`
+	FunctionID string          `json:"function_id"`
+	Seq        int             `json:"seq"` // Position of the item, from 0
+	Data       json.RawMessage `json:"data"`
+}
+
+// chunkWriter passes the function's stdout through to out, except for the
+// lines starting with chunkMarker, which it hands to onChunk as they
+// complete. A chunk longer than limit is dropped.
+type chunkWriter struct {
+	out     io.Writer
+	onChunk func(seq int, data json.RawMessage)
+	limit   int
+
+	line    []byte // Chunk line read so far
+	inChunk bool   // Whether the current line is a chunk line
+	midLine bool   // Whether a line has been started
+	dropped bool   // Whether the current chunk exceeded limit
+	seq     int
+}
+
+// Write implements io.Writer. Like limitedBuffer it never fails, so the
+// child process is not killed by a broken pipe.
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if !w.midLine {
+			w.midLine = true
+			w.inChunk = p[0] == chunkMarker
+		}
+
+		end := bytes.IndexByte(p, '\n') + 1
+		if end == 0 {
+			end = len(p)
+		}
+
+		if !w.inChunk {
+			w.out.Write(p[:end])
+		} else if len(w.line)+end > w.limit {
+			w.dropped = true
+		} else if !w.dropped {
+			w.line = append(w.line, p[:end]...)
+		}
+
+		if p[end-1] == '\n' {
+			w.endLine()
+		}
+		p = p[end:]
+	}
+	return n, nil
+}
+
+// endLine reports a completed chunk line and starts a new line
+func (w *chunkWriter) endLine() {
+	if w.inChunk {
+		if !w.dropped && w.onChunk != nil {
+			data := bytes.TrimSpace(w.line[1:])
+			w.onChunk(w.seq, json.RawMessage(append([]byte(nil), data...)))
+		}
+		w.seq++
+	}
+	w.line = w.line[:0]
+	w.midLine, w.inChunk, w.dropped = false, false, false
+}
@@ -0,0 +1,103 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestChunkWriter(t *testing.T) {
+	var out bytes.Buffer
+	var chunks, seqs []string
+	w := &chunkWriter{
+		out:   &out,
+		limit: 16,
+		onChunk: func(seq int, data json.RawMessage) {
+			chunks = append(chunks, string(data))
+			seqs = append(seqs, strconv.Itoa(seq))
+		},
+	}
+
+	// Chunk lines may arrive split over several writes
+	for _, p := range []string{"log line\n\x1e{\"a\"", ":1}\n", "\x1e2\nanother", " log line\n", "\x1e\"far too long for the limit\"\n", "\x1e3\n"} {
+		if n, err := w.Write([]byte(p)); n != len(p) || err != nil {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+	}
+
+	if got := strings.Join(chunks, ","); got != `{"a":1},2,3` {
+		t.Errorf("chunks = %s, want the items within the limit", got)
+	}
+	// A dropped chunk keeps its position
+	if got := strings.Join(seqs, ","); got != "0,1,3" {
+		t.Errorf("chunks numbered %s, want 0,1,3", got)
+	}
+	if out.String() != "log line\nanother log line\n" {
+		t.Errorf("output = %q, want the lines that aren't chunks", out.String())
+	}
+}
+
+// generatorHandler yields the numbers up to the one in the event
+const generatorHandler = `
+def handler(event, context):
+    for i in range(event["n"]):
+        yield {"token": i}
+`
+
+func TestExecuteGenerator(t *testing.T) {
+	e := newTestExecutor(t)
+	var mu sync.Mutex
+	var chunks []string
+	e.OnChunk = func(payload *FunctionPayload, seq int, data json.RawMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		if payload.RequestID != "req-1" || seq != len(chunks) {
+			t.Errorf("chunk %d of request %s, want %d of req-1", seq, payload.RequestID, len(chunks))
+		}
+		chunks = append(chunks, string(data))
+	}
+
+	result := e.Execute(&FunctionPayload{
+		FunctionID: "f",
+		RequestID:  "req-1",
+		Runtime:    "python3",
+		Code:       generatorHandler,
+		Timeout:    30,
+		Event:      map[string]interface{}{"n": 3},
+	})
+	if result.StatusCode != 200 {
+		t.Fatalf("execution failed: %s (%s)\n%s", result.ErrorMessage, result.ErrorType, result.Logs)
+	}
+	if got := strings.Join(chunks, ","); got != `{"token": 0},{"token": 1},{"token": 2}` {
+		t.Errorf("chunks = %s", got)
+	}
+
+	// The result is the list of every item
+	var items []map[string]int
+	if err := json.Unmarshal(result.Output, &items); err != nil || len(items) != 3 || items[2]["token"] != 2 {
+		t.Errorf("output = %s, want the three items: %v", result.Output, err)
+	}
+	if strings.Contains(result.Logs, "\x1e") {
+		t.Errorf("logs include the chunk lines: %q", result.Logs)
+	}
+}
+
+func TestExecuteReturnSendsNoChunks(t *testing.T) {
+	e := newTestExecutor(t)
+	e.OnChunk = func(payload *FunctionPayload, seq int, data json.RawMessage) {
+		t.Errorf("handler returning a value sent chunk %s", data)
+	}
+	result := e.Execute(&FunctionPayload{
+		FunctionID: "f",
+		RequestID:  "req-1",
+		Runtime:    "python3",
+		Code:       "def handler(event, context):\n    return [1, 2]\n",
+		Timeout:    30,
+	})
+	if result.StatusCode != 200 || strings.ReplaceAll(string(result.Output), " ", "") != "[1,2]" {
+		t.Errorf("execution = %d %s, want the returned list", result.StatusCode, result.Output)
+	}
+}
@@ -0,0 +1,141 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrInvalidEntryPoint is returned when a function's entry point doesn't
+// name a callable accepting (event, context)
+var ErrInvalidEntryPoint = errors.New("invalid entry point")
+
+// validateTimeout bounds how long the entry point probe may run
+const validateTimeout = 10 * time.Second
+
+// probeScript checks that an entry point names a callable accepting
+// (event, context). It imports the module and inspects the callable; if the
+// module can't be imported because a dependency isn't installed, the probe
+// falls back to a static check of the source so validation does not require
+// installing requirements.
+const probeScript = `
+import ast
+import importlib
+import inspect
+import json
+import sys
+
+module_name, func_name = sys.argv[1], sys.argv[2]
+entry = module_name + "." + func_name
+
+
+def result(error=None):
+    print(json.dumps({"valid": error is None, "error": error}))
+    sys.exit(0)
+
+
+def check_static():
+    with open(module_name + ".py") as f:
+        tree = ast.parse(f.read())
+    for node in tree.body:
+        if isinstance(node, (ast.FunctionDef, ast.AsyncFunctionDef)) and node.name == func_name:
+            args = node.args
+            positional = len(args.posonlyargs) + len(args.args)
+            required = positional - len(args.defaults)
+            missing_kwonly = [a.arg for a, d in zip(args.kwonlyargs, args.kw_defaults) if d is None]
+            if (positional < 2 and args.vararg is None) or required > 2 or missing_kwonly:
+                result("%s must accept (event, context)" % entry)
+            result()
+        if isinstance(node, ast.Assign) and any(isinstance(t, ast.Name) and t.id == func_name for t in node.targets):
+            result()
+    result("module '%s' has no attribute '%s'" % (module_name, func_name))
+
+
+try:
+    try:
+        module = importlib.import_module(module_name)
+    except ImportError as e:
+        if getattr(e, "name", None) == module_name:
+            result("module '%s' not found" % module_name)
+        check_static()
+
+    fn = getattr(module, func_name, None)
+    if fn is None:
+        result("module '%s' has no attribute '%s'" % (module_name, func_name))
+    if not callable(fn):
+        result("%s is not callable" % entry)
+    try:
+        inspect.signature(fn).bind(None, None)
+    except TypeError:
+        result("%s must accept (event, context)" % entry)
+    except ValueError:
+        pass  # no signature available, e.g. builtins
+    result()
+except SystemExit:
+    raise
+except Exception as e:
+    result("failed to load %s: %s: %s" % (module_name, type(e).__name__, e))
+`
+
+// probeResult is the JSON line printed by the probe script
+type probeResult struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error"`
+}
+
+// ValidateEntryPoint checks that the payload's entry point names a function
+// that exists and accepts (event, context), without installing requirements
+// or running the handler. A nil error means the entry point is valid.
+func (e *Executor) ValidateEntryPoint(payload *FunctionPayload) error {
+	rt, err := LookupRuntime(payload.Runtime)
+	if err != nil || rt.Language != languagePython {
+		return fmt.Errorf("%w: unsupported runtime %s", ErrInvalidEntryPoint, payload.Runtime)
+	}
+
+	entryPoint := rt.entryPoint(payload)
+	parts := strings.Split(entryPoint, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("%w: %s must have the format file.function", ErrInvalidEntryPoint, entryPoint)
+	}
+
+	execDir, err := os.MkdirTemp(e.BaseDir, "validate-")
+	if err != nil {
+		return fmt.Errorf("failed to create validation directory: %v", err)
+	}
+	defer os.RemoveAll(execDir)
+
+	if err := writeFunctionFiles(payload, rt, execDir); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(execDir, "probe.py"), []byte(probeScript), 0644); err != nil {
+		return fmt.Errorf("failed to write probe.py: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "python3", "probe.py", parts[0], parts[1])
+	cmd.Dir = execDir
+	stdout := &limitedBuffer{limit: e.MaxOutputBytes}
+	cmd.Stdout = stdout
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("entry point probe failed: %v", err)
+	}
+
+	// The module may print while importing; the probe's result is the last line
+	lines := strings.Split(strings.TrimSpace(stdout.buf.String()), "\n")
+	var res probeResult
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &res); err != nil {
+		return fmt.Errorf("failed to parse entry point probe output: %v", err)
+	}
+	if !res.Valid {
+		return fmt.Errorf("%w: %s", ErrInvalidEntryPoint, res.Error)
+	}
+	return nil
+}
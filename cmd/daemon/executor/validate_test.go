@@ -0,0 +1,80 @@
+package executor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateEntryPoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       string
+		entryPoint string
+		wantErr    string // Empty when the entry point is valid
+	}{
+		{
+			name: "valid",
+			code: "def handler(event, context):\n    return event\n",
+		},
+		{
+			name:       "missing function",
+			code:       "def handler(event, context):\n    return event\n",
+			entryPoint: "handler.handlr",
+			wantErr:    "has no attribute 'handlr'",
+		},
+		{
+			name:       "missing module",
+			code:       "def handler(event, context):\n    return event\n",
+			entryPoint: "main.handler",
+			wantErr:    "module 'main' not found",
+		},
+		{
+			name:    "wrong signature",
+			code:    "def handler(event):\n    return event\n",
+			wantErr: "must accept (event, context)",
+		},
+		{
+			name:    "not callable",
+			code:    "handler = 42\n",
+			wantErr: "is not callable",
+		},
+		{
+			name:       "malformed entry point",
+			code:       "def handler(event, context):\n    return event\n",
+			entryPoint: "handler",
+			wantErr:    "must have the format file.function",
+		},
+		{
+			name:    "import error",
+			code:    "raise RuntimeError('broken at import')\n\ndef handler(event, context):\n    return event\n",
+			wantErr: "broken at import",
+		},
+		{
+			// Requirements aren't installed to validate, so the source is
+			// checked instead
+			name: "uninstalled dependency",
+			code: "import not_installed_package\n\ndef handler(event, context):\n    return event\n",
+		},
+		{
+			name:    "uninstalled dependency and missing function",
+			code:    "import not_installed_package\n\ndef main(event, context):\n    return event\n",
+			wantErr: "has no attribute 'handler'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestExecutor(t)
+			err := e.ValidateEntryPoint(&FunctionPayload{Runtime: "python3", Code: tt.code, EntryPoint: tt.entryPoint})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateEntryPoint() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidEntryPoint) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateEntryPoint() = %v, want %v mentioning %q", err, ErrInvalidEntryPoint, tt.wantErr)
+			}
+		})
+	}
+}
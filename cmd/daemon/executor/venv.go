@@ -0,0 +1,122 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// venvCacheDir is the subdirectory of BaseDir holding virtual environments,
+// one per distinct set of requirements and package indexes
+const venvCacheDir = "venvs"
+
+// venvReadyFile marks a cached virtual environment whose install finished
+const venvReadyFile = ".ready"
+
+// venvLocks serializes builds of the same virtual environment
+var venvLocks sync.Map
+
+// WarmupResult reports how a Warmup call went
+type WarmupResult struct {
+	Duration int64 `json:"duration_ms"`
+	Cached   bool  `json:"cached"` // The environment had already been built
+}
+
+// Warmup builds the virtual environment for the payload's requirements
+// without running the handler, so a later execution of the same requirements
+// finds it in the cache
+func (e *Executor) Warmup(payload *FunctionPayload) (*WarmupResult, error) {
+	startTime := time.Now()
+	_, cached, err := e.venv(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &WarmupResult{Duration: time.Since(startTime).Milliseconds(), Cached: cached}, nil
+}
+
+// venv returns the path of the cached virtual environment for the payload's
+// requirements, building it first if needed. cached reports whether it
+// already existed. Payloads without requirements need no environment.
+func (e *Executor) venv(payload *FunctionPayload) (path string, cached bool, err error) {
+	if payload.Requirements == "" {
+		return "", false, nil
+	}
+
+	indexArgs, secrets, err := pipIndexArgs(payload.Environment)
+	if err != nil {
+		return "", false, err
+	}
+
+	// Credentials are part of the key so a function can't reuse an
+	// environment installed from an index it has no access to
+	sum := sha256.Sum256([]byte(payload.Requirements + "\x00" + strings.Join(indexArgs, "\x00")))
+	key := hex.EncodeToString(sum[:])
+	path = filepath.Join(e.BaseDir, venvCacheDir, key)
+
+	lock, _ := venvLocks.LoadOrStore(path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, err := os.Stat(filepath.Join(path, venvReadyFile)); err == nil {
+		return path, true, nil
+	}
+
+	// Start over from a clean directory; a previous build may have failed halfway
+	os.RemoveAll(path)
+	if err := e.buildVenv(path, payload.Requirements, indexArgs, secrets); err != nil {
+		os.RemoveAll(path)
+		return "", false, err
+	}
+	if err := os.WriteFile(filepath.Join(path, venvReadyFile), nil, 0644); err != nil {
+		return "", false, fmt.Errorf("failed to mark virtual environment ready: %v", err)
+	}
+	return path, false, nil
+}
+
+// buildVenv creates a virtual environment at path and installs requirements
+// into it. Virtual environments aren't relocatable, so it's built in place.
+func (e *Executor) buildVenv(path, requirements string, indexArgs, secrets []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create virtual environment cache: %v", err)
+	}
+
+	createVenvCmd := exec.Command("python3", "-m", "venv", path)
+	if output, err := createVenvCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create virtual environment: %v, output: %s", err, output)
+	}
+
+	requirementsPath := filepath.Join(path, "requirements.txt")
+	if err := os.WriteFile(requirementsPath, []byte(requirements), 0644); err != nil {
+		return fmt.Errorf("failed to write requirements.txt: %v", err)
+	}
+
+	// Ensure pip is installed using the venv's Python interpreter
+	pythonPath := filepath.Join(path, "bin", "python")
+	err := e.runInstallStep("ensure pip is installed", func() *exec.Cmd {
+		cmd := exec.Command(pythonPath, "-m", "ensurepip", "--default-pip")
+		cmd.Dir = path
+		return cmd
+	})
+	if err != nil {
+		return err
+	}
+
+	// Install requirements in the virtual environment, retrying transient
+	// network errors
+	pipPath := filepath.Join(path, "bin", "pip")
+	pipArgs := append(append([]string{"install"}, indexArgs...), "-r", requirementsPath)
+	if len(indexArgs) > 0 {
+		e.Logger.Printf("Installing requirements with %s", redact(strings.Join(indexArgs, " "), secrets))
+	}
+	return e.runInstallStep("install requirements", func() *exec.Cmd {
+		cmd := exec.Command(pipPath, pipArgs...)
+		cmd.Dir = path
+		return cmd
+	}, secrets...)
+}
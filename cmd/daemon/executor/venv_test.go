@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWarmupBuildsVenv(t *testing.T) {
+	mockPython(t)
+	e := newInstallExecutor(t)
+	payload := &FunctionPayload{Requirements: "requests\n"}
+
+	result, err := e.Warmup(payload)
+	if err != nil {
+		t.Fatalf("Warmup: %v", err)
+	}
+	if result.Cached {
+		t.Error("first warmup reported a cached environment")
+	}
+
+	path, cached, err := e.venv(payload)
+	if err != nil {
+		t.Fatalf("venv: %v", err)
+	}
+	if !cached {
+		t.Error("environment built by the warmup isn't reused")
+	}
+	for _, name := range []string{venvReadyFile, "requirements.txt", filepath.Join("bin", "pip")} {
+		if _, err := os.Stat(filepath.Join(path, name)); err != nil {
+			t.Errorf("virtual environment is missing %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(path, "pip-args")); err != nil {
+		t.Errorf("requirements weren't installed: %v", err)
+	}
+
+	// A second warmup finds the environment in the cache
+	if result, err := e.Warmup(payload); err != nil || !result.Cached {
+		t.Errorf("second Warmup() = %+v, %v; want it cached", result, err)
+	}
+}
+
+func TestWarmupWithoutRequirements(t *testing.T) {
+	e := newInstallExecutor(t)
+	if _, err := e.Warmup(&FunctionPayload{}); err != nil {
+		t.Fatalf("Warmup: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(e.BaseDir, venvCacheDir)); !os.IsNotExist(err) {
+		t.Errorf("a virtual environment was built without requirements: %v", err)
+	}
+}
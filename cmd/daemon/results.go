@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bluequbit/faas/deamon/executor"
+)
+
+// Result delivery defaults, overridden with FAAS_RESULT_WORKERS and
+// FAAS_RESULT_BUFFER
+const (
+	defaultResultWorkers = 4
+	defaultResultBuffer  = 64
+)
+
+// slowResultDelivery is the delivery latency above which a result is logged
+const slowResultDelivery = time.Second
+
+// ResultDeliveryStats reports how result delivery to the control plane is
+// doing. Latencies run from when a result was handed over for delivery
+// until the control plane accepted it, so they include time spent waiting
+// for a worker.
+type ResultDeliveryStats struct {
+	Workers        int   `json:"workers"`
+	Pending        int   `json:"pending"` // Results waiting for or being delivered
+	Delivered      int64 `json:"delivered"`
+	Failed         int64 `json:"failed"`
+	AvgLatencyMs   int64 `json:"avg_latency_ms"`
+	MaxLatencyMs   int64 `json:"max_latency_ms"`
+	LastLatencyMs  int64 `json:"last_latency_ms"`
+	totalLatencyMs int64
+}
+
+// resultDelivery is a job for the result senders
+type resultDelivery struct {
+	result   *executor.ExecutionResult
+	queuedAt time.Time
+	done     chan error
+}
+
+// resultSender delivers results to the control plane from a fixed number
+// of workers, so a burst of finishing executions doesn't turn into a burst
+// of concurrent reports. Results wait in a bounded buffer; once it is full,
+// deliver blocks.
+type resultSender struct {
+	client  *http.Client
+	workers int
+	queue   chan *resultDelivery
+	send    func(*http.Client, *executor.ExecutionResult) error
+
+	mu    sync.Mutex
+	stats ResultDeliveryStats
+}
+
+// newResultSender starts workers senders that deliver results with send
+func newResultSender(client *http.Client, workers, buffer int, send func(*http.Client, *executor.ExecutionResult) error) *resultSender {
+	s := &resultSender{
+		client:  client,
+		workers: workers,
+		queue:   make(chan *resultDelivery, buffer),
+		send:    send,
+	}
+	s.stats.Workers = workers
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	return s
+}
+
+// deliver queues result for delivery and returns a channel that receives
+// the outcome once a worker has sent it
+func (s *resultSender) deliver(result *executor.ExecutionResult) <-chan error {
+	delivery := &resultDelivery{result: result, queuedAt: time.Now(), done: make(chan error, 1)}
+	s.mu.Lock()
+	s.stats.Pending++
+	s.mu.Unlock()
+	s.queue <- delivery
+	return delivery.done
+}
+
+func (s *resultSender) worker() {
+	for delivery := range s.queue {
+		err := s.send(s.client, delivery.result)
+		latency := time.Since(delivery.queuedAt)
+		if err == nil && latency > slowResultDelivery {
+			log.Printf("Result for request ID %s took %v to deliver", delivery.result.RequestID, latency.Round(time.Millisecond))
+		}
+		s.record(latency, err)
+		delivery.done <- err
+	}
+}
+
+// record adds a finished delivery to the stats
+func (s *resultSender) record(latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Pending--
+	if err != nil {
+		s.stats.Failed++
+		return
+	}
+
+	ms := latency.Milliseconds()
+	s.stats.Delivered++
+	s.stats.totalLatencyMs += ms
+	s.stats.AvgLatencyMs = s.stats.totalLatencyMs / s.stats.Delivered
+	s.stats.LastLatencyMs = ms
+	if ms > s.stats.MaxLatencyMs {
+		s.stats.MaxLatencyMs = ms
+	}
+}
+
+// Stats returns a snapshot of the delivery stats
+func (s *resultSender) Stats() ResultDeliveryStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
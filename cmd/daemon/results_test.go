@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluequbit/faas/deamon/executor"
+)
+
+func TestResultSenderBoundsConcurrentDeliveries(t *testing.T) {
+	const workers, completions = 4, 50
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	delivered := make(map[string]bool)
+	send := func(client *http.Client, result *executor.ExecutionResult) error {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		delivered[result.RequestID] = true
+		mu.Unlock()
+		return nil
+	}
+	sender := newResultSender(http.DefaultClient, workers, 8, send)
+
+	// Executions finishing at once each hand over their result
+	var wg sync.WaitGroup
+	for i := 0; i < completions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := <-sender.deliver(&executor.ExecutionResult{RequestID: fmt.Sprintf("req-%d", i)}); err != nil {
+				t.Errorf("delivery of req-%d failed: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if maxInFlight > workers {
+		t.Errorf("%d results were delivered at once, want at most %d", maxInFlight, workers)
+	}
+	if len(delivered) != completions {
+		t.Errorf("%d of %d results were delivered", len(delivered), completions)
+	}
+	stats := sender.Stats()
+	if stats.Workers != workers || stats.Delivered != completions || stats.Failed != 0 || stats.Pending != 0 {
+		t.Errorf("stats = %+v, want %d delivered and none pending", stats, completions)
+	}
+	if stats.AvgLatencyMs < 5 || stats.MaxLatencyMs < stats.AvgLatencyMs {
+		t.Errorf("latencies avg %dms, max %dms; want them to include the delivery", stats.AvgLatencyMs, stats.MaxLatencyMs)
+	}
+}
+
+func TestResultSenderReportsFailures(t *testing.T) {
+	errRejected := errors.New("rejected")
+	sender := newResultSender(http.DefaultClient, 1, 0, func(*http.Client, *executor.ExecutionResult) error {
+		return errRejected
+	})
+
+	if err := <-sender.deliver(&executor.ExecutionResult{RequestID: "req-1"}); !errors.Is(err, errRejected) {
+		t.Errorf("delivery error = %v, want %v", err, errRejected)
+	}
+	if stats := sender.Stats(); stats.Failed != 1 || stats.Delivered != 0 || stats.Pending != 0 {
+		t.Errorf("stats = %+v, want one failed delivery", stats)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/vm"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// AgentMode indicates whether this process runs as a host agent
+var AgentMode bool
+
+// Host agent environment variables
+const (
+	EnvControlPlaneURL = "FAAS_CONTROL_PLANE_URL" // Control plane the agent registers with
+	EnvAgentID         = "FAAS_AGENT_ID"          // Host ID, defaults to the hostname
+	EnvAgentAddress    = "FAAS_AGENT_ADDRESS"     // URL the control plane reaches this agent at
+)
+
+// agentHeartbeatInterval is how often a host agent re-registers with the control plane
+const agentHeartbeatInterval = 30 * time.Second
+
+func init() {
+	flag.BoolVar(&AgentMode, "agent", false, "Run as a host agent that boots VMs for a remote control plane")
+}
+
+// runAgent runs this process as a host agent until interrupted. The agent
+// boots VMs on request from the control plane, relays daemon traffic for /api
+// to it and re-registers every agentHeartbeatInterval.
+func runAgent(stateManager *state.StateManager, logger *logrus.Logger) {
+	controlPlaneURL := strings.TrimRight(os.Getenv(EnvControlPlaneURL), "/")
+	controlPlane, err := url.Parse(controlPlaneURL)
+	if err != nil || controlPlane.Host == "" {
+		logger.Fatalf("%s must be set to the control plane URL", EnvControlPlaneURL)
+	}
+
+	address := os.Getenv(EnvAgentAddress)
+	if address == "" {
+		logger.Fatalf("%s must be set to this agent's URL", EnvAgentAddress)
+	}
+
+	token := vm.HostToken()
+	if token == "" {
+		logger.Fatalf("%s must be set to the control plane's host token", vm.EnvHostToken)
+	}
+
+	id := os.Getenv(EnvAgentID)
+	if id == "" {
+		if id, err = os.Hostname(); err != nil {
+			logger.Fatalf("Failed to determine host ID: %v", err)
+		}
+	}
+
+	vmManager, err := vm.NewAgentVMManager(stateManager, logger)
+	if err != nil {
+		logger.Fatalf("Failed to initialize VM manager: %v", err)
+	}
+
+	router := mux.NewRouter()
+	vm.NewAgentHandler(vmManager).RegisterRoutes(router)
+
+	// Daemons report results to their host, so relay them to the control plane
+	router.PathPrefix("/api/").Handler(httputil.NewSingleHostReverseProxy(controlPlane))
+
+	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	host := state.Host{ID: id, Address: address, Capacity: vmManager.Capacity()}
+	go sendHeartbeats(controlPlaneURL, token, host, logger)
+
+	// VM creation waits for the VM to boot, so allow longer writes than the API
+	srv := &http.Server{
+		Addr:         ":8080",
+		Handler:      router,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 60 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+
+	go func() {
+		logger.Infof("Running as host agent %s at %s for control plane %s", id, address, controlPlaneURL)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	<-sigChan
+
+	logger.Info("Shutting down host agent...")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Errorf("Host agent shutdown failed: %v", err)
+	}
+
+	vmManager.Cleanup()
+	stateManager.Close()
+
+	logger.Info("Host agent stopped")
+}
+
+// sendHeartbeats registers host with the control plane every
+// agentHeartbeatInterval
+func sendHeartbeats(controlPlaneURL, token string, host state.Host, logger *logrus.Logger) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(agentHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := registerHost(client, controlPlaneURL, token, host); err != nil {
+			logger.Warnf("Failed to register with control plane: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// registerHost sends a single host registration to the control plane,
+// authenticated with the host token
+func registerHost(client *http.Client, controlPlaneURL, token string, host state.Host) error {
+	data, err := json.Marshal(host)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, controlPlaneURL+"/api/hosts/register", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(vm.HostTokenHeader, token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
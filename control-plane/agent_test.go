@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/vm"
+)
+
+func TestRegisterHostPresentsToken(t *testing.T) {
+	var token string
+	var registered state.Host
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/hosts/register" {
+			http.NotFound(w, r)
+			return
+		}
+		token = r.Header.Get(vm.HostTokenHeader)
+		json.NewDecoder(r.Body).Decode(&registered)
+	}))
+	defer controlPlane.Close()
+
+	host := state.Host{ID: "host-2", Address: "http://10.0.0.2:8080", Capacity: 4}
+	if err := registerHost(controlPlane.Client(), controlPlane.URL, "host-token", host); err != nil {
+		t.Fatalf("registerHost: %v", err)
+	}
+	if token != "host-token" {
+		t.Errorf("registration carried token %q, want host-token", token)
+	}
+	if registered.ID != host.ID || registered.Capacity != host.Capacity {
+		t.Errorf("registered %+v, want %+v", registered, host)
+	}
+}
+
+func TestRegisterHostRejected(t *testing.T) {
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Invalid host token", http.StatusUnauthorized)
+	}))
+	defer controlPlane.Close()
+
+	if err := registerHost(controlPlane.Client(), controlPlane.URL, "wrong", state.Host{ID: "host-2"}); err == nil {
+		t.Error("registerHost succeeded although the control plane rejected it")
+	}
+}
@@ -2,18 +2,38 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"time"
 	_ "net/http/pprof"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/bluequbit/faas/control-plane/auth"
+	"github.com/bluequbit/faas/control-plane/buildinfo"
+	"github.com/bluequbit/faas/control-plane/cron"
+	"github.com/bluequbit/faas/control-plane/daemonclient"
 	"github.com/bluequbit/faas/control-plane/registry"
+	"github.com/bluequbit/faas/control-plane/runtimes"
 	"github.com/bluequbit/faas/control-plane/scheduler"
 	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/types"
 	"github.com/bluequbit/faas/control-plane/vm"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
+// maxUploadMemory is the amount of a multipart upload kept in memory before spilling to disk
+const maxUploadMemory = 32 << 20
+
 // APIHandler handles API requests
 type APIHandler struct {
 	functionRegistry *registry.FunctionRegistry
@@ -22,23 +42,85 @@ type APIHandler struct {
 	authManager      *auth.AuthManager
 	stateManager     *state.StateManager
 	logger           *logrus.Logger
+	resultSecret     string         // Shared secret daemons present on result reports, if any
+	hostToken        string         // Shared token host agents register with, if any
+	devMode          bool           // Whether development-only endpoints such as purge are enabled
+	testMode         bool           // Whether POST /test/invoke runs functions on the test host VM
+	background       sync.WaitGroup // Audit writes and log deliveries still in flight
 }
 
 // FunctionRequest represents a request to register a function
 type FunctionRequest struct {
-	Name         string `json:"name"`
-	Runtime      string `json:"runtime"`
-	Memory       int    `json:"memory"`
-	Timeout      int    `json:"timeout"`
-	Code         string `json:"code"`
-	Requirements string `json:"requirements"`
-	Config       string `json:"config"`
+	Name         string              `json:"name"`
+	Runtime      string              `json:"runtime"`
+	Memory       int                 `json:"memory"`
+	Timeout      int                 `json:"timeout"`
+	Environment  FunctionEnvironment `json:"environment,omitempty"`
+	Labels       map[string]string   `json:"labels,omitempty"`
+	EntryPoint   string              `json:"entry_point,omitempty"`
+	Code         string              `json:"code"`
+	Requirements string              `json:"requirements"`
+	Config       string              `json:"config"`
+	// Files holds additional source and data files keyed by relative path
+	Files map[string]string `json:"files,omitempty"`
+	// Schedule is an optional cron expression; when set the function is
+	// invoked asynchronously with ScheduleInput at each activation
+	Schedule      string                 `json:"schedule,omitempty"`
+	ScheduleInput map[string]interface{} `json:"schedule_input,omitempty"`
+	// SkipValidation disables the deploy-time entry point check
+	SkipValidation bool `json:"skip_validation,omitempty"`
+	// CanaryPercent, on update, deploys the new code as a canary receiving
+	// this percentage of invokes until promoted
+	CanaryPercent int `json:"canary_percent,omitempty"`
+	// RateLimit caps the function's invocations per second across all
+	// callers, with bursts of up to RateBurst; on update, 0 removes the limit
+	RateLimit *float64 `json:"rate_limit,omitempty"`
+	RateBurst int      `json:"rate_burst,omitempty"`
+	// Concurrency caps the function's executions queued or running at once;
+	// on update, 0 removes the limit
+	Concurrency *int `json:"concurrency,omitempty"`
+	// Scratch gives executions a SCRATCH_DIR kept between executions on the
+	// same VM; on update, false turns it off
+	Scratch *bool `json:"scratch,omitempty"`
+	// Cacheable marks the function deterministic so its results are cached
+	// for CacheTTL seconds, or the default TTL when zero; on update, false
+	// turns caching off
+	Cacheable *bool `json:"cacheable,omitempty"`
+	CacheTTL  int   `json:"cache_ttl,omitempty"`
+	// LogDestination is where the stderr of executions is forwarded, an
+	// http(s) URL receiving a JSON POST or a file:// path appended to; on
+	// update, "" stops forwarding
+	LogDestination *string `json:"log_destination,omitempty"`
+	// Retry is the retry policy of asynchronous executions; on update, a
+	// max_attempts of 0 turns retries off
+	Retry *state.RetryPolicy `json:"retry,omitempty"`
+	// CPU is the number of vCPUs of the VMs executions run on; zero means
+	// the default, or, on update, leaves it unchanged
+	CPU int `json:"cpu,omitempty"`
+}
+
+// FunctionPatchRequest is the body of PATCH /api/functions/{id}. Omitted
+// fields are left unchanged; an empty environment or labels object clears
+// it. The code can only be changed with PUT.
+type FunctionPatchRequest struct {
+	Memory      *int                 `json:"memory,omitempty"`
+	Timeout     *int                 `json:"timeout,omitempty"`
+	Concurrency *int                 `json:"concurrency,omitempty"`
+	Environment *FunctionEnvironment `json:"environment,omitempty"`
+	Labels      map[string]string    `json:"labels,omitempty"`
 }
 
 // InvokeRequest represents a request to invoke a function
 type InvokeRequest struct {
-	Input map[string]interface{} `json:"input"`
-	Sync  bool                   `json:"sync"`
+	Input       map[string]interface{} `json:"input"`
+	Environment map[string]string      `json:"environment,omitempty"`
+	Sync        bool                   `json:"sync"`
+	// DeadlineMS caps how long a synchronous invoke waits for the result
+	DeadlineMS int `json:"deadline_ms,omitempty"`
+	// Timeout overrides the function's timeout, in seconds, for this execution
+	Timeout int `json:"timeout,omitempty"`
+	// Tags are stored on the execution, see GET /api/executions?tag=
+	Tags map[string]string `json:"tags,omitempty"`
 }
 
 // APIKeyRequest represents a request to generate an API key
@@ -56,20 +138,12 @@ type VMInfo struct {
 	Status      string `json:"status"`
 }
 
-// ExecutionResult represents the result of a function execution
-type ExecutionResult struct {
-	RequestID    string `json:"request_id"`
-	FunctionID   string `json:"function_id"`
-	StatusCode   int    `json:"status_code"`
-	Output       string `json:"output"`
-	ErrorMessage string `json:"error_message,omitempty"`
-	Duration     int64  `json:"duration_ms"`
-	MemoryUsage  int64  `json:"memory_usage_kb,omitempty"`
-}
-
 // NewAPIHandler creates a new API handler
 func NewAPIHandler(functionRegistry *registry.FunctionRegistry, vmManager *vm.VMManager, scheduler *scheduler.Scheduler, authManager *auth.AuthManager, stateManager *state.StateManager, logger *logrus.Logger) *APIHandler {
 	return &APIHandler{
+		resultSecret:     daemonclient.ResultSecret(),
+		hostToken:        vm.HostToken(),
+		devMode:          devModeEnabled(),
 		functionRegistry: functionRegistry,
 		vmManager:        vmManager,
 		scheduler:        scheduler,
@@ -79,17 +153,31 @@ func NewAPIHandler(functionRegistry *registry.FunctionRegistry, vmManager *vm.VM
 	}
 }
 
+// Wait blocks until the audit writes and log deliveries started in the
+// background have finished, e.g. before the state manager is closed on shutdown
+func (h *APIHandler) Wait() {
+	h.background.Wait()
+}
+
 // RegisterRoutes registers API routes
 func (h *APIHandler) RegisterRoutes(router *mux.Router) {
 	// API routes
 	api := router.PathPrefix("/api").Subrouter()
+	api.Use(compressionMiddleware)
+	api.Use(limitMiddleware)
+
+	// Readiness sits next to /health for load balancers and orchestrators
+	router.HandleFunc("/ready", h.readyHandler).Methods("GET")
 
 	// Public routes
 	api.HandleFunc("/health", h.healthHandler).Methods("GET")
+	api.HandleFunc("/runtimes", h.listRuntimesHandler).Methods("GET")
+	api.HandleFunc("/version", h.versionHandler).Methods("GET")
 
 	// Auth routes
 	auth := api.PathPrefix("/auth").Subrouter()
 	auth.HandleFunc("/api-key", h.generateAPIKeyHandler).Methods("POST")
+	auth.Handle("/whoami", h.authManager.Middleware(http.HandlerFunc(h.whoamiHandler))).Methods("GET")
 
 	// Protected routes
 	protected := api.PathPrefix("").Subrouter()
@@ -99,27 +187,112 @@ func (h *APIHandler) RegisterRoutes(router *mux.Router) {
 	functions := api.PathPrefix("/functions").Subrouter()
 	functions.HandleFunc("", h.listFunctionsHandler).Methods("GET")
 	functions.HandleFunc("", h.registerFunctionHandler).Methods("POST")
+	functions.Handle("", h.authManager.RoleMiddleware("admin", http.HandlerFunc(h.bulkDeleteFunctionsHandler))).Methods("DELETE")
+	functions.HandleFunc("/upload", h.uploadFunctionHandler).Methods("POST")
 	functions.HandleFunc("/{id}", h.getFunctionHandler).Methods("GET")
 	functions.HandleFunc("/{id}", h.updateFunctionHandler).Methods("PUT")
+	functions.HandleFunc("/{id}", h.patchFunctionHandler).Methods("PATCH")
 	functions.HandleFunc("/{id}", h.deleteFunctionHandler).Methods("DELETE")
 	functions.HandleFunc("/{id}/invoke", h.invokeFunctionHandler).Methods("POST")
+	functions.HandleFunc("/{id}/schedule", h.getScheduleHandler).Methods("GET")
+	functions.HandleFunc("/{id}/promote", h.promoteFunctionHandler).Methods("POST")
+	functions.HandleFunc("/{id}/clone", h.cloneFunctionHandler).Methods("POST")
+	functions.HandleFunc("/{id}/disable", h.disableFunctionHandler).Methods("POST")
+	functions.HandleFunc("/{id}/enable", h.enableFunctionHandler).Methods("POST")
+	functions.HandleFunc("/{id}/stats", h.getFunctionStatsHandler).Methods("GET")
+	functions.HandleFunc("/{id}/last-execution", h.getLastExecutionHandler).Methods("GET")
+	functions.HandleFunc("/{id}/executions/watch", h.watchExecutionsHandler).Methods("GET")
 	functions.HandleFunc("/name/{name}", h.getFunctionByNameHandler).Methods("GET")
 	functions.HandleFunc("/name/{name}/invoke", h.invokeFunctionByNameHandler).Methods("POST")
-	// functions.HandleFunc("/test/invoke", h.invokeTestFunctionHandler).Methods("POST")
+
+	// Test mode invokes skip VM allocation for the test host VM's daemon
+	if h.testMode {
+		router.HandleFunc("/test/invoke", h.invokeTestFunctionHandler).Methods("POST")
+	}
 
 	// Execution routes
 	executions := api.PathPrefix("/executions").Subrouter()
+	executions.HandleFunc("", h.queryExecutionsHandler).Methods("GET")
+	executions.HandleFunc("/dead-letters", h.listDeadLettersHandler).Methods("GET")
 	executions.HandleFunc("/{id}", h.getExecutionHandler).Methods("GET")
+	executions.HandleFunc("/{id}/result", h.getExecutionResultHandler).Methods("GET")
+	executions.HandleFunc("/{id}/cancel", h.cancelExecutionHandler).Methods("POST")
+	executions.HandleFunc("/{id}/requeue", h.requeueExecutionHandler).Methods("POST")
+	executions.HandleFunc("/{id}/artifacts", h.listArtifactsHandler).Methods("GET")
+	executions.HandleFunc("/{id}/artifacts/{name:.+}", h.getArtifactHandler).Methods("GET")
 	executions.HandleFunc("/function/{id}", h.listExecutionsHandler).Methods("GET")
 
 	// VM routes
 	vms := api.PathPrefix("/vms").Subrouter()
 	vms.HandleFunc("", h.listVMsHandler).Methods("GET")
 	vms.HandleFunc("/{id}", h.getVMHandler).Methods("GET")
+	vms.HandleFunc("/{id}/info", h.getVMInfoHandler).Methods("GET")
+	vms.HandleFunc("/{id}/console", h.getVMConsoleHandler).Methods("GET")
 	vms.HandleFunc("/register", h.registerVMHandler).Methods("POST")
+	vms.Handle("/reconcile", h.authManager.RoleMiddleware("admin", http.HandlerFunc(h.reconcileVMsHandler))).Methods("POST")
+
+	// Host agent routes
+	hosts := api.PathPrefix("/hosts").Subrouter()
+	hosts.HandleFunc("", h.listHostsHandler).Methods("GET")
+	hosts.HandleFunc("/register", h.registerHostHandler).Methods("POST")
+
+	// Usage routes
+	api.Handle("/usage", h.authManager.Middleware(http.HandlerFunc(h.usageHandler))).Methods("GET")
+
+	// Audit routes
+	api.Handle("/audit", h.authManager.RoleMiddleware("admin", http.HandlerFunc(h.listAuditHandler))).Methods("GET")
 
-	// Result routes - no auth required for VM to report results
+	// Admin routes
+	api.Handle("/admin/purge", h.authManager.RoleMiddleware("admin", http.HandlerFunc(h.purgeHandler))).Methods("POST")
+
+	// Secret routes - admin only
+	api.Handle("/secrets", h.authManager.RoleMiddleware("admin", http.HandlerFunc(h.listSecretsHandler))).Methods("GET")
+	api.Handle("/secrets", h.authManager.RoleMiddleware("admin", http.HandlerFunc(h.setSecretHandler))).Methods("POST")
+	api.Handle("/secrets/{name}", h.authManager.RoleMiddleware("admin", http.HandlerFunc(h.deleteSecretHandler))).Methods("DELETE")
+
+	// Result routes - daemons authenticate with the result secret, if configured
 	api.HandleFunc("/results", h.handleResultHandler).Methods("POST")
+	api.HandleFunc("/results/chunks", h.handleChunkHandler).Methods("POST")
+}
+
+// usageHandler reports aggregate usage over a time window. Users see their
+// own usage; admins may query any user with ?user= or, without it, all users.
+func (h *APIHandler) usageHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey, _ := auth.FromContext(r.Context())
+	query := r.URL.Query()
+
+	filter := state.UsageFilter{
+		UserID:     query.Get("user"),
+		FunctionID: query.Get("function"),
+	}
+	if !apiKey.HasRole("admin") {
+		if filter.UserID != "" && filter.UserID != apiKey.UserID {
+			http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+			return
+		}
+		filter.UserID = apiKey.UserID
+	}
+
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "Invalid "+param+": expected an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	usage, err := h.stateManager.GetUsage(filter)
+	if err != nil {
+		h.logger.Errorf("Failed to compute usage: %v", err)
+		http.Error(w, "Failed to compute usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
 }
 
 // healthHandler handles health check requests
@@ -128,6 +301,44 @@ func (h *APIHandler) healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// versionHandler reports the control plane build and, if a VM's daemon can
+// be reached, the daemon's version info. Only one daemon is asked so a
+// stale VM record can delay the response by at most one request timeout.
+func (h *APIHandler) versionHandler(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"control_plane": buildinfo.Get(),
+	}
+
+	daemonErr := "no running VMs"
+	if vms, err := h.vmManager.ListVMs(); err == nil {
+		for i := range vms {
+			if vms[i].Status != "ready" && vms[i].Status != "busy" {
+				continue
+			}
+			info, err := h.vmManager.GetDaemonInfo(&vms[i])
+			if err != nil {
+				daemonErr = err.Error()
+				break
+			}
+			response["daemon"] = info
+			daemonErr = ""
+			break
+		}
+	}
+	if daemonErr != "" {
+		response["daemon_error"] = daemonErr
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// listRuntimesHandler handles supported runtime listing requests
+func (h *APIHandler) listRuntimesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runtimes.List())
+}
+
 // generateAPIKeyHandler handles API key generation requests
 func (h *APIHandler) generateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
 	var req APIKeyRequest
@@ -138,6 +349,7 @@ func (h *APIHandler) generateAPIKeyHandler(w http.ResponseWriter, r *http.Reques
 
 	// Generate API key
 	key, err := h.authManager.GenerateAPIKey(req.UserID, req.Roles, time.Duration(req.ExpiresIn)*time.Second)
+	h.audit(r, auditAPIKeyGenerate, req.UserID, err)
 	if err != nil {
 		http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
 		return
@@ -150,6 +362,22 @@ func (h *APIHandler) generateAPIKeyHandler(w http.ResponseWriter, r *http.Reques
 	})
 }
 
+// whoamiHandler returns the identity of the authenticated API key
+func (h *APIHandler) whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":    apiKey.UserID,
+		"roles":      apiKey.Roles,
+		"expires_at": apiKey.ExpiresAt,
+	})
+}
+
 // registerFunctionHandler handles function registration requests
 func (h *APIHandler) registerFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	var req FunctionRequest
@@ -158,8 +386,147 @@ func (h *APIHandler) registerFunctionHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	h.registerFunction(w, r, &req)
+}
+
+// uploadFunctionHandler handles multipart function registration requests.
+// The "metadata" field holds a JSON FunctionRequest and every file part named
+// "files/<path>" is stored under <path>; handler.py, requirements.txt and
+// skyscale.yaml populate the corresponding request fields.
+func (h *APIHandler) uploadFunctionHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		http.Error(w, "Invalid multipart body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req FunctionRequest
+	if err := json.Unmarshal([]byte(r.FormValue("metadata")), &req); err != nil {
+		http.Error(w, "Invalid metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.Files = map[string]string{}
+	for name, headers := range r.MultipartForm.File {
+		path := strings.TrimPrefix(name, "files/")
+		if path == name || len(headers) == 0 {
+			continue
+		}
+
+		content, err := readFormFile(headers[0])
+		if err != nil {
+			http.Error(w, "Failed to read file "+path+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch path {
+		case "handler.py":
+			req.Code = content
+		case "requirements.txt":
+			req.Requirements = content
+		case "skyscale.yaml":
+			req.Config = content
+		default:
+			req.Files[path] = content
+		}
+	}
+
+	h.registerFunction(w, r, &req)
+}
+
+// readFormFile reads the contents of an uploaded multipart file
+func readFormFile(header *multipart.FileHeader) (string, error) {
+	f, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// reconcileSpec fills the runtime, entry point, memory, timeout and vCPUs
+// the request leaves unset from the settings declared in its skyscale.yaml,
+// then from the defaults. Fields set in the request take precedence over the
+// file.
+func (h *APIHandler) reconcileSpec(req *FunctionRequest) error {
+	spec, err := registry.ParseSpec(req.Config)
+	if err != nil {
+		return err
+	}
+
+	reconcile := func(field string, requested *string, declared, fallback string) {
+		if *requested != "" && declared != "" && *requested != declared {
+			h.logger.Infof("Function %s: request %s %q overrides %q from skyscale.yaml", req.Name, field, *requested, declared)
+		}
+		if *requested == "" {
+			*requested = declared
+		}
+		if *requested == "" {
+			*requested = fallback
+		}
+	}
+	reconcile("runtime", &req.Runtime, spec.Runtime, registry.DefaultRuntime)
+	reconcile("entry point", &req.EntryPoint, spec.EntryPoint, registry.DefaultEntryPoint)
+
+	// Zero memory, timeout and vCPUs are left to the registry's defaults
+	if req.Memory == 0 {
+		req.Memory = spec.Memory
+	}
+	if req.Timeout == 0 {
+		req.Timeout = spec.Timeout
+	}
+	if req.CPU == 0 {
+		req.CPU = spec.CPU
+	}
+	return registry.ValidateCPU(req.CPU)
+}
+
+// registerFunction validates and registers a function, writing the response
+func (h *APIHandler) registerFunction(w http.ResponseWriter, r *http.Request, req *FunctionRequest) {
+	if err := scheduler.ValidateEnvironment(req.Environment.names()); err != nil {
+		http.Error(w, "Invalid environment: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.reconcileSpec(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	registration := req.registration()
+	if err := registration.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check the entry point exists before registering. If the probe itself
+	// can't run the function is registered anyway.
+	if !req.SkipValidation {
+		err := h.scheduler.ValidateEntryPoint(req.Runtime, req.EntryPoint, &registration.Code)
+		if errors.Is(err, scheduler.ErrInvalidEntryPoint) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			h.logger.Warnf("Skipping entry point validation for function %s: %v", req.Name, err)
+		}
+	}
+
 	// Register function
-	function, err := h.functionRegistry.RegisterFunction(req.Name, req.Runtime, req.Memory, req.Timeout, req.Code, req.Requirements, req.Config)
+	function, err := h.functionRegistry.RegisterFunction(registration)
+	h.audit(r, auditFunctionCreate, req.Name, err)
+	if errors.Is(err, registry.ErrSecretNotFound) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, registry.ErrFunctionExists) {
+		http.Error(w, "Function '"+req.Name+"' already exists", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to register function: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -170,6 +537,50 @@ func (h *APIHandler) registerFunctionHandler(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(function)
 }
 
+// registration returns the registry registration of a new function
+func (req *FunctionRequest) registration() registry.FunctionRegistration {
+	registration := registry.FunctionRegistration{
+		Name:       req.Name,
+		Runtime:    req.Runtime,
+		Memory:     req.Memory,
+		Timeout:    req.Timeout,
+		EntryPoint: req.EntryPoint,
+		Code: registry.FunctionCode{
+			Code:         req.Code,
+			Requirements: req.Requirements,
+			Config:       req.Config,
+			Files:        req.Files,
+		},
+		Environment:   req.Environment.Values,
+		Secrets:       req.Environment.Secrets,
+		Labels:        req.Labels,
+		RateBurst:     req.RateBurst,
+		CPU:           req.CPU,
+		Schedule:      req.Schedule,
+		ScheduleInput: req.ScheduleInput,
+	}
+	if req.RateLimit != nil {
+		registration.RateLimit = *req.RateLimit
+	}
+	if req.Concurrency != nil {
+		registration.Concurrency = *req.Concurrency
+	}
+	if req.Scratch != nil {
+		registration.Scratch = *req.Scratch
+	}
+	if req.Cacheable != nil && *req.Cacheable {
+		registration.Cacheable = true
+		registration.CacheTTL = req.CacheTTL
+	}
+	if req.LogDestination != nil {
+		registration.LogDestination = *req.LogDestination
+	}
+	if req.Retry != nil {
+		registration.Retry = *req.Retry
+	}
+	return registration
+}
+
 // updateFunctionHandler handles function update requests
 func (h *APIHandler) updateFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -181,18 +592,260 @@ func (h *APIHandler) updateFunctionHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if req.Schedule != "" {
+		if _, err := cron.Parse(req.Schedule); err != nil {
+			http.Error(w, "Invalid schedule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.RateLimit != nil {
+		if err := registry.ValidateRateLimit(*req.RateLimit, req.RateBurst); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Concurrency != nil {
+		if err := registry.ValidateConcurrency(*req.Concurrency); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := registry.ValidateCacheTTL(req.CacheTTL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.LogDestination != nil {
+		if err := registry.ValidateLogDestination(*req.LogDestination); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Retry != nil {
+		if err := registry.ValidateRetryPolicy(*req.Retry); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := registry.ValidateCPU(req.CPU); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Update function
-	function, err := h.functionRegistry.UpdateFunction(id, req.Code, req.Requirements, req.Config)
+	function, err := h.functionRegistry.UpdateFunction(id, req.Timeout, req.Code, req.Requirements, req.Config, req.CanaryPercent)
+	h.audit(r, auditFunctionUpdate, id, err)
+	if errors.Is(err, registry.ErrInvalidTimeout) || errors.Is(err, registry.ErrInvalidCanary) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to update function: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if req.RateLimit != nil {
+		if function, err = h.functionRegistry.SetRateLimit(function.ID, *req.RateLimit, req.RateBurst); err != nil {
+			http.Error(w, "Failed to set rate limit: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Concurrency != nil {
+		if function, err = h.functionRegistry.SetConcurrency(function.ID, *req.Concurrency); err != nil {
+			http.Error(w, "Failed to set concurrency limit: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Scratch != nil {
+		if function, err = h.functionRegistry.SetScratch(function.ID, *req.Scratch); err != nil {
+			http.Error(w, "Failed to set scratch directory: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Cacheable != nil {
+		if function, err = h.functionRegistry.SetCaching(function.ID, *req.Cacheable, req.CacheTTL); err != nil {
+			http.Error(w, "Failed to set result caching: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.LogDestination != nil {
+		if function, err = h.functionRegistry.SetLogDestination(function.ID, *req.LogDestination); err != nil {
+			http.Error(w, "Failed to set log destination: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Retry != nil {
+		if function, err = h.functionRegistry.SetRetryPolicy(function.ID, *req.Retry); err != nil {
+			http.Error(w, "Failed to set retry policy: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.CPU != 0 {
+		if function, err = h.functionRegistry.SetCPU(function.ID, req.CPU); err != nil {
+			http.Error(w, "Failed to set vCPU count: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Cached results may no longer match the updated function
+	h.scheduler.InvalidateCache(function.ID)
+
+	if req.Schedule != "" {
+		if _, err := h.functionRegistry.SetSchedule(function.ID, req.Schedule, req.ScheduleInput); err != nil {
+			http.Error(w, "Failed to set schedule: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Return function metadata
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(function)
 }
 
+// patchFunctionHandler changes some of a function's settings without
+// redeploying it. The version stays the same, since the code doesn't change.
+func (h *APIHandler) patchFunctionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	// Unknown fields are rejected so code sent here isn't silently dropped
+	var req FunctionPatchRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	patch := registry.FunctionPatch{Memory: req.Memory, Timeout: req.Timeout, Concurrency: req.Concurrency, Labels: req.Labels}
+	if req.Environment != nil {
+		// The new environment replaces the old one as a whole
+		if err := scheduler.ValidateEnvironment(req.Environment.names()); err != nil {
+			http.Error(w, "Invalid environment: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		patch.Environment = req.Environment.Values
+		patch.Secrets = req.Environment.Secrets
+	}
+
+	function, err := h.functionRegistry.PatchFunction(id, patch)
+	h.audit(r, auditFunctionUpdate, id, err)
+	switch {
+	case errors.Is(err, registry.ErrInvalidMemory), errors.Is(err, registry.ErrInvalidTimeout),
+		errors.Is(err, registry.ErrInvalidConcurrency), errors.Is(err, registry.ErrInvalidLabel),
+		errors.Is(err, registry.ErrSecretNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Function not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update function: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Cached results may no longer match the new settings
+	h.scheduler.InvalidateCache(function.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(function)
+}
+
+// promoteFunctionHandler sends all traffic to a function's canary version
+func (h *APIHandler) promoteFunctionHandler(w http.ResponseWriter, r *http.Request) {
+	function, err := h.functionRegistry.PromoteFunction(mux.Vars(r)["id"])
+	h.audit(r, auditFunctionPromote, mux.Vars(r)["id"], err)
+	if errors.Is(err, registry.ErrNoCanary) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Function not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(function)
+}
+
+// CloneRequest names the function created by a clone
+type CloneRequest struct {
+	Name string `json:"name"`
+}
+
+// cloneFunctionHandler creates a new function with the latest code and the
+// settings of an existing one, e.g. to run a variant of it side by side
+func (h *APIHandler) cloneFunctionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req CloneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.functionRegistry.GetFunction(id); err != nil {
+		http.Error(w, "Function not found", http.StatusNotFound)
+		return
+	}
+
+	function, err := h.functionRegistry.CloneFunction(id, req.Name)
+	h.audit(r, auditFunctionClone, id, err)
+	if errors.Is(err, registry.ErrFunctionExists) {
+		http.Error(w, "Function '"+req.Name+"' already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to clone function: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(function)
+}
+
+// disableFunctionHandler stops a function from being invoked without deleting it
+func (h *APIHandler) disableFunctionHandler(w http.ResponseWriter, r *http.Request) {
+	h.setFunctionDisabled(w, r, true)
+}
+
+// enableFunctionHandler allows a disabled function to be invoked again
+func (h *APIHandler) enableFunctionHandler(w http.ResponseWriter, r *http.Request) {
+	h.setFunctionDisabled(w, r, false)
+}
+
+// setFunctionDisabled disables or enables the function named in the request
+func (h *APIHandler) setFunctionDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	id := mux.Vars(r)["id"]
+	function, err := h.functionRegistry.SetDisabled(id, disabled)
+	action := auditFunctionEnable
+	if disabled {
+		action = auditFunctionDisable
+	}
+	h.audit(r, action, id, err)
+	if err != nil {
+		http.Error(w, "Function not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(function)
+}
+
 // getFunctionHandler handles function retrieval requests
 func (h *APIHandler) getFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -210,6 +863,42 @@ func (h *APIHandler) getFunctionHandler(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(function)
 }
 
+// getFunctionStatsHandler returns the input and output size percentiles of a
+// function's finished executions
+func (h *APIHandler) getFunctionStatsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := h.functionRegistry.GetFunction(id); err != nil {
+		http.Error(w, "Function not found", http.StatusNotFound)
+		return
+	}
+
+	stats, err := h.stateManager.GetPayloadStats(id)
+	if err != nil {
+		http.Error(w, "Failed to compute stats: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// getScheduleHandler handles function schedule retrieval requests
+func (h *APIHandler) getScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Get schedule
+	schedule, err := h.functionRegistry.GetSchedule(id)
+	if err != nil {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+
+	// Return schedule
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
 // getFunctionByNameHandler handles function retrieval by name requests
 func (h *APIHandler) getFunctionByNameHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -241,6 +930,41 @@ func (h *APIHandler) listFunctionsHandler(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(functions)
 }
 
+// bulkDeleteFunctionsHandler deletes all functions matching a label selector.
+// The request must include confirm=true to guard against accidental deletes.
+func (h *APIHandler) bulkDeleteFunctionsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("label") == "" {
+		http.Error(w, "A label selector is required", http.StatusBadRequest)
+		return
+	}
+	if query.Get("confirm") != "true" {
+		http.Error(w, "Bulk delete requires confirm=true", http.StatusBadRequest)
+		return
+	}
+
+	selector, err := registry.ParseLabelSelector(query.Get("label"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := h.functionRegistry.DeleteFunctionsBySelector(selector)
+	for _, id := range deleted {
+		h.audit(r, auditFunctionDelete, id, nil)
+	}
+	if err != nil {
+		h.audit(r, auditFunctionDelete, "label="+query.Get("label"), err)
+		http.Error(w, "Failed to delete functions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted": deleted,
+	})
+}
+
 // deleteFunctionHandler handles function deletion requests
 func (h *APIHandler) deleteFunctionHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -248,6 +972,7 @@ func (h *APIHandler) deleteFunctionHandler(w http.ResponseWriter, r *http.Reques
 
 	// Delete function
 	err := h.functionRegistry.DeleteFunction(id)
+	h.audit(r, auditFunctionDelete, id, err)
 	if err != nil {
 		http.Error(w, "Failed to delete function: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -269,19 +994,92 @@ func (h *APIHandler) invokeFunctionHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if err := scheduler.ValidateEnvironment(req.Environment); err != nil {
+		http.Error(w, "Invalid environment: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := h.invokeOptions(r, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if wantsStream(r) {
+		h.streamInvoke(w, req.Sync, opts, func(opts scheduler.InvokeOptions) (*types.ExecutionResult, error) {
+			return h.scheduler.ScheduleExecution(id, req.Input, opts, true)
+		})
+		return
+	}
+
 	// Invoke function
-	response, err := h.scheduler.ScheduleExecution(id, req.Input, req.Sync)
+	response, err := h.scheduler.ScheduleExecution(id, req.Input, opts, req.Sync)
 	if err != nil {
-		http.Error(w, "Failed to invoke function: "+err.Error(), http.StatusInternalServerError)
+		writeInvokeError(w, err)
 		return
 	}
 
 	// Return response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeInvokeResponse(w, response)
 }
 
-// invokeTestFunctionHandler handles function invocation requests for test mode
+// TestInvokeRequest is the body of POST /test/invoke: an invoke request
+// naming the function by ID or name
+type TestInvokeRequest struct {
+	FunctionID string `json:"function_id,omitempty"`
+	Name       string `json:"name,omitempty"`
+	InvokeRequest
+}
+
+// invokeTestFunctionHandler handles function invocation requests for test
+// mode. The function runs synchronously on the test host VM's daemon, on
+// 127.0.0.1, without going through the warm pools.
+func (h *APIHandler) invokeTestFunctionHandler(w http.ResponseWriter, r *http.Request) {
+	var req TestInvokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	id := req.FunctionID
+	if id == "" {
+		if req.Name == "" {
+			http.Error(w, "function_id or name is required", http.StatusBadRequest)
+			return
+		}
+		function, err := h.functionRegistry.GetFunctionByName(req.Name)
+		if err != nil {
+			http.Error(w, "Function '"+req.Name+"' not found", http.StatusNotFound)
+			return
+		}
+		id = function.ID
+	}
+
+	if err := scheduler.ValidateEnvironment(req.Environment); err != nil {
+		http.Error(w, "Invalid environment: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := h.invokeOptions(r, &req.InvokeRequest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hostVM, err := h.vmManager.GetOrCreateTestHostVM()
+	if err != nil {
+		http.Error(w, "Failed to get test host VM: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := h.scheduler.ExecuteOnVM(id, req.Input, opts, hostVM)
+	if err != nil {
+		writeInvokeError(w, err)
+		return
+	}
+
+	writeInvokeResponse(w, response)
+}
 
 // invokeFunctionByNameHandler handles function invocation by name requests
 func (h *APIHandler) invokeFunctionByNameHandler(w http.ResponseWriter, r *http.Request) {
@@ -294,19 +1092,215 @@ func (h *APIHandler) invokeFunctionByNameHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
+	if err := scheduler.ValidateEnvironment(req.Environment); err != nil {
+		http.Error(w, "Invalid environment: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := h.invokeOptions(r, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if wantsStream(r) {
+		h.streamInvoke(w, req.Sync, opts, func(opts scheduler.InvokeOptions) (*types.ExecutionResult, error) {
+			return h.scheduler.ScheduleExecutionByName(name, req.Input, opts, true)
+		})
+		return
+	}
+
 	// Invoke function
-	response, err := h.scheduler.ScheduleExecutionByName(name, req.Input, req.Sync)
+	response, err := h.scheduler.ScheduleExecutionByName(name, req.Input, opts, req.Sync)
 	if err != nil {
-		http.Error(w, "Failed to invoke function: "+err.Error(), http.StatusInternalServerError)
+		writeInvokeError(w, err)
 		return
 	}
 
 	// Return response
+	writeInvokeResponse(w, response)
+}
+
+// InvokeError is the body of an invoke the platform refused or failed to
+// run. Its source is always types.SourcePlatform, since the function didn't
+// run; failures of the function itself are reported in the execution result.
+type InvokeError struct {
+	Error  string `json:"error"`
+	Source string `json:"source"`
+}
+
+// writeInvokeError responds to a failed invoke with a status code telling
+// clients whether the failure is permanent or worth retrying
+func writeInvokeError(w http.ResponseWriter, err error) {
+	if writeRateLimited(w, err) || writeCapacityExceeded(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, scheduler.ErrFunctionNotFound), errors.Is(err, registry.ErrVersionNotFound):
+		writeInvokeFailure(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, registry.ErrFunctionDisabled):
+		writeInvokeFailure(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, registry.ErrCorruptStorage):
+		writeInvokeFailure(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, scheduler.ErrConcurrencyLimit):
+		w.Header().Set("Retry-After", strconv.Itoa(concurrencyRetryAfter))
+		writeInvokeFailure(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, scheduler.ErrQueueFull):
+		w.Header().Set("Retry-After", strconv.Itoa(capacityRetryAfter))
+		writeInvokeFailure(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		writeInvokeFailure(w, "Failed to invoke function: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeInvokeFailure writes an InvokeError with the status code
+func writeInvokeFailure(w http.ResponseWriter, message string, code int) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(InvokeError{Error: message, Source: types.SourcePlatform})
 }
 
-// getExecutionHandler handles execution retrieval requests
+// writeRateLimited responds 429 with a Retry-After header, in whole seconds,
+// if err reports a throttled invocation
+func writeRateLimited(w http.ResponseWriter, err error) bool {
+	var limited *scheduler.RateLimitError
+	if !errors.As(err, &limited) {
+		return false
+	}
+	retryAfter := int(math.Ceil(limited.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeInvokeFailure(w, err.Error(), http.StatusTooManyRequests)
+	return true
+}
+
+// writeCapacityExceeded responds 503 with a Retry-After header if err reports
+// that no VM could be allocated
+func writeCapacityExceeded(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, vm.ErrCapacityExceeded) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(capacityRetryAfter))
+	writeInvokeFailure(w, err.Error(), http.StatusServiceUnavailable)
+	return true
+}
+
+// capacityRetryAfter is the Retry-After hint, in seconds, sent when all VMs are
+// in use or the execution queue is full
+const capacityRetryAfter = 5
+
+// concurrencyRetryAfter is the Retry-After hint, in seconds, sent when a
+// function has as many executions in progress as its concurrency limit allows
+const concurrencyRetryAfter = 1
+
+// versionHeader carries the pinned function version on invoke requests and
+// the version that served the invocation on responses
+const versionHeader = "X-Function-Version"
+
+// deadlineHeader caps, in milliseconds, how long a synchronous invoke waits
+// for its result; it takes precedence over the deadline_ms body field
+const deadlineHeader = "X-Invoke-Deadline"
+
+// invokeOptions builds the scheduler options for an invoke request, after
+// checking its input against the platform's limits. A version can be pinned
+// with the ?version= query parameter or the X-Function-Version header.
+func (h *APIHandler) invokeOptions(r *http.Request, req *InvokeRequest) (scheduler.InvokeOptions, error) {
+	version := r.URL.Query().Get("version")
+	if version == "" {
+		version = r.Header.Get(versionHeader)
+	}
+	opts := scheduler.InvokeOptions{
+		Environment: req.Environment,
+		Version:     version,
+	}
+
+	if err := scheduler.ValidateInput(req.Input); err != nil {
+		return opts, err
+	}
+
+	// Attribute the execution to the caller when an API key is given
+	if apiKey, ok := h.authManager.Identify(r); ok {
+		opts.UserID = apiKey.UserID
+	}
+
+	if req.Timeout != 0 {
+		if err := registry.ValidateTimeout(req.Timeout); err != nil {
+			return opts, err
+		}
+		opts.Timeout = req.Timeout
+	}
+
+	// Tags follow the same format as function labels
+	if err := registry.ValidateLabels(req.Tags); err != nil {
+		return opts, fmt.Errorf("invalid tags: %v", err)
+	}
+	opts.Tags = req.Tags
+
+	deadlineMS := req.DeadlineMS
+	if header := r.Header.Get(deadlineHeader); header != "" {
+		ms, err := strconv.Atoi(header)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s header: %q is not a number of milliseconds", deadlineHeader, header)
+		}
+		deadlineMS = ms
+	}
+	if deadlineMS < 0 {
+		return opts, fmt.Errorf("invoke deadline must be positive, got %d ms", deadlineMS)
+	}
+	if deadlineMS > 0 {
+		opts.Deadline = time.Now().Add(time.Duration(deadlineMS) * time.Millisecond)
+	}
+	return opts, nil
+}
+
+// writeInvokeResponse writes the result of an invocation. A successful
+// synchronous execution whose output is an HTTP-style response (see
+// types.ParseHTTPResponse) sets the status code, headers and body directly;
+// anything else is returned as the JSON execution result. A function that
+// ran and failed responds 200 with the error in the result, while a result
+// whose source is the platform responds with its 5xx status code.
+func writeInvokeResponse(w http.ResponseWriter, result *types.ExecutionResult) {
+	if result.Version != "" {
+		w.Header().Set(versionHeader, result.Version)
+	}
+
+	// Accepted asynchronous invocations, and synchronous ones that gave up
+	// waiting, point at where the result will be
+	if result.StatusCode == http.StatusAccepted || result.StatusCode == http.StatusGatewayTimeout {
+		result.ResultURL = "/api/executions/" + result.RequestID + "/result"
+		w.Header().Set("Location", result.ResultURL)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(result.StatusCode)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	if result.StatusCode == http.StatusOK && result.ErrorMessage == "" {
+		if response, ok := types.ParseHTTPResponse(result.Output); ok {
+			for key, value := range response.Headers {
+				w.Header().Set(key, value)
+			}
+			body, contentType := response.Payload()
+			if w.Header().Get("Content-Type") == "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.WriteHeader(response.StatusCode)
+			w.Write(body)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Source == types.SourcePlatform && result.StatusCode >= http.StatusInternalServerError {
+		w.WriteHeader(result.StatusCode)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// getExecutionHandler returns an execution with the VM that ran it and a
+// breakdown of its time into phases, see ExecutionDetail
 func (h *APIHandler) getExecutionHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -319,17 +1313,152 @@ func (h *APIHandler) getExecutionHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Return execution
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.executionDetail(execution))
+}
+
+// getLastExecutionHandler returns the execution of a function that started
+// most recently, in the same shape as getExecutionHandler
+func (h *APIHandler) getLastExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := h.functionRegistry.GetFunction(id); err != nil {
+		http.Error(w, "Function not found", http.StatusNotFound)
+		return
+	}
+
+	execution, err := h.stateManager.GetLatestExecution(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Function has never been invoked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get last execution: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.executionDetail(execution))
+}
+
+// getExecutionResultHandler returns the result of an execution. The response
+// status follows the execution: 202 while it is queued or running, 504 if it
+// timed out in the queue and 200 once it has finished.
+func (h *APIHandler) getExecutionResultHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := h.scheduler.GetExecutionResult(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Execution not found", http.StatusNotFound)
+		return
+	}
+
+	status := result.StatusCode
+	if status < http.StatusOK {
+		status = http.StatusAccepted
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// cancelExecutionHandler cancels an async execution that is still queued.
+// It responds 409 if the execution has already started or finished.
+func (h *APIHandler) cancelExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	execution, err := h.scheduler.CancelExecution(id)
+	h.audit(r, auditExecutionCancel, id, err)
+	if err != nil {
+		switch {
+		case errors.Is(err, scheduler.ErrExecutionNotFound):
+			http.Error(w, "Execution not found", http.StatusNotFound)
+		case errors.Is(err, scheduler.ErrExecutionStarted):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			h.logger.Errorf("Failed to cancel execution %s: %v", id, err)
+			http.Error(w, "Failed to cancel execution", http.StatusInternalServerError)
+		}
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(execution)
 }
 
-// listExecutionsHandler handles execution listing requests
+// listDeadLettersHandler lists the dead-lettered executions that haven't
+// been requeued, oldest first, optionally only those of ?function_id=
+func (h *APIHandler) listDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	executions, err := h.stateManager.ListDeadLetters(r.URL.Query().Get("function_id"))
+	if err != nil {
+		http.Error(w, "Failed to list dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(executions)
+}
+
+// requeueExecutionHandler runs a dead-lettered execution again as a new
+// async execution, responding like an async invoke
+func (h *APIHandler) requeueExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	result, err := h.scheduler.RequeueDeadLetter(id)
+	h.audit(r, auditExecutionRequeue, id, err)
+	if err != nil {
+		switch {
+		case errors.Is(err, scheduler.ErrExecutionNotFound):
+			http.Error(w, "Execution not found", http.StatusNotFound)
+		case errors.Is(err, scheduler.ErrNotDeadLettered):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			writeInvokeError(w, err)
+		}
+		return
+	}
+
+	writeInvokeResponse(w, result)
+}
+
+// listArtifactsHandler lists the artifacts produced by an execution
+func (h *APIHandler) listArtifactsHandler(w http.ResponseWriter, r *http.Request) {
+	artifacts, err := h.stateManager.ListArtifacts(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Failed to list artifacts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(artifacts)
+}
+
+// getArtifactHandler returns the contents of an artifact
+func (h *APIHandler) getArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	artifact, err := h.stateManager.GetArtifact(vars["id"], vars["name"])
+	if err != nil {
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(artifact.Name))
+	if contentType == "" {
+		contentType = http.DetectContentType(artifact.Data)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+	w.Write(artifact.Data)
+}
+
+// listExecutionsHandler lists a function's executions, oldest first,
+// optionally started within an RFC 3339 ?since= / ?until= window
 func (h *APIHandler) listExecutionsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	id := vars["id"]
+	filter := state.ExecutionFilter{FunctionID: vars["id"]}
+	if err := parseTimeWindow(r.URL.Query(), &filter.Since, &filter.Until); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// List executions
-	executions, err := h.stateManager.ListExecutions(id)
+	executions, err := h.stateManager.QueryExecutions(filter)
 	if err != nil {
 		http.Error(w, "Failed to list executions", http.StatusInternalServerError)
 		return
@@ -340,6 +1469,40 @@ func (h *APIHandler) listExecutionsHandler(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(executions)
 }
 
+// queryExecutionsHandler lists executions across functions, oldest first,
+// filtered by ?function_id=, an RFC 3339 ?since= / ?until= window and
+// ?tag=key=value. Repeated or comma-separated tags must all match.
+func (h *APIHandler) queryExecutionsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := state.ExecutionFilter{FunctionID: query.Get("function_id")}
+	if err := parseTimeWindow(query, &filter.Since, &filter.Until); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, selector := range query["tag"] {
+		tags, err := registry.ParseLabelSelector(selector)
+		if err != nil {
+			http.Error(w, "Invalid tag: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if filter.Tags == nil {
+			filter.Tags = map[string]string{}
+		}
+		for key, value := range tags {
+			filter.Tags[key] = value
+		}
+	}
+
+	executions, err := h.stateManager.QueryExecutions(filter)
+	if err != nil {
+		http.Error(w, "Failed to list executions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(executions)
+}
+
 // listVMsHandler handles VM listing requests
 func (h *APIHandler) listVMsHandler(w http.ResponseWriter, r *http.Request) {
 	// List VMs
@@ -354,6 +1517,27 @@ func (h *APIHandler) listVMsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(vms)
 }
 
+// ReconcileResponse reports the warm pool after a reconciliation
+type ReconcileResponse struct {
+	WarmPoolSize   int                 `json:"warm_pool_size"`
+	WarmPoolTarget int                 `json:"warm_pool_target"`
+	Pools          []vm.WarmPoolStatus `json:"pools"` // Per image, see FAAS_VM_RUNTIME_ROOTFS
+}
+
+// reconcileVMsHandler refills the warm pool to its target right away instead
+// of waiting for the pool manager's next pass
+func (h *APIHandler) reconcileVMsHandler(w http.ResponseWriter, r *http.Request) {
+	size := h.vmManager.ReconcileWarmPool()
+	h.audit(r, auditVMReconcile, "warm_pool", nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReconcileResponse{
+		WarmPoolSize:   size,
+		WarmPoolTarget: h.vmManager.WarmPoolTarget(),
+		Pools:          h.vmManager.WarmPools(),
+	})
+}
+
 // getVMHandler handles VM retrieval requests
 func (h *APIHandler) getVMHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -371,28 +1555,85 @@ func (h *APIHandler) getVMHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(vm)
 }
 
+// getVMInfoHandler returns the version and capabilities of a VM's daemon
+func (h *APIHandler) getVMInfoHandler(w http.ResponseWriter, r *http.Request) {
+	vm, err := h.vmManager.GetVMByID(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "VM not found", http.StatusNotFound)
+		return
+	}
+
+	info, err := h.vmManager.GetDaemonInfo(vm)
+	if err != nil {
+		h.logger.Warnf("Failed to get daemon info for VM %s: %v", vm.ID, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(info)
+}
+
+// getVMConsoleHandler returns the last lines of a VM's serial console
+// (?lines=N, default 100). VMs that failed to boot can be inspected too.
+func (h *APIHandler) getVMConsoleHandler(w http.ResponseWriter, r *http.Request) {
+	lines, err := strconv.Atoi(r.URL.Query().Get("lines"))
+	if err != nil || lines <= 0 {
+		lines = vm.DefaultConsoleLines
+	}
+
+	output, err := h.vmManager.ConsoleOutput(mux.Vars(r)["id"], lines)
+	if errors.Is(err, vm.ErrNoConsole) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to read VM console: %v", err)
+		http.Error(w, "Failed to read VM console", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(output)
+}
+
 // registerVMHandler handles VM registration requests
 func (h *APIHandler) registerVMHandler(w http.ResponseWriter, r *http.Request) {
+	// Daemons present the result secret here too
+	if !daemonclient.VerifyResultSecret(r, h.resultSecret) {
+		h.logger.Warnf("Rejected VM registration from %s without a valid result secret", r.RemoteAddr)
+		http.Error(w, "Invalid result secret", http.StatusUnauthorized)
+		return
+	}
+
 	var vmInfo VMInfo
 	if err := json.NewDecoder(r.Body).Decode(&vmInfo); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	if vmInfo.VMID == "" {
+		http.Error(w, "vm_id is required", http.StatusBadRequest)
+		return
+	}
+
 	h.logger.Infof("Registering VM: %s (%s) at %s", vmInfo.VMID, vmInfo.MachineName, vmInfo.IPAddress)
 
 	// Get VM from state manager
 	vm, err := h.vmManager.GetVMByID(vmInfo.VMID)
 	if err != nil {
-		// VM not found, create a new one
-		h.logger.Warnf("VM not found in state manager: %s", vmInfo.VMID)
-		http.Error(w, "VM not found", http.StatusNotFound)
-		return
+		// VM not found, so it was started outside the control plane; record it
+		h.logger.Infof("Recording externally started VM %s", vmInfo.VMID)
+		vm = &state.VM{
+			ID:        vmInfo.VMID,
+			CreatedAt: time.Now(),
+		}
 	}
 
 	// Update VM status
 	vm.Status = vmInfo.Status
 	vm.IP = vmInfo.IPAddress
+	vm.LastUsed = time.Now()
 	if err := h.stateManager.SaveVM(vm); err != nil {
 		h.logger.Errorf("Failed to update VM status: %v", err)
 		http.Error(w, "Failed to update VM status", http.StatusInternalServerError)
@@ -404,9 +1645,61 @@ func (h *APIHandler) registerVMHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("VM registered"))
 }
 
+// listHostsHandler handles host agent listing requests
+func (h *APIHandler) listHostsHandler(w http.ResponseWriter, r *http.Request) {
+	hosts, err := h.vmManager.ListHosts()
+	if err != nil {
+		http.Error(w, "Failed to list hosts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hosts)
+}
+
+// registerHostHandler handles host agent registration and heartbeats
+func (h *APIHandler) registerHostHandler(w http.ResponseWriter, r *http.Request) {
+	if h.hostToken == "" {
+		http.Error(w, "Host agents are disabled, set "+vm.EnvHostToken+" to enable them", http.StatusForbidden)
+		return
+	}
+	if !vm.VerifyHostToken(r, h.hostToken) {
+		h.logger.Warnf("Rejected host registration from %s without a valid host token", r.RemoteAddr)
+		http.Error(w, "Invalid host token", http.StatusUnauthorized)
+		return
+	}
+
+	var host state.Host
+	if err := json.NewDecoder(r.Body).Decode(&host); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.vmManager.RegisterHost(&host); err != nil {
+		if errors.Is(err, vm.ErrInvalidHost) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.logger.Errorf("Failed to register host: %v", err)
+		http.Error(w, "Failed to register host", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Debugf("Host %s at %s checked in with capacity %d", host.ID, host.Address, host.Capacity)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(host)
+}
+
 // handleResultHandler handles function execution result reports from VMs
 func (h *APIHandler) handleResultHandler(w http.ResponseWriter, r *http.Request) {
-	var result ExecutionResult
+	if !daemonclient.VerifyResultSecret(r, h.resultSecret) {
+		h.logger.Warnf("Rejected result report from %s without a valid result secret", r.RemoteAddr)
+		http.Error(w, "Invalid result secret", http.StatusUnauthorized)
+		return
+	}
+
+	var result types.ExecutionResult
 	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
@@ -422,18 +1715,51 @@ func (h *APIHandler) handleResultHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// A result arriving after the execution ended, e.g. once a synchronous
+	// invoke gave up on it or as a duplicate, must not replace the outcome
+	// already recorded; whoever ended it has returned the VM
+	if execution.Status.Terminal() {
+		h.logger.Warnf("Ignoring result for execution %s, which already ended as %s", execution.ID, execution.Status)
+		http.Error(w, fmt.Sprintf("Execution already ended as %s", execution.Status), http.StatusConflict)
+		return
+	}
+
 	// Update execution status
-	execution.Status = "completed"
+	execution.Status = state.StatusCompleted
 	execution.EndTime = time.Now()
 	execution.Duration = result.Duration
+	execution.SetupMs = result.SetupMs
+	execution.HandlerMs = result.HandlerMs
+	execution.OutputBytes = int64(len(result.Output))
+	execution.Stderr = result.Logs
+	scheduler.ObserveOutputBytes(execution.FunctionID, len(result.Output))
 
 	if result.StatusCode == 200 {
 		// Store the output in the logs field since there's no Result field
-		execution.Logs = result.Output
+		execution.Logs = string(result.Output)
 	} else {
-		execution.Status = "error"
+		execution.Status = state.StatusFailed
 		execution.Error = result.ErrorMessage
+		execution.ErrorType = result.ErrorType
+		execution.ReasonCode = result.ReasonCode
+	}
+	h.maskExecutionSecrets(execution)
+
+	// Store artifacts before the execution is marked finished so they are
+	// available to anyone who sees it complete
+	if len(result.Artifacts) > 0 {
+		if err := h.stateManager.SaveArtifacts(execution.ID, result.Artifacts); err != nil {
+			h.logger.Errorf("Failed to save artifacts: %v", err)
+			http.Error(w, "Failed to save artifacts", http.StatusInternalServerError)
+			return
+		}
 	}
+	if result.ArtifactsTruncated {
+		h.logger.Warnf("Artifacts of execution %s exceeded the size cap and were truncated", execution.ID)
+	}
+
+	// Keep the VM of a crashed function for inspection instead of reusing it
+	h.scheduler.QuarantineFailed(execution)
 
 	// Save execution
 	if err := h.stateManager.SaveExecution(execution); err != nil {
@@ -441,6 +1767,16 @@ func (h *APIHandler) handleResultHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Failed to save execution", http.StatusInternalServerError)
 		return
 	}
+	if err := h.vmManager.ReturnAsyncVM(execution.VMID); err != nil {
+		h.logger.Errorf("Failed to return VM %s to pool: %v", execution.VMID, err)
+	}
+	h.scheduler.CacheResult(execution)
+	h.scheduler.RetryFailed(execution)
+	h.scheduler.NotifyCompletion(execution.FunctionID)
+
+	if function, err := h.functionRegistry.GetFunction(execution.FunctionID); err == nil {
+		h.forwardLogs(function, execution)
+	}
 
 	// Return success
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,2194 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/auth"
+	"github.com/bluequbit/faas/control-plane/buildinfo"
+	"github.com/bluequbit/faas/control-plane/daemonclient"
+	"github.com/bluequbit/faas/control-plane/daemonclient/daemontest"
+	"github.com/bluequbit/faas/control-plane/registry"
+	"github.com/bluequbit/faas/control-plane/runtimes"
+	"github.com/bluequbit/faas/control-plane/scheduler"
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/types"
+	"github.com/bluequbit/faas/control-plane/vm"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// testAPI is a control plane API served from a fresh database in a
+// temporary directory
+type testAPI struct {
+	*httptest.Server
+	handler *APIHandler
+	key     string // Admin API key
+}
+
+// newTestAPI starts the API routes of a new control plane. Configure it
+// through the environment before calling.
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+
+	// The database is created in the working directory
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv(vm.EnvDataDir, dir)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	stateManager, err := state.NewStateManager(logger)
+	if err != nil {
+		t.Fatalf("failed to create state manager: %v", err)
+	}
+	vmManager, err := vm.NewVMManager(stateManager, logger, true)
+	if err != nil {
+		t.Fatalf("failed to create VM manager: %v", err)
+	}
+	t.Cleanup(vmManager.Cleanup)
+	functionRegistry, err := registry.NewFunctionRegistry(stateManager, logger)
+	if err != nil {
+		t.Fatalf("failed to create function registry: %v", err)
+	}
+	functionScheduler, err := scheduler.NewScheduler(vmManager, functionRegistry, stateManager, logger)
+	if err != nil {
+		t.Fatalf("failed to create scheduler: %v", err)
+	}
+	t.Cleanup(functionScheduler.Stop)
+	authManager, err := auth.NewAuthManager(logger)
+	if err != nil {
+		t.Fatalf("failed to create auth manager: %v", err)
+	}
+	key, err := authManager.GenerateAPIKey("admin", []string{"admin"}, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate API key: %v", err)
+	}
+
+	handler := NewAPIHandler(functionRegistry, vmManager, functionScheduler, authManager, stateManager, logger)
+	// Background writes must finish before the directory is removed
+	t.Cleanup(handler.Wait)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return &testAPI{Server: server, handler: handler, key: key}
+}
+
+// do sends an authenticated request with body encoded as JSON, unless it's
+// nil, and decodes the response into out, unless it's nil
+func (a *testAPI) do(t *testing.T, method, path string, body, out interface{}) *http.Response {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, a.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: invalid response: %v", method, path, err)
+		}
+	}
+	return resp
+}
+
+// registerFunction registers a function through the registry
+func (a *testAPI) registerFunction(t *testing.T, name string) *registry.FunctionMetadata {
+	t.Helper()
+	function, err := a.handler.functionRegistry.RegisterFunction(registry.FunctionRegistration{
+		Name:    name,
+		Runtime: "python3",
+		Memory:  128,
+		Timeout: 30,
+		Code:    registry.FunctionCode{Code: "def handler(event, context):\n    return event\n"},
+	})
+	if err != nil {
+		t.Fatalf("failed to register function: %v", err)
+	}
+	return function
+}
+
+// runningExecution stores a running execution of a function, as if it had
+// been dispatched to a daemon
+func (a *testAPI) runningExecution(t *testing.T, functionID string) *state.Execution {
+	t.Helper()
+	execution := &state.Execution{
+		ID:         "exec-" + functionID,
+		FunctionID: functionID,
+		Status:     state.StatusRunning,
+		StartTime:  time.Now(),
+		Attempt:    1,
+	}
+	if err := a.handler.stateManager.SaveExecution(execution); err != nil {
+		t.Fatalf("failed to save execution: %v", err)
+	}
+	return execution
+}
+
+// execution returns the stored execution with the given ID
+func (a *testAPI) execution(t *testing.T, id string) *state.Execution {
+	t.Helper()
+	execution, err := a.handler.stateManager.GetExecution(id)
+	if err != nil {
+		t.Fatalf("execution %s not stored: %v", id, err)
+	}
+	return execution
+}
+
+// completedResult is the result a daemon reports for a finished execution
+func completedResult(execution *state.Execution, output string) *types.ExecutionResult {
+	return &types.ExecutionResult{
+		RequestID:  execution.ID,
+		FunctionID: execution.FunctionID,
+		StatusCode: http.StatusOK,
+		Output:     types.OutputFromString(output),
+	}
+}
+
+func TestResultReportWithSecret(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "s3cret")
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+	execution := a.runningExecution(t, function.ID)
+
+	if status := daemontest.Report(a.URL, "s3cret", completedResult(execution, `{"ok":true}`)); status != http.StatusOK {
+		t.Fatalf("report with the secret got status %d, want 200", status)
+	}
+	stored := a.execution(t, execution.ID)
+	if stored.Status != state.StatusCompleted || stored.Logs != `{"ok":true}` {
+		t.Errorf("stored execution is %q with output %q, want the reported result", stored.Status, stored.Logs)
+	}
+}
+
+func TestResultReportArtifacts(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+	execution := a.runningExecution(t, function.ID)
+
+	result := completedResult(execution, `{"ok":true}`)
+	result.Artifacts = map[string][]byte{"report.txt": []byte("all good\n")}
+	if status := daemontest.Report(a.URL, "", result); status != http.StatusOK {
+		t.Fatalf("report got status %d, want 200", status)
+	}
+
+	resp, err := http.Get(a.URL + "/api/executions/" + execution.ID + "/artifacts/report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "all good\n" {
+		t.Errorf("artifact got status %d with %q, want 200 with the reported contents", resp.StatusCode, body)
+	}
+	if resp := a.do(t, http.MethodGet, "/api/executions/"+execution.ID+"/artifacts/missing.txt", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing artifact got status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestResultReportOutputBytes(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+	execution := a.runningExecution(t, function.ID)
+
+	const output = `{"greeting":"hello world"}`
+	if status := daemontest.Report(a.URL, "", completedResult(execution, output)); status != http.StatusOK {
+		t.Fatalf("report got status %d, want 200", status)
+	}
+	if stored := a.execution(t, execution.ID); stored.OutputBytes != int64(len(output)) {
+		t.Errorf("output bytes = %d, want %d", stored.OutputBytes, len(output))
+	}
+
+	var stats state.PayloadStats
+	if resp := a.do(t, http.MethodGet, "/api/functions/"+function.ID+"/stats", nil, &stats); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET stats got status %d, want 200", resp.StatusCode)
+	}
+	if stats.Executions != 1 || stats.OutputBytes.Max != int64(len(output)) {
+		t.Errorf("stats = %+v, want one execution of %d output bytes", stats, len(output))
+	}
+}
+
+func TestResultReportReasonCode(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hungry")
+	execution := a.runningExecution(t, function.ID)
+
+	result := &types.ExecutionResult{
+		RequestID:    execution.ID,
+		FunctionID:   function.ID,
+		StatusCode:   http.StatusInternalServerError,
+		ErrorMessage: "out of memory",
+		ErrorType:    "oom",
+		ReasonCode:   state.ReasonOOM,
+	}
+	if status := daemontest.Report(a.URL, "", result); status != http.StatusOK {
+		t.Fatalf("report got status %d, want 200", status)
+	}
+	if stored := a.execution(t, execution.ID); stored.Status != state.StatusFailed || stored.ReasonCode != state.ReasonOOM {
+		t.Errorf("execution is %q with reason code %q, want failed with %s", stored.Status, stored.ReasonCode, state.ReasonOOM)
+	}
+}
+
+func TestWatchExecutionsReturnsOnCompletion(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "watched")
+	execution := a.runningExecution(t, function.ID)
+	cursor := time.Now().Format(time.RFC3339Nano)
+
+	type watchResult struct {
+		response WatchResponse
+		status   int
+		elapsed  time.Duration
+	}
+	watched := make(chan watchResult, 1)
+	req, err := http.NewRequest(http.MethodGet, a.URL+"/api/functions/"+function.ID+"/executions/watch?timeout=30&cursor="+cursor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+a.key)
+	go func() {
+		var result watchResult
+		start := time.Now()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			watched <- result
+			return
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&result.response)
+		result.status, result.elapsed = resp.StatusCode, time.Since(start)
+		watched <- result
+	}()
+
+	// Give the long-poll time to start waiting
+	time.Sleep(200 * time.Millisecond)
+	if status := daemontest.Report(a.URL, "", completedResult(execution, `{"ok":true}`)); status != http.StatusOK {
+		t.Fatalf("report got status %d, want 200", status)
+	}
+
+	var result watchResult
+	select {
+	case result = <-watched:
+	case <-time.After(10 * time.Second):
+		t.Fatal("long-poll didn't return after the execution finished")
+	}
+	if result.status != http.StatusOK {
+		t.Fatalf("watch got status %d, want 200", result.status)
+	}
+	if result.elapsed > 5*time.Second {
+		t.Errorf("long-poll returned after %v, want it right after the result", result.elapsed)
+	}
+	if len(result.response.Executions) != 1 || result.response.Executions[0].ID != execution.ID {
+		t.Fatalf("watch returned %+v, want the finished execution", result.response.Executions)
+	}
+
+	// Nothing has finished since the returned cursor
+	var next WatchResponse
+	a.do(t, http.MethodGet, "/api/functions/"+function.ID+"/executions/watch?timeout=0&cursor="+result.response.Cursor, nil, &next)
+	if len(next.Executions) != 0 {
+		t.Errorf("watch from the returned cursor = %+v, want no executions", next.Executions)
+	}
+}
+
+func TestResultReportRejected(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "s3cret")
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+	execution := a.runningExecution(t, function.ID)
+
+	for _, secret := range []string{"", "wrong"} {
+		if status := daemontest.Report(a.URL, secret, completedResult(execution, `{"forged":true}`)); status != http.StatusUnauthorized {
+			t.Errorf("report with secret %q got status %d, want 401", secret, status)
+		}
+	}
+	if stored := a.execution(t, execution.ID); stored.Status != state.StatusRunning || stored.Logs != "" {
+		t.Errorf("rejected reports changed the execution to %q with output %q", stored.Status, stored.Logs)
+	}
+}
+
+func TestResultReportWithoutConfiguredSecret(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "")
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+	execution := a.runningExecution(t, function.ID)
+
+	if status := daemontest.Report(a.URL, "", completedResult(execution, `{"ok":true}`)); status != http.StatusOK {
+		t.Fatalf("report got status %d, want 200 when no secret is configured", status)
+	}
+	if stored := a.execution(t, execution.ID); stored.Status != state.StatusCompleted {
+		t.Errorf("stored status = %q, want completed", stored.Status)
+	}
+}
+
+func TestResultChunkRejected(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "s3cret")
+	a := newTestAPI(t)
+
+	resp, err := http.Post(a.URL+"/api/results/chunks", "application/json", bytes.NewReader([]byte(`{"request_id":"x","seq":1,"data":1}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("chunk without the secret got status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestResultReportFailureEndsSyncInvoke(t *testing.T) {
+	daemon := daemontest.NewServer(func(payload *daemontest.Payload) *types.ExecutionResult {
+		return &types.ExecutionResult{
+			RequestID:    payload.RequestID,
+			FunctionID:   payload.FunctionID,
+			StatusCode:   http.StatusInternalServerError,
+			ErrorMessage: "ZeroDivisionError: division by zero",
+			ErrorType:    "runtime",
+		}
+	})
+	defer daemon.Close()
+	t.Setenv(daemonclient.EnvDaemonURL, daemon.URL)
+	a := newTestAPI(t)
+	daemon.ReportTo(a.URL, "")
+	function := a.registerFunction(t, "divide")
+
+	vmInstance := &state.VM{ID: "vm-test", IP: "127.0.0.1", Status: "ready"}
+	result, err := a.handler.scheduler.ExecuteOnVM(function.ID, nil, scheduler.InvokeOptions{}, vmInstance)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	if result.Status != string(state.StatusFailed) || result.ErrorType != "runtime" {
+		t.Fatalf("got status %q error type %q, want the reported failure", result.Status, result.ErrorType)
+	}
+	if result.ErrorMessage != "ZeroDivisionError: division by zero" {
+		t.Errorf("error message = %q", result.ErrorMessage)
+	}
+}
+
+func TestResultReportAfterExecutionEnded(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "")
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+	execution := a.runningExecution(t, function.ID)
+	execution.Status = state.StatusTimeout
+	execution.Error = "Function too slow"
+	if err := a.handler.stateManager.SaveExecution(execution); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := daemontest.Report(a.URL, "", completedResult(execution, `{"late":true}`)); status != http.StatusConflict {
+		t.Fatalf("late report got status %d, want 409", status)
+	}
+	if stored := a.execution(t, execution.ID); stored.Status != state.StatusTimeout || stored.Logs != "" {
+		t.Errorf("late report changed the execution to %q with output %q", stored.Status, stored.Logs)
+	}
+}
+
+func TestResultReportDuplicate(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "")
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+	execution := a.runningExecution(t, function.ID)
+
+	if status := daemontest.Report(a.URL, "", completedResult(execution, `{"n":1}`)); status != http.StatusOK {
+		t.Fatalf("first report got status %d, want 200", status)
+	}
+	if status := daemontest.Report(a.URL, "", completedResult(execution, `{"n":2}`)); status != http.StatusConflict {
+		t.Fatalf("duplicate report got status %d, want 409", status)
+	}
+	if stored := a.execution(t, execution.ID); stored.Logs != `{"n":1}` {
+		t.Errorf("output = %q, want the first report's", stored.Logs)
+	}
+}
+
+// post sends a POST authenticated only by the given headers. It is safe to
+// call from other goroutines, returning 0 if the request can't be sent.
+func post(t *testing.T, url, body string, headers map[string]string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRegisterHost(t *testing.T) {
+	t.Setenv(vm.EnvHostToken, "host-token")
+	a := newTestAPI(t)
+	host := `{"id": "host-2", "address": "http://10.0.0.2:8080", "capacity": 4}`
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"without the token", nil, http.StatusUnauthorized},
+		{"with a wrong token", map[string]string{vm.HostTokenHeader: "guess"}, http.StatusUnauthorized},
+		{"with an API key instead", map[string]string{"Authorization": "Bearer " + a.key}, http.StatusUnauthorized},
+		{"with the token", map[string]string{vm.HostTokenHeader: "host-token"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if status := post(t, a.URL+"/api/hosts/register", host, tt.headers); status != tt.want {
+			t.Errorf("registration %s got status %d, want %d", tt.name, status, tt.want)
+		}
+	}
+
+	hosts, err := a.handler.vmManager.ListHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].ID != "host-2" {
+		t.Errorf("registered hosts = %+v, want host-2 only", hosts)
+	}
+}
+
+func TestRegisterHostWithoutConfiguredToken(t *testing.T) {
+	t.Setenv(vm.EnvHostToken, "")
+	a := newTestAPI(t)
+
+	host := `{"id": "host-2", "address": "http://10.0.0.2:8080", "capacity": 4}`
+	if status := post(t, a.URL+"/api/hosts/register", host, map[string]string{vm.HostTokenHeader: ""}); status != http.StatusForbidden {
+		t.Errorf("registration got status %d, want 403 with host agents disabled", status)
+	}
+}
+
+func TestRegisterVMRequiresResultSecret(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "s3cret")
+	a := newTestAPI(t)
+	info := `{"vm_id": "vm-1", "ip_address": "172.16.0.2", "status": "ready"}`
+
+	if status := post(t, a.URL+"/api/vms/register", info, nil); status != http.StatusUnauthorized {
+		t.Errorf("registration without the secret got status %d, want 401", status)
+	}
+	if _, err := a.handler.stateManager.GetVM("vm-1"); err == nil {
+		t.Error("the rejected registration recorded the VM")
+	}
+
+	if status := post(t, a.URL+"/api/vms/register", info, map[string]string{daemonclient.ResultSecretHeader: "s3cret"}); status != http.StatusOK {
+		t.Errorf("registration with the secret got status %d, want 200", status)
+	}
+	if _, err := a.handler.stateManager.GetVM("vm-1"); err != nil {
+		t.Errorf("the registration didn't record the VM: %v", err)
+	}
+}
+
+func TestPatchFunctionTimeoutOnly(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+
+	var patched registry.FunctionMetadata
+	if resp := a.do(t, http.MethodPatch, "/api/functions/"+function.ID, map[string]int{"timeout": 60}, &patched); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH got status %d, want 200", resp.StatusCode)
+	}
+	if patched.Timeout != 60 || patched.Memory != 128 || patched.Version != function.Version {
+		t.Errorf("patched function = %+v, want only the timeout changed", patched)
+	}
+	code, err := a.handler.functionRegistry.GetFunctionCode(function.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code.Code != "def handler(event, context):\n    return event\n" {
+		t.Errorf("code changed to %q", code.Code)
+	}
+}
+
+func TestPatchFunctionRejectsInvalidSettings(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"reserved environment variable", `{"environment": {"FAAS_RESULT_SECRET": "x"}}`},
+		{"reserved environment prefix", `{"environment": {"SKYSCALE_TOKEN": "x"}}`},
+		{"negative memory", `{"memory": -1}`},
+		{"negative concurrency", `{"concurrency": -1}`},
+		{"code", `{"code": "def handler(event, context):\n    return 1\n"}`},
+	}
+	for _, tt := range tests {
+		resp := a.do(t, http.MethodPatch, "/api/functions/"+function.ID, json.RawMessage(tt.body), nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("PATCH with %s got status %d, want 400", tt.name, resp.StatusCode)
+		}
+	}
+
+	stored, err := a.handler.functionRegistry.GetFunction(function.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Memory != 128 || stored.Concurrency != 0 || len(stored.Environment) != 0 {
+		t.Errorf("rejected patches changed the function to %+v", stored)
+	}
+}
+
+func TestInvokeConcurrencyLimit(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+	if resp := a.do(t, http.MethodPatch, "/api/functions/"+function.ID, map[string]int{"concurrency": 1}, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH got status %d, want 200", resp.StatusCode)
+	}
+	a.runningExecution(t, function.ID)
+
+	resp := a.do(t, http.MethodPost, "/api/functions/"+function.ID+"/invoke", map[string]interface{}{"sync": true}, nil)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("invoke got status %d, want 429", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("429 response has no Retry-After header")
+	}
+}
+
+func TestAsyncInvokeResultLocation(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+
+	var result types.ExecutionResult
+	resp := a.do(t, http.MethodPost, "/api/functions/"+function.ID+"/invoke", map[string]interface{}{"input": map[string]string{}}, &result)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("invoke got status %d, want 202", resp.StatusCode)
+	}
+	want := "/api/executions/" + result.RequestID + "/result"
+	if location := resp.Header.Get("Location"); location != want {
+		t.Errorf("Location = %q, want %q", location, want)
+	}
+	if result.ResultURL != want {
+		t.Errorf("result_url = %q, want %q", result.ResultURL, want)
+	}
+
+	// The location serves the execution's result
+	if resp := a.do(t, http.MethodGet, want, nil, nil); resp.StatusCode == http.StatusNotFound {
+		t.Errorf("GET %s got status 404", want)
+	}
+}
+
+func TestRegisterFunctionConflict(t *testing.T) {
+	a := newTestAPI(t)
+	body := `{"name": "hello", "runtime": "python3", "code": "def handler(event, context):\n    return event\n",
+		"concurrency": 2, "labels": {"team": "data"}, "skip_validation": true}`
+	headers := map[string]string{"Authorization": "Bearer " + a.key}
+
+	const registrations = 4
+	statuses := make(chan int, registrations)
+	var wg sync.WaitGroup
+	for i := 0; i < registrations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- post(t, a.URL+"/api/functions", body, headers)
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("registration got status %d, want 200 or 409", status)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d registrations succeeded, want exactly 1", created)
+	}
+
+	function, err := a.handler.functionRegistry.GetFunctionByName("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if function.Concurrency != 2 || function.Labels["team"] != "data" {
+		t.Errorf("registered function = %+v, want its settings saved", function)
+	}
+}
+
+func TestFunctionTimeoutOutOfRange(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+
+	register := `{"name": "day-long", "runtime": "python3", "timeout": 86400, "skip_validation": true,
+		"code": "def handler(event, context):\n    return event\n"}`
+	if resp := a.do(t, http.MethodPost, "/api/functions", json.RawMessage(register), nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("register got status %d, want 400", resp.StatusCode)
+	}
+	update := `{"timeout": 301, "code": "def handler(event, context):\n    return event\n"}`
+	if resp := a.do(t, http.MethodPut, "/api/functions/"+function.ID, json.RawMessage(update), nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("PUT got status %d, want 400", resp.StatusCode)
+	}
+	if resp := a.do(t, http.MethodPatch, "/api/functions/"+function.ID, map[string]int{"timeout": 301}, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("PATCH got status %d, want 400", resp.StatusCode)
+	}
+	if resp := a.do(t, http.MethodPatch, "/api/functions/"+function.ID, map[string]int{"timeout": 300}, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("PATCH to the maximum got status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestListRuntimes(t *testing.T) {
+	a := newTestAPI(t)
+
+	var list []runtimes.Runtime
+	if resp := a.do(t, http.MethodGet, "/api/runtimes", nil, &list); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/runtimes got status %d, want 200", resp.StatusCode)
+	}
+	for _, rt := range list {
+		if rt.Name == "python3" && rt.Language == "python" {
+			return
+		}
+	}
+	t.Errorf("python3 isn't listed in %+v", list)
+}
+
+func TestBulkDeleteFunctionsByLabel(t *testing.T) {
+	a := newTestAPI(t)
+	register := func(name, env string) string {
+		function, err := a.handler.functionRegistry.RegisterFunction(registry.FunctionRegistration{
+			Name:    name,
+			Runtime: "python3",
+			Code:    registry.FunctionCode{Code: "def handler(event, context):\n    return event\n"},
+			Labels:  map[string]string{"env": env},
+		})
+		if err != nil {
+			t.Fatalf("failed to register function: %v", err)
+		}
+		return function.ID
+	}
+	test := register("test-a", "test")
+	prod := register("prod-a", "prod")
+
+	if resp := a.do(t, http.MethodDelete, "/api/functions?label=env=test", nil, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("DELETE without confirm=true got status %d, want 400", resp.StatusCode)
+	}
+	userKey, err := a.handler.authManager.GenerateAPIKey("dev", []string{"user"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodDelete, a.URL+"/api/functions?label=env=test&confirm=true", nil)
+	req.Header.Set("Authorization", "Bearer "+userKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("DELETE without the admin role got status %d, want 403", resp.StatusCode)
+	}
+
+	var result struct {
+		Deleted []string `json:"deleted"`
+	}
+	if resp := a.do(t, http.MethodDelete, "/api/functions?label=env=test&confirm=true", nil, &result); resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE got status %d, want 200", resp.StatusCode)
+	}
+	if len(result.Deleted) != 1 || result.Deleted[0] != test {
+		t.Errorf("deleted %v, want [%s]", result.Deleted, test)
+	}
+	if _, err := a.handler.functionRegistry.GetFunction(prod); err != nil {
+		t.Errorf("unmatched function was deleted: %v", err)
+	}
+}
+
+func TestWriteInvokeResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		result      *types.ExecutionResult
+		status      int
+		contentType string
+		header      string // Location header
+		body        string
+	}{
+		{
+			name:        "HTTP-style response",
+			result:      &types.ExecutionResult{StatusCode: http.StatusOK, Output: json.RawMessage(`{"statusCode": 201, "headers": {"Location": "/items/1"}, "body": {"id": 1}}`)},
+			status:      http.StatusCreated,
+			contentType: "application/json",
+			header:      "/items/1",
+			body:        `{"id": 1}`,
+		},
+		{
+			name:        "HTTP-style text body",
+			result:      &types.ExecutionResult{StatusCode: http.StatusOK, Output: json.RawMessage(`{"statusCode": 404, "body": "not here"}`)},
+			status:      http.StatusNotFound,
+			contentType: "text/plain; charset=utf-8",
+			body:        "not here",
+		},
+		{
+			name:        "plain output",
+			result:      &types.ExecutionResult{RequestID: "req-1", StatusCode: http.StatusOK, Output: json.RawMessage(`{"message": "hi"}`)},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `"output":{"message":"hi"}`,
+		},
+		{
+			name:        "sync invoke past its deadline",
+			result:      &types.ExecutionResult{RequestID: "req-1", StatusCode: http.StatusGatewayTimeout, Source: types.SourcePlatform},
+			status:      http.StatusGatewayTimeout,
+			contentType: "application/json",
+			header:      "/api/executions/req-1/result",
+			body:        `"result_url":"/api/executions/req-1/result"`,
+		},
+		{
+			name:        "failed execution is never unwrapped",
+			result:      &types.ExecutionResult{StatusCode: http.StatusOK, ErrorMessage: "boom", Output: json.RawMessage(`{"statusCode": 201}`)},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `"error_message":"boom"`,
+		},
+		{
+			name:        "function exception",
+			result:      &types.ExecutionResult{StatusCode: http.StatusInternalServerError, ErrorMessage: "boom", ErrorType: "exception", Source: types.SourceFunction},
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `"source":"function"`,
+		},
+		{
+			name:        "platform failure",
+			result:      &types.ExecutionResult{StatusCode: http.StatusBadGateway, ErrorMessage: "Daemon rejected the execution request", Source: types.SourcePlatform},
+			status:      http.StatusBadGateway,
+			contentType: "application/json",
+			body:        `"source":"platform"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeInvokeResponse(w, tt.result)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := w.Header().Get("Location"); got != tt.header {
+				t.Errorf("Location = %q, want %q", got, tt.header)
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("body = %s, want it to contain %s", w.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestWriteCapacityExceeded(t *testing.T) {
+	w := httptest.NewRecorder()
+	if !writeCapacityExceeded(w, fmt.Errorf("failed to allocate VM: %w", vm.ErrCapacityExceeded)) {
+		t.Fatal("a wrapped capacity error wasn't written")
+	}
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After = %q; want 503 with a Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	var body InvokeError
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Source != types.SourcePlatform || body.Error == "" {
+		t.Errorf("body = %+v, %v; want an error from the platform", body, err)
+	}
+
+	if writeCapacityExceeded(httptest.NewRecorder(), fmt.Errorf("failed to allocate VM: boot failed")) {
+		t.Error("another allocation error was written as capacity exceeded")
+	}
+}
+
+func TestRegisterVMUpsert(t *testing.T) {
+	a := newTestAPI(t)
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := a.handler.stateManager.SaveVM(&state.VM{ID: "vm-known", Status: "booting", IP: "172.16.0.2", CreatedAt: created}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A VM the control plane created updates its record
+	if status := post(t, a.URL+"/api/vms/register", `{"vm_id": "vm-known", "ip_address": "172.16.0.3", "status": "ready"}`, nil); status != http.StatusOK {
+		t.Fatalf("registration of a known VM got status %d, want 200", status)
+	}
+	known, err := a.handler.stateManager.GetVM("vm-known")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if known.Status != "ready" || known.IP != "172.16.0.3" || !known.CreatedAt.Equal(created) {
+		t.Errorf("known VM stored as %+v", known)
+	}
+
+	// A VM started outside the control plane is recorded
+	if status := post(t, a.URL+"/api/vms/register", `{"vm_id": "vm-external", "ip_address": "172.16.0.9", "status": "ready"}`, nil); status != http.StatusOK {
+		t.Fatalf("registration of a new VM got status %d, want 200", status)
+	}
+	external, err := a.handler.stateManager.GetVM("vm-external")
+	if err != nil {
+		t.Fatalf("new VM not recorded: %v", err)
+	}
+	if external.Status != "ready" || external.IP != "172.16.0.9" || external.CreatedAt.IsZero() {
+		t.Errorf("new VM stored as %+v", external)
+	}
+
+	if status := post(t, a.URL+"/api/vms/register", `{"ip_address": "172.16.0.9"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("registration without a VM ID got status %d, want 400", status)
+	}
+}
+
+func TestVMConsole(t *testing.T) {
+	a := newTestAPI(t)
+	testVM, err := a.handler.vmManager.CreateTestHostVM()
+	if err != nil {
+		t.Fatalf("CreateTestHostVM: %v", err)
+	}
+
+	if resp := a.do(t, http.MethodGet, "/api/vms/"+testVM.ID+"/console", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("console of the test host VM got status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestInvokeDeadline(t *testing.T) {
+	a := newTestAPI(t)
+	tests := []struct {
+		name    string
+		header  string
+		body    int // deadline_ms
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "body field", body: 3000, want: 3 * time.Second},
+		{name: "header", header: "2000", want: 2 * time.Second},
+		{name: "header takes precedence", header: "1000", body: 3000, want: time.Second},
+		{name: "invalid header", header: "soon", wantErr: true},
+		{name: "negative deadline", body: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/functions/f/invoke", nil)
+			if tt.header != "" {
+				r.Header.Set(deadlineHeader, tt.header)
+			}
+			start := time.Now()
+			opts, err := a.handler.invokeOptions(r, &InvokeRequest{DeadlineMS: tt.body})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("invokeOptions() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.want == 0 {
+				if !opts.Deadline.IsZero() {
+					t.Errorf("deadline = %v, want none", opts.Deadline)
+				}
+				return
+			}
+			if remaining := opts.Deadline.Sub(start); remaining < tt.want || remaining > tt.want+time.Second {
+				t.Errorf("deadline is %v away, want %v", remaining, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterFunctionGzip(t *testing.T) {
+	a := newTestAPI(t)
+	code := "def handler(event, context):\n    return event\n"
+	data, err := json.Marshal(map[string]interface{}{
+		"name":            "compressed",
+		"runtime":         "python3",
+		"code":            code,
+		"skip_validation": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write(data)
+	gz.Close()
+
+	req, err := http.NewRequest(http.MethodPost, a.URL+"/api/functions", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Authorization", "Bearer "+a.key)
+	// Keep the client from decoding the response itself
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("registration got status %d, want 200", resp.StatusCode)
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatalf("response Content-Encoding = %q, want gzip", encoding)
+	}
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("response isn't gzip-encoded: %v", err)
+	}
+	var function registry.FunctionMetadata
+	if err := json.NewDecoder(reader).Decode(&function); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if function.Name != "compressed" {
+		t.Errorf("registered %q, want compressed", function.Name)
+	}
+	stored, err := a.handler.functionRegistry.GetFunctionCode(function.ID)
+	if err != nil {
+		t.Fatalf("GetFunctionCode: %v", err)
+	}
+	if stored.Code != code {
+		t.Errorf("stored code %q, want %q", stored.Code, code)
+	}
+}
+
+func TestInvalidGzipBody(t *testing.T) {
+	a := newTestAPI(t)
+	req, err := http.NewRequest(http.MethodPost, a.URL+"/api/functions", strings.NewReader(`{"name": "plain"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Authorization", "Bearer "+a.key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for a body that isn't gzip", resp.StatusCode)
+	}
+}
+
+func TestDisabledFunctionCannotBeInvoked(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "misbehaving")
+
+	var disabled registry.FunctionMetadata
+	if resp := a.do(t, http.MethodPost, "/api/functions/"+function.ID+"/disable", nil, &disabled); resp.StatusCode != http.StatusOK {
+		t.Fatalf("disable got status %d, want 200", resp.StatusCode)
+	}
+	if disabled.Status != registry.StatusDisabled {
+		t.Errorf("status = %q, want %q", disabled.Status, registry.StatusDisabled)
+	}
+	for _, invoke := range []string{"/api/functions/" + function.ID + "/invoke", "/api/functions/name/misbehaving/invoke"} {
+		if resp := a.do(t, http.MethodPost, invoke, map[string]interface{}{}, nil); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("POST %s got status %d, want 403", invoke, resp.StatusCode)
+		}
+	}
+	// The code is kept
+	if code, err := a.handler.functionRegistry.GetFunctionCode(function.ID); err != nil || code.Code == "" {
+		t.Errorf("code of the disabled function is gone: %v", err)
+	}
+
+	if resp := a.do(t, http.MethodPost, "/api/functions/"+function.ID+"/enable", nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("enable got status %d, want 200", resp.StatusCode)
+	}
+	if resp := a.do(t, http.MethodPost, "/api/functions/"+function.ID+"/invoke", map[string]interface{}{}, nil); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("invoke after enable got status %d, want 202", resp.StatusCode)
+	}
+}
+
+func TestDisableMissingFunction(t *testing.T) {
+	a := newTestAPI(t)
+	if resp := a.do(t, http.MethodPost, "/api/functions/missing/disable", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestRegisterFunctionSecretReference(t *testing.T) {
+	a := newTestAPI(t)
+	if resp := a.do(t, http.MethodPost, "/api/secrets", SecretRequest{Name: "db-password", Value: "hunter2"}, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("setting the secret got status %d, want 200", resp.StatusCode)
+	}
+
+	var function registry.FunctionMetadata
+	resp := a.do(t, http.MethodPost, "/api/functions", map[string]interface{}{
+		"name":    "with-secret",
+		"runtime": "python3",
+		"code":    "def handler(event, context):\n    return event\n",
+		"environment": map[string]interface{}{
+			"DB_HOST": "db",
+			"DB_PASS": map[string]string{"secret": "db-password"},
+		},
+		"skip_validation": true,
+	}, &function)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("registration got status %d, want 200", resp.StatusCode)
+	}
+	if function.Secrets["DB_PASS"] != "db-password" || function.Environment["DB_PASS"] != "" {
+		t.Errorf("registered environment %v with secrets %v, want DB_PASS as a reference", function.Environment, function.Secrets)
+	}
+
+	var details json.RawMessage
+	a.do(t, http.MethodGet, "/api/functions/"+function.ID, nil, &details)
+	if strings.Contains(string(details), "hunter2") {
+		t.Errorf("function details expose the secret value: %s", details)
+	}
+}
+
+func TestListExecutionsTimeWindow(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "busy")
+	base := time.Now().Truncate(time.Second)
+	for id, start := range map[string]time.Time{"early": base.Add(-time.Hour), "at-since": base, "at-until": base.Add(time.Hour)} {
+		if err := a.handler.stateManager.SaveExecution(&state.Execution{ID: id, FunctionID: function.ID, Status: state.StatusCompleted, StartTime: start}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := "/api/executions/function/" + function.ID + "?since=" + base.UTC().Format(time.RFC3339) + "&until=" + base.Add(time.Hour).UTC().Format(time.RFC3339)
+	var executions []state.Execution
+	if resp := a.do(t, http.MethodGet, path, nil, &executions); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if len(executions) != 1 || executions[0].ID != "at-since" {
+		t.Errorf("listed %+v, want only the execution at the start of the window", executions)
+	}
+
+	if resp := a.do(t, http.MethodGet, "/api/executions/function/"+function.ID+"?since=yesterday", nil, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid since got status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestRegisterFunctionReadsSpec(t *testing.T) {
+	const config = "runtime: python3.10\nentrypoint: main.run\nmemory: 256\ntimeout: 60\n"
+	tests := []struct {
+		name       string
+		request    map[string]interface{}
+		runtime    string
+		entryPoint string
+		memory     int
+		timeout    int
+	}{
+		{
+			name:       "request omits the settings",
+			request:    map[string]interface{}{"config": config},
+			runtime:    "python3.10",
+			entryPoint: "main.run",
+			memory:     256,
+			timeout:    60,
+		},
+		{
+			name:       "request fields take precedence",
+			request:    map[string]interface{}{"config": config, "runtime": "python3.9", "memory": 512},
+			runtime:    "python3.9",
+			entryPoint: "main.run",
+			memory:     512,
+			timeout:    60,
+		},
+		{
+			name:       "neither declares the runtime",
+			request:    map[string]interface{}{"config": "timeout: 45\n"},
+			runtime:    registry.DefaultRuntime,
+			entryPoint: registry.DefaultEntryPoint,
+			timeout:    45,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAPI(t)
+			tt.request["name"] = fmt.Sprintf("spec-%d", i)
+			tt.request["code"] = "def run(event, context):\n    return event\n"
+			tt.request["skip_validation"] = true
+
+			var function registry.FunctionMetadata
+			if resp := a.do(t, http.MethodPost, "/api/functions", tt.request, &function); resp.StatusCode != http.StatusOK {
+				t.Fatalf("registration got status %d, want 200", resp.StatusCode)
+			}
+			stored, err := a.handler.functionRegistry.GetFunction(function.ID)
+			if err != nil {
+				t.Fatalf("GetFunction: %v", err)
+			}
+			if stored.Runtime != tt.runtime || stored.EntryPoint != tt.entryPoint {
+				t.Errorf("runtime %q with entry point %q, want %q with %q", stored.Runtime, stored.EntryPoint, tt.runtime, tt.entryPoint)
+			}
+			if tt.memory != 0 && stored.Memory != tt.memory {
+				t.Errorf("memory = %d, want %d", stored.Memory, tt.memory)
+			}
+			if stored.Timeout != tt.timeout {
+				t.Errorf("timeout = %d, want %d", stored.Timeout, tt.timeout)
+			}
+		})
+	}
+}
+
+func TestRegisterFunctionInvalidSpec(t *testing.T) {
+	a := newTestAPI(t)
+	resp := a.do(t, http.MethodPost, "/api/functions", map[string]interface{}{
+		"name":            "broken",
+		"code":            "def handler(event, context):\n    return event\n",
+		"config":          "runtime: [python3",
+		"skip_validation": true,
+	}, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an unparseable skyscale.yaml", resp.StatusCode)
+	}
+}
+
+func TestPurgeEmptiesState(t *testing.T) {
+	a := newTestAPI(t)
+	a.handler.SetDevMode(true)
+	for _, name := range []string{"first", "second"} {
+		function := a.registerFunction(t, name)
+		a.runningExecution(t, function.ID)
+	}
+	if err := a.handler.stateManager.SaveVM(&state.VM{ID: "vm-stale", Status: "ready", IP: "172.16.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var purged PurgeResponse
+	if resp := a.do(t, http.MethodPost, "/api/admin/purge?confirm=true", nil, &purged); resp.StatusCode != http.StatusOK {
+		t.Fatalf("purge got status %d, want 200", resp.StatusCode)
+	}
+	if purged.PurgeCounts == nil || purged.Functions != 2 || purged.Executions != 2 || purged.VMs != 1 {
+		t.Errorf("purge removed %+v, want 2 functions, 2 executions and 1 VM", purged.PurgeCounts)
+	}
+
+	if functions, err := a.handler.stateManager.ListFunctions(); err != nil || len(functions) != 0 {
+		t.Errorf("%d functions left after the purge: %v", len(functions), err)
+	}
+	executions, err := a.handler.stateManager.QueryExecutions(state.ExecutionFilter{})
+	if err != nil || len(executions) != 0 {
+		t.Errorf("%d executions left after the purge: %v", len(executions), err)
+	}
+	if vms, err := a.handler.stateManager.ListVMs(); err != nil || len(vms) != 0 {
+		t.Errorf("%d VMs left after the purge: %v", len(vms), err)
+	}
+	entries, err := os.ReadDir(filepath.Join(vm.DataDir(), "function-storage"))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("function storage holds %d entries after the purge: %v", len(entries), err)
+	}
+}
+
+func TestPurgeRefused(t *testing.T) {
+	a := newTestAPI(t)
+	a.registerFunction(t, "kept")
+
+	if resp := a.do(t, http.MethodPost, "/api/admin/purge?confirm=true", nil, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("purge outside dev mode got status %d, want 403", resp.StatusCode)
+	}
+	a.handler.SetDevMode(true)
+	if resp := a.do(t, http.MethodPost, "/api/admin/purge", nil, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("purge without confirm got status %d, want 400", resp.StatusCode)
+	}
+	if functions, _ := a.handler.stateManager.ListFunctions(); len(functions) != 1 {
+		t.Errorf("%d functions left after refused purges, want 1", len(functions))
+	}
+}
+
+func TestInvokeRateLimit(t *testing.T) {
+	a := newTestAPI(t)
+	var function registry.FunctionMetadata
+	resp := a.do(t, http.MethodPost, "/api/functions", map[string]interface{}{
+		"name":            "limited",
+		"runtime":         "python3",
+		"code":            "def handler(event, context):\n    return event\n",
+		"rate_limit":      0.1,
+		"rate_burst":      2,
+		"skip_validation": true,
+	}, &function)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("registration got status %d, want 200", resp.StatusCode)
+	}
+	if function.RateLimit != 0.1 || function.RateBurst != 2 {
+		t.Fatalf("registered rate limit %v with burst %d, want 0.1 with 2", function.RateLimit, function.RateBurst)
+	}
+
+	invoke := "/api/functions/" + function.ID + "/invoke"
+	for i := 0; i < 2; i++ {
+		if resp := a.do(t, http.MethodPost, invoke, map[string]interface{}{}, nil); resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("invoke %d within the burst got status %d, want 202", i+1, resp.StatusCode)
+		}
+	}
+	resp = a.do(t, http.MethodPost, invoke, map[string]interface{}{}, nil)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("invoke past the burst got status %d, want 429", resp.StatusCode)
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter == "" || retryAfter == "0" {
+		t.Errorf("Retry-After = %q, want the seconds until the next token", retryAfter)
+	}
+}
+
+func TestRegisterFunctionInvalidRateLimit(t *testing.T) {
+	a := newTestAPI(t)
+	resp := a.do(t, http.MethodPost, "/api/functions", map[string]interface{}{
+		"name":            "limited",
+		"runtime":         "python3",
+		"code":            "def handler(event, context):\n    return event\n",
+		"rate_limit":      -1,
+		"skip_validation": true,
+	}, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("registration with a negative rate limit got status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestDeleteFunctionAudited(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+
+	if resp := a.do(t, http.MethodDelete, "/api/functions/"+function.ID, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE got status %d, want 200", resp.StatusCode)
+	}
+	if resp := a.do(t, http.MethodDelete, "/api/functions/missing", nil, nil); resp.StatusCode == http.StatusOK {
+		t.Fatal("DELETE of a missing function succeeded")
+	}
+	// Audit events are written in the background
+	a.handler.Wait()
+
+	var events []state.AuditEvent
+	if resp := a.do(t, http.MethodGet, "/api/audit?action=function.delete", nil, &events); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/audit got status %d, want 200", resp.StatusCode)
+	}
+	results := make(map[string]state.AuditEvent)
+	for _, event := range events {
+		results[event.Target] = event
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d audit events, want 2: %+v", len(events), events)
+	}
+	if event := results[function.ID]; event.UserID != "admin" || event.Result != "success" {
+		t.Errorf("delete audited as %+v, want a success by admin", event)
+	}
+	if event := results["missing"]; event.Result != "failure" || event.Error == "" {
+		t.Errorf("failed delete audited as %+v, want a failure with its error", event)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	defer func(version, commit, date string) {
+		buildinfo.Version, buildinfo.Commit, buildinfo.BuildDate = version, commit, date
+	}(buildinfo.Version, buildinfo.Commit, buildinfo.BuildDate)
+	buildinfo.Version, buildinfo.Commit, buildinfo.BuildDate = "v1.2.0", "abc1234", "2026-10-01T12:00:00Z"
+
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/info" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"version": "v1.1.0"}`))
+	}))
+	defer daemon.Close()
+	t.Setenv(daemonclient.EnvDaemonURL, daemon.URL)
+	a := newTestAPI(t)
+
+	var response struct {
+		ControlPlane buildinfo.Info `json:"control_plane"`
+		Daemon       struct {
+			Version string `json:"version"`
+		} `json:"daemon"`
+		DaemonError string `json:"daemon_error"`
+	}
+	if resp := a.do(t, http.MethodGet, "/api/version", nil, &response); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/version got status %d, want 200", resp.StatusCode)
+	}
+	if got := response.ControlPlane; got.Version != "v1.2.0" || got.Commit != "abc1234" || got.BuildDate != "2026-10-01T12:00:00Z" || got.GoVersion == "" {
+		t.Errorf("control plane build info = %+v, want the injected values", got)
+	}
+	if response.DaemonError != "no running VMs" {
+		t.Errorf("daemon error = %q, want no running VMs", response.DaemonError)
+	}
+
+	// With a VM running, the daemon's version is included
+	if err := a.handler.stateManager.SaveVM(&state.VM{ID: "vm-1", IP: "127.0.0.1", Status: "ready"}); err != nil {
+		t.Fatal(err)
+	}
+	response.DaemonError = ""
+	if resp := a.do(t, http.MethodGet, "/api/version", nil, &response); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/version got status %d, want 200", resp.StatusCode)
+	}
+	if response.Daemon.Version != "v1.1.0" || response.DaemonError != "" {
+		t.Errorf("daemon version = %q with error %q, want v1.1.0", response.Daemon.Version, response.DaemonError)
+	}
+}
+
+func TestReadyProbesDaemon(t *testing.T) {
+	daemon := daemontest.NewServer(daemontest.Succeed("{}"))
+	t.Setenv(daemonclient.EnvDaemonURL, daemon.URL)
+	a := newTestAPI(t)
+	ready := func() (int, ReadinessResponse) {
+		t.Helper()
+		var response ReadinessResponse
+		resp, err := http.Get(a.URL + "/ready")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatalf("invalid readiness response: %v", err)
+		}
+		return resp.StatusCode, response
+	}
+
+	// Without a running VM there is no daemon to execute functions
+	if status, response := ready(); status != http.StatusServiceUnavailable || response.Ready {
+		t.Errorf("GET /ready without VMs = %d, ready %v; want 503", status, response.Ready)
+	}
+
+	if err := a.handler.stateManager.SaveVM(&state.VM{ID: "vm-1", IP: "127.0.0.1", Status: "ready"}); err != nil {
+		t.Fatal(err)
+	}
+	status, response := ready()
+	if status != http.StatusOK || !response.Ready {
+		t.Fatalf("GET /ready with the daemon up = %d, ready %v: %+v", status, response.Ready, response.Checks)
+	}
+	if check := response.Checks["daemon"]; !check.OK || check.Detail != "VM vm-1" {
+		t.Errorf("daemon check = %+v, want vm-1 probed", check)
+	}
+	if check := response.Checks["database"]; !check.OK {
+		t.Errorf("database check = %+v", check)
+	}
+
+	daemon.Close()
+	status, response = ready()
+	if status != http.StatusServiceUnavailable || response.Ready {
+		t.Errorf("GET /ready with the daemon down = %d, ready %v; want 503", status, response.Ready)
+	}
+	if check := response.Checks["daemon"]; check.OK || !strings.Contains(check.Error, vm.ErrDaemonUnreachable.Error()) {
+		t.Errorf("daemon check = %+v, want the daemon reported unreachable", check)
+	}
+}
+
+func TestInvokeTimeoutOutOfRange(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+
+	for _, timeout := range []int{-1, 301} {
+		resp := a.do(t, http.MethodPost, "/api/functions/"+function.ID+"/invoke", map[string]interface{}{"sync": true, "timeout": timeout}, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("invoke with a %ds timeout got status %d, want 400", timeout, resp.StatusCode)
+		}
+	}
+}
+
+func TestLogsForwardedToDestination(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "")
+	// The sink listens on the loopback interface
+	t.Setenv(registry.EnvLogSinkAllowLocal, "true")
+	records := make(chan LogRecord, 1)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var record LogRecord
+		json.NewDecoder(r.Body).Decode(&record)
+		records <- record
+	}))
+	defer sink.Close()
+
+	a := newTestAPI(t)
+	var function registry.FunctionMetadata
+	resp := a.do(t, http.MethodPost, "/api/functions", map[string]interface{}{
+		"name":            "chatty",
+		"runtime":         "python3",
+		"code":            "def handler(event, context):\n    return event\n",
+		"log_destination": sink.URL,
+		"skip_validation": true,
+	}, &function)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("registration got status %d, want 200", resp.StatusCode)
+	}
+	execution := a.runningExecution(t, function.ID)
+
+	result := completedResult(execution, `{"ok":true}`)
+	result.Logs = "warming up\ndone\n"
+	if status := daemontest.Report(a.URL, "", result); status != http.StatusOK {
+		t.Fatalf("result report got status %d, want 200", status)
+	}
+	a.handler.Wait()
+
+	select {
+	case record := <-records:
+		if record.ExecutionID != execution.ID || record.FunctionName != "chatty" || record.Logs != "warming up\ndone\n" {
+			t.Errorf("sink received %+v", record)
+		}
+	default:
+		t.Fatal("logs didn't reach the destination")
+	}
+	if stored := a.execution(t, execution.ID); stored.Stderr != "warming up\ndone\n" {
+		t.Errorf("stored stderr = %q, want the reported logs", stored.Stderr)
+	}
+}
+
+func TestLogsForwardedToFile(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "")
+	a := newTestAPI(t)
+	dir := t.TempDir()
+	t.Setenv(registry.EnvLogSinkDir, dir)
+	function := a.registerFunction(t, "chatty")
+	path := filepath.Join(dir, "chatty.log")
+	if _, err := a.handler.functionRegistry.SetLogDestination(function.ID, "file://"+path); err != nil {
+		t.Fatal(err)
+	}
+	execution := a.runningExecution(t, function.ID)
+
+	result := completedResult(execution, "{}")
+	result.Logs = "one\ntwo\n"
+	if status := daemontest.Report(a.URL, "", result); status != http.StatusOK {
+		t.Fatalf("result report got status %d, want 200", status)
+	}
+	a.handler.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("logs weren't appended to the file: %v", err)
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], execution.ID+" one") || !strings.HasSuffix(lines[1], execution.ID+" two") {
+		t.Errorf("log file = %q, want each line prefixed with the execution ID", data)
+	}
+}
+
+func TestLogDeliveryFailureDoesNotFailExecution(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "")
+	t.Setenv(registry.EnvLogSinkAllowLocal, "true")
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "sink down", http.StatusServiceUnavailable)
+	}))
+	defer sink.Close()
+
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "chatty")
+	if _, err := a.handler.functionRegistry.SetLogDestination(function.ID, sink.URL); err != nil {
+		t.Fatal(err)
+	}
+	execution := a.runningExecution(t, function.ID)
+
+	result := completedResult(execution, "{}")
+	result.Logs = "lost\n"
+	if status := daemontest.Report(a.URL, "", result); status != http.StatusOK {
+		t.Fatalf("result report got status %d, want 200", status)
+	}
+	a.handler.Wait()
+	if stored := a.execution(t, execution.ID); stored.Status != state.StatusCompleted {
+		t.Errorf("execution status = %q after a failed log delivery, want completed", stored.Status)
+	}
+}
+
+func TestInvalidLogDestination(t *testing.T) {
+	a := newTestAPI(t)
+	t.Setenv(registry.EnvLogSinkDir, filepath.Join(t.TempDir(), "logs"))
+	for _, destination := range []string{
+		"ftp://logs.example.com",
+		"file://relative/path",
+		"not a url",
+		"file:///root/.ssh/authorized_keys",
+		"file://" + registry.LogSinkDir() + "/../escape.log",
+		"http://127.0.0.1:8081/execute",
+		"http://169.254.169.254/latest/meta-data/",
+	} {
+		resp := a.do(t, http.MethodPost, "/api/functions", map[string]interface{}{
+			"name":            "chatty",
+			"runtime":         "python3",
+			"code":            "def handler(event, context):\n    return event\n",
+			"log_destination": destination,
+			"skip_validation": true,
+		}, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("registration with log destination %q got status %d, want 400", destination, resp.StatusCode)
+		}
+	}
+}
+
+func TestExecutionTiming(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	execution := &state.Execution{
+		QueuedAt:       start,
+		StartTime:      start.Add(100 * time.Millisecond),
+		EndTime:        start.Add(time.Second),
+		QueueWaitMs:    100,
+		VMAllocationMs: 200,
+		SetupMs:        300,
+		HandlerMs:      250,
+	}
+	timing := executionTiming(execution)
+	if timing.TotalMs != 1000 || timing.OtherMs != 150 {
+		t.Errorf("total %dms with %dms other, want 1000ms with 150ms other", timing.TotalMs, timing.OtherMs)
+	}
+
+	// Phases measured on different clocks may overrun the total
+	execution.HandlerMs = 900
+	if timing := executionTiming(execution); timing.OtherMs != 0 {
+		t.Errorf("other = %dms with the phases over the total, want 0", timing.OtherMs)
+	}
+}
+
+func TestExecutionDetailPhasesSumToTotal(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "")
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+	if err := a.handler.stateManager.SaveVM(&state.VM{ID: "vm-1", IP: "172.16.0.2", Status: "busy", Runtime: "python3", CPU: 2, BootDuration: 800}); err != nil {
+		t.Fatal(err)
+	}
+	queuedAt := time.Now().Add(-500 * time.Millisecond)
+	execution := &state.Execution{
+		ID:             "exec-timed",
+		FunctionID:     function.ID,
+		Status:         state.StatusRunning,
+		VMID:           "vm-1",
+		QueuedAt:       queuedAt,
+		StartTime:      queuedAt.Add(100 * time.Millisecond),
+		QueueWaitMs:    100,
+		VMAllocationMs: 50,
+		ColdStart:      true,
+		Attempt:        1,
+	}
+	if err := a.handler.stateManager.SaveExecution(execution); err != nil {
+		t.Fatal(err)
+	}
+
+	result := completedResult(execution, "{}")
+	result.SetupMs = 120
+	result.HandlerMs = 180
+	if status := daemontest.Report(a.URL, "", result); status != http.StatusOK {
+		t.Fatalf("result report got status %d, want 200", status)
+	}
+
+	var detail struct {
+		VM     ExecutionVM     `json:"vm"`
+		Timing ExecutionTiming `json:"timing"`
+	}
+	if resp := a.do(t, http.MethodGet, "/api/executions/exec-timed", nil, &detail); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET execution got status %d, want 200", resp.StatusCode)
+	}
+	timing := detail.Timing
+	if timing.QueueWaitMs != 100 || timing.VMAllocationMs != 50 || !timing.ColdStart || timing.SetupMs != 120 || timing.HandlerMs != 180 {
+		t.Errorf("timing = %+v, want the recorded phases", timing)
+	}
+	if sum := timing.QueueWaitMs + timing.VMAllocationMs + timing.SetupMs + timing.HandlerMs + timing.OtherMs; sum != timing.TotalMs {
+		t.Errorf("phases sum to %dms, want the total of %dms", sum, timing.TotalMs)
+	}
+	if timing.TotalMs < 500 || timing.TotalMs > 5000 {
+		t.Errorf("total = %dms, want about the 500ms since the execution was queued", timing.TotalMs)
+	}
+	if detail.VM.ID != "vm-1" || detail.VM.Runtime != "python3" || detail.VM.CPU != 2 || detail.VM.BootDurationMs != 800 {
+		t.Errorf("VM = %+v, want vm-1's details", detail.VM)
+	}
+}
+
+func TestCloneFunction(t *testing.T) {
+	a := newTestAPI(t)
+	source := a.registerFunction(t, "hello")
+	a.registerFunction(t, "taken")
+
+	var clone registry.FunctionMetadata
+	if resp := a.do(t, http.MethodPost, "/api/functions/"+source.ID+"/clone", CloneRequest{Name: "hello-b"}, &clone); resp.StatusCode != http.StatusOK {
+		t.Fatalf("clone got status %d, want 200", resp.StatusCode)
+	}
+	if clone.ID == source.ID || clone.Name != "hello-b" || clone.Runtime != source.Runtime || clone.Timeout != source.Timeout {
+		t.Errorf("clone = %+v, want a new function with the source's settings", clone)
+	}
+	code, err := a.handler.functionRegistry.GetFunctionCode(clone.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code.Code != "def handler(event, context):\n    return event\n" {
+		t.Errorf("clone code = %q, want the source's", code.Code)
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		body   CloneRequest
+		status int
+	}{
+		{"name taken", source.ID, CloneRequest{Name: "taken"}, http.StatusConflict},
+		{"no name", source.ID, CloneRequest{}, http.StatusBadRequest},
+		{"missing source", "missing", CloneRequest{Name: "hello-c"}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if resp := a.do(t, http.MethodPost, "/api/functions/"+tt.id+"/clone", tt.body, nil); resp.StatusCode != tt.status {
+			t.Errorf("%s: clone got status %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestCorruptFunctionCannotBeInvoked(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "partial")
+	dir := filepath.Join(vm.DataDir(), "function-storage", function.ID)
+	if err := os.Remove(filepath.Join(dir, "requirements.txt")); err != nil {
+		t.Fatal(err)
+	}
+	// The first invoke finds the file missing when it reads the code
+	if _, err := a.handler.functionRegistry.GetFunctionCode(function.ID); !errors.Is(err, registry.ErrCorruptStorage) {
+		t.Fatalf("GetFunctionCode error = %v, want %v", err, registry.ErrCorruptStorage)
+	}
+
+	var described registry.FunctionMetadata
+	if resp := a.do(t, http.MethodGet, "/api/functions/"+function.ID, nil, &described); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET got status %d, want 200", resp.StatusCode)
+	}
+	if described.Status != registry.StatusCorrupt {
+		t.Errorf("status = %q, want %q", described.Status, registry.StatusCorrupt)
+	}
+	for _, invoke := range []string{"/api/functions/" + function.ID + "/invoke", "/api/functions/name/partial/invoke"} {
+		if resp := a.do(t, http.MethodPost, invoke, map[string]interface{}{}, nil); resp.StatusCode != http.StatusConflict {
+			t.Errorf("POST %s got status %d, want 409", invoke, resp.StatusCode)
+		}
+	}
+}
+
+func TestQueryExecutionsByTag(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "resize")
+	invoke := func(tags map[string]string) string {
+		t.Helper()
+		var result types.ExecutionResult
+		body := map[string]interface{}{"input": map[string]string{}, "tags": tags}
+		if resp := a.do(t, http.MethodPost, "/api/functions/"+function.ID+"/invoke", body, &result); resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("invoke got status %d, want 202", resp.StatusCode)
+		}
+		return result.RequestID
+	}
+	tagged := invoke(map[string]string{"batch": "xyz", "example.com/step": "thumbnail"})
+	invoke(map[string]string{"batch": "abc"})
+	invoke(nil)
+
+	for _, query := range []string{"tag=batch=xyz", "tag=batch=xyz&tag=example.com/step=thumbnail", "tag=batch=xyz,example.com/step=thumbnail&function_id=" + function.ID} {
+		var executions []state.Execution
+		if resp := a.do(t, http.MethodGet, "/api/executions?"+query, nil, &executions); resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET ?%s got status %d, want 200", query, resp.StatusCode)
+		}
+		if len(executions) != 1 || executions[0].ID != tagged || executions[0].Tags["batch"] != "xyz" {
+			t.Errorf("GET ?%s listed %+v, want only execution %s with its tags", query, executions, tagged)
+		}
+	}
+
+	var executions []state.Execution
+	if a.do(t, http.MethodGet, "/api/executions?tag=batch=xyz&function_id=other", nil, &executions); len(executions) != 0 {
+		t.Errorf("listed %+v for another function, want none", executions)
+	}
+	if resp := a.do(t, http.MethodGet, "/api/executions?tag=batch", nil, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("tag without a value got status %d, want 400", resp.StatusCode)
+	}
+	body := map[string]interface{}{"input": map[string]string{}, "tags": map[string]string{"bad key!": "x"}}
+	if resp := a.do(t, http.MethodPost, "/api/functions/"+function.ID+"/invoke", body, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invoke with an invalid tag got status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestDeadLettersListedAndRequeued(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "flaky")
+	for _, execution := range []*state.Execution{
+		{ID: "exec-dead", FunctionID: function.ID, Status: state.StatusDeadLetter, Error: "flaky", EndTime: time.Now()},
+		{ID: "exec-failed", FunctionID: function.ID, Status: state.StatusFailed, EndTime: time.Now()},
+	} {
+		if err := a.handler.stateManager.SaveExecution(execution); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadLetter := &state.DeadLetter{ExecutionID: "exec-dead", FunctionID: function.ID, Input: map[string]interface{}{"n": 1.0}, Tags: map[string]string{"batch": "xyz"}}
+	if err := a.handler.stateManager.SaveDeadLetter(deadLetter); err != nil {
+		t.Fatal(err)
+	}
+
+	var listed []state.Execution
+	if resp := a.do(t, http.MethodGet, "/api/executions/dead-letters?function_id="+function.ID, nil, &listed); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET dead-letters got status %d, want 200", resp.StatusCode)
+	}
+	if len(listed) != 1 || listed[0].ID != "exec-dead" {
+		t.Fatalf("dead letters = %+v, want only exec-dead", listed)
+	}
+
+	var result types.ExecutionResult
+	if resp := a.do(t, http.MethodPost, "/api/executions/exec-dead/requeue", nil, &result); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("requeue got status %d, want 202", resp.StatusCode)
+	}
+	if requeued := a.execution(t, result.RequestID); requeued.Tags["batch"] != "xyz" {
+		t.Errorf("requeued execution has tags %v, want the dead letter's", requeued.Tags)
+	}
+	if a.do(t, http.MethodGet, "/api/executions/dead-letters", nil, &listed); len(listed) != 0 {
+		t.Errorf("dead letters after the requeue = %+v, want none", listed)
+	}
+
+	for id, want := range map[string]int{"exec-dead": http.StatusConflict, "exec-failed": http.StatusConflict, "exec-missing": http.StatusNotFound} {
+		if resp := a.do(t, http.MethodPost, "/api/executions/"+id+"/requeue", nil, nil); resp.StatusCode != want {
+			t.Errorf("requeue of %s got status %d, want %d", id, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestRegisterFunctionCPU(t *testing.T) {
+	a := newTestAPI(t)
+	register := func(name string, cpu int) (*http.Response, registry.FunctionMetadata) {
+		var function registry.FunctionMetadata
+		body := map[string]interface{}{"name": name, "runtime": "python3", "code": "def handler(event, context):\n    return event\n", "cpu": cpu, "skip_validation": true}
+		return a.do(t, http.MethodPost, "/api/functions", body, &function), function
+	}
+
+	resp, function := register("crunch", 2)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("registration got status %d, want 200", resp.StatusCode)
+	}
+	if function.CPU != 2 {
+		t.Errorf("registered function has %d vCPUs, want 2", function.CPU)
+	}
+	if resp, _ := register("greedy", registry.MaxCPUs()+1); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("registration over the vCPU limit got status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestTestModeInvoke(t *testing.T) {
+	daemon := daemontest.NewServer(daemontest.Succeed(`{"message":"hi"}`))
+	defer daemon.Close()
+	t.Setenv(daemonclient.EnvDaemonURL, daemon.URL)
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "hello")
+
+	if resp := a.do(t, http.MethodPost, "/test/invoke", map[string]interface{}{"name": "hello"}, nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("POST /test/invoke outside test mode got status %d, want 404", resp.StatusCode)
+	}
+
+	// Serve the routes again with test mode on
+	a.handler.SetTestMode(true)
+	router := mux.NewRouter()
+	a.handler.RegisterRoutes(router)
+	a.Server = httptest.NewServer(router)
+	t.Cleanup(a.Server.Close)
+	daemon.ReportTo(a.URL, "")
+
+	for _, body := range []map[string]interface{}{
+		{"name": "hello", "input": map[string]string{"name": "world"}},
+		{"function_id": function.ID, "input": map[string]string{"name": "world"}},
+	} {
+		var result types.ExecutionResult
+		if resp := a.do(t, http.MethodPost, "/test/invoke", body, &result); resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST /test/invoke %v got status %d, want 200", body, resp.StatusCode)
+		}
+		if string(result.Output) != `{"message":"hi"}` {
+			t.Errorf("output = %s, want the function's result", result.Output)
+		}
+	}
+
+	payloads := daemon.Payloads()
+	if len(payloads) != 2 || payloads[0].Event["name"] != "world" {
+		t.Fatalf("daemon received %+v, want both invokes", payloads)
+	}
+	if execution := a.execution(t, payloads[0].RequestID); execution.VMID != "host-vm-test" || execution.Status != state.StatusCompleted {
+		t.Errorf("execution ran on %q with status %q, want completed on host-vm-test", execution.VMID, execution.Status)
+	}
+
+	for body, want := range map[string]int{`{}`: http.StatusBadRequest, `{"name": "missing"}`: http.StatusNotFound} {
+		if status := post(t, a.URL+"/test/invoke", body, map[string]string{"Authorization": "Bearer " + a.key}); status != want {
+			t.Errorf("POST /test/invoke %s got status %d, want %d", body, status, want)
+		}
+	}
+}
+
+func TestInvokeInputTooComplex(t *testing.T) {
+	t.Setenv(scheduler.EnvInputMaxDepth, "2")
+	t.Setenv(scheduler.EnvInputMaxKeys, "3")
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "nested")
+
+	inputs := map[string]map[string]interface{}{
+		"too deep":      {"a": map[string]interface{}{"b": []interface{}{1}}},
+		"too many keys": {"a": 1, "b": 2, "c": 3, "d": 4},
+	}
+	for name, input := range inputs {
+		for _, invoke := range []string{"/api/functions/" + function.ID + "/invoke", "/api/functions/name/nested/invoke"} {
+			if resp := a.do(t, http.MethodPost, invoke, InvokeRequest{Input: input}, nil); resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("POST %s with an input %s got status %d, want 400", invoke, name, resp.StatusCode)
+			}
+		}
+	}
+	if resp := a.do(t, http.MethodPost, "/api/functions/"+function.ID+"/invoke", InvokeRequest{Input: map[string]interface{}{"a": map[string]interface{}{"b": 1}}}, nil); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("invoke with an input within the limits got status %d, want 202", resp.StatusCode)
+	}
+}
+
+func TestLastExecution(t *testing.T) {
+	// The daemon echoes the input so the two executions can be told apart
+	daemon := daemontest.NewServer(func(payload *daemontest.Payload) *types.ExecutionResult {
+		return daemontest.Succeed(fmt.Sprintf(`{"n":%v}`, payload.Event["n"]))(payload)
+	})
+	defer daemon.Close()
+	t.Setenv(daemonclient.EnvDaemonURL, daemon.URL)
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "counter")
+	a.handler.SetTestMode(true)
+	router := mux.NewRouter()
+	a.handler.RegisterRoutes(router)
+	a.Server = httptest.NewServer(router)
+	t.Cleanup(a.Server.Close)
+	daemon.ReportTo(a.URL, "")
+
+	for _, path := range []string{"/api/functions/" + function.ID + "/last-execution", "/api/functions/missing/last-execution"} {
+		if resp := a.do(t, http.MethodGet, path, nil, nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s got status %d, want 404", path, resp.StatusCode)
+		}
+	}
+
+	for n := 1; n <= 2; n++ {
+		body := map[string]interface{}{"function_id": function.ID, "input": map[string]int{"n": n}}
+		if resp := a.do(t, http.MethodPost, "/test/invoke", body, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("invoke %d got status %d, want 200", n, resp.StatusCode)
+		}
+	}
+	payloads := daemon.Payloads()
+	if len(payloads) != 2 {
+		t.Fatalf("daemon received %d requests, want 2", len(payloads))
+	}
+
+	var last ExecutionDetail
+	if resp := a.do(t, http.MethodGet, "/api/functions/"+function.ID+"/last-execution", nil, &last); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET last-execution got status %d, want 200", resp.StatusCode)
+	}
+	if last.ID != payloads[1].RequestID || last.Status != state.StatusCompleted || last.Logs != `{"n":2}` {
+		t.Errorf("last execution = %s with status %q and output %q, want the second invoke's", last.ID, last.Status, last.Logs)
+	}
+	if last.Timing == nil || last.StartTime.IsZero() {
+		t.Errorf("last execution = %+v, want its start time and timing", last)
+	}
+}
+
+// trickle sends a POST request whose body is sent in pieces over duration
+func trickle(t *testing.T, url, key, body string, duration time.Duration) (*http.Response, error) {
+	t.Helper()
+	reader, writer := io.Pipe()
+	go func() {
+		const pieces = 5
+		size := len(body)/pieces + 1
+		for start := 0; start < len(body); start += size {
+			time.Sleep(duration / pieces)
+			end := start + size
+			if end > len(body) {
+				end = len(body)
+			}
+			if _, err := writer.Write([]byte(body[start:end])); err != nil {
+				return
+			}
+		}
+		writer.Close()
+	}()
+	req, err := http.NewRequest(http.MethodPost, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	return http.DefaultClient.Do(req)
+}
+
+func TestDeployOutlivesReadTimeout(t *testing.T) {
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "existing")
+	// A server whose read timeout is shorter than the upload
+	router := mux.NewRouter()
+	a.handler.RegisterRoutes(router)
+	server := httptest.NewUnstartedServer(router)
+	server.Config.ReadTimeout = 300 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+
+	body := `{"name": "slow", "runtime": "python3", "code": "def handler(event, context):\n    return event\n", "skip_validation": true}`
+	resp, err := trickle(t, server.URL+"/api/functions", a.key, body, time.Second)
+	if err != nil {
+		t.Fatalf("slow deploy: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("slow deploy got status %d, want 200", resp.StatusCode)
+	}
+	if _, err := a.handler.functionRegistry.GetFunctionByName("slow"); err != nil {
+		t.Errorf("slowly deployed function wasn't registered: %v", err)
+	}
+
+	// Other routes keep the server's read timeout
+	resp, err = trickle(t, server.URL+"/api/functions/"+function.ID+"/invoke", a.key, `{"input": {"name": "world"}}`, time.Second)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < 400 {
+			t.Errorf("slow invoke got status %d, want it cut off", resp.StatusCode)
+		}
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	t.Setenv(EnvHTTPMaxBodyBytes, "64")
+	t.Setenv(EnvHTTPMaxDeployBytes, "1024")
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "small")
+	headers := map[string]string{"Authorization": "Bearer " + a.key}
+
+	large := `{"input": {"data": "` + strings.Repeat("x", 100) + `"}}`
+	if status := post(t, a.URL+"/api/functions/"+function.ID+"/invoke", large, headers); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("invoke over the body limit got status %d, want 413", status)
+	}
+	if status := post(t, a.URL+"/api/functions/"+function.ID+"/invoke", `{"input": {}}`, headers); status != http.StatusAccepted {
+		t.Errorf("invoke within the body limit got status %d, want 202", status)
+	}
+
+	// Deploy routes have their own, larger limit
+	code := strings.Repeat("# padding\n", 20) + "def handler(event, context):\n    return event\n"
+	deploy, err := json.Marshal(map[string]interface{}{"name": "padded", "runtime": "python3", "code": code, "skip_validation": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := post(t, a.URL+"/api/functions", string(deploy), headers); status != http.StatusOK {
+		t.Errorf("deploy within the deploy limit got status %d, want 200", status)
+	}
+	code = strings.Repeat("# padding\n", 200) + code
+	if deploy, err = json.Marshal(map[string]interface{}{"name": "huge", "runtime": "python3", "code": code, "skip_validation": true}); err != nil {
+		t.Fatal(err)
+	}
+	if status := post(t, a.URL+"/api/functions", string(deploy), headers); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("deploy over the deploy limit got status %d, want 413", status)
+	}
+}
+
+func TestInvokeErrorSource(t *testing.T) {
+	daemon := daemontest.NewServer(func(payload *daemontest.Payload) *types.ExecutionResult {
+		return &types.ExecutionResult{RequestID: payload.RequestID, FunctionID: payload.FunctionID, StatusCode: http.StatusInternalServerError, ErrorMessage: "division by zero", ErrorType: "exception"}
+	})
+	defer daemon.Close()
+	t.Setenv(daemonclient.EnvDaemonURL, daemon.URL)
+	a := newTestAPI(t)
+	function := a.registerFunction(t, "divide")
+	a.handler.SetTestMode(true)
+	router := mux.NewRouter()
+	a.handler.RegisterRoutes(router)
+	a.Server = httptest.NewServer(router)
+	t.Cleanup(a.Server.Close)
+	daemon.ReportTo(a.URL, "")
+	body := map[string]interface{}{"function_id": function.ID, "input": map[string]int{"n": 0}}
+
+	// The function ran and raised
+	var result types.ExecutionResult
+	if resp := a.do(t, http.MethodPost, "/test/invoke", body, &result); resp.StatusCode != http.StatusOK {
+		t.Fatalf("invoke of a raising function got status %d, want 200", resp.StatusCode)
+	}
+	if result.Source != types.SourceFunction || result.ErrorMessage != "division by zero" {
+		t.Errorf("result = %+v, want the function's exception", result)
+	}
+	var fetched types.ExecutionResult
+	if resp := a.do(t, http.MethodGet, "/api/executions/"+result.RequestID+"/result", nil, &fetched); resp.StatusCode != http.StatusOK || fetched.Source != types.SourceFunction {
+		t.Errorf("fetched result got status %d with source %q, want 200 from the function", resp.StatusCode, fetched.Source)
+	}
+
+	// The platform couldn't run the function
+	daemon.Reject(http.StatusInternalServerError)
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, a.URL+"/test/invoke", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+a.key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	result = types.ExecutionResult{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadGateway || result.Source != types.SourcePlatform {
+		t.Errorf("invoke the daemon rejected got status %d with source %q, want 502 from the platform", resp.StatusCode, result.Source)
+	}
+}
+
+func TestFileLogDestinationRequiresSinkDir(t *testing.T) {
+	t.Setenv(registry.EnvLogSinkDir, "")
+	a := newTestAPI(t)
+	path := filepath.Join(t.TempDir(), "chatty.log")
+	register := func() *http.Response {
+		return a.do(t, http.MethodPost, "/api/functions", map[string]interface{}{
+			"name":            "chatty",
+			"runtime":         "python3",
+			"code":            "def handler(event, context):\n    return event\n",
+			"log_destination": "file://" + path,
+			"skip_validation": true,
+		}, nil)
+	}
+	if resp := register(); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("registration with a file destination and no sink directory got status %d, want 400", resp.StatusCode)
+	}
+	t.Setenv(registry.EnvLogSinkDir, filepath.Dir(path))
+	if resp := register(); resp.StatusCode != http.StatusOK {
+		t.Errorf("registration with a file in the sink directory got status %d, want 200", resp.StatusCode)
+	}
+	t.Setenv(registry.EnvLogSinkDir, "")
+
+	// Destinations stored before the limits changed aren't written to
+	if err := deliverLogs("file://"+path, &LogRecord{ExecutionID: "exec-1", Logs: "secret\n"}); !errors.Is(err, registry.ErrInvalidLogDestination) {
+		t.Errorf("deliverLogs error = %v, want %v", err, registry.ErrInvalidLogDestination)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("log file was written with file destinations disabled: %v", err)
+	}
+}
+
+func TestLogSinkRefusesLocalAddresses(t *testing.T) {
+	t.Setenv(registry.EnvLogSinkAllowLocal, "")
+	for _, address := range []string{"127.0.0.1:8081", "[::1]:8081", "169.254.169.254:80", "0.0.0.0:8081"} {
+		if err := logSinkDialControl("tcp", address, nil); err == nil {
+			t.Errorf("connection to %s allowed", address)
+		}
+	}
+	if err := logSinkDialControl("tcp", "10.0.0.5:9000", nil); err != nil {
+		t.Errorf("connection to a remote address refused: %v", err)
+	}
+
+	// The client refuses a local address even when validation is bypassed,
+	// as with a host name resolving to one
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("log record delivered to a local address")
+	}))
+	defer sink.Close()
+	if resp, err := logSinkClient.Post(sink.URL, "application/json", strings.NewReader("{}")); err == nil {
+		resp.Body.Close()
+		t.Error("delivery to a local address succeeded")
+	}
+}
+
+func TestStreamedChunksMaskSecrets(t *testing.T) {
+	t.Setenv(daemonclient.EnvResultSecret, "")
+	// The daemon yields the secret, then returns it
+	var controlURL string
+	daemon := daemontest.NewServer(func(payload *daemontest.Payload) *types.ExecutionResult {
+		chunk := fmt.Sprintf(`{"request_id": %q, "function_id": %q, "seq": 0, "data": {"password": "hunter2"}}`, payload.RequestID, payload.FunctionID)
+		if status := post(t, controlURL+"/api/results/chunks", chunk, nil); status != http.StatusOK {
+			t.Errorf("chunk report got status %d, want 200", status)
+		}
+		return daemontest.Succeed(`{"password": "hunter2"}`)(payload)
+	})
+	defer daemon.Close()
+	t.Setenv(daemonclient.EnvDaemonURL, daemon.URL)
+	a := newTestAPI(t)
+	controlURL = a.URL
+	daemon.ReportTo(a.URL, "")
+
+	if resp := a.do(t, http.MethodPost, "/api/secrets", SecretRequest{Name: "db-password", Value: "hunter2"}, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("setting the secret got status %d, want 200", resp.StatusCode)
+	}
+	var function registry.FunctionMetadata
+	if resp := a.do(t, http.MethodPost, "/api/functions", map[string]interface{}{
+		"name":            "leaky",
+		"runtime":         "python3",
+		"code":            "import os\n\ndef handler(event, context):\n    yield {\"password\": os.environ[\"DB_PASS\"]}\n",
+		"environment":     map[string]interface{}{"DB_PASS": map[string]string{"secret": "db-password"}},
+		"skip_validation": true,
+	}, &function); resp.StatusCode != http.StatusOK {
+		t.Fatalf("registration got status %d, want 200", resp.StatusCode)
+	}
+
+	// Stream an invoke on the test host VM, as no VM can be booted here
+	hostVM, err := a.handler.vmManager.GetOrCreateTestHostVM()
+	if err != nil {
+		t.Fatalf("GetOrCreateTestHostVM: %v", err)
+	}
+	w := httptest.NewRecorder()
+	a.handler.streamInvoke(w, true, scheduler.InvokeOptions{}, func(opts scheduler.InvokeOptions) (*types.ExecutionResult, error) {
+		return a.handler.scheduler.ExecuteOnVM(function.ID, nil, opts, hostVM)
+	})
+	stream := w.Body.String()
+	if !strings.Contains(stream, "event: chunk") || !strings.Contains(stream, "event: result") {
+		t.Fatalf("stream = %s, want a chunk and the result", stream)
+	}
+	if strings.Contains(stream, "hunter2") {
+		t.Errorf("stream exposes the secret value: %s", stream)
+	}
+	if !strings.Contains(stream, `data: {"password": "****"}`) {
+		t.Errorf("stream = %s, want the yielded secret masked", stream)
+	}
+}
+
+func TestInvokeUnknownFunction(t *testing.T) {
+	a := newTestAPI(t)
+	for _, path := range []string{"/api/functions/missing/invoke", "/api/functions/name/missing/invoke"} {
+		req, err := http.NewRequest(http.MethodPost, a.URL+path, strings.NewReader(`{"input": {}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+a.key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body InvokeError
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("POST %s got status %d, want 404", path, resp.StatusCode)
+		}
+		if err != nil || body.Source != types.SourcePlatform || body.Error == "" {
+			t.Errorf("POST %s: body = %+v, %v; want an error from the platform", path, body, err)
+		}
+	}
+}
+
+func TestWriteInvokeError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{"function not found", fmt.Errorf("resolving: %w", scheduler.ErrFunctionNotFound), http.StatusNotFound, ""},
+		{"version not found", fmt.Errorf("resolving: %w", registry.ErrVersionNotFound), http.StatusNotFound, ""},
+		{"disabled", fmt.Errorf("invoke: %w", registry.ErrFunctionDisabled), http.StatusForbidden, ""},
+		{"corrupt", fmt.Errorf("invoke: %w", registry.ErrCorruptStorage), http.StatusConflict, ""},
+		{"queue full", fmt.Errorf("enqueue: %w", scheduler.ErrQueueFull), http.StatusServiceUnavailable, "5"},
+		{"concurrency limit", fmt.Errorf("invoke: %w", scheduler.ErrConcurrencyLimit), http.StatusTooManyRequests, "1"},
+		{"anything else", errors.New("boom"), http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeInvokeError(w, tt.err)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+			var body InvokeError
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Source != types.SourcePlatform {
+				t.Errorf("body = %+v, %v; want an error from the platform", body, err)
+			}
+		})
+	}
+}
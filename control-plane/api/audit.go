@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+// Audited actions
+const (
+	auditFunctionCreate   = "function.create"
+	auditFunctionClone    = "function.clone"
+	auditFunctionUpdate   = "function.update"
+	auditFunctionDelete   = "function.delete"
+	auditFunctionPromote  = "function.promote"
+	auditFunctionDisable  = "function.disable"
+	auditFunctionEnable   = "function.enable"
+	auditAPIKeyGenerate   = "api_key.generate"
+	auditSecretSet        = "secret.set"
+	auditSecretDelete     = "secret.delete"
+	auditVMReconcile      = "vm.reconcile"
+	auditStatePurge       = "state.purge"
+	auditExecutionCancel  = "execution.cancel"
+	auditExecutionRequeue = "execution.requeue"
+)
+
+// audit records a privileged operation performed by the request's caller.
+// The write happens in the background: a failure to audit is logged but
+// never fails or delays the operation itself.
+func (h *APIHandler) audit(r *http.Request, action, target string, opErr error) {
+	event := &state.AuditEvent{
+		Action:    action,
+		Target:    target,
+		Result:    "success",
+		Timestamp: time.Now(),
+	}
+	if apiKey, ok := h.authManager.Identify(r); ok {
+		event.UserID = apiKey.UserID
+	}
+	if opErr != nil {
+		event.Result = "failure"
+		event.Error = opErr.Error()
+	}
+
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		if err := h.stateManager.SaveAuditEvent(event); err != nil {
+			h.logger.Errorf("Failed to record audit event %s on %s: %v", action, target, err)
+		}
+	}()
+}
+
+// parseTimeWindow reads the RFC 3339 ?since= and ?until= parameters into
+// since and until, leaving them zero when absent
+func parseTimeWindow(query url.Values, since, until *time.Time) error {
+	for param, t := range map[string]*time.Time{"since": since, "until": until} {
+		if value := query.Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: expected an RFC 3339 timestamp", param)
+			}
+			// Timestamps are stored in local time and compared as text
+			*t = parsed.Local()
+		}
+	}
+	return nil
+}
+
+// listAuditHandler returns audit events, newest first, filtered by ?user=,
+// ?action= and an RFC 3339 ?since= / ?until= window
+func (h *APIHandler) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := state.AuditFilter{
+		UserID: query.Get("user"),
+		Action: query.Get("action"),
+	}
+
+	if err := parseTimeWindow(query, &filter.Since, &filter.Until); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.stateManager.ListAuditEvents(filter)
+	if err != nil {
+		http.Error(w, "Failed to list audit events: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
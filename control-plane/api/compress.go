@@ -0,0 +1,110 @@
+package api
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// compressionMiddleware decodes gzip-encoded request bodies and gzips
+// responses for clients that send Accept-Encoding: gzip
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+			body, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "Invalid gzip request body", http.StatusBadRequest)
+				return
+			}
+			defer body.Close()
+			r.Body = body
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		}
+
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the client accepts gzip-encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response body. Headers are held back
+// until the handler writes a body, so empty responses go out unencoded.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz     *gzip.Writer
+	status int  // Status passed to WriteHeader, if any
+	sent   bool // Whether the headers have been sent
+}
+
+// WriteHeader records the status; it is sent with the first write
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write compresses b into the response body
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.sent {
+		w.sent = true
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		// Leave bodies the handler already encoded alone
+		if w.Header().Get("Content-Encoding") == "" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Del("Content-Length")
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+		}
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// Flush sends the data compressed so far, for streamed responses
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close sends the headers of an empty response or flushes the gzip stream
+func (w *gzipResponseWriter) Close() error {
+	if !w.sent {
+		w.sent = true
+		if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+	}
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
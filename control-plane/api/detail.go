@@ -0,0 +1,92 @@
+package api
+
+import (
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+// ExecutionDetail is the body of GET /api/executions/{id}: the execution
+// record with the VM that ran it and a breakdown of where its time went
+type ExecutionDetail struct {
+	*state.Execution
+	VMInfo *ExecutionVM     `json:"vm,omitempty"`
+	Timing *ExecutionTiming `json:"timing"`
+}
+
+// ExecutionVM describes the VM an execution ran on. Only the ID is known
+// once the VM has been destroyed.
+type ExecutionVM struct {
+	ID             string `json:"id"`
+	IP             string `json:"ip,omitempty"`
+	HostID         string `json:"host_id,omitempty"`
+	Runtime        string `json:"runtime,omitempty"`
+	Memory         int    `json:"memory_mb,omitempty"`
+	CPU            int    `json:"vcpus,omitempty"`
+	BootDurationMs int64  `json:"boot_duration_ms,omitempty"`
+}
+
+// ExecutionTiming splits an execution's total time, from being queued (or
+// started, for synchronous executions) until its result arrived, into
+// phases. OtherMs is whatever the phases don't cover, mostly dispatching
+// the request to the daemon and reporting the result back.
+type ExecutionTiming struct {
+	TotalMs        int64 `json:"total_ms"`
+	QueueWaitMs    int64 `json:"queue_wait_ms"`
+	VMAllocationMs int64 `json:"vm_allocation_ms"` // A VM boot when cold_start is set
+	ColdStart      bool  `json:"cold_start"`
+	SetupMs        int64 `json:"dependency_install_ms"`
+	HandlerMs      int64 `json:"handler_ms"`
+	OtherMs        int64 `json:"other_ms"`
+}
+
+// executionTiming computes the phase breakdown of an execution. The total
+// of an execution that hasn't finished runs until now.
+func executionTiming(execution *state.Execution) *ExecutionTiming {
+	timing := &ExecutionTiming{
+		QueueWaitMs:    execution.QueueWaitMs,
+		VMAllocationMs: execution.VMAllocationMs,
+		ColdStart:      execution.ColdStart,
+		SetupMs:        execution.SetupMs,
+		HandlerMs:      execution.HandlerMs,
+	}
+
+	start := execution.StartTime
+	if !execution.QueuedAt.IsZero() && execution.QueuedAt.Before(start) {
+		start = execution.QueuedAt
+	}
+	if start.IsZero() {
+		return timing
+	}
+	end := execution.EndTime
+	if end.IsZero() {
+		end = time.Now()
+	}
+	timing.TotalMs = end.Sub(start).Milliseconds()
+
+	phases := timing.QueueWaitMs + timing.VMAllocationMs + timing.SetupMs + timing.HandlerMs
+	timing.OtherMs = max(timing.TotalMs-phases, 0)
+	return timing
+}
+
+// executionDetail builds the detail response of an execution
+func (h *APIHandler) executionDetail(execution *state.Execution) *ExecutionDetail {
+	detail := &ExecutionDetail{
+		Execution: execution,
+		Timing:    executionTiming(execution),
+	}
+	if execution.VMID == "" {
+		return detail
+	}
+
+	detail.VMInfo = &ExecutionVM{ID: execution.VMID}
+	if vm, err := h.stateManager.GetVM(execution.VMID); err == nil {
+		detail.VMInfo.IP = vm.IP
+		detail.VMInfo.HostID = vm.HostID
+		detail.VMInfo.Runtime = vm.Runtime
+		detail.VMInfo.Memory = vm.Memory
+		detail.VMInfo.CPU = vm.CPU
+		detail.VMInfo.BootDurationMs = vm.BootDuration
+	}
+	return detail
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Request size and deploy timeout environment variables
+const (
+	EnvHTTPMaxBodyBytes   = "FAAS_HTTP_MAX_BODY_BYTES"
+	EnvHTTPMaxDeployBytes = "FAAS_HTTP_MAX_DEPLOY_BYTES"
+	EnvHTTPDeployTimeout  = "FAAS_HTTP_DEPLOY_TIMEOUT_SECONDS"
+)
+
+// deployRoutes are the routes that receive function code, keyed by method
+// and path template. Their bodies may be large and slow to upload, and
+// deploying may validate the entry point on a VM before responding.
+var deployRoutes = map[string]bool{
+	"POST /api/functions":        true,
+	"POST /api/functions/upload": true,
+	"PUT /api/functions/{id}":    true,
+}
+
+// getMaxBodyBytes returns the largest request body accepted outside deploy
+// routes. The default leaves room for result reports carrying the daemon's
+// maximum of 10 MiB of artifacts, which are base64-encoded.
+func getMaxBodyBytes() int64 {
+	// Check environment variable first
+	if size := os.Getenv(EnvHTTPMaxBodyBytes); size != "" {
+		if val, err := strconv.ParseInt(size, 10, 64); err == nil && val > 0 {
+			return val
+		}
+	}
+	// Default to 32 MiB
+	return 32 << 20
+}
+
+// getMaxDeployBytes returns the largest request body accepted by deploy
+// routes
+func getMaxDeployBytes() int64 {
+	// Check environment variable first
+	if size := os.Getenv(EnvHTTPMaxDeployBytes); size != "" {
+		if val, err := strconv.ParseInt(size, 10, 64); err == nil && val > 0 {
+			return val
+		}
+	}
+	// Default to 100 MiB
+	return 100 << 20
+}
+
+// getDeployTimeout returns how long deploy routes may take to read the
+// request and write the response, replacing the server's read and write
+// timeouts
+func getDeployTimeout() time.Duration {
+	// Check environment variable first
+	if timeout := os.Getenv(EnvHTTPDeployTimeout); timeout != "" {
+		if val, err := strconv.Atoi(timeout); err == nil && val > 0 {
+			return time.Duration(val) * time.Second
+		}
+	}
+	// Default to 5 minutes
+	return 5 * time.Minute
+}
+
+// limitMiddleware caps the size of request bodies, responding 413 up front
+// when the declared length is too large, and gives deploy routes longer
+// deadlines than the server's. It runs inside compressionMiddleware, so the
+// cap applies to decoded bodies.
+func limitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := getMaxBodyBytes()
+		if isDeployRoute(r) {
+			limit = getMaxDeployBytes()
+			deadline := time.Now().Add(getDeployTimeout())
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(deadline)
+			rc.SetWriteDeadline(deadline)
+		}
+
+		if r.ContentLength > limit {
+			http.Error(w, fmt.Sprintf("Request body of %d bytes exceeds the limit of %d bytes", r.ContentLength, limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isDeployRoute reports whether the request matched one of deployRoutes
+func isDeployRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	return err == nil && deployRoutes[r.Method+" "+template]
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/registry"
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+// logSinkTimeout bounds each delivery to an HTTP log destination
+const logSinkTimeout = 5 * time.Second
+
+var logSinkClient = newLogSinkClient()
+
+// newLogSinkClient returns the client delivering to HTTP log destinations.
+// It refuses to connect to local addresses, unless
+// registry.LogSinkAllowsLocal, so that a host name resolving to one can't
+// reach the daemon or a metadata service.
+func newLogSinkClient() *http.Client {
+	dialer := &net.Dialer{Timeout: logSinkTimeout, Control: logSinkDialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: logSinkTimeout, Transport: transport}
+}
+
+// logSinkDialControl rejects connections to local addresses
+func logSinkDialControl(network, address string, _ syscall.RawConn) error {
+	if registry.LogSinkAllowsLocal() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && registry.IsLocalIP(ip) {
+		return fmt.Errorf("log destination address %s is local", host)
+	}
+	return nil
+}
+
+// logFileMu keeps concurrent appends to file destinations from interleaving
+var logFileMu sync.Mutex
+
+// LogRecord is the body POSTed to HTTP log destinations for each execution
+type LogRecord struct {
+	FunctionID   string    `json:"function_id"`
+	FunctionName string    `json:"function_name"`
+	ExecutionID  string    `json:"execution_id"`
+	Status       string    `json:"status"`
+	Timestamp    time.Time `json:"timestamp"`
+	Logs         string    `json:"logs"`
+}
+
+// forwardLogs ships an execution's stderr to its function's log destination,
+// if any. Delivery happens in the background: a failure is logged but never
+// fails or delays the result report.
+func (h *APIHandler) forwardLogs(function *registry.FunctionMetadata, execution *state.Execution) {
+	if function.LogDestination == "" || execution.Stderr == "" {
+		return
+	}
+	record := &LogRecord{
+		FunctionID:   function.ID,
+		FunctionName: function.Name,
+		ExecutionID:  execution.ID,
+		Status:       string(execution.Status),
+		Timestamp:    execution.EndTime,
+		Logs:         execution.Stderr,
+	}
+
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		if err := deliverLogs(function.LogDestination, record); err != nil {
+			h.logger.Warnf("Failed to forward logs of execution %s to %s: %v", execution.ID, function.LogDestination, err)
+		}
+	}()
+}
+
+// deliverLogs POSTs record to an http(s) destination as JSON or appends its
+// lines, prefixed with the time and execution ID, to a file:// destination.
+// The destination is validated again, as the limits may have changed since
+// it was set.
+func deliverLogs(destination string, record *LogRecord) error {
+	if err := registry.ValidateLogDestination(destination); err != nil {
+		return err
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme == "file" {
+		var lines strings.Builder
+		prefix := record.Timestamp.UTC().Format(time.RFC3339) + " " + record.ExecutionID + " "
+		for _, line := range strings.Split(strings.TrimRight(record.Logs, "\n"), "\n") {
+			lines.WriteString(prefix + line + "\n")
+		}
+
+		logFileMu.Lock()
+		defer logFileMu.Unlock()
+		file, err := os.OpenFile(filepath.Clean(u.Path), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := file.WriteString(lines.String()); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	resp, err := logSinkClient.Post(destination, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("destination responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+// EnvDevMode enables development-only endpoints such as purge
+const EnvDevMode = "FAAS_DEV_MODE"
+
+// PurgeResponse reports what a purge removed
+type PurgeResponse struct {
+	TerminatedVMs int `json:"terminated_vms"`
+	*state.PurgeCounts
+}
+
+// devModeEnabled reports whether FAAS_DEV_MODE is set to true
+func devModeEnabled() bool {
+	return os.Getenv(EnvDevMode) == "true"
+}
+
+// SetDevMode enables or disables the development-only endpoints; the
+// control plane enables them in test mode
+func (h *APIHandler) SetDevMode(enabled bool) {
+	h.devMode = enabled
+}
+
+// SetTestMode registers POST /test/invoke, which runs functions on the test
+// host VM; it must be called before RegisterRoutes
+func (h *APIHandler) SetTestMode(enabled bool) {
+	h.testMode = enabled
+}
+
+// purgeHandler resets the control plane to a clean slate: it terminates all
+// VMs and deletes all functions, executions and their storage. It needs
+// ?confirm=true and is refused outside development and test mode.
+func (h *APIHandler) purgeHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.devMode {
+		http.Error(w, "Purge is only available in test mode or with "+EnvDevMode+"=true", http.StatusForbidden)
+		return
+	}
+	if r.URL.Query().Get("confirm") != "true" {
+		http.Error(w, "Purge deletes all functions, executions and VMs; pass ?confirm=true to proceed", http.StatusBadRequest)
+		return
+	}
+
+	response, err := h.purge()
+	h.audit(r, auditStatePurge, "all", err)
+	if err != nil {
+		http.Error(w, "Failed to purge: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *APIHandler) purge() (*PurgeResponse, error) {
+	terminated, err := h.vmManager.Purge()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := h.stateManager.Purge()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.functionRegistry.PurgeStorage(); err != nil {
+		return nil, err
+	}
+	h.logger.Warnf("Purged all state: %d VMs terminated, %d functions and %d executions deleted", terminated, counts.Functions, counts.Executions)
+	return &PurgeResponse{TerminatedVMs: terminated, PurgeCounts: counts}, nil
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ReadinessCheck is the outcome of one readiness check. Optional checks are
+// reported but don't make the control plane unready.
+type ReadinessCheck struct {
+	OK       bool   `json:"ok"`
+	Optional bool   `json:"optional,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ReadinessResponse is the response of /ready
+type ReadinessResponse struct {
+	Ready  bool                      `json:"ready"`
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+// readyHandler reports whether the control plane can execute functions: the
+// database must be reachable and a running VM's daemon must answer its
+// health check. Redis and the warm pool are reported but not required. It
+// responds 503 when not ready.
+func (h *APIHandler) readyHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]ReadinessCheck{}
+
+	database := ReadinessCheck{OK: true}
+	if err := h.stateManager.Ping(); err != nil {
+		database = ReadinessCheck{Error: err.Error()}
+	}
+	checks["database"] = database
+
+	redis := ReadinessCheck{OK: true, Optional: true, Detail: "available"}
+	if !h.stateManager.HasResultCache() {
+		redis = ReadinessCheck{Optional: true, Detail: "not available, caching in memory"}
+	}
+	checks["redis"] = redis
+
+	warmVMs := h.vmManager.WarmPoolSize()
+	checks["warm_pool"] = ReadinessCheck{
+		OK:       warmVMs > 0,
+		Optional: true,
+		Detail:   fmt.Sprintf("%d/%d warm VMs", warmVMs, h.vmManager.WarmPoolTarget()),
+	}
+
+	daemon := ReadinessCheck{OK: true}
+	vm, err := h.vmManager.ProbeDaemon()
+	if vm != nil {
+		daemon.Detail = "VM " + vm.ID
+	}
+	if err != nil {
+		daemon.OK = false
+		daemon.Error = err.Error()
+	}
+	checks["daemon"] = daemon
+
+	response := ReadinessResponse{Ready: true, Checks: checks}
+	for _, check := range checks {
+		if !check.OK && !check.Optional {
+			response.Ready = false
+		}
+	}
+
+	status := http.StatusOK
+	if !response.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bluequbit/faas/control-plane/registry"
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/types"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// SecretRequest represents a request to create or replace a secret
+type SecretRequest struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// FunctionEnvironment is a function's environment as given on registration.
+// Each variable is either a plain string or a reference to a secret,
+// {"secret": "<name>"}, resolved at invoke time.
+type FunctionEnvironment struct {
+	Values  map[string]string // Plain variables, stored with the function
+	Secrets map[string]string // Secret names by variable name
+}
+
+// UnmarshalJSON parses an environment object mixing plain values and secret references
+func (e *FunctionEnvironment) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	e.Values, e.Secrets = map[string]string{}, map[string]string{}
+	for name, value := range raw {
+		var plain string
+		if err := json.Unmarshal(value, &plain); err == nil {
+			e.Values[name] = plain
+			continue
+		}
+		var ref struct {
+			Secret string `json:"secret"`
+		}
+		if err := json.Unmarshal(value, &ref); err != nil || ref.Secret == "" {
+			return fmt.Errorf("environment variable %s must be a string or {\"secret\": \"<name>\"}", name)
+		}
+		e.Secrets[name] = ref.Secret
+	}
+	return nil
+}
+
+// names returns every variable name in the environment
+func (e *FunctionEnvironment) names() map[string]string {
+	names := make(map[string]string, len(e.Values)+len(e.Secrets))
+	for name, value := range e.Values {
+		names[name] = value
+	}
+	for name := range e.Secrets {
+		names[name] = ""
+	}
+	return names
+}
+
+// setSecretHandler creates or replaces a secret. The value is never returned.
+func (h *APIHandler) setSecretHandler(w http.ResponseWriter, r *http.Request) {
+	var req SecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := h.functionRegistry.SetSecret(req.Name, req.Value)
+	h.audit(r, auditSecretSet, req.Name, err)
+	if errors.Is(err, registry.ErrInvalidSecret) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(secret)
+}
+
+// listSecretsHandler lists secret names and timestamps, without values
+func (h *APIHandler) listSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	secrets, err := h.stateManager.ListSecrets()
+	if err != nil {
+		http.Error(w, "Failed to list secrets: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if secrets == nil {
+		secrets = []state.Secret{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(secrets)
+}
+
+// deleteSecretHandler deletes a secret. Functions still referencing it fail
+// to invoke until it is recreated.
+func (h *APIHandler) deleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	err := h.stateManager.DeleteSecret(name)
+	h.audit(r, auditSecretDelete, name, err)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Secret not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maskExecutionSecrets replaces the values of the function's secrets in the
+// execution's stored output and error
+func (h *APIHandler) maskExecutionSecrets(execution *state.Execution) {
+	values := h.functionSecretValues(execution.FunctionID)
+	if len(values) == 0 {
+		return
+	}
+	execution.Logs = registry.MaskSecrets(execution.Logs, values)
+	execution.Stderr = registry.MaskSecrets(execution.Stderr, values)
+	execution.Error = registry.MaskSecrets(execution.Error, values)
+}
+
+// maskChunkSecrets replaces the values of the function's secrets in an item
+// yielded by a generator handler. The function is that of the stored
+// execution; the chunk's own function ID is only used when there is none.
+func (h *APIHandler) maskChunkSecrets(chunk *types.StreamChunk) {
+	functionID := chunk.FunctionID
+	if execution, err := h.stateManager.GetExecution(chunk.RequestID); err == nil {
+		functionID = execution.FunctionID
+	}
+	values := h.functionSecretValues(functionID)
+	if len(values) == 0 {
+		return
+	}
+	masked := registry.MaskSecrets(string(chunk.Data), values)
+	// Masking a secret made of JSON syntax can leave the item invalid
+	chunk.Data = types.OutputFromString(masked)
+}
+
+// functionSecretValues returns the values of a function's secrets, keyed by
+// variable name, or nil if it has none or they can't be resolved
+func (h *APIHandler) functionSecretValues(functionID string) map[string]string {
+	function, err := h.functionRegistry.GetFunction(functionID)
+	if err != nil || len(function.Secrets) == 0 {
+		return nil
+	}
+	values, err := h.functionRegistry.SecretValues(function)
+	if err != nil {
+		h.logger.Warnf("Failed to resolve secrets of function %s for masking: %v", function.ID, err)
+		return nil
+	}
+	return values
+}
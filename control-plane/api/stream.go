@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/daemonclient"
+	"github.com/bluequbit/faas/control-plane/scheduler"
+	"github.com/bluequbit/faas/control-plane/types"
+)
+
+// wantsStream reports whether an invoke asked for the items of a generator
+// handler as server-sent events
+func wantsStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// streamInvoke runs a synchronous invoke and streams it as server-sent
+// events: a "chunk" event, with the item's position as its ID, for each item
+// a generator handler yields, then a "result" event carrying the execution
+// result, or an "error" event if the invoke failed after streaming started.
+// Failures before the first event get the usual error responses.
+func (h *APIHandler) streamInvoke(w http.ResponseWriter, sync bool, opts scheduler.InvokeOptions, invoke func(scheduler.InvokeOptions) (*types.ExecutionResult, error)) {
+	if !sync {
+		http.Error(w, "Streaming requires a synchronous invoke", http.StatusBadRequest)
+		return
+	}
+
+	chunks := make(chan *types.StreamChunk)
+	opts.Chunks = chunks
+
+	var result *types.ExecutionResult
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		result, err = invoke(opts)
+		// The scheduler stops sending chunks before the invoke returns
+		close(chunks)
+	}()
+
+	// The stream lasts as long as the function runs
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	started := false
+	start := func() {
+		started = true
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+	}
+
+	// Keep receiving after write errors so the scheduler is never blocked
+	for chunk := range chunks {
+		if !started {
+			start()
+		}
+		writeEvent(w, "chunk", strconv.Itoa(chunk.Seq), chunk.Data)
+		rc.Flush()
+	}
+	<-done
+
+	if err != nil && !started {
+		writeInvokeError(w, err)
+		return
+	}
+	if !started {
+		start()
+	}
+	if err != nil {
+		data, _ := json.Marshal(InvokeError{Error: err.Error(), Source: types.SourcePlatform})
+		writeEvent(w, "error", "", data)
+	} else {
+		data, _ := json.Marshal(result)
+		writeEvent(w, "result", "", data)
+	}
+	rc.Flush()
+}
+
+// writeEvent writes a server-sent event, splitting multi-line data over
+// several data fields
+func writeEvent(w http.ResponseWriter, event, id string, data []byte) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: %s\n", event)
+	if id != "" {
+		fmt.Fprintf(&buf, "id: %s\n", id)
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	w.Write(buf.Bytes())
+}
+
+// handleChunkHandler receives an item yielded by a generator handler from a
+// daemon and forwards it, with secrets masked, to the streaming invoke
+// waiting for it
+func (h *APIHandler) handleChunkHandler(w http.ResponseWriter, r *http.Request) {
+	if !daemonclient.VerifyResultSecret(r, h.resultSecret) {
+		h.logger.Warnf("Rejected chunk report from %s without a valid result secret", r.RemoteAddr)
+		http.Error(w, "Invalid result secret", http.StatusUnauthorized)
+		return
+	}
+
+	var chunk types.StreamChunk
+	if err := json.NewDecoder(r.Body).Decode(&chunk); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Items are masked like the stored result
+	h.maskChunkSecrets(&chunk)
+	h.scheduler.PublishChunk(&chunk)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Chunk received"))
+}
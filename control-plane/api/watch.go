@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/gorilla/mux"
+)
+
+// Long-poll wait bounds for watchExecutionsHandler
+const (
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 60 * time.Second
+)
+
+// WatchResponse is the body of an executions long-poll. Cursor is passed back
+// as ?cursor= to receive only executions that finish later.
+type WatchResponse struct {
+	Executions []state.Execution `json:"executions"`
+	Cursor     string            `json:"cursor"`
+}
+
+// watchExecutionsHandler long-polls for finished executions of a function.
+// It returns the executions that finished after ?cursor= (an RFC 3339 end
+// time, default now) as soon as there are any, or an empty list once
+// ?timeout= seconds (default 30, at most 60) pass.
+func (h *APIHandler) watchExecutionsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	cursor := time.Now()
+	if value := query.Get("cursor"); value != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			http.Error(w, "invalid cursor: expected an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		// Timestamps are stored in local time and compared as text
+		cursor = parsed.Local()
+	}
+
+	timeout := defaultWatchTimeout
+	if value := query.Get("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			http.Error(w, "invalid timeout: expected a number of seconds", http.StatusBadRequest)
+			return
+		}
+		timeout = min(time.Duration(seconds)*time.Second, maxWatchTimeout)
+	}
+
+	if _, err := h.functionRegistry.GetFunction(id); err != nil {
+		http.Error(w, "Function not found", http.StatusNotFound)
+		return
+	}
+
+	// Outlive the server's write timeout while waiting
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 10*time.Second))
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		// Subscribe before querying so a completion in between isn't missed
+		completed := h.scheduler.WaitForCompletion(id)
+		executions, err := h.stateManager.ListFinishedExecutions(id, cursor)
+		if err != nil {
+			http.Error(w, "Failed to list executions", http.StatusInternalServerError)
+			return
+		}
+		if len(executions) > 0 {
+			cursor = executions[len(executions)-1].EndTime
+			writeWatchResponse(w, executions, cursor)
+			return
+		}
+
+		select {
+		case <-completed:
+		case <-deadline.C:
+			writeWatchResponse(w, []state.Execution{}, cursor)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeWatchResponse(w http.ResponseWriter, executions []state.Execution, cursor time.Time) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WatchResponse{
+		Executions: executions,
+		Cursor:     cursor.Format(time.RFC3339Nano),
+	})
+}
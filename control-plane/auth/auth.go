@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
@@ -29,6 +30,28 @@ type APIKey struct {
 	Roles     []string
 }
 
+// HasRole reports whether the key grants role
+func (k APIKey) HasRole(role string) bool {
+	for _, r := range k.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// contextKey is the type of context keys set by this package
+type contextKey struct{}
+
+// apiKeyContextKey is the context key for the validated API key
+var apiKeyContextKey = contextKey{}
+
+// FromContext returns the API key validated by Middleware for a request
+func FromContext(ctx context.Context) (APIKey, bool) {
+	apiKey, ok := ctx.Value(apiKeyContextKey).(APIKey)
+	return apiKey, ok
+}
+
 // NewAuthManager creates a new authentication manager
 func NewAuthManager(logger *logrus.Logger) (*AuthManager, error) {
 	return &AuthManager{
@@ -130,18 +153,35 @@ func (a *AuthManager) Middleware(next http.Handler) http.Handler {
 		}
 
 		// Validate API key
-		apiKey := parts[1]
-		_, err := a.ValidateAPIKey(apiKey)
+		apiKey, err := a.ValidateAPIKey(parts[1])
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Unauthorized: %v", err), http.StatusUnauthorized)
 			return
 		}
 
-		// Call next handler
-		next.ServeHTTP(w, r)
+		// Call next handler with the validated key in the request context
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, apiKey)))
 	})
 }
 
+// Identify returns the API key a request is authenticated with, if any.
+// Unlike Middleware it doesn't reject requests without a valid key.
+func (a *AuthManager) Identify(r *http.Request) (APIKey, bool) {
+	if apiKey, ok := FromContext(r.Context()); ok {
+		return apiKey, true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return APIKey{}, false
+	}
+	apiKey, err := a.ValidateAPIKey(token)
+	if err != nil {
+		return APIKey{}, false
+	}
+	return apiKey, true
+}
+
 // RoleMiddleware creates a middleware for role-based authorization
 func (a *AuthManager) RoleMiddleware(role string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,32 @@
+// Package buildinfo holds the version of the control plane build. The values
+// are injected at build time, e.g.
+//
+//	go build -ldflags "-X github.com/bluequbit/faas/control-plane/buildinfo.Version=v1.2.0"
+package buildinfo
+
+import "runtime"
+
+// Build metadata, set with -ldflags "-X ..."
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Info describes a build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info of the running binary
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
@@ -0,0 +1,220 @@
+// Package cron provides parsing and evaluation of cron expressions used to
+// trigger scheduled function invocations.
+//
+// Standard five-field expressions (minute hour day-of-month month day-of-week)
+// are supported, including lists, ranges and steps, as well as the shorthand
+// descriptors @yearly, @monthly, @weekly, @daily, @hourly and @every <duration>.
+
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	expr string
+
+	// every is set for "@every <duration>" schedules, which fire at a fixed
+	// interval instead of on calendar fields
+	every time.Duration
+
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+// field describes the valid range of a cron field
+type field struct {
+	name     string
+	min, max int
+}
+
+var (
+	minuteField = field{"minute", 0, 59}
+	hourField   = field{"hour", 0, 23}
+	domField    = field{"day of month", 1, 31}
+	monthField  = field{"month", 1, 12}
+	dowField    = field{"day of week", 0, 7}
+)
+
+// descriptors maps the shorthand descriptors to their five-field equivalent
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, errors.New("cron expression cannot be empty")
+	}
+
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid @every duration: %v", err)
+		}
+		if d < time.Second {
+			return nil, errors.New("@every duration must be at least 1s")
+		}
+		return &Schedule{expr: expr, every: d}, nil
+	}
+
+	spec := expr
+	if strings.HasPrefix(spec, "@") {
+		full, ok := descriptors[spec]
+		if !ok {
+			return nil, fmt.Errorf("unknown cron descriptor: %s", spec)
+		}
+		spec = full
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields, got %d", len(fields))
+	}
+
+	s := &Schedule{expr: expr}
+	var err error
+	if s.minute, err = parseField(fields[0], minuteField); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[1], hourField); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseField(fields[2], domField); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseField(fields[3], monthField); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseField(fields[4], dowField); err != nil {
+		return nil, err
+	}
+	// Allow 7 as an alias for Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+
+	return s, nil
+}
+
+// parseField parses a single comma-separated cron field into a bitmask
+func parseField(value string, f field) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(value, ",") {
+		b, err := parseRange(part, f)
+		if err != nil {
+			return 0, err
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+// parseRange parses a single range expression such as "*", "5", "1-5" or "*/15"
+func parseRange(part string, f field) (uint64, error) {
+	step := 1
+	if i := strings.Index(part, "/"); i >= 0 {
+		n, err := strconv.Atoi(part[i+1:])
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid step in %s field: %s", f.name, part)
+		}
+		step = n
+		part = part[:i]
+	}
+
+	start, end := f.min, f.max
+	switch {
+	case part == "*" || part == "?":
+	case strings.Contains(part, "-"):
+		bounds := strings.SplitN(part, "-", 2)
+		var err1, err2 error
+		start, err1 = strconv.Atoi(bounds[0])
+		end, err2 = strconv.Atoi(bounds[1])
+		if err1 != nil || err2 != nil {
+			return 0, fmt.Errorf("invalid range in %s field: %s", f.name, part)
+		}
+	default:
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value in %s field: %s", f.name, part)
+		}
+		start = n
+		if step == 1 {
+			end = n
+		}
+	}
+
+	if start < f.min || end > f.max || start > end {
+		return 0, fmt.Errorf("%s field out of range [%d-%d]: %s", f.name, f.min, f.max, part)
+	}
+
+	var bits uint64
+	for i := start; i <= end; i += step {
+		bits |= 1 << uint(i)
+	}
+	return bits, nil
+}
+
+// String returns the original expression
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// Next returns the first activation time strictly after t, or the zero time
+// if the schedule can never fire
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every).Truncate(time.Second)
+	}
+
+	// Start at the next whole minute
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// dayMatches reports whether t matches the day-of-month and day-of-week
+// fields. As in Vixie cron, when both are restricted either may match.
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
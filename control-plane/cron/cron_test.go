@@ -0,0 +1,57 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2024, 5, 15, 10, 30, 20, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 5, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2024, 5, 15, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 5, 16, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		// With both day fields restricted either one matches
+		{"0 0 20 * 5", time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 5, 15, 11, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2024, 5, 15, 10, 31, 50, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Parse(%q).Next() = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"@sometimes",
+		"@every 10ms",
+		"@every soon",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) accepted an invalid expression", expr)
+		}
+	}
+}
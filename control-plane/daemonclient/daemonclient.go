@@ -0,0 +1,207 @@
+// Package daemonclient provides the HTTP client used by the control plane to
+// talk to the daemon running inside each VM.
+//
+// By default the daemon is reached over plain HTTP, which is convenient for
+// local development. Setting FAAS_DAEMON_TLS_CA switches to HTTPS and verifies
+// the daemon's certificate against that CA; FAAS_DAEMON_TLS_CERT and
+// FAAS_DAEMON_TLS_KEY additionally present a client certificate so the daemon
+// can authenticate the control plane (mutual TLS).
+//
+// FAAS_DAEMON_URL sends every request to a single base URL instead of the VM's
+// IP address, e.g. to point the control plane at a stub daemon during tests.
+//
+// FAAS_RESULT_SECRET is a shared secret daemons present when reporting
+// results. The control plane puts it in each VM's metadata service (MMDS) for
+// the daemon to read at startup, and removes it once the daemon is ready.
+
+package daemonclient
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Environment variable names
+const (
+	EnvDaemonTLSCA   = "FAAS_DAEMON_TLS_CA"
+	EnvDaemonTLSCert = "FAAS_DAEMON_TLS_CERT"
+	EnvDaemonTLSKey  = "FAAS_DAEMON_TLS_KEY"
+	EnvDaemonURL     = "FAAS_DAEMON_URL"
+	EnvResultSecret  = "FAAS_RESULT_SECRET"
+)
+
+// ResultSecretHeader carries the result secret on result reports
+const ResultSecretHeader = "X-Result-Secret"
+
+// Port is the port the daemon listens on inside each VM
+const Port = 8081
+
+// Connection pool settings. Connections to each daemon are kept alive and
+// reused across invocations instead of being dialed per request.
+const (
+	maxIdleConns        = 256
+	maxIdleConnsPerHost = 16
+	idleConnTimeout     = 90 * time.Second
+)
+
+// Client sends requests to VM daemons over a shared connection pool
+type Client struct {
+	scheme  string
+	baseURL string // Overrides the per-VM address when set
+	http    *http.Client
+}
+
+// New creates a daemon client configured from the environment
+func New() (*Client, error) {
+	tlsConfig, err := loadTLSConfig(os.Getenv(EnvDaemonTLSCA), os.Getenv(EnvDaemonTLSCert), os.Getenv(EnvDaemonTLSKey))
+	if err != nil {
+		return nil, err
+	}
+	client := NewWithTLS(tlsConfig)
+	if base := os.Getenv(EnvDaemonURL); base != "" {
+		parsed, err := url.Parse(base)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("%s must be an http or https URL", EnvDaemonURL)
+		}
+		client.baseURL = strings.TrimSuffix(base, "/")
+	}
+	return client, nil
+}
+
+// NewWithTLS creates a daemon client using the given TLS configuration.
+// A nil config means plain HTTP.
+func NewWithTLS(tlsConfig *tls.Config) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+	scheme := "http"
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+		scheme = "https"
+	}
+	return &Client{
+		scheme: scheme,
+		// No client-wide timeout: each request is bounded by its own context
+		http: &http.Client{Transport: transport},
+	}
+}
+
+// URL returns the URL of path on the daemon at ip, or on the configured base
+// URL if there is one
+func (c *Client) URL(ip, path string) string {
+	if c.baseURL != "" {
+		return c.baseURL + path
+	}
+	return fmt.Sprintf("%s://%s:%d%s", c.scheme, ip, Port, path)
+}
+
+// Do sends req to a daemon, bounding the whole exchange, including reading
+// the response body, by timeout
+func (c *Client) Do(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// Get sends a GET request to a daemon with the given timeout
+func (c *Client) Get(url string, timeout time.Duration) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req, timeout)
+}
+
+// Post sends a POST request to a daemon with the given timeout
+func (c *Client) Post(url, contentType string, body io.Reader, timeout time.Duration) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req, timeout)
+}
+
+// ResultSecret returns the configured result secret, or "" if result reports
+// aren't authenticated
+func ResultSecret() string {
+	return os.Getenv(EnvResultSecret)
+}
+
+// VerifyResultSecret reports whether r carries the given result secret. Every
+// request is accepted when no secret is configured.
+func VerifyResultSecret(r *http.Request, secret string) bool {
+	if secret == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(ResultSecretHeader)), []byte(secret)) == 1
+}
+
+// maxDrainBytes bounds how much of an unread response body is discarded on
+// close so the connection can be returned to the pool
+const maxDrainBytes = 64 * 1024
+
+// cancelOnClose releases a request's context once its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close drains and closes the body, then cancels the request context
+func (b *cancelOnClose) Close() error {
+	io.Copy(io.Discard, io.LimitReader(b.ReadCloser, maxDrainBytes))
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// loadTLSConfig builds a TLS configuration from PEM file paths. It returns a
+// nil config when no CA is configured.
+func loadTLSConfig(caPath, certPath, keyPath string) (*tls.Config, error) {
+	if caPath == "" {
+		if certPath != "" || keyPath != "" {
+			return nil, fmt.Errorf("%s must be set when a daemon client certificate is configured", EnvDaemonTLSCA)
+		}
+		return nil, nil
+	}
+
+	caPEM, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read daemon CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no valid certificates found in daemon CA file")
+	}
+
+	config := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certPath != "" || keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load daemon client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
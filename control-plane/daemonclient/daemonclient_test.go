@@ -0,0 +1,314 @@
+package daemonclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority issuing certificates for the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+	path string // PEM file of the CA certificate
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "skyscale test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, path, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, pool: pool, path: path}
+}
+
+// issue creates a certificate for 127.0.0.1 signed by the CA and returns it
+// along with the paths of its certificate and key PEM files
+func (ca *testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) (tls.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, certPath, keyPath
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTLSDaemon starts a daemon stub over TLS that requires a client
+// certificate signed by ca
+func newTLSDaemon(t *testing.T, ca *testCA) *httptest.Server {
+	t.Helper()
+	serverCert, _, _ := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	daemon := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	daemon.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	daemon.StartTLS()
+	t.Cleanup(daemon.Close)
+	return daemon
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	daemon := newTLSDaemon(t, ca)
+	_, certPath, keyPath := ca.issue(t, 3, x509.ExtKeyUsageClientAuth)
+
+	t.Setenv(EnvDaemonTLSCA, ca.path)
+	t.Setenv(EnvDaemonTLSCert, certPath)
+	t.Setenv(EnvDaemonTLSKey, keyPath)
+	t.Setenv(EnvDaemonURL, daemon.URL)
+	client, err := New()
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	resp, err := client.Get(client.URL("", "/health"), 5*time.Second)
+	if err != nil {
+		t.Fatalf("request over mutual TLS failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if resp.TLS == nil || len(resp.TLS.VerifiedChains) == 0 {
+		t.Error("the daemon's certificate wasn't verified")
+	}
+}
+
+func TestTLSWithoutClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	daemon := newTLSDaemon(t, ca)
+
+	t.Setenv(EnvDaemonTLSCA, ca.path)
+	t.Setenv(EnvDaemonTLSCert, "")
+	t.Setenv(EnvDaemonTLSKey, "")
+	t.Setenv(EnvDaemonURL, daemon.URL)
+	client, err := New()
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if resp, err := client.Get(client.URL("", "/health"), 5*time.Second); err == nil {
+		resp.Body.Close()
+		t.Error("a daemon requiring a client certificate accepted a client without one")
+	}
+}
+
+func TestTLSRejectsUnknownDaemon(t *testing.T) {
+	ca := newTestCA(t)
+	daemon := newTLSDaemon(t, newTestCA(t))
+	_, certPath, keyPath := ca.issue(t, 3, x509.ExtKeyUsageClientAuth)
+
+	t.Setenv(EnvDaemonTLSCA, ca.path)
+	t.Setenv(EnvDaemonTLSCert, certPath)
+	t.Setenv(EnvDaemonTLSKey, keyPath)
+	t.Setenv(EnvDaemonURL, daemon.URL)
+	client, err := New()
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if resp, err := client.Get(client.URL("", "/health"), 5*time.Second); err == nil {
+		resp.Body.Close()
+		t.Error("connected to a daemon whose certificate isn't signed by the configured CA")
+	}
+}
+
+func TestPlaintextByDefault(t *testing.T) {
+	t.Setenv(EnvDaemonTLSCA, "")
+	t.Setenv(EnvDaemonTLSCert, "")
+	t.Setenv(EnvDaemonTLSKey, "")
+	t.Setenv(EnvDaemonURL, "")
+	client, err := New()
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if url := client.URL("172.16.0.2", "/execute"); url != "http://172.16.0.2:8081/execute" {
+		t.Errorf("URL = %s, want plain HTTP", url)
+	}
+}
+
+func TestLoadTLSConfigRequiresCA(t *testing.T) {
+	if _, err := loadTLSConfig("", "cert.pem", "key.pem"); err == nil {
+		t.Error("accepted a client certificate without a CA")
+	}
+	if _, err := loadTLSConfig(filepath.Join(t.TempDir(), "missing.pem"), "", ""); err == nil {
+		t.Error("accepted a missing CA file")
+	}
+}
+
+// countingDaemon starts a daemon stub that counts the connections opened to it
+func countingDaemon(tb testing.TB) (*httptest.Server, *atomic.Int64) {
+	tb.Helper()
+	var conns atomic.Int64
+	daemon := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "accepted"}`))
+	}))
+	daemon.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	daemon.Start()
+	tb.Cleanup(daemon.Close)
+	return daemon, &conns
+}
+
+func TestConnectionsReused(t *testing.T) {
+	daemon, conns := countingDaemon(t)
+	client := NewWithTLS(nil)
+
+	for i := 0; i < 20; i++ {
+		resp, err := client.Post(daemon.URL+"/execute", "application/json", nil, 5*time.Second)
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		resp.Body.Close()
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("20 sequential requests opened %d connections, want 1", n)
+	}
+}
+
+func TestConnectionReusedAfterUnreadBody(t *testing.T) {
+	daemon, conns := countingDaemon(t)
+	client := NewWithTLS(nil)
+
+	// Closing a response without reading it still returns the connection
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(daemon.URL+"/health", 5*time.Second)
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		resp.Body.Close()
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("5 requests opened %d connections, want 1", n)
+	}
+}
+
+func TestDoTimeout(t *testing.T) {
+	release := make(chan struct{})
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer daemon.Close()
+	defer close(release)
+	client := NewWithTLS(nil)
+
+	start := time.Now()
+	if resp, err := client.Get(daemon.URL+"/health", 100*time.Millisecond); err == nil {
+		resp.Body.Close()
+		t.Fatal("request to a hanging daemon succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("request took %v, want it cut off by its 100ms timeout", elapsed)
+	}
+}
+
+// BenchmarkInvokeRequests compares connection setup across repeated requests
+// through the pooled client and through a new client and transport per
+// request. conns/op is the number of TCP connections opened per request.
+func BenchmarkInvokeRequests(b *testing.B) {
+	b.Run("pooled", func(b *testing.B) {
+		daemon, conns := countingDaemon(b)
+		client := NewWithTLS(nil)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			resp, err := client.Post(daemon.URL+"/execute", "application/json", nil, 5*time.Second)
+			if err != nil {
+				b.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+		b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+	})
+
+	b.Run("client per request", func(b *testing.B) {
+		daemon, conns := countingDaemon(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			client := &http.Client{Transport: &http.Transport{}}
+			resp, err := client.Post(daemon.URL+"/execute", "application/json", nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			resp.Body.Close()
+			client.CloseIdleConnections()
+		}
+		b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+	})
+}
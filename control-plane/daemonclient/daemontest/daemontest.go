@@ -0,0 +1,166 @@
+// Package daemontest provides a mock VM daemon for tests of the control
+// plane, in the style of net/http/httptest.
+//
+// A Daemon acknowledges execution requests on /execute and, like the real
+// daemon, reports each result to the control plane's /api/results endpoint
+// afterwards. Point the control plane at it with FAAS_DAEMON_URL before
+// creating the scheduler.
+package daemontest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/bluequbit/faas/control-plane/daemonclient"
+	"github.com/bluequbit/faas/control-plane/types"
+)
+
+// Payload is the part of an execution request the mock daemon decodes
+type Payload struct {
+	FunctionID  string                 `json:"function_id"`
+	Name        string                 `json:"name"`
+	RequestID   string                 `json:"request_id"`
+	Runtime     string                 `json:"runtime"`
+	Code        string                 `json:"code"`
+	Version     string                 `json:"version"`
+	Timeout     int                    `json:"timeout"`
+	Environment map[string]string      `json:"environment"`
+	Event       map[string]interface{} `json:"event"`
+	Context     map[string]interface{} `json:"context"`
+}
+
+// RunFunc returns the result of an execution, or nil if the function never
+// finishes and no result is reported
+type RunFunc func(payload *Payload) *types.ExecutionResult
+
+// Daemon is a mock daemon serving /execute and /health
+type Daemon struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	run        RunFunc
+	status     int    // Status execution requests are acknowledged with
+	controlURL string // Where results are reported
+	secret     string // Result secret presented on reports
+	payloads   []*Payload
+	reports    sync.WaitGroup
+}
+
+// NewServer starts a mock daemon that runs executions with run. Results are
+// only reported once ReportTo has been called.
+func NewServer(run RunFunc) *Daemon {
+	d := &Daemon{run: run, status: http.StatusAccepted}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/execute", d.handleExecute)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	d.Server = httptest.NewServer(mux)
+	return d
+}
+
+// ReportTo makes the daemon report results to the control plane at baseURL,
+// presenting secret if it isn't empty
+func (d *Daemon) ReportTo(baseURL, secret string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.controlURL = baseURL
+	d.secret = secret
+}
+
+// Reject makes the daemon answer execution requests with status instead of
+// accepting them
+func (d *Daemon) Reject(status int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status = status
+}
+
+// Payloads returns the execution requests the daemon received
+func (d *Daemon) Payloads() []*Payload {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*Payload(nil), d.payloads...)
+}
+
+// Close waits for pending result reports and shuts the daemon down
+func (d *Daemon) Close() {
+	d.reports.Wait()
+	d.Server.Close()
+}
+
+// handleExecute records an execution request and acknowledges it, then runs
+// the execution and reports its result in the background
+func (d *Daemon) handleExecute(w http.ResponseWriter, r *http.Request) {
+	var payload Payload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	d.payloads = append(d.payloads, &payload)
+	status, controlURL, secret := d.status, d.controlURL, d.secret
+	d.mu.Unlock()
+
+	if status != http.StatusAccepted {
+		http.Error(w, "Execution rejected", status)
+		return
+	}
+
+	d.reports.Add(1)
+	go func() {
+		defer d.reports.Done()
+		result := d.run(&payload)
+		if result == nil || controlURL == "" {
+			return
+		}
+		Report(controlURL, secret, result)
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Function execution started"))
+}
+
+// Report posts result to the control plane at baseURL the way the daemon
+// does, returning the response status or 0 if the request failed
+func Report(baseURL, secret string, result *types.ExecutionResult) int {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return 0
+	}
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/api/results", bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(daemonclient.ResultSecretHeader, secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// Succeed returns a RunFunc completing every execution with output
+func Succeed(output string) RunFunc {
+	return func(payload *Payload) *types.ExecutionResult {
+		return &types.ExecutionResult{
+			RequestID:  payload.RequestID,
+			FunctionID: payload.FunctionID,
+			StatusCode: http.StatusOK,
+			Output:     types.OutputFromString(output),
+		}
+	}
+}
+
+// Hang is a RunFunc whose executions never finish
+func Hang(payload *Payload) *types.ExecutionResult {
+	return nil
+}
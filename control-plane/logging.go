@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logging environment variables
+const (
+	EnvLogLevel  = "FAAS_LOG_LEVEL"  // logrus level name, e.g. debug or warn
+	EnvLogFormat = "FAAS_LOG_FORMAT" // text or json
+)
+
+// configureLogger applies the level and format set in the environment.
+// Invalid values are reported as warnings and the defaults, info and text,
+// are kept.
+func configureLogger(logger *logrus.Logger) {
+	logger.SetLevel(logrus.InfoLevel)
+	logger.SetFormatter(&logrus.TextFormatter{})
+
+	switch format := os.Getenv(EnvLogFormat); format {
+	case "", "text":
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		logger.Warnf("Invalid %s %q, expected text or json; using text", EnvLogFormat, format)
+	}
+
+	if value := os.Getenv(EnvLogLevel); value != "" {
+		level, err := logrus.ParseLevel(value)
+		if err != nil {
+			logger.Warnf("Invalid %s %q; using info", EnvLogLevel, value)
+			return
+		}
+		logger.SetLevel(level)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestConfigureLoggerLevel(t *testing.T) {
+	tests := []struct {
+		value string
+		want  logrus.Level
+		warns bool
+	}{
+		{value: "", want: logrus.InfoLevel},
+		{value: "debug", want: logrus.DebugLevel},
+		{value: "warn", want: logrus.WarnLevel},
+		{value: "ERROR", want: logrus.ErrorLevel},
+		{value: "verbose", want: logrus.InfoLevel, warns: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(EnvLogLevel, tt.value)
+			t.Setenv(EnvLogFormat, "")
+			var out bytes.Buffer
+			logger := logrus.New()
+			logger.SetOutput(&out)
+
+			configureLogger(logger)
+			if logger.GetLevel() != tt.want {
+				t.Errorf("level = %v, want %v", logger.GetLevel(), tt.want)
+			}
+			if warned := strings.Contains(out.String(), EnvLogLevel); warned != tt.warns {
+				t.Errorf("warned = %v, want %v: %s", warned, tt.warns, out.String())
+			}
+		})
+	}
+}
+
+func TestConfigureLoggerFormat(t *testing.T) {
+	tests := []struct {
+		value string
+		json  bool
+		warns bool
+	}{
+		{value: "", json: false},
+		{value: "text", json: false},
+		{value: "json", json: true},
+		{value: "yaml", json: false, warns: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv(EnvLogLevel, "")
+			t.Setenv(EnvLogFormat, tt.value)
+			var out bytes.Buffer
+			logger := logrus.New()
+			logger.SetOutput(&out)
+
+			configureLogger(logger)
+			if _, ok := logger.Formatter.(*logrus.JSONFormatter); ok != tt.json {
+				t.Errorf("JSON formatter = %v, want %v", ok, tt.json)
+			}
+			if warned := strings.Contains(out.String(), EnvLogFormat); warned != tt.warns {
+				t.Errorf("warned = %v, want %v: %s", warned, tt.warns, out.String())
+			}
+		})
+	}
+}
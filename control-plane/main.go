@@ -34,7 +34,7 @@ func main() {
 
 	logger := logrus.New()
 	logger.SetOutput(os.Stdout)
-	logger.SetLevel(logrus.InfoLevel)
+	configureLogger(logger)
 	logger.Info("Starting Skyscale Control Plane")
 
 	// Check if running in test mode
@@ -48,12 +48,18 @@ func main() {
 		logger.Fatalf("Failed to initialize state manager: %v", err)
 	}
 
+	// Run as a host agent for a remote control plane if requested
+	if AgentMode {
+		runAgent(stateManager, logger)
+		return
+	}
+
 	functionRegistry, err := registry.NewFunctionRegistry(stateManager, logger)
 	if err != nil {
 		logger.Fatalf("Failed to initialize function registry: %v", err)
 	}
 
-	vmManager, err := vm.NewVMManager(stateManager, logger)
+	vmManager, err := vm.NewVMManager(stateManager, logger, TestMode)
 	if err != nil {
 		logger.Fatalf("Failed to initialize VM manager: %v", err)
 	}
@@ -68,6 +74,10 @@ func main() {
 		logger.Fatalf("Failed to initialize scheduler: %v", err)
 	}
 
+	// Start the cron trigger for scheduled functions
+	cronTrigger := scheduler.NewCronTrigger(functionScheduler, stateManager, logger, 10*time.Second)
+	cronTrigger.Start()
+
 	authManager, err := auth.NewAuthManager(logger)
 	if err != nil {
 		logger.Fatalf("Failed to initialize auth manager: %v", err)
@@ -79,6 +89,10 @@ func main() {
 
 	// Register API routes
 	apiHandler := api.NewAPIHandler(functionRegistry, vmManager, functionScheduler, authManager, stateManager, logger)
+	if TestMode {
+		apiHandler.SetDevMode(true)
+		apiHandler.SetTestMode(true)
+	}
 	apiHandler.RegisterRoutes(router)
 
 	// Add metrics endpoint
@@ -97,18 +111,10 @@ func main() {
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte(`{"test_mode": true, "host_vm_id": "` + TestHostVMID + `"}`))
 		})
-		//add a test/invoke endpoint
-
 	}
 
 	// Start HTTP server
-	srv := &http.Server{
-		Addr:         ":8080",
-		Handler:      router,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
-	}
+	srv := newServer(":8080", router)
 
 	// Start server in a goroutine
 	go func() {
@@ -133,6 +139,9 @@ func main() {
 	}
 
 	// Cleanup resources
+	cronTrigger.Stop()
+	functionScheduler.Stop()
+	apiHandler.Wait()
 	vmManager.Cleanup()
 	stateManager.Close()
 
@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/vm"
+)
+
+// Environment variable names
+const (
+	EnvFunctionMaxTimeout = "FAAS_FUNCTION_MAX_TIMEOUT"
+	EnvFunctionMinTimeout = "FAAS_FUNCTION_MIN_TIMEOUT"
+	EnvFunctionStorageDir = "FAAS_FUNCTION_STORAGE_DIR"
+	EnvFunctionMaxCPUs    = "FAAS_FUNCTION_MAX_CPUS"
+	EnvLogSinkDir         = "FAAS_LOG_SINK_DIR"
+	EnvLogSinkAllowLocal  = "FAAS_LOG_SINK_ALLOW_LOCAL"
+)
+
+// DefaultTimeout is the timeout in seconds given to functions registered without one
+const DefaultTimeout = 30
+
+// getStorageDir returns the directory holding each function's code, files
+// and versions
+func getStorageDir() string {
+	// Check environment variable first
+	if dir := os.Getenv(EnvFunctionStorageDir); dir != "" {
+		return dir
+	}
+	return filepath.Join(vm.DataDir(), "function-storage")
+}
+
+// LogSinkDir returns the directory file:// log destinations must be in, or
+// "" when file destinations aren't allowed
+func LogSinkDir() string {
+	if dir := os.Getenv(EnvLogSinkDir); dir != "" {
+		return filepath.Clean(dir)
+	}
+	return ""
+}
+
+// LogSinkAllowsLocal reports whether http(s) log destinations may be
+// loopback or link-local addresses, such as a collector on the control
+// plane's own host
+func LogSinkAllowsLocal() bool {
+	return os.Getenv(EnvLogSinkAllowLocal) == "true"
+}
+
+// EffectiveMemory returns the memory limit in MB a function with the given
+// configured memory runs with. Functions can't use more than their VM has,
+// and those registered without a limit get the whole VM.
+func EffectiveMemory(memory int) int {
+	vmMemory := vm.DefaultMemoryMB()
+	if memory <= 0 || memory > vmMemory {
+		return vmMemory
+	}
+	return memory
+}
+
+// EffectiveCPU returns the number of vCPUs a function with the given
+// configured count runs with; those registered without one get the
+// default of the warm VMs
+func EffectiveCPU(cpu int) int {
+	if cpu <= 0 {
+		return vm.DefaultCPUCount()
+	}
+	return cpu
+}
+
+// MaxCPUs returns the most vCPUs a function may ask for
+func MaxCPUs() int {
+	// Check environment variable first
+	if cpus := os.Getenv(EnvFunctionMaxCPUs); cpus != "" {
+		if val, err := strconv.Atoi(cpus); err == nil && val > 0 {
+			return val
+		}
+	}
+	// Default to 4 vCPUs
+	return 4
+}
+
+// MaxTimeout returns the maximum function timeout allowed by the platform
+func MaxTimeout() time.Duration {
+	// Check environment variable first
+	if timeout := os.Getenv(EnvFunctionMaxTimeout); timeout != "" {
+		if val, err := strconv.Atoi(timeout); err == nil && val > 0 {
+			return time.Duration(val) * time.Second
+		}
+	}
+	// Default to 5 minutes
+	return 300 * time.Second
+}
+
+// MinTimeout returns the minimum function timeout allowed by the platform
+func MinTimeout() time.Duration {
+	// Check environment variable first
+	if timeout := os.Getenv(EnvFunctionMinTimeout); timeout != "" {
+		if val, err := strconv.Atoi(timeout); err == nil && val > 0 {
+			return time.Duration(val) * time.Second
+		}
+	}
+	// Default to 1 second
+	return time.Second
+}
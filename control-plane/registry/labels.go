@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// labelPattern restricts label keys and values to a conservative character set
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]{0,61}[A-Za-z0-9])?$`)
+
+// ErrInvalidLabel is returned when a label key, value or selector is malformed
+var ErrInvalidLabel = errors.New("invalid label")
+
+// ValidateLabels checks that all label keys and values are well formed
+func ValidateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if !labelPattern.MatchString(key) {
+			return fmt.Errorf("%w: key %q", ErrInvalidLabel, key)
+		}
+		if !labelPattern.MatchString(value) {
+			return fmt.Errorf("%w: value %q for key %q", ErrInvalidLabel, value, key)
+		}
+	}
+	return nil
+}
+
+// ParseLabelSelector parses a selector of the form "key=value[,key=value...]"
+func ParseLabelSelector(selector string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, term := range strings.Split(selector, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(term), "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: selector term %q must be key=value", ErrInvalidLabel, term)
+		}
+		result[key] = value
+	}
+
+	if err := ValidateLabels(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// MatchLabels reports whether labels contain every key/value pair in selector
+func MatchLabels(labels, selector map[string]string) bool {
+	if len(selector) == 0 {
+		return false
+	}
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	selector, err := ParseLabelSelector("env=test, team=data")
+	if err != nil {
+		t.Fatalf("ParseLabelSelector: %v", err)
+	}
+	if len(selector) != 2 || selector["env"] != "test" || selector["team"] != "data" {
+		t.Errorf("selector = %v", selector)
+	}
+
+	for _, invalid := range []string{"", "env", "env=", "env=te st", "=test"} {
+		if _, err := ParseLabelSelector(invalid); !errors.Is(err, ErrInvalidLabel) {
+			t.Errorf("ParseLabelSelector(%q) error = %v, want %v", invalid, err, ErrInvalidLabel)
+		}
+	}
+}
+
+func TestMatchLabels(t *testing.T) {
+	labels := map[string]string{"env": "test", "team": "data"}
+	tests := []struct {
+		selector map[string]string
+		want     bool
+	}{
+		{map[string]string{"env": "test"}, true},
+		{map[string]string{"env": "test", "team": "data"}, true},
+		{map[string]string{"env": "prod"}, false},
+		{map[string]string{"env": "test", "owner": "alice"}, false},
+		// An empty selector matches nothing rather than everything
+		{map[string]string{}, false},
+	}
+	for _, tt := range tests {
+		if got := MatchLabels(labels, tt.selector); got != tt.want {
+			t.Errorf("MatchLabels(%v) = %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestDeleteFunctionsBySelector(t *testing.T) {
+	r := newTestRegistry(t)
+	register := func(name string, labels map[string]string) string {
+		reg := testRegistration(name)
+		reg.Labels = labels
+		function, err := r.RegisterFunction(reg)
+		if err != nil {
+			t.Fatalf("RegisterFunction: %v", err)
+		}
+		return function.ID
+	}
+	want := []string{
+		register("test-a", map[string]string{"env": "test"}),
+		register("test-b", map[string]string{"env": "test", "team": "data"}),
+	}
+	kept := []string{
+		register("prod", map[string]string{"env": "prod"}),
+		register("unlabeled", nil),
+	}
+
+	deleted, err := r.DeleteFunctionsBySelector(map[string]string{"env": "test"})
+	if err != nil {
+		t.Fatalf("DeleteFunctionsBySelector: %v", err)
+	}
+	sort.Strings(deleted)
+	sort.Strings(want)
+	if len(deleted) != 2 || deleted[0] != want[0] || deleted[1] != want[1] {
+		t.Errorf("deleted %v, want %v", deleted, want)
+	}
+
+	for _, id := range want {
+		if _, err := r.GetFunction(id); err == nil {
+			t.Errorf("function %s still exists", id)
+		}
+	}
+	for _, id := range kept {
+		if _, err := r.GetFunction(id); err != nil {
+			t.Errorf("function %s was deleted: %v", id, err)
+		}
+	}
+}
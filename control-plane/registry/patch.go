@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"time"
+)
+
+// FunctionPatch holds the metadata fields PatchFunction changes. Nil fields
+// are left unchanged; an empty map clears the environment, secret references
+// or labels.
+type FunctionPatch struct {
+	Memory      *int // Zero means the VM's memory, see EffectiveMemory
+	Timeout     *int
+	Concurrency *int // Zero removes the limit
+	Environment map[string]string
+	Secrets     map[string]string // Secret names by environment variable name
+	Labels      map[string]string
+}
+
+// PatchFunction changes the metadata fields set in patch, leaving the other
+// fields, the code and the version as they are. Every field is checked
+// before any is changed, and they are saved together.
+func (r *FunctionRegistry) PatchFunction(id string, patch FunctionPatch) (*FunctionMetadata, error) {
+	if patch.Memory != nil {
+		if err := ValidateMemory(*patch.Memory); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Timeout != nil {
+		if err := ValidateTimeout(*patch.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Concurrency != nil {
+		if err := ValidateConcurrency(*patch.Concurrency); err != nil {
+			return nil, err
+		}
+	}
+	if err := ValidateLabels(patch.Labels); err != nil {
+		return nil, err
+	}
+	if err := r.checkSecretRefs(patch.Secrets); err != nil {
+		return nil, err
+	}
+
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if patch.Memory != nil {
+		function.Memory = *patch.Memory
+	}
+	if patch.Timeout != nil {
+		function.Timeout = *patch.Timeout
+	}
+	if patch.Concurrency != nil {
+		function.Concurrency = *patch.Concurrency
+	}
+	if patch.Environment != nil {
+		function.Environment = patch.Environment
+	}
+	if patch.Secrets != nil {
+		function.SecretRefs = patch.Secrets
+	}
+	if patch.Labels != nil {
+		function.Labels = patch.Labels
+	}
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
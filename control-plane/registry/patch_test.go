@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPatchFunctionTimeoutOnly(t *testing.T) {
+	r := newTestRegistry(t)
+	function, err := r.RegisterFunction(FunctionRegistration{
+		Name:        "hello",
+		Runtime:     "python3",
+		Memory:      128,
+		Timeout:     30,
+		Code:        FunctionCode{Code: testCode, Requirements: "requests\n"},
+		Environment: map[string]string{"GREETING": "hi"},
+	})
+	if err != nil {
+		t.Fatalf("RegisterFunction: %v", err)
+	}
+
+	timeout := 60
+	patched, err := r.PatchFunction(function.ID, FunctionPatch{Timeout: &timeout})
+	if err != nil {
+		t.Fatalf("PatchFunction: %v", err)
+	}
+	if patched.Timeout != 60 {
+		t.Errorf("timeout = %d, want 60", patched.Timeout)
+	}
+	if patched.Version != function.Version {
+		t.Errorf("version = %s, want %s unchanged", patched.Version, function.Version)
+	}
+	if patched.Memory != 128 || patched.Environment["GREETING"] != "hi" {
+		t.Errorf("other settings changed: memory %d, environment %v", patched.Memory, patched.Environment)
+	}
+
+	code, err := r.GetFunctionCode(function.ID)
+	if err != nil {
+		t.Fatalf("GetFunctionCode: %v", err)
+	}
+	if code.Code != testCode || code.Requirements != "requests\n" {
+		t.Errorf("code changed to %+v", code)
+	}
+}
+
+func TestPatchFunctionValidatesAllFields(t *testing.T) {
+	r := newTestRegistry(t)
+	function, err := r.RegisterFunction(testRegistration("hello"))
+	if err != nil {
+		t.Fatalf("RegisterFunction: %v", err)
+	}
+
+	memory, timeout, concurrency := -1, 60, -1
+	tests := []struct {
+		name  string
+		patch FunctionPatch
+		want  error
+	}{
+		{"negative memory", FunctionPatch{Memory: &memory, Timeout: &timeout}, ErrInvalidMemory},
+		{"negative concurrency", FunctionPatch{Concurrency: &concurrency, Timeout: &timeout}, ErrInvalidConcurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := r.PatchFunction(function.ID, tt.patch); !errors.Is(err, tt.want) {
+				t.Fatalf("PatchFunction() error = %v, want %v", err, tt.want)
+			}
+			// Valid fields of a rejected patch aren't applied either
+			if stored, _ := r.GetFunction(function.ID); stored.Timeout != 30 {
+				t.Errorf("timeout = %d, want 30 unchanged", stored.Timeout)
+			}
+		})
+	}
+}
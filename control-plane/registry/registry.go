@@ -1,4 +1,3 @@
-
 // Package registry provides functionality for managing function metadata and code.
 //
 // The FunctionRegistry manages the registration, updating, and retrieval of functions.
@@ -12,13 +11,20 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/bluequbit/faas/control-plane/cron"
 	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/vm"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 // FunctionRegistry manages the serverless functions
@@ -30,15 +36,50 @@ type FunctionRegistry struct {
 
 // FunctionMetadata contains metadata about a function
 type FunctionMetadata struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Runtime   string    `json:"runtime"`
-	Memory    int       `json:"memory"`
-	Timeout   int       `json:"timeout"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Status    string    `json:"status"`
-	Version   string    `json:"version"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Runtime string `json:"runtime"`
+	Memory  int    `json:"memory"`
+	Timeout int    `json:"timeout"`
+	// EffectiveMemory is the memory limit in MB executions run with, see
+	// EffectiveMemory
+	EffectiveMemory int       `json:"effective_memory"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	Status          string    `json:"status"`
+	Version         string    `json:"version"`
+	EntryPoint      string    `json:"entry_point"`
+	// StableVersion is set during a canary deployment; it receives the
+	// invokes that don't go to the canary Version
+	StableVersion string `json:"stable_version,omitempty"`
+	CanaryPercent int    `json:"canary_percent,omitempty"`
+	// RateLimit is the maximum invocations per second, with bursts of up to
+	// RateBurst; zero means unlimited
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	RateBurst int     `json:"rate_burst,omitempty"`
+	// Concurrency is the maximum executions queued or running at once; zero
+	// means unlimited
+	Concurrency int               `json:"concurrency,omitempty"`
+	Environment map[string]string `json:"environment,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// Secrets maps environment variable names to the names of the secrets
+	// whose values they receive at invoke time
+	Secrets map[string]string `json:"secrets,omitempty"`
+	// Scratch is set when executions get a SCRATCH_DIR that persists
+	// between executions on the same VM
+	Scratch bool `json:"scratch,omitempty"`
+	// Cacheable is set for deterministic functions whose results are cached
+	// for CacheTTL seconds; zero means the scheduler's default TTL
+	Cacheable bool `json:"cacheable,omitempty"`
+	CacheTTL  int  `json:"cache_ttl,omitempty"`
+	// LogDestination is where the stderr of executions is forwarded, an
+	// http(s) URL or a file:// path
+	LogDestination string `json:"log_destination,omitempty"`
+	// Retry is the retry policy of asynchronous executions, if any
+	Retry *state.RetryPolicy `json:"retry,omitempty"`
+	// CPU is the number of vCPUs of the VMs executions run on, see
+	// EffectiveCPU
+	CPU int `json:"cpu"`
 }
 
 // FunctionCode contains the code and requirements for a function
@@ -46,13 +87,109 @@ type FunctionCode struct {
 	Code         string `json:"code"`
 	Requirements string `json:"requirements"`
 	Config       string `json:"config"`
+	// Files holds additional source and data files keyed by their path
+	// relative to the function root, e.g. "lib/helpers.py"
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// filesDir is the subdirectory of a function's storage directory holding its additional files
+const filesDir = "files"
+
+// versionsDir is the subdirectory of a function's storage directory holding
+// snapshots of previous versions, one directory per version
+const versionsDir = "versions"
+
+// DefaultEntryPoint is the entry point of functions registered without one
+const DefaultEntryPoint = "handler.handler"
+
+// Function statuses
+const (
+	StatusReady    = "ready"
+	StatusDisabled = "disabled" // Invokes are rejected until the function is enabled again
+	StatusCorrupt  = "corrupt"  // Stored code is incomplete; invokes are rejected until the function is redeployed
+)
+
+// ErrFunctionExists is returned when registering a function whose name is already taken
+var ErrFunctionExists = errors.New("function with this name already exists")
+
+// ErrVersionNotFound is returned when a requested function version doesn't exist
+var ErrVersionNotFound = errors.New("function version not found")
+
+// ErrInvalidCanary is returned when a canary percentage is outside 0-99
+var ErrInvalidCanary = errors.New("invalid canary percentage")
+
+// ErrNoCanary is returned when promoting a function that has no canary deployment
+var ErrNoCanary = errors.New("function has no canary deployment")
+
+// ErrInvalidRateLimit is returned when a rate limit or burst is negative
+var ErrInvalidRateLimit = errors.New("invalid rate limit")
+
+// ErrInvalidConcurrency is returned when a concurrency limit is negative
+var ErrInvalidConcurrency = errors.New("invalid concurrency limit")
+
+// ErrInvalidSchedule is returned when a cron expression can't be parsed or
+// never fires
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// ErrInvalidCacheTTL is returned when a result cache TTL is negative
+var ErrInvalidCacheTTL = errors.New("invalid cache TTL")
+
+// ErrInvalidRetryPolicy is returned when a retry policy is out of bounds or
+// names an unknown error type
+var ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
+// ErrInvalidLogDestination is returned when a log destination is neither an
+// http(s) URL of a remote host nor a file:// path inside LogSinkDir
+var ErrInvalidLogDestination = errors.New("invalid log destination")
+
+// ErrCorruptStorage is returned when a function's stored code is missing
+// one of its standard files, e.g. after a deploy was interrupted
+var ErrCorruptStorage = errors.New("function storage is corrupt")
+
+// ErrFunctionDisabled is returned when invoking a disabled function
+var ErrFunctionDisabled = errors.New("function disabled")
+
+// ErrInvalidTimeout is returned when a function timeout is outside the platform limits
+var ErrInvalidTimeout = errors.New("invalid timeout")
+
+// ValidateTimeout checks a timeout in seconds against the platform limits
+func ValidateTimeout(timeout int) error {
+	t := time.Duration(timeout) * time.Second
+	if t < MinTimeout() || t > MaxTimeout() {
+		return fmt.Errorf("%w: %ds is outside the allowed range of %v to %v", ErrInvalidTimeout, timeout, MinTimeout(), MaxTimeout())
+	}
+	return nil
+}
+
+// ErrInvalidMemory is returned when a function's memory is negative
+var ErrInvalidMemory = errors.New("invalid memory")
+
+// ValidateMemory checks a memory limit in MB. Zero means the VM's memory,
+// and limits above it are clamped, see EffectiveMemory.
+func ValidateMemory(memory int) error {
+	if memory < 0 {
+		return fmt.Errorf("%w: %d MB", ErrInvalidMemory, memory)
+	}
+	return nil
+}
+
+// ErrInvalidCPU is returned when a vCPU count is outside the platform limits
+var ErrInvalidCPU = errors.New("invalid vCPU count")
+
+// ValidateCPU checks a vCPU count against the platform limits. Zero means
+// the default count.
+func ValidateCPU(cpu int) error {
+	if cpu < 0 || cpu > MaxCPUs() {
+		return fmt.Errorf("%w: %d is outside the allowed range of 1 to %d", ErrInvalidCPU, cpu, MaxCPUs())
+	}
+	return nil
 }
 
 // NewFunctionRegistry creates a new function registry
 func NewFunctionRegistry(stateManager *state.StateManager, logger *logrus.Logger) (*FunctionRegistry, error) {
 	// Create storage directory if it doesn't exist
-	storageDir := "function-storage"
-	if err := os.MkdirAll(storageDir, 0755); err != nil {
+	storageDir := getStorageDir()
+	if err := vm.PrepareStorageDir(storageDir); err != nil {
 		return nil, err
 	}
 
@@ -63,82 +200,197 @@ func NewFunctionRegistry(stateManager *state.StateManager, logger *logrus.Logger
 	}, nil
 }
 
-// RegisterFunction registers a new function
-func (r *FunctionRegistry) RegisterFunction(name, runtime string, memory, timeout int, code, requirements, config string) (*FunctionMetadata, error) {
-	// Check if function with the same name already exists
-	_, err := r.stateManager.GetFunctionByName(name)
-	if err == nil {
-		return nil, errors.New("function with this name already exists")
-	}
-
-	// Create function ID
-	id := uuid.New().String()
+// FunctionRegistration holds everything a function is registered with.
+// Zero fields get their defaults.
+type FunctionRegistration struct {
+	Name       string
+	Runtime    string
+	Memory     int    // Zero means the VM's memory, see EffectiveMemory
+	Timeout    int    // Zero means DefaultTimeout
+	EntryPoint string // Empty means DefaultEntryPoint
+	Code       FunctionCode
+	// Environment holds plain variables, Secrets the secret names of the
+	// variables receiving secret values
+	Environment map[string]string
+	Secrets     map[string]string
+	Labels      map[string]string
+	// RateLimit is in invocations per second; a zero RateBurst defaults to
+	// the rate rounded up
+	RateLimit      float64
+	RateBurst      int
+	Concurrency    int
+	Scratch        bool
+	Cacheable      bool
+	CacheTTL       int
+	LogDestination string
+	Retry          state.RetryPolicy
+	CPU            int
+	// Schedule is an optional cron expression at which the function is
+	// invoked asynchronously with ScheduleInput
+	Schedule      string
+	ScheduleInput map[string]interface{}
+}
 
-	// Create function directory
-	functionDir := filepath.Join(r.storageDir, id)
-	if err := os.MkdirAll(functionDir, 0755); err != nil {
-		return nil, err
+// Validate checks every setting of a registration that doesn't depend on
+// stored state, i.e. all but the secret references
+func (reg *FunctionRegistration) Validate() error {
+	if reg.Timeout != 0 {
+		if err := ValidateTimeout(reg.Timeout); err != nil {
+			return err
+		}
+	}
+	if err := ValidateMemory(reg.Memory); err != nil {
+		return err
+	}
+	if err := ValidateCPU(reg.CPU); err != nil {
+		return err
+	}
+	for path := range reg.Code.Files {
+		if err := ValidateFilePath(path); err != nil {
+			return err
+		}
+	}
+	if err := ValidateLabels(reg.Labels); err != nil {
+		return err
+	}
+	if err := ValidateRateLimit(reg.RateLimit, reg.RateBurst); err != nil {
+		return err
+	}
+	if err := ValidateConcurrency(reg.Concurrency); err != nil {
+		return err
+	}
+	if err := ValidateCacheTTL(reg.CacheTTL); err != nil {
+		return err
+	}
+	if err := ValidateLogDestination(reg.LogDestination); err != nil {
+		return err
+	}
+	if err := ValidateRetryPolicy(reg.Retry); err != nil {
+		return err
+	}
+	if reg.Schedule != "" {
+		if _, err := newSchedule("", reg.Schedule, reg.ScheduleInput); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// Write function code
-	if err := ioutil.WriteFile(filepath.Join(functionDir, "handler.py"), []byte(code), 0644); err != nil {
+// RegisterFunction registers a new function with all its settings, and its
+// schedule if it has one, in a single insert. Names are unique in the
+// database, so of concurrent registrations of a name exactly one succeeds
+// and the others fail with ErrFunctionExists.
+func (r *FunctionRegistry) RegisterFunction(reg FunctionRegistration) (*FunctionMetadata, error) {
+	if err := reg.Validate(); err != nil {
 		return nil, err
 	}
-
-	// Write requirements.txt
-	if err := ioutil.WriteFile(filepath.Join(functionDir, "requirements.txt"), []byte(requirements), 0644); err != nil {
+	if err := r.checkSecretRefs(reg.Secrets); err != nil {
 		return nil, err
 	}
 
-	// Write skyscale.yaml
-	if err := ioutil.WriteFile(filepath.Join(functionDir, "skyscale.yaml"), []byte(config), 0644); err != nil {
-		return nil, err
+	// Fail early when the name is taken; a function created concurrently is
+	// caught by the insert below
+	if _, err := r.stateManager.GetFunctionByName(reg.Name); err == nil {
+		return nil, ErrFunctionExists
 	}
 
-	// Create function in state manager
 	now := time.Now()
 	function := &state.Function{
-		ID:        id,
-		Name:      name,
-		Runtime:   runtime,
-		Memory:    memory,
-		Timeout:   timeout,
-		CreatedAt: now,
-		UpdatedAt: now,
-		Status:    "ready",
-		Version:   "1.0.0",
-		Code:      code,
+		ID:             uuid.New().String(),
+		Name:           reg.Name,
+		Runtime:        reg.Runtime,
+		Memory:         reg.Memory,
+		Timeout:        reg.Timeout,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Status:         StatusReady,
+		Version:        "1.0.0",
+		Code:           reg.Code.Code,
+		EntryPoint:     reg.EntryPoint,
+		RateLimit:      reg.RateLimit,
+		RateBurst:      rateBurst(reg.RateLimit, reg.RateBurst),
+		Concurrency:    reg.Concurrency,
+		Environment:    reg.Environment,
+		Labels:         reg.Labels,
+		SecretRefs:     reg.Secrets,
+		Scratch:        reg.Scratch,
+		Cacheable:      reg.Cacheable,
+		CacheTTL:       reg.CacheTTL,
+		LogDestination: reg.LogDestination,
+		Retry:          reg.Retry,
+		CPU:            reg.CPU,
+	}
+	if function.Timeout == 0 {
+		function.Timeout = DefaultTimeout
+	}
+	if function.EntryPoint == "" {
+		function.EntryPoint = DefaultEntryPoint
 	}
 
-	if err := r.stateManager.SaveFunction(function); err != nil {
+	var schedule *state.Schedule
+	if reg.Schedule != "" {
+		var err error
+		if schedule, err = newSchedule(function.ID, reg.Schedule, reg.ScheduleInput); err != nil {
+			return nil, err
+		}
+	}
+
+	// The code is stored under the new ID first, so the function is never
+	// visible without it
+	functionDir := filepath.Join(r.storageDir, function.ID)
+	if err := writeCode(functionDir, &reg.Code); err != nil {
+		os.RemoveAll(functionDir)
+		return nil, err
+	}
+
+	if err := r.stateManager.CreateFunction(function, schedule); err != nil {
 		// Cleanup on failure
 		os.RemoveAll(functionDir)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrFunctionExists
+		}
 		return nil, err
 	}
 
-	return &FunctionMetadata{
-		ID:        function.ID,
-		Name:      function.Name,
-		Runtime:   function.Runtime,
-		Memory:    function.Memory,
-		Timeout:   function.Timeout,
-		CreatedAt: function.CreatedAt,
-		UpdatedAt: function.UpdatedAt,
-		Status:    function.Status,
-		Version:   function.Version,
-	}, nil
+	return toMetadata(function), nil
 }
 
-// UpdateFunction updates an existing function
-func (r *FunctionRegistry) UpdateFunction(id string, code, requirements, config string) (*FunctionMetadata, error) {
+// UpdateFunction updates an existing function. A zero timeout keeps the
+// current one. A nonzero canaryPercent deploys the new code as a canary that
+// receives that percentage of invokes until it is promoted, the rest going to
+// the version that was serving before; zero switches all traffic at once.
+func (r *FunctionRegistry) UpdateFunction(id string, timeout int, code, requirements, config string, canaryPercent int) (*FunctionMetadata, error) {
+	if canaryPercent < 0 || canaryPercent > 99 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidCanary, canaryPercent)
+	}
+
 	// Get function from state manager
 	function, err := r.stateManager.GetFunction(id)
 	if err != nil {
 		return nil, err
 	}
 
-	// Update function directory
+	// Validate timeout
+	if timeout != 0 {
+		if err := ValidateTimeout(timeout); err != nil {
+			return nil, err
+		}
+		function.Timeout = timeout
+	}
+
+	// Update function directory, recreating it if it went missing
 	functionDir := filepath.Join(r.storageDir, id)
+	if err := os.MkdirAll(functionDir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Keep a snapshot of the current version so it can still be invoked.
+	// Corrupt code can't be kept; redeploying replaces it.
+	if err := snapshotVersion(functionDir, function.Version); errors.Is(err, ErrCorruptStorage) {
+		r.logger.Warnf("Not keeping version %s of function %s: %v", function.Version, function.Name, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to snapshot version %s: %v", function.Version, err)
+	}
 
 	// Write function code
 	if err := ioutil.WriteFile(filepath.Join(functionDir, "handler.py"), []byte(code), 0644); err != nil {
@@ -155,26 +407,108 @@ func (r *FunctionRegistry) UpdateFunction(id string, code, requirements, config
 		return nil, err
 	}
 
+	// Replacing a canary keeps the original stable version
+	if canaryPercent == 0 {
+		function.StableVersion = ""
+	} else if function.StableVersion == "" {
+		function.StableVersion = function.Version
+	}
+	function.CanaryPercent = canaryPercent
+
 	// Update function in state manager
 	function.UpdatedAt = time.Now()
 	function.Code = code
 	function.Version = incrementVersion(function.Version)
+	if function.Status == StatusCorrupt {
+		function.Status = StatusReady
+	}
 
 	if err := r.stateManager.SaveFunction(function); err != nil {
 		return nil, err
 	}
 
-	return &FunctionMetadata{
-		ID:        function.ID,
-		Name:      function.Name,
-		Runtime:   function.Runtime,
-		Memory:    function.Memory,
-		Timeout:   function.Timeout,
-		CreatedAt: function.CreatedAt,
-		UpdatedAt: function.UpdatedAt,
-		Status:    function.Status,
-		Version:   function.Version,
-	}, nil
+	return toMetadata(function), nil
+}
+
+// CloneFunction registers a new function named name with the latest code,
+// files and settings of the function id. The clone starts its own version
+// history at 1.0.0 without a canary deployment, schedule or executions.
+func (r *FunctionRegistry) CloneFunction(id, name string) (*FunctionMetadata, error) {
+	source, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.stateManager.GetFunctionByName(name); err == nil {
+		return nil, ErrFunctionExists
+	}
+
+	code, err := r.GetFunctionCode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := *source
+	clone.ID = uuid.New().String()
+	clone.Name = name
+	clone.CreatedAt = time.Now()
+	clone.UpdatedAt = clone.CreatedAt
+	clone.Status = StatusReady
+	clone.Version = "1.0.0"
+	clone.StableVersion = ""
+	clone.CanaryPercent = 0
+	clone.Environment = copyMap(source.Environment)
+	clone.Labels = copyMap(source.Labels)
+	clone.SecretRefs = copyMap(source.SecretRefs)
+	clone.Retry.RetryOn = append([]string(nil), source.Retry.RetryOn...)
+
+	functionDir := filepath.Join(r.storageDir, clone.ID)
+	if err := writeCode(functionDir, code); err != nil {
+		os.RemoveAll(functionDir)
+		return nil, err
+	}
+	if err := r.stateManager.CreateFunction(&clone, nil); err != nil {
+		// Cleanup on failure
+		os.RemoveAll(functionDir)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrFunctionExists
+		}
+		return nil, err
+	}
+
+	return toMetadata(&clone), nil
+}
+
+// copyMap returns a copy of m, or nil if m is nil
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// PromoteFunction ends a canary deployment, sending all invokes to the
+// canary version
+func (r *FunctionRegistry) PromoteFunction(id string) (*FunctionMetadata, error) {
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+	if function.StableVersion == "" {
+		return nil, ErrNoCanary
+	}
+
+	function.StableVersion = ""
+	function.CanaryPercent = 0
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
 }
 
 // GetFunction retrieves a function by ID
@@ -184,17 +518,7 @@ func (r *FunctionRegistry) GetFunction(id string) (*FunctionMetadata, error) {
 		return nil, err
 	}
 
-	return &FunctionMetadata{
-		ID:        function.ID,
-		Name:      function.Name,
-		Runtime:   function.Runtime,
-		Memory:    function.Memory,
-		Timeout:   function.Timeout,
-		CreatedAt: function.CreatedAt,
-		UpdatedAt: function.UpdatedAt,
-		Status:    function.Status,
-		Version:   function.Version,
-	}, nil
+	return toMetadata(function), nil
 }
 
 // GetFunctionByName retrieves a function by name
@@ -204,42 +528,124 @@ func (r *FunctionRegistry) GetFunctionByName(name string) (*FunctionMetadata, er
 		return nil, err
 	}
 
-	return &FunctionMetadata{
-		ID:        function.ID,
-		Name:      function.Name,
-		Runtime:   function.Runtime,
-		Memory:    function.Memory,
-		Timeout:   function.Timeout,
-		CreatedAt: function.CreatedAt,
-		UpdatedAt: function.UpdatedAt,
-		Status:    function.Status,
-		Version:   function.Version,
-	}, nil
+	return toMetadata(function), nil
 }
 
-// GetFunctionCode retrieves the code for a function
+// GetFunctionCode retrieves the code for a function. If a standard file is
+// missing it returns ErrCorruptStorage and marks the function corrupt.
 func (r *FunctionRegistry) GetFunctionCode(id string) (*FunctionCode, error) {
 	// Get function from state manager
-	_, err := r.stateManager.GetFunction(id)
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.readLatestCode(function)
+}
+
+// readLatestCode reads a function's latest code, marking the function
+// corrupt if its storage is incomplete
+func (r *FunctionRegistry) readLatestCode(function *state.Function) (*FunctionCode, error) {
+	code, err := readCode(filepath.Join(r.storageDir, function.ID))
+	if errors.Is(err, ErrCorruptStorage) && function.Status != StatusCorrupt {
+		r.logger.Errorf("Marking function %s corrupt: %v", function.Name, err)
+		function.Status = StatusCorrupt
+		function.UpdatedAt = time.Now()
+		if err := r.stateManager.SaveFunction(function); err != nil {
+			r.logger.Errorf("Failed to mark function %s corrupt: %v", function.Name, err)
+		}
+	}
+	return code, err
+}
+
+// GetFunctionCodeVersion retrieves the code of a specific function version.
+// An empty version or the current version returns the latest code.
+func (r *FunctionRegistry) GetFunctionCodeVersion(id, version string) (*FunctionCode, error) {
+	function, err := r.stateManager.GetFunction(id)
 	if err != nil {
 		return nil, err
 	}
 
+	if version == "" || version == function.Version {
+		return r.readLatestCode(function)
+	}
+
+	versionDir := filepath.Join(r.storageDir, id, versionsDir, filepath.Base(version))
+	if _, err := os.Stat(versionDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, version)
+		}
+		return nil, err
+	}
+
+	return readCode(versionDir)
+}
+
+// snapshotVersion copies the current code and files of a function into its
+// versions directory under the given version
+func snapshotVersion(functionDir, version string) error {
+	code, err := readCode(functionDir)
+	if err != nil {
+		return err
+	}
+
+	return writeCode(filepath.Join(functionDir, versionsDir, version), code)
+}
+
+// writeCode writes the standard function files and additional files of code
+// into dir, creating it if needed
+func writeCode(dir string, code *FunctionCode) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	standard := map[string]string{
+		"handler.py":       code.Code,
+		"requirements.txt": code.Requirements,
+		"skyscale.yaml":    code.Config,
+	}
+	for name, content := range standard {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	for path, content := range code.Files {
+		target := filepath.Join(dir, filesDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readCode reads the standard function files and additional files stored
+// in dir. A missing standard file is reported as ErrCorruptStorage.
+func readCode(functionDir string) (*FunctionCode, error) {
 	// Read function code
-	functionDir := filepath.Join(r.storageDir, id)
-	code, err := ioutil.ReadFile(filepath.Join(functionDir, "handler.py"))
+	code, err := readStandardFile(functionDir, "handler.py")
 	if err != nil {
 		return nil, err
 	}
 
 	// Read requirements.txt
-	requirements, err := ioutil.ReadFile(filepath.Join(functionDir, "requirements.txt"))
+	requirements, err := readStandardFile(functionDir, "requirements.txt")
 	if err != nil {
 		return nil, err
 	}
 
 	// Read skyscale.yaml
-	config, err := ioutil.ReadFile(filepath.Join(functionDir, "skyscale.yaml"))
+	config, err := readStandardFile(functionDir, "skyscale.yaml")
+	if err != nil {
+		return nil, err
+	}
+
+	// Read additional files
+	files, err := readFiles(filepath.Join(functionDir, filesDir))
 	if err != nil {
 		return nil, err
 	}
@@ -248,9 +654,97 @@ func (r *FunctionRegistry) GetFunctionCode(id string) (*FunctionCode, error) {
 		Code:         string(code),
 		Requirements: string(requirements),
 		Config:       string(config),
+		Files:        files,
 	}, nil
 }
 
+// readStandardFile reads one of the files every stored function has
+func readStandardFile(functionDir, name string) ([]byte, error) {
+	content, err := ioutil.ReadFile(filepath.Join(functionDir, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s is missing, redeploy the function", ErrCorruptStorage, name)
+	}
+	return content, err
+}
+
+// SetFunctionFiles replaces the additional files of a function
+func (r *FunctionRegistry) SetFunctionFiles(id string, files map[string]string) error {
+	if _, err := r.stateManager.GetFunction(id); err != nil {
+		return err
+	}
+
+	for path := range files {
+		if err := ValidateFilePath(path); err != nil {
+			return err
+		}
+	}
+
+	dir := filepath.Join(r.storageDir, id, filesDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	for path, content := range files {
+		target := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateFilePath checks that a function file path is relative, stays within
+// the function root and doesn't clash with the standard function files
+func ValidateFilePath(path string) error {
+	clean := filepath.ToSlash(filepath.Clean(path))
+	if path == "" || filepath.IsAbs(path) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid file path: %s", path)
+	}
+	switch clean {
+	case "handler.py", "requirements.txt", "skyscale.yaml":
+		return fmt.Errorf("file path %s is reserved", path)
+	}
+	return nil
+}
+
+// readFiles reads all files under dir into a map keyed by slash-separated
+// relative path. A missing dir yields an empty map.
+func readFiles(dir string) (map[string]string, error) {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return files, nil
+}
+
 // ListFunctions lists all functions
 func (r *FunctionRegistry) ListFunctions() ([]FunctionMetadata, error) {
 	functions, err := r.stateManager.ListFunctions()
@@ -260,17 +754,7 @@ func (r *FunctionRegistry) ListFunctions() ([]FunctionMetadata, error) {
 
 	result := make([]FunctionMetadata, len(functions))
 	for i, function := range functions {
-		result[i] = FunctionMetadata{
-			ID:        function.ID,
-			Name:      function.Name,
-			Runtime:   function.Runtime,
-			Memory:    function.Memory,
-			Timeout:   function.Timeout,
-			CreatedAt: function.CreatedAt,
-			UpdatedAt: function.UpdatedAt,
-			Status:    function.Status,
-			Version:   function.Version,
-		}
+		result[i] = *toMetadata(&function)
 	}
 
 	return result, nil
@@ -290,10 +774,484 @@ func (r *FunctionRegistry) DeleteFunction(id string) error {
 		return err
 	}
 
+	// Delete any schedule attached to the function
+	if err := r.stateManager.DeleteSchedule(function.ID); err != nil {
+		r.logger.Warnf("Failed to delete schedule for function %s: %v", function.ID, err)
+	}
+
 	// Delete function from state manager
 	return r.stateManager.DeleteFunction(function.ID)
 }
 
+// SetEntryPoint sets the entry point ("file.function") of a function
+func (r *FunctionRegistry) SetEntryPoint(id, entryPoint string) (*FunctionMetadata, error) {
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	function.EntryPoint = entryPoint
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
+
+// SetLabels replaces the labels of a function
+func (r *FunctionRegistry) SetLabels(id string, labels map[string]string) (*FunctionMetadata, error) {
+	if err := ValidateLabels(labels); err != nil {
+		return nil, err
+	}
+
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	function.Labels = labels
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
+
+// ValidateRateLimit checks a rate in invocations per second and its burst
+func ValidateRateLimit(rate float64, burst int) error {
+	if rate < 0 || burst < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return fmt.Errorf("%w: rate %v, burst %d", ErrInvalidRateLimit, rate, burst)
+	}
+	return nil
+}
+
+// rateBurst returns the burst stored with a rate limit: none without a
+// limit, and the rate rounded up when no burst is given
+func rateBurst(rate float64, burst int) int {
+	if rate == 0 {
+		return 0
+	}
+	if burst == 0 {
+		return int(math.Ceil(rate))
+	}
+	return burst
+}
+
+// SetRateLimit limits a function to rate invocations per second with bursts
+// of up to burst; a burst of zero defaults to the rate rounded up, and a rate
+// of zero removes the limit
+func (r *FunctionRegistry) SetRateLimit(id string, rate float64, burst int) (*FunctionMetadata, error) {
+	if err := ValidateRateLimit(rate, burst); err != nil {
+		return nil, err
+	}
+
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	function.RateLimit = rate
+	function.RateBurst = rateBurst(rate, burst)
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
+
+// ValidateConcurrency checks a limit on the executions running at once
+func ValidateConcurrency(limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidConcurrency, limit)
+	}
+	return nil
+}
+
+// SetConcurrency limits how many executions of a function are queued or
+// running at once; a limit of zero removes it
+func (r *FunctionRegistry) SetConcurrency(id string, limit int) (*FunctionMetadata, error) {
+	if err := ValidateConcurrency(limit); err != nil {
+		return nil, err
+	}
+
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	function.Concurrency = limit
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
+
+// SetDisabled disables or re-enables invokes of a function, leaving its code
+// and history in place
+func (r *FunctionRegistry) SetDisabled(id string, disabled bool) (*FunctionMetadata, error) {
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case disabled:
+		function.Status = StatusDisabled
+	case function.Status != StatusCorrupt:
+		// Enabling doesn't fix corrupt code, only an update does
+		function.Status = StatusReady
+	}
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
+
+// SetCPU sets the number of vCPUs of the VMs a function's executions run
+// on; zero goes back to the default count
+func (r *FunctionRegistry) SetCPU(id string, cpu int) (*FunctionMetadata, error) {
+	if err := ValidateCPU(cpu); err != nil {
+		return nil, err
+	}
+
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	function.CPU = cpu
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
+
+// SetScratch turns a function's persistent scratch directory on or off
+func (r *FunctionRegistry) SetScratch(id string, enabled bool) (*FunctionMetadata, error) {
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	function.Scratch = enabled
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
+
+// ValidateCacheTTL checks a result cache TTL in seconds
+func ValidateCacheTTL(ttl int) error {
+	if ttl < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidCacheTTL, ttl)
+	}
+	return nil
+}
+
+// ValidateLogDestination checks a log destination. Empty means none. File
+// destinations must be inside LogSinkDir, and http(s) destinations may only
+// name loopback or link-local hosts when LogSinkAllowsLocal.
+func ValidateLogDestination(destination string) error {
+	if destination == "" {
+		return nil
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidLogDestination, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return fmt.Errorf("%w: %s has no host", ErrInvalidLogDestination, destination)
+		}
+		if !LogSinkAllowsLocal() && isLocalHost(u.Hostname()) {
+			return fmt.Errorf("%w: %s is a local address", ErrInvalidLogDestination, u.Hostname())
+		}
+	case "file":
+		if u.Host != "" || !filepath.IsAbs(u.Path) {
+			return fmt.Errorf("%w: %s is not an absolute file path", ErrInvalidLogDestination, destination)
+		}
+		dir := LogSinkDir()
+		if dir == "" {
+			return fmt.Errorf("%w: file destinations are disabled, set %s to allow them", ErrInvalidLogDestination, EnvLogSinkDir)
+		}
+		if rel, err := filepath.Rel(dir, filepath.Clean(u.Path)); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%w: %s is outside %s", ErrInvalidLogDestination, u.Path, dir)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported scheme %q", ErrInvalidLogDestination, u.Scheme)
+	}
+	return nil
+}
+
+// isLocalHost reports whether a host name or address refers to the local
+// host or its link, where the daemon and cloud metadata services listen
+func isLocalHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && IsLocalIP(ip)
+}
+
+// IsLocalIP reports whether ip is a loopback, link-local or unspecified
+// address, which log destinations may not reach unless LogSinkAllowsLocal
+func IsLocalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// SetLogDestination sets where the stderr of a function's executions is
+// forwarded; an empty destination stops forwarding
+func (r *FunctionRegistry) SetLogDestination(id, destination string) (*FunctionMetadata, error) {
+	if err := ValidateLogDestination(destination); err != nil {
+		return nil, err
+	}
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	function.LogDestination = destination
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
+
+// Retry policy bounds
+const (
+	MaxRetryAttempts       = 10
+	MaxRetryBackoffSeconds = 3600
+)
+
+// ErrorTypePlatform names, in retry policies, the failures that happen before
+// the function runs, such as a VM that can't be allocated; they carry no
+// error type
+const ErrorTypePlatform = "platform"
+
+// retryErrorTypes are the error types a retry policy can name
+var retryErrorTypes = map[string]bool{
+	"setup_error":         true,
+	"import_error":        true,
+	"exception":           true,
+	"oom":                 true,
+	"serialization_error": true,
+	"timeout":             true,
+	"exit":                true,
+	ErrorTypePlatform:     true,
+}
+
+// DefaultRetryOn are the error types retried by policies that don't list
+// any. The others fail the same way on every attempt.
+var DefaultRetryOn = []string{"exception", "timeout", "exit", ErrorTypePlatform}
+
+// ValidateRetryPolicy checks a retry policy
+func ValidateRetryPolicy(policy state.RetryPolicy) error {
+	if policy.MaxAttempts < 0 || policy.MaxAttempts > MaxRetryAttempts {
+		return fmt.Errorf("%w: max_attempts must be between 0 and %d", ErrInvalidRetryPolicy, MaxRetryAttempts)
+	}
+	if policy.BackoffSeconds < 0 || policy.BackoffSeconds > MaxRetryBackoffSeconds {
+		return fmt.Errorf("%w: backoff_seconds must be between 0 and %d", ErrInvalidRetryPolicy, MaxRetryBackoffSeconds)
+	}
+	for _, errorType := range policy.RetryOn {
+		if !retryErrorTypes[errorType] {
+			return fmt.Errorf("%w: unknown error type %q", ErrInvalidRetryPolicy, errorType)
+		}
+	}
+	return nil
+}
+
+// Retryable reports whether a policy retries failures of the given error
+// type; failures without one count as ErrorTypePlatform
+func Retryable(policy *state.RetryPolicy, errorType string) bool {
+	if errorType == "" {
+		errorType = ErrorTypePlatform
+	}
+	retryOn := policy.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = DefaultRetryOn
+	}
+	for _, retryable := range retryOn {
+		if retryable == errorType {
+			return true
+		}
+	}
+	return false
+}
+
+// retryPolicy returns the policy for metadata, or nil if it never retries
+func retryPolicy(policy state.RetryPolicy) *state.RetryPolicy {
+	if policy.MaxAttempts <= 1 {
+		return nil
+	}
+	return &policy
+}
+
+// SetRetryPolicy sets how failed asynchronous executions of a function are
+// retried; a policy with max_attempts of 1 or less turns retries off
+func (r *FunctionRegistry) SetRetryPolicy(id string, policy state.RetryPolicy) (*FunctionMetadata, error) {
+	if err := ValidateRetryPolicy(policy); err != nil {
+		return nil, err
+	}
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	function.Retry = policy
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
+
+// SetCaching turns caching of a function's results on or off. Results are
+// kept for ttl seconds; zero uses the scheduler's default TTL.
+func (r *FunctionRegistry) SetCaching(id string, cacheable bool, ttl int) (*FunctionMetadata, error) {
+	if err := ValidateCacheTTL(ttl); err != nil {
+		return nil, err
+	}
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	function.Cacheable = cacheable
+	function.CacheTTL = ttl
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
+
+// PurgeStorage removes the stored code and files of every function
+func (r *FunctionRegistry) PurgeStorage() error {
+	if err := os.RemoveAll(r.storageDir); err != nil {
+		return err
+	}
+	return os.MkdirAll(r.storageDir, 0755)
+}
+
+// DeleteFunctionsBySelector deletes every function whose labels match the
+// selector and returns the IDs of the deleted functions
+func (r *FunctionRegistry) DeleteFunctionsBySelector(selector map[string]string) ([]string, error) {
+	functions, err := r.stateManager.ListFunctions()
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []string{}
+	for _, function := range functions {
+		if !MatchLabels(function.Labels, selector) {
+			continue
+		}
+		if err := r.DeleteFunction(function.ID); err != nil {
+			return deleted, fmt.Errorf("failed to delete function %s: %v", function.ID, err)
+		}
+		deleted = append(deleted, function.ID)
+	}
+
+	return deleted, nil
+}
+
+// SetSchedule attaches a cron schedule to a function, replacing any existing one.
+// Scheduled invocations run asynchronously with the given input.
+func (r *FunctionRegistry) SetSchedule(id, expression string, input map[string]interface{}) (*state.Schedule, error) {
+	if _, err := r.stateManager.GetFunction(id); err != nil {
+		return nil, err
+	}
+
+	schedule, err := newSchedule(id, expression, input)
+	if err != nil {
+		return nil, err
+	}
+	if existing, err := r.stateManager.GetSchedule(id); err == nil {
+		schedule.CreatedAt = existing.CreatedAt
+		schedule.LastRun = existing.LastRun
+	}
+
+	if err := r.stateManager.SaveSchedule(schedule); err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// newSchedule parses a cron expression into the schedule of the function id,
+// due at its next activation
+func newSchedule(id, expression string, input map[string]interface{}) (*state.Schedule, error) {
+	sched, err := cron.Parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+
+	next := sched.Next(time.Now())
+	if next.IsZero() {
+		return nil, fmt.Errorf("%w: %s never fires", ErrInvalidSchedule, expression)
+	}
+
+	return &state.Schedule{
+		FunctionID: id,
+		Expression: sched.String(),
+		Input:      input,
+		NextRun:    next,
+	}, nil
+}
+
+// GetSchedule retrieves the cron schedule attached to a function
+func (r *FunctionRegistry) GetSchedule(id string) (*state.Schedule, error) {
+	return r.stateManager.GetSchedule(id)
+}
+
+// toMetadata converts a stored function into its public metadata
+func toMetadata(function *state.Function) *FunctionMetadata {
+	return &FunctionMetadata{
+		ID:              function.ID,
+		Name:            function.Name,
+		Runtime:         function.Runtime,
+		Memory:          function.Memory,
+		EffectiveMemory: EffectiveMemory(function.Memory),
+		Timeout:         function.Timeout,
+		CreatedAt:       function.CreatedAt,
+		UpdatedAt:       function.UpdatedAt,
+		Status:          function.Status,
+		Version:         function.Version,
+		EntryPoint:      function.EntryPoint,
+		StableVersion:   function.StableVersion,
+		CanaryPercent:   function.CanaryPercent,
+		RateLimit:       function.RateLimit,
+		RateBurst:       function.RateBurst,
+		Concurrency:     function.Concurrency,
+		Environment:     function.Environment,
+		Labels:          function.Labels,
+		Secrets:         function.SecretRefs,
+		Scratch:         function.Scratch,
+		Cacheable:       function.Cacheable,
+		CacheTTL:        function.CacheTTL,
+		LogDestination:  function.LogDestination,
+		Retry:           retryPolicy(function.Retry),
+		CPU:             EffectiveCPU(function.CPU),
+	}
+}
+
 // incrementVersion increments the version number
 func incrementVersion(version string) string {
 	var major, minor, patch int
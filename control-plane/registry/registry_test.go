@@ -0,0 +1,520 @@
+package registry
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/vm"
+	"github.com/sirupsen/logrus"
+)
+
+const testCode = "def handler(event, context):\n    return event\n"
+
+// newTestRegistry creates a function registry backed by a fresh database and
+// function storage in a temporary directory
+func newTestRegistry(t *testing.T) *FunctionRegistry {
+	t.Helper()
+
+	// The database is created in the working directory
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv(vm.EnvDataDir, dir)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	stateManager, err := state.NewStateManager(logger)
+	if err != nil {
+		t.Fatalf("failed to create state manager: %v", err)
+	}
+	r, err := NewFunctionRegistry(stateManager, logger)
+	if err != nil {
+		t.Fatalf("failed to create function registry: %v", err)
+	}
+	return r
+}
+
+// testRegistration is the registration of a minimal function
+func testRegistration(name string) FunctionRegistration {
+	return FunctionRegistration{Name: name, Runtime: "python3", Memory: 128, Timeout: 30, Code: FunctionCode{Code: testCode}}
+}
+
+func TestRegisterFunctionSavesAllSettings(t *testing.T) {
+	r := newTestRegistry(t)
+	reg := testRegistration("hello")
+	reg.EntryPoint = "main.handle"
+	reg.Code.Files = map[string]string{"lib/util.py": "X = 1\n"}
+	reg.Labels = map[string]string{"team": "data"}
+	reg.RateLimit = 2.5
+	reg.Concurrency = 3
+	reg.Cacheable = true
+	reg.CacheTTL = 60
+	reg.Retry = state.RetryPolicy{MaxAttempts: 3}
+	reg.CPU = 1
+	reg.Schedule = "*/5 * * * *"
+
+	function, err := r.RegisterFunction(reg)
+	if err != nil {
+		t.Fatalf("RegisterFunction: %v", err)
+	}
+
+	stored, err := r.GetFunction(function.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.EntryPoint != "main.handle" || stored.Labels["team"] != "data" || stored.Concurrency != 3 || !stored.Cacheable || stored.CacheTTL != 60 {
+		t.Errorf("stored function = %+v", stored)
+	}
+	if stored.RateLimit != 2.5 || stored.RateBurst != 3 {
+		t.Errorf("rate limit = %v burst %d, want 2.5 with the default burst of 3", stored.RateLimit, stored.RateBurst)
+	}
+	if stored.Retry == nil || stored.Retry.MaxAttempts != 3 {
+		t.Errorf("retry policy = %+v", stored.Retry)
+	}
+	code, err := r.GetFunctionCode(function.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code.Files["lib/util.py"] != "X = 1\n" {
+		t.Errorf("files = %v", code.Files)
+	}
+	if _, err := r.GetSchedule(function.ID); err != nil {
+		t.Errorf("schedule not stored: %v", err)
+	}
+}
+
+func TestRegisterFunctionRejectsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*FunctionRegistration)
+		want   error
+	}{
+		{"negative memory", func(reg *FunctionRegistration) { reg.Memory = -128 }, ErrInvalidMemory},
+		{"timeout out of range", func(reg *FunctionRegistration) { reg.Timeout = -1 }, ErrInvalidTimeout},
+		{"negative concurrency", func(reg *FunctionRegistration) { reg.Concurrency = -1 }, ErrInvalidConcurrency},
+		{"too many vCPUs", func(reg *FunctionRegistration) { reg.CPU = MaxCPUs() + 1 }, ErrInvalidCPU},
+		{"invalid schedule", func(reg *FunctionRegistration) { reg.Schedule = "every minute" }, ErrInvalidSchedule},
+		{"missing secret", func(reg *FunctionRegistration) { reg.Secrets = map[string]string{"TOKEN": "token"} }, ErrSecretNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(t)
+			reg := testRegistration("hello")
+			tt.modify(&reg)
+			if _, err := r.RegisterFunction(reg); !errors.Is(err, tt.want) {
+				t.Fatalf("RegisterFunction() error = %v, want %v", err, tt.want)
+			}
+			// Nothing is stored for a rejected registration
+			if functions, _ := r.ListFunctions(); len(functions) != 0 {
+				t.Errorf("stored %d functions", len(functions))
+			}
+		})
+	}
+}
+
+func TestRegisterFunctionConcurrently(t *testing.T) {
+	r := newTestRegistry(t)
+
+	const registrations = 8
+	errs := make([]error, registrations)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = r.RegisterFunction(testRegistration("hello"))
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrFunctionExists):
+			t.Errorf("RegisterFunction() error = %v, want %v", err, ErrFunctionExists)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d registrations succeeded, want exactly 1", succeeded)
+	}
+
+	functions, err := r.ListFunctions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(functions) != 1 {
+		t.Fatalf("stored %d functions, want 1", len(functions))
+	}
+	// The losers' code is removed again
+	dirs, err := os.ReadDir(r.storageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || dirs[0].Name() != functions[0].ID {
+		t.Errorf("function storage holds %d entries, want only %s", len(dirs), functions[0].ID)
+	}
+}
+
+func TestValidateTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		min     string
+		max     string
+		timeout int
+		valid   bool
+	}{
+		{"below the default minimum", "", "", 0, false},
+		{"at the default minimum", "", "", 1, true},
+		{"at the default maximum", "", "", 300, true},
+		{"above the default maximum", "", "", 301, false},
+		{"negative", "", "", -30, false},
+		{"at a configured maximum", "", "60", 60, true},
+		{"above a configured maximum", "", "60", 61, false},
+		{"below a configured minimum", "5", "", 4, false},
+		{"at a configured minimum", "5", "", 5, true},
+		{"unparsable limits use the defaults", "soon", "later", 300, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvFunctionMinTimeout, tt.min)
+			t.Setenv(EnvFunctionMaxTimeout, tt.max)
+			err := ValidateTimeout(tt.timeout)
+			if tt.valid && err != nil {
+				t.Errorf("ValidateTimeout(%d) = %v, want nil", tt.timeout, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidTimeout) {
+				t.Errorf("ValidateTimeout(%d) = %v, want %v", tt.timeout, err, ErrInvalidTimeout)
+			}
+		})
+	}
+}
+
+func TestTimeoutLimitsApplyToRegistrationAndUpdate(t *testing.T) {
+	r := newTestRegistry(t)
+	reg := testRegistration("hello")
+	reg.Timeout = 300
+	function, err := r.RegisterFunction(reg)
+	if err != nil {
+		t.Fatalf("RegisterFunction at the maximum timeout: %v", err)
+	}
+
+	reg = testRegistration("day-long")
+	reg.Timeout = 86400
+	if _, err := r.RegisterFunction(reg); !errors.Is(err, ErrInvalidTimeout) {
+		t.Errorf("RegisterFunction() error = %v, want %v", err, ErrInvalidTimeout)
+	}
+
+	if _, err := r.UpdateFunction(function.ID, 301, testCode, "", "", 0); !errors.Is(err, ErrInvalidTimeout) {
+		t.Errorf("UpdateFunction() error = %v, want %v", err, ErrInvalidTimeout)
+	}
+	if stored, _ := r.GetFunction(function.ID); stored.Timeout != 300 {
+		t.Errorf("timeout = %d, want 300 unchanged", stored.Timeout)
+	}
+}
+
+func TestGetFunctionCodeVersion(t *testing.T) {
+	r := newTestRegistry(t)
+	function, err := r.RegisterFunction(testRegistration("hello"))
+	if err != nil {
+		t.Fatalf("RegisterFunction: %v", err)
+	}
+	const newCode = "def handler(event, context):\n    return {'v': 2}\n"
+	updated, err := r.UpdateFunction(function.ID, 0, newCode, "", "", 0)
+	if err != nil {
+		t.Fatalf("UpdateFunction: %v", err)
+	}
+	if updated.Version == function.Version {
+		t.Fatalf("update kept version %s", function.Version)
+	}
+
+	old, err := r.GetFunctionCodeVersion(function.ID, function.Version)
+	if err != nil {
+		t.Fatalf("GetFunctionCodeVersion(%s): %v", function.Version, err)
+	}
+	if old.Code != testCode {
+		t.Errorf("old version has code %q", old.Code)
+	}
+	for _, version := range []string{"", updated.Version} {
+		latest, err := r.GetFunctionCodeVersion(function.ID, version)
+		if err != nil || latest.Code != newCode {
+			t.Errorf("GetFunctionCodeVersion(%q) = %v, %v; want the latest code", version, latest, err)
+		}
+	}
+	if _, err := r.GetFunctionCodeVersion(function.ID, "9.9.9"); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("GetFunctionCodeVersion(9.9.9) error = %v, want %v", err, ErrVersionNotFound)
+	}
+}
+
+func TestCanaryDeployment(t *testing.T) {
+	r := newTestRegistry(t)
+	function, err := r.RegisterFunction(testRegistration("hello"))
+	if err != nil {
+		t.Fatalf("RegisterFunction: %v", err)
+	}
+	if _, err := r.PromoteFunction(function.ID); !errors.Is(err, ErrNoCanary) {
+		t.Errorf("PromoteFunction() without a canary error = %v, want %v", err, ErrNoCanary)
+	}
+	for _, percent := range []int{-1, 100} {
+		if _, err := r.UpdateFunction(function.ID, 0, testCode, "", "", percent); !errors.Is(err, ErrInvalidCanary) {
+			t.Errorf("UpdateFunction(%d%%) error = %v, want %v", percent, err, ErrInvalidCanary)
+		}
+	}
+
+	canary, err := r.UpdateFunction(function.ID, 0, "def handler(event, context):\n    return 2\n", "", "", 10)
+	if err != nil {
+		t.Fatalf("UpdateFunction: %v", err)
+	}
+	if canary.StableVersion != function.Version || canary.CanaryPercent != 10 {
+		t.Errorf("canary has stable version %q at %d%%, want %s at 10%%", canary.StableVersion, canary.CanaryPercent, function.Version)
+	}
+
+	// Replacing the canary keeps the version that was serving before it
+	replaced, err := r.UpdateFunction(function.ID, 0, "def handler(event, context):\n    return 3\n", "", "", 30)
+	if err != nil {
+		t.Fatalf("UpdateFunction: %v", err)
+	}
+	if replaced.StableVersion != function.Version || replaced.CanaryPercent != 30 {
+		t.Errorf("replaced canary has stable version %q at %d%%, want %s at 30%%", replaced.StableVersion, replaced.CanaryPercent, function.Version)
+	}
+
+	promoted, err := r.PromoteFunction(function.ID)
+	if err != nil {
+		t.Fatalf("PromoteFunction: %v", err)
+	}
+	if promoted.Version != replaced.Version || promoted.StableVersion != "" || promoted.CanaryPercent != 0 {
+		t.Errorf("promoted function is at %s, stable %q at %d%%; want %s alone", promoted.Version, promoted.StableVersion, promoted.CanaryPercent, replaced.Version)
+	}
+}
+
+func TestSetRateLimit(t *testing.T) {
+	r := newTestRegistry(t)
+	function, err := r.RegisterFunction(testRegistration("hello"))
+	if err != nil {
+		t.Fatalf("RegisterFunction: %v", err)
+	}
+
+	// The burst defaults to the rate rounded up
+	limited, err := r.SetRateLimit(function.ID, 2.5, 0)
+	if err != nil {
+		t.Fatalf("SetRateLimit: %v", err)
+	}
+	if limited.RateLimit != 2.5 || limited.RateBurst != 3 {
+		t.Errorf("rate limit = %v with burst %d, want 2.5 with 3", limited.RateLimit, limited.RateBurst)
+	}
+
+	if _, err := r.SetRateLimit(function.ID, -1, 0); !errors.Is(err, ErrInvalidRateLimit) {
+		t.Errorf("SetRateLimit(-1) error = %v, want %v", err, ErrInvalidRateLimit)
+	}
+
+	unlimited, err := r.SetRateLimit(function.ID, 0, 5)
+	if err != nil {
+		t.Fatalf("SetRateLimit: %v", err)
+	}
+	if unlimited.RateLimit != 0 || unlimited.RateBurst != 0 {
+		t.Errorf("rate limit = %v with burst %d after removing it", unlimited.RateLimit, unlimited.RateBurst)
+	}
+}
+
+func TestFunctionStorageDirConfigured(t *testing.T) {
+	storage := filepath.Join(t.TempDir(), "functions")
+	t.Setenv(EnvFunctionStorageDir, storage)
+	r := newTestRegistry(t)
+
+	function, err := r.RegisterFunction(testRegistration("hello"))
+	if err != nil {
+		t.Fatalf("RegisterFunction: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(storage, function.ID)); err != nil {
+		t.Errorf("function code isn't under the configured storage: %v", err)
+	}
+}
+
+func TestFunctionStorageDirDefault(t *testing.T) {
+	r := newTestRegistry(t)
+	if want := filepath.Join(vm.DataDir(), "function-storage"); r.storageDir != want {
+		t.Errorf("function storage in %s, want %s under the data directory", r.storageDir, want)
+	}
+}
+
+func TestCloneFunction(t *testing.T) {
+	r := newTestRegistry(t)
+	reg := testRegistration("hello")
+	reg.Code.Requirements = "requests\n"
+	reg.Code.Files = map[string]string{"lib/util.py": "X = 1\n"}
+	reg.Environment = map[string]string{"MODE": "a"}
+	reg.Labels = map[string]string{"team": "data"}
+	reg.EntryPoint = "main.handle"
+	source, err := r.RegisterFunction(reg)
+	if err != nil {
+		t.Fatalf("RegisterFunction: %v", err)
+	}
+	// The clone takes the latest code, not the first version's
+	if _, err := r.UpdateFunction(source.ID, 0, "def handle(event, context):\n    return 2\n", "", "", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	clone, err := r.CloneFunction(source.ID, "hello-b")
+	if err != nil {
+		t.Fatalf("CloneFunction: %v", err)
+	}
+	if clone.ID == source.ID || clone.Name != "hello-b" {
+		t.Errorf("clone is %s named %q, want a new ID named hello-b", clone.ID, clone.Name)
+	}
+	if clone.Version != "1.0.0" || clone.Runtime != "python3" || clone.Memory != 128 || clone.EntryPoint != "main.handle" ||
+		clone.Environment["MODE"] != "a" || clone.Labels["team"] != "data" {
+		t.Errorf("clone = %+v, want the source's settings at version 1.0.0", clone)
+	}
+
+	sourceCode, err := r.GetFunctionCode(source.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloneCode, err := r.GetFunctionCode(clone.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cloneCode.Code != sourceCode.Code || cloneCode.Requirements != sourceCode.Requirements || cloneCode.Files["lib/util.py"] != "X = 1\n" {
+		t.Errorf("clone code = %+v, want the source's %+v", cloneCode, sourceCode)
+	}
+}
+
+func TestCloneFunctionNameTaken(t *testing.T) {
+	r := newTestRegistry(t)
+	source, err := r.RegisterFunction(testRegistration("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RegisterFunction(testRegistration("taken")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.CloneFunction(source.ID, "taken"); !errors.Is(err, ErrFunctionExists) {
+		t.Errorf("CloneFunction onto a taken name: error = %v, want %v", err, ErrFunctionExists)
+	}
+}
+
+func TestMissingFileMarksFunctionCorrupt(t *testing.T) {
+	for _, name := range []string{"handler.py", "requirements.txt", "skyscale.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			r := newTestRegistry(t)
+			function, err := r.RegisterFunction(testRegistration("hello"))
+			if err != nil {
+				t.Fatalf("RegisterFunction: %v", err)
+			}
+			if err := os.Remove(filepath.Join(r.storageDir, function.ID, name)); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = r.GetFunctionCode(function.ID)
+			if !errors.Is(err, ErrCorruptStorage) || !strings.Contains(err.Error(), name) {
+				t.Errorf("GetFunctionCode error = %v, want %v naming %s", err, ErrCorruptStorage, name)
+			}
+			if stored, err := r.GetFunction(function.ID); err != nil || stored.Status != StatusCorrupt {
+				t.Fatalf("status after reading incomplete code = %+v, %v; want %q", stored, err, StatusCorrupt)
+			}
+
+			// Enabling the function doesn't hide the corruption
+			if enabled, err := r.SetDisabled(function.ID, false); err != nil || enabled.Status != StatusCorrupt {
+				t.Errorf("status after enabling = %+v, %v; want %q", enabled, err, StatusCorrupt)
+			}
+
+			// Redeploying the code makes the function ready again
+			updated, err := r.UpdateFunction(function.ID, 0, testCode, "", "", 0)
+			if err != nil {
+				t.Fatalf("UpdateFunction: %v", err)
+			}
+			if updated.Status != StatusReady {
+				t.Errorf("status after an update = %q, want %q", updated.Status, StatusReady)
+			}
+			if _, err := r.GetFunctionCode(function.ID); err != nil {
+				t.Errorf("GetFunctionCode after an update: %v", err)
+			}
+		})
+	}
+}
+
+func TestFunctionCPU(t *testing.T) {
+	r := newTestRegistry(t)
+	reg := testRegistration("crunch")
+	reg.CPU = 2
+	function, err := r.RegisterFunction(reg)
+	if err != nil {
+		t.Fatalf("RegisterFunction: %v", err)
+	}
+	if stored, err := r.GetFunction(function.ID); err != nil || stored.CPU != 2 {
+		t.Fatalf("stored function = %+v, %v; want 2 vCPUs", stored, err)
+	}
+
+	// Zero goes back to the default of the warm VMs
+	function, err = r.SetCPU(function.ID, 0)
+	if err != nil {
+		t.Fatalf("SetCPU(0): %v", err)
+	}
+	if function.CPU != vm.DefaultCPUCount() {
+		t.Errorf("vCPUs = %d, want the default %d", function.CPU, vm.DefaultCPUCount())
+	}
+
+	t.Setenv(EnvFunctionMaxCPUs, "8")
+	if _, err := r.SetCPU(function.ID, 8); err != nil {
+		t.Errorf("SetCPU(8) with a max of 8: %v", err)
+	}
+	if _, err := r.SetCPU(function.ID, 9); !errors.Is(err, ErrInvalidCPU) {
+		t.Errorf("SetCPU(9) error = %v, want %v", err, ErrInvalidCPU)
+	}
+}
+
+func TestValidateLogDestination(t *testing.T) {
+	tests := []struct {
+		name        string
+		dir         string
+		allowLocal  string
+		destination string
+		valid       bool
+	}{
+		{"none", "", "", "", true},
+		{"remote https", "", "", "https://logs.example.com/ingest", true},
+		{"remote address", "", "", "http://10.0.0.5:9000/logs", true},
+		{"loopback", "", "", "http://127.0.0.1:8081/execute", false},
+		{"loopback IPv6", "", "", "http://[::1]:8081/", false},
+		{"localhost", "", "", "http://localhost:8081/", false},
+		{"link-local metadata service", "", "", "http://169.254.169.254/latest/meta-data/", false},
+		{"unspecified", "", "", "http://0.0.0.0:8081/", false},
+		{"local hosts allowed", "", "true", "http://127.0.0.1:9000/logs", true},
+		{"file with no sink directory", "", "", "file:///var/log/skyscale/fn.log", false},
+		{"file in the sink directory", "/var/log/skyscale", "", "file:///var/log/skyscale/fn.log", true},
+		{"file in a subdirectory", "/var/log/skyscale", "", "file:///var/log/skyscale/team/fn.log", true},
+		{"file outside the sink directory", "/var/log/skyscale", "", "file:///root/.ssh/authorized_keys", false},
+		{"file escaping with dots", "/var/log/skyscale", "", "file:///var/log/skyscale/../../../etc/cron.d/x", false},
+		{"sibling with the same prefix", "/var/log/skyscale", "", "file:///var/log/skyscale-other/fn.log", false},
+		{"the sink directory itself", "/var/log/skyscale", "", "file:///var/log/skyscale", false},
+		{"relative file", "/var/log/skyscale", "", "file://relative/path", false},
+		{"unsupported scheme", "", "", "ftp://logs.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvLogSinkDir, tt.dir)
+			t.Setenv(EnvLogSinkAllowLocal, tt.allowLocal)
+			err := ValidateLogDestination(tt.destination)
+			if tt.valid && err != nil {
+				t.Errorf("ValidateLogDestination(%q) = %v, want nil", tt.destination, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidLogDestination) {
+				t.Errorf("ValidateLogDestination(%q) = %v, want %v", tt.destination, err, ErrInvalidLogDestination)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidSecret is returned when a secret name or value is malformed
+var ErrInvalidSecret = errors.New("invalid secret")
+
+// ErrSecretNotFound is returned when a function references a secret that doesn't exist
+var ErrSecretNotFound = errors.New("secret not found")
+
+// secretMask replaces secret values in execution output and errors
+const secretMask = "****"
+
+// SetSecret creates or replaces a secret
+func (r *FunctionRegistry) SetSecret(name, value string) (*state.Secret, error) {
+	if !labelPattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: name %q", ErrInvalidSecret, name)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("%w: value of %s is empty", ErrInvalidSecret, name)
+	}
+
+	now := time.Now()
+	secret, err := r.stateManager.GetSecret(name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		secret = &state.Secret{Name: name, CreatedAt: now}
+	} else if err != nil {
+		return nil, err
+	}
+	secret.Value = value
+	secret.UpdatedAt = now
+
+	if err := r.stateManager.SaveSecret(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// SetSecretRefs sets the environment variables of a function that receive
+// secret values, keyed by variable name. Every referenced secret must exist.
+func (r *FunctionRegistry) SetSecretRefs(id string, refs map[string]string) (*FunctionMetadata, error) {
+	if err := r.checkSecretRefs(refs); err != nil {
+		return nil, err
+	}
+
+	function, err := r.stateManager.GetFunction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	function.SecretRefs = refs
+	function.UpdatedAt = time.Now()
+	if err := r.stateManager.SaveFunction(function); err != nil {
+		return nil, err
+	}
+
+	return toMetadata(function), nil
+}
+
+// checkSecretRefs checks that every secret referenced by refs exists
+func (r *FunctionRegistry) checkSecretRefs(refs map[string]string) error {
+	for variable, name := range refs {
+		if _, err := r.stateManager.GetSecret(name); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: %s (referenced by %s)", ErrSecretNotFound, name, variable)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// SecretValues resolves a function's secret references into environment
+// variable values
+func (r *FunctionRegistry) SecretValues(function *FunctionMetadata) (map[string]string, error) {
+	values := make(map[string]string, len(function.Secrets))
+	for variable, name := range function.Secrets {
+		secret, err := r.stateManager.GetSecret(name)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s (referenced by %s)", ErrSecretNotFound, name, variable)
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[variable] = secret.Value
+	}
+	return values, nil
+}
+
+// MaskSecrets replaces every secret value in text with a mask, longest
+// values first so one value containing another is masked whole
+func MaskSecrets(text string, values map[string]string) string {
+	sorted := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" {
+			sorted = append(sorted, value)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	for _, value := range sorted {
+		text = strings.ReplaceAll(text, value, secretMask)
+	}
+	return text
+}
@@ -0,0 +1,33 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultRuntime is the runtime of functions that declare none
+const DefaultRuntime = "python3"
+
+// ErrInvalidConfig is returned when a function's skyscale.yaml can't be parsed
+var ErrInvalidConfig = errors.New("invalid skyscale.yaml")
+
+// FunctionSpec holds the settings a function declares in its skyscale.yaml
+type FunctionSpec struct {
+	Runtime    string `yaml:"runtime"`
+	EntryPoint string `yaml:"entrypoint"`
+	Memory     int    `yaml:"memory"`
+	Timeout    int    `yaml:"timeout"`
+	CPU        int    `yaml:"cpu"`
+}
+
+// ParseSpec parses the settings out of a function's skyscale.yaml. An empty
+// config declares nothing.
+func ParseSpec(config string) (*FunctionSpec, error) {
+	var spec FunctionSpec
+	if err := yaml.Unmarshal([]byte(config), &spec); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	return &spec, nil
+}
@@ -0,0 +1,40 @@
+// Package runtimes describes the function runtimes supported by the daemon.
+//
+// The list mirrors the runtimes the daemon knows how to execute and is kept
+// static so it can be served cheaply to clients.
+
+package runtimes
+
+// Runtime describes a supported function runtime
+type Runtime struct {
+	Name           string `json:"name"`
+	Language       string `json:"language"`
+	Version        string `json:"version"`
+	Interpreter    string `json:"interpreter"`
+	DefaultMemory  int    `json:"default_memory"`  // in MB
+	DefaultTimeout int    `json:"default_timeout"` // in seconds
+}
+
+// supported lists the runtimes accepted by the daemon's runFunction
+var supported = []Runtime{
+	{Name: "python3", Language: "python", Version: "3", Interpreter: "python3", DefaultMemory: 128, DefaultTimeout: 30},
+	{Name: "python3.9", Language: "python", Version: "3.9", Interpreter: "python3", DefaultMemory: 128, DefaultTimeout: 30},
+	{Name: "python3.10", Language: "python", Version: "3.10", Interpreter: "python3", DefaultMemory: 128, DefaultTimeout: 30},
+}
+
+// List returns all supported runtimes
+func List() []Runtime {
+	result := make([]Runtime, len(supported))
+	copy(result, supported)
+	return result
+}
+
+// Get returns the runtime with the given name
+func Get(name string) (Runtime, bool) {
+	for _, rt := range supported {
+		if rt.Name == name {
+			return rt, true
+		}
+	}
+	return Runtime{}, false
+}
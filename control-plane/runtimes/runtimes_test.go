@@ -0,0 +1,36 @@
+package runtimes
+
+import "testing"
+
+func TestListIncludesPython(t *testing.T) {
+	found := map[string]bool{}
+	for _, rt := range List() {
+		if rt.Language == "python" {
+			found[rt.Name] = true
+		}
+		if rt.DefaultMemory <= 0 || rt.DefaultTimeout <= 0 {
+			t.Errorf("runtime %s has no defaults: %+v", rt.Name, rt)
+		}
+	}
+	for _, name := range []string{"python3", "python3.9", "python3.10"} {
+		if !found[name] {
+			t.Errorf("runtime %s isn't listed", name)
+		}
+	}
+}
+
+func TestListReturnsCopy(t *testing.T) {
+	List()[0].Name = "changed"
+	if List()[0].Name == "changed" {
+		t.Error("modifying the list changed the supported runtimes")
+	}
+}
+
+func TestGet(t *testing.T) {
+	if rt, ok := Get("python3.10"); !ok || rt.Version != "3.10" {
+		t.Errorf("Get(python3.10) = %+v, %v", rt, ok)
+	}
+	if _, ok := Get("node18"); ok {
+		t.Error("Get(node18) found an unsupported runtime")
+	}
+}
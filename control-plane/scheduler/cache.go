@@ -0,0 +1,227 @@
+package scheduler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/registry"
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/types"
+	"github.com/sirupsen/logrus"
+)
+
+// maxCachedResults bounds the number of results kept in the result cache
+const maxCachedResults = 1000
+
+// resultCache keeps the outputs of cacheable functions, keyed by function,
+// version, input and environment overrides, in Redis if the state manager
+// has it and in memory otherwise. Executions dispatched on a miss are
+// remembered by request ID until their result is reported.
+type resultCache struct {
+	mu      sync.Mutex
+	redis   *state.StateManager // nil without Redis
+	logger  *logrus.Logger
+	entries map[string]cachedResult
+	pending map[string]pendingResult
+}
+
+// cachedResult is a stored function output
+type cachedResult struct {
+	functionID string
+	output     string
+	expires    time.Time
+}
+
+// pendingResult is where a dispatched execution's output will be cached
+type pendingResult struct {
+	key        string
+	functionID string
+	ttl        time.Duration
+}
+
+func newResultCache(stateManager *state.StateManager, logger *logrus.Logger) *resultCache {
+	cache := &resultCache{
+		logger:  logger,
+		entries: make(map[string]cachedResult),
+		pending: make(map[string]pendingResult),
+	}
+	if stateManager.HasResultCache() {
+		cache.redis = stateManager
+	}
+	return cache
+}
+
+// cacheKey returns the cache key of a request to a function version. JSON
+// encoding sorts map keys, so equal inputs hash the same.
+func cacheKey(request *ExecutionRequest, version string) (string, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"input":       request.Input,
+		"environment": request.Environment,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return request.FunctionID + "/" + version + "/" + hex.EncodeToString(sum[:]), nil
+}
+
+// get returns the unexpired output stored under key
+func (c *resultCache) get(key string, now time.Time) (string, bool) {
+	if c.redis != nil {
+		output, ok, err := c.redis.GetCachedResult(key)
+		if err != nil {
+			c.logger.Warnf("Failed to read cached result: %v", err)
+		}
+		return output, ok
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expires) {
+		return "", false
+	}
+	return entry.output, true
+}
+
+// expect remembers that the output of the execution should be cached under key
+func (c *resultCache) expect(requestID string, pending pendingResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[requestID] = pending
+}
+
+// forget drops a pending execution, returning where its output was to be cached
+func (c *resultCache) forget(requestID string) (pendingResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending, ok := c.pending[requestID]
+	delete(c.pending, requestID)
+	return pending, ok
+}
+
+// put stores an output. In memory, expired entries are evicted when the
+// cache is full, and a full cache of live entries drops the new output.
+func (c *resultCache) put(pending pendingResult, output string, now time.Time) {
+	if c.redis != nil {
+		if err := c.redis.SetCachedResult(pending.key, output, pending.ttl); err != nil {
+			c.logger.Warnf("Failed to cache result: %v", err)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCachedResults {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= maxCachedResults {
+			return
+		}
+	}
+	c.entries[pending.key] = cachedResult{
+		functionID: pending.functionID,
+		output:     output,
+		expires:    now.Add(pending.ttl),
+	}
+}
+
+// invalidate drops all outputs of a function
+func (c *resultCache) invalidate(functionID string) {
+	if c.redis != nil {
+		if err := c.redis.DeleteCachedResults(functionID + "/"); err != nil {
+			c.logger.Warnf("Failed to invalidate cached results of function %s: %v", functionID, err)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if entry.functionID == functionID {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// cachedExecution serves a request to a cacheable function from the result
+// cache. On a hit the execution is recorded as completed without allocating
+// a VM; on a miss the request is remembered so CacheResult stores its output,
+// and nil is returned.
+func (s *Scheduler) cachedExecution(function *registry.FunctionMetadata, request *ExecutionRequest) *types.ExecutionResult {
+	if !function.Cacheable {
+		return nil
+	}
+	key, err := cacheKey(request, request.Version)
+	if err != nil {
+		s.logger.Warnf("Not caching execution %s: %v", request.RequestID, err)
+		return nil
+	}
+
+	now := time.Now()
+	output, ok := s.cache.get(key, now)
+	if !ok {
+		ttl := getCacheTTL()
+		if function.CacheTTL > 0 {
+			ttl = time.Duration(function.CacheTTL) * time.Second
+		}
+		s.cache.expect(request.RequestID, pendingResult{key: key, functionID: function.ID, ttl: ttl})
+		return nil
+	}
+
+	s.logger.Infof("Serving execution %s of function %s from the result cache", request.RequestID, function.ID)
+	execution := &state.Execution{
+		ID:          request.RequestID,
+		FunctionID:  request.FunctionID,
+		Version:     request.Version,
+		UserID:      request.UserID,
+		Tags:        request.Tags,
+		Status:      state.StatusCompleted,
+		StartTime:   now,
+		EndTime:     now,
+		Logs:        output,
+		OutputBytes: int64(len(output)),
+		Cached:      true,
+	}
+	if err := s.finishExecution(execution); err != nil {
+		s.logger.Errorf("Failed to save cached execution %s: %v", request.RequestID, err)
+	}
+
+	result := &types.ExecutionResult{
+		RequestID:  request.RequestID,
+		FunctionID: request.FunctionID,
+		StatusCode: 200,
+		Status:     string(state.StatusCompleted),
+		Version:    request.Version,
+		Output:     types.OutputFromString(output),
+		Cached:     true,
+		Source:     types.SourceFunction,
+	}
+	if !request.Sync {
+		// Async callers fetch the output from the result URL as usual
+		result.StatusCode = 202
+		result.Output = nil
+	}
+	return result
+}
+
+// CacheResult stores the output of a reported execution of a cacheable
+// function. Only completed executions are cached.
+func (s *Scheduler) CacheResult(execution *state.Execution) {
+	pending, ok := s.cache.forget(execution.ID)
+	if ok && execution.Status == state.StatusCompleted {
+		s.cache.put(pending, execution.Logs, time.Now())
+	}
+}
+
+// InvalidateCache drops the cached results of a function, for use when its
+// code or settings change
+func (s *Scheduler) InvalidateCache(functionID string) {
+	s.cache.invalidate(functionID)
+}
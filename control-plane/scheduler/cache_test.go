@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bluequbit/faas/control-plane/daemonclient/daemontest"
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+func TestSecondInvokeServedFromCache(t *testing.T) {
+	s, daemon := newTestScheduler(t, daemontest.Succeed(`{"sum":3}`))
+	function := registerTestFunction(t, s)
+	function, err := s.functionRegistry.SetCaching(function.ID, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := map[string]interface{}{"a": 1, "b": 2}
+
+	// The first invoke misses the cache and runs on a VM. The test scheduler
+	// can't allocate VMs, so it is run on the test VM the way schedule runs
+	// a sync invoke.
+	first := &ExecutionRequest{
+		FunctionID:   function.ID,
+		FunctionName: function.Name,
+		Input:        input,
+		Event:        input,
+		Version:      function.Version,
+		Sync:         true,
+		RequestID:    "exec-first",
+		Attempt:      1,
+		VM:           testVM,
+	}
+	if result := s.cachedExecution(function, first); result != nil {
+		t.Fatalf("first invoke served from an empty cache: %+v", result)
+	}
+	if result, err := s.executeFunction(first); err != nil || result.StatusCode != http.StatusOK {
+		t.Fatalf("first invoke = %+v, %v", result, err)
+	}
+
+	// The second is served from the cache, without a VM or the daemon
+	second, err := s.ScheduleExecution(function.ID, map[string]interface{}{"b": 2, "a": 1}, InvokeOptions{}, true)
+	if err != nil {
+		t.Fatalf("second invoke: %v", err)
+	}
+	if !second.Cached || second.StatusCode != http.StatusOK || string(second.Output) != `{"sum":3}` {
+		t.Errorf("second invoke = %d %s, cached %v; want the cached output", second.StatusCode, second.Output, second.Cached)
+	}
+	if n := len(daemon.Payloads()); n != 1 {
+		t.Errorf("daemon received %d requests, want 1", n)
+	}
+	execution, err := s.stateManager.GetExecution(second.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !execution.Cached || execution.Status != state.StatusCompleted {
+		t.Errorf("cached execution stored as %s, cached %v", execution.Status, execution.Cached)
+	}
+
+	// Other input isn't served from the cache
+	other := &ExecutionRequest{FunctionID: function.ID, Input: map[string]interface{}{"a": 2}, Version: function.Version, RequestID: "exec-other"}
+	if result := s.cachedExecution(function, other); result != nil {
+		t.Error("invoke with other input served from the cache")
+	}
+	s.cache.forget(other.RequestID)
+
+	// Nor is anything once the function changes
+	s.InvalidateCache(function.ID)
+	again := &ExecutionRequest{FunctionID: function.ID, Input: input, Version: function.Version, RequestID: "exec-again"}
+	if result := s.cachedExecution(function, again); result != nil {
+		t.Error("invoke served from the cache after it was invalidated")
+	}
+}
+
+func TestCacheKeyIncludesVersion(t *testing.T) {
+	request := &ExecutionRequest{FunctionID: "f", Input: map[string]interface{}{"a": 1}}
+	v1, err := cacheKey(request, "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := cacheKey(request, "1.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1 == v2 {
+		t.Error("two versions of a function share a cache key")
+	}
+}
+
+func TestUncacheableFunctionNotCached(t *testing.T) {
+	s, _ := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+	request := &ExecutionRequest{FunctionID: function.ID, Version: function.Version, RequestID: "exec"}
+	if result := s.cachedExecution(function, request); result != nil {
+		t.Error("a function that isn't cacheable was served from the cache")
+	}
+	if _, ok := s.cache.forget(request.RequestID); ok {
+		t.Error("the result of a function that isn't cacheable would be cached")
+	}
+}
@@ -0,0 +1,67 @@
+package scheduler
+
+import (
+	"sync"
+
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+// completions lets callers wait for the next execution of a function to
+// finish. Each function has a channel that is closed, waking all waiters,
+// when one of its executions completes, and is then replaced.
+type completions struct {
+	mu      sync.Mutex
+	waiters map[string]chan struct{}
+}
+
+func newCompletions() *completions {
+	return &completions{waiters: make(map[string]chan struct{})}
+}
+
+// wait returns a channel that is closed when the next execution of the
+// function finishes
+func (c *completions) wait(functionID string) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.waiters[functionID]
+	if !ok {
+		ch = make(chan struct{})
+		c.waiters[functionID] = ch
+	}
+	return ch
+}
+
+// notify wakes everyone waiting on the function
+func (c *completions) notify(functionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ch, ok := c.waiters[functionID]; ok {
+		close(ch)
+		delete(c.waiters, functionID)
+	}
+}
+
+// WaitForCompletion returns a channel that is closed when the next execution
+// of the function finishes. Subscribe before checking for finished
+// executions so none is missed in between.
+func (s *Scheduler) WaitForCompletion(functionID string) <-chan struct{} {
+	return s.completions.wait(functionID)
+}
+
+// NotifyCompletion reports that an execution of the function has finished,
+// for executions completed outside the scheduler such as reported results
+func (s *Scheduler) NotifyCompletion(functionID string) {
+	s.completions.notify(functionID)
+}
+
+// finishExecution saves an execution that has reached a terminal status,
+// retries it if it failed and its function's retry policy allows, and wakes
+// the callers waiting for it. Its output is not cached, since only reported
+// results are.
+func (s *Scheduler) finishExecution(execution *state.Execution) error {
+	s.cache.forget(execution.ID)
+	err := s.stateManager.SaveExecution(execution)
+	s.RetryFailed(execution)
+	s.completions.notify(execution.FunctionID)
+	return err
+}
@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variable names
+const (
+	EnvMaxQueueAge           = "FAAS_MAX_QUEUE_AGE_SECONDS"
+	EnvDispatchTimeout       = "FAAS_DISPATCH_TIMEOUT_SECONDS"
+	EnvExecutionTimeoutGrace = "FAAS_EXECUTION_TIMEOUT_GRACE_SECONDS"
+	EnvCacheTTL              = "FAAS_CACHE_TTL_SECONDS"
+	EnvInputMaxDepth         = "FAAS_INPUT_MAX_DEPTH"
+	EnvInputMaxKeys          = "FAAS_INPUT_MAX_KEYS"
+)
+
+// getMaxQueueAge returns how long an asynchronous execution may wait in the
+// queue before it is failed with a queue timeout
+func getMaxQueueAge() time.Duration {
+	// Check environment variable first
+	if age := os.Getenv(EnvMaxQueueAge); age != "" {
+		if val, err := strconv.Atoi(age); err == nil && val > 0 {
+			return time.Duration(val) * time.Second
+		}
+	}
+	// Default to 5 minutes
+	return 5 * time.Minute
+}
+
+// getDispatchTimeout returns how long to wait for a daemon to acknowledge an
+// execution request. It doesn't include the time the function runs, which
+// the daemon reports back separately.
+func getDispatchTimeout() time.Duration {
+	// Check environment variable first
+	if timeout := os.Getenv(EnvDispatchTimeout); timeout != "" {
+		if val, err := strconv.Atoi(timeout); err == nil && val > 0 {
+			return time.Duration(val) * time.Second
+		}
+	}
+	// Default to 10 seconds
+	return 10 * time.Second
+}
+
+// getExecutionTimeoutGrace returns how long past the function's timeout a
+// synchronous invoke waits for the result, covering the daemon's own
+// overhead such as reporting it
+func getExecutionTimeoutGrace() time.Duration {
+	// Check environment variable first
+	if grace := os.Getenv(EnvExecutionTimeoutGrace); grace != "" {
+		if val, err := strconv.Atoi(grace); err == nil && val >= 0 {
+			return time.Duration(val) * time.Second
+		}
+	}
+	// Default to 5 seconds
+	return 5 * time.Second
+}
+
+// getCacheTTL returns how long results of cacheable functions that don't set
+// their own TTL are kept
+func getCacheTTL() time.Duration {
+	// Check environment variable first
+	if ttl := os.Getenv(EnvCacheTTL); ttl != "" {
+		if val, err := strconv.Atoi(ttl); err == nil && val > 0 {
+			return time.Duration(val) * time.Second
+		}
+	}
+	// Default to 5 minutes
+	return 5 * time.Minute
+}
+
+// getInputMaxDepth returns how deeply objects and arrays may nest in an
+// invocation's input, counting the input object itself
+func getInputMaxDepth() int {
+	// Check environment variable first
+	if depth := os.Getenv(EnvInputMaxDepth); depth != "" {
+		if val, err := strconv.Atoi(depth); err == nil && val > 0 {
+			return val
+		}
+	}
+	// Default to 32 levels
+	return 32
+}
+
+// getInputMaxKeys returns how many object keys an invocation's input may
+// have in total, at all levels
+func getInputMaxKeys() int {
+	// Check environment variable first
+	if keys := os.Getenv(EnvInputMaxKeys); keys != "" {
+		if val, err := strconv.Atoi(keys); err == nil && val > 0 {
+			return val
+		}
+	}
+	// Default to 10000 keys
+	return 10000
+}
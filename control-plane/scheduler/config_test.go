@@ -0,0 +1,44 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetDispatchTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 10 * time.Second},
+		{"3", 3 * time.Second},
+		{"0", 10 * time.Second},
+		{"-1", 10 * time.Second},
+		{"soon", 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Setenv(EnvDispatchTimeout, tt.value)
+		if got := getDispatchTimeout(); got != tt.want {
+			t.Errorf("getDispatchTimeout() with %q = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetExecutionTimeoutGrace(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 5 * time.Second},
+		{"2", 2 * time.Second},
+		{"0", 0},
+		{"-1", 5 * time.Second},
+		{"later", 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Setenv(EnvExecutionTimeoutGrace, tt.value)
+		if got := getExecutionTimeoutGrace(); got != tt.want {
+			t.Errorf("getExecutionTimeoutGrace() with %q = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
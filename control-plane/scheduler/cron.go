@@ -0,0 +1,105 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/cron"
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/sirupsen/logrus"
+)
+
+// CronTrigger fires asynchronous invocations for functions with a cron schedule.
+//
+// Schedules are persisted in the state manager, so they survive restarts. Each
+// run is claimed with a conditional update before it is enqueued, which keeps a
+// run from firing twice. The trigger nevertheless assumes a single control-plane
+// instance per database; running several instances against one store is not
+// supported until leader election exists.
+type CronTrigger struct {
+	scheduler    *Scheduler
+	stateManager *state.StateManager
+	logger       *logrus.Logger
+	interval     time.Duration
+	now          func() time.Time
+	stop         chan struct{}
+}
+
+// NewCronTrigger creates a new cron trigger that checks for due schedules every interval
+func NewCronTrigger(scheduler *Scheduler, stateManager *state.StateManager, logger *logrus.Logger, interval time.Duration) *CronTrigger {
+	return &CronTrigger{
+		scheduler:    scheduler,
+		stateManager: stateManager,
+		logger:       logger,
+		interval:     interval,
+		now:          time.Now,
+		stop:         make(chan struct{}),
+	}
+}
+
+// Start runs the trigger loop in the background
+func (c *CronTrigger) Start() {
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.RunDue()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the trigger loop
+func (c *CronTrigger) Stop() {
+	close(c.stop)
+}
+
+// RunDue enqueues an invocation for every schedule that is due and returns the
+// number of invocations fired
+func (c *CronTrigger) RunDue() int {
+	now := c.now()
+	schedules, err := c.stateManager.ListDueSchedules(now)
+	if err != nil {
+		c.logger.Errorf("Failed to list due schedules: %v", err)
+		return 0
+	}
+
+	fired := 0
+	for _, schedule := range schedules {
+		sched, err := cron.Parse(schedule.Expression)
+		if err != nil {
+			c.logger.Errorf("Invalid schedule %q for function %s: %v", schedule.Expression, schedule.FunctionID, err)
+			continue
+		}
+
+		// Missed runs (e.g. while the control plane was down) are coalesced
+		// into a single invocation
+		next := sched.Next(now)
+		claimed, err := c.stateManager.ClaimScheduleRun(schedule.FunctionID, schedule.NextRun, next, now)
+		if err != nil {
+			c.logger.Errorf("Failed to claim schedule run for function %s: %v", schedule.FunctionID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		input := schedule.Input
+		if input == nil {
+			input = map[string]interface{}{}
+		}
+
+		c.logger.Infof("Triggering scheduled invocation of function %s (%s)", schedule.FunctionID, schedule.Expression)
+		if _, err := c.scheduler.ScheduleExecution(schedule.FunctionID, input, InvokeOptions{}, false); err != nil {
+			c.logger.Errorf("Failed to enqueue scheduled invocation of function %s: %v", schedule.FunctionID, err)
+			continue
+		}
+		fired++
+	}
+
+	return fired
+}
@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/daemonclient/daemontest"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCronTriggerRunDue(t *testing.T) {
+	s, _ := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+	schedule, err := s.functionRegistry.SetSchedule(function.ID, "*/5 * * * *", map[string]interface{}{"source": "cron"})
+	if err != nil {
+		t.Fatalf("SetSchedule: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	trigger := NewCronTrigger(s, s.stateManager, logger, time.Minute)
+	now := schedule.NextRun.Add(-time.Second)
+	trigger.now = func() time.Time { return now }
+
+	if fired := trigger.RunDue(); fired != 0 {
+		t.Fatalf("fired %d invocations before the schedule was due", fired)
+	}
+
+	now = schedule.NextRun.Add(time.Second)
+	if fired := trigger.RunDue(); fired != 1 {
+		t.Fatalf("fired %d invocations when due, want 1", fired)
+	}
+	if fired := trigger.RunDue(); fired != 0 {
+		t.Fatalf("fired the same run again")
+	}
+
+	// Runs missed while the trigger wasn't running fire once
+	now = schedule.NextRun.Add(time.Hour)
+	if fired := trigger.RunDue(); fired != 1 {
+		t.Fatalf("fired %d invocations for the missed runs, want 1", fired)
+	}
+
+	executions, err := s.stateManager.ListExecutions(function.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executions) != 2 {
+		t.Fatalf("stored %d executions, want 2", len(executions))
+	}
+	stored, err := s.stateManager.GetSchedule(function.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.NextRun.After(now) || stored.NextRun.Minute()%5 != 0 {
+		t.Errorf("next run = %v, want the first activation after %v", stored.NextRun, now)
+	}
+}
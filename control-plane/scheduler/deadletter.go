@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/types"
+	"gorm.io/gorm"
+)
+
+// ErrNotDeadLettered is returned when requeueing an execution that isn't a
+// dead letter or has already been requeued
+var ErrNotDeadLettered = errors.New("execution is not dead-lettered")
+
+// deadLetter marks an async execution that failed on its last allowed
+// attempt as dead_letter and keeps its request so it can be requeued
+func (s *Scheduler) deadLetter(execution *state.Execution, request *ExecutionRequest) {
+	deadLetter := &state.DeadLetter{
+		ExecutionID: execution.ID,
+		FunctionID:  request.FunctionID,
+		Version:     request.Version,
+		UserID:      request.UserID,
+		Input:       request.Input,
+		Environment: request.Environment,
+		Tags:        request.Tags,
+		Timeout:     request.Timeout,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.stateManager.SaveDeadLetter(deadLetter); err != nil {
+		s.logger.Errorf("Failed to dead-letter execution %s: %v", execution.ID, err)
+		return
+	}
+
+	execution.Status = state.StatusDeadLetter
+	if err := s.stateManager.SaveExecution(execution); err != nil {
+		s.logger.Errorf("Failed to mark execution %s as dead-lettered: %v", execution.ID, err)
+	}
+}
+
+// RequeueDeadLetter runs a dead-lettered execution again as a new async
+// execution with the same input, options and tags, and a fresh set of
+// retries. The dead-lettered execution keeps its status but is no longer
+// listed as a dead letter, and can't be requeued again.
+func (s *Scheduler) RequeueDeadLetter(executionID string) (*types.ExecutionResult, error) {
+	execution, err := s.stateManager.GetExecution(executionID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExecutionNotFound, err)
+	}
+	if execution.Status != state.StatusDeadLetter {
+		return nil, fmt.Errorf("%w: status is %s", ErrNotDeadLettered, execution.Status)
+	}
+
+	deadLetter, err := s.stateManager.GetDeadLetter(executionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: it has already been requeued", ErrNotDeadLettered)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Claim the dead letter so concurrent requeues run it only once
+	if err := s.stateManager.DeleteDeadLetter(executionID); errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: it has already been requeued", ErrNotDeadLettered)
+	} else if err != nil {
+		return nil, err
+	}
+
+	opts := InvokeOptions{
+		Environment: deadLetter.Environment,
+		Version:     deadLetter.Version,
+		UserID:      deadLetter.UserID,
+		Timeout:     deadLetter.Timeout,
+		Tags:        deadLetter.Tags,
+	}
+	result, err := s.ScheduleExecution(deadLetter.FunctionID, deadLetter.Input, opts, false)
+	if err != nil {
+		// Keep it a dead letter so the requeue can be tried again
+		if saveErr := s.stateManager.SaveDeadLetter(deadLetter); saveErr != nil {
+			s.logger.Errorf("Failed to restore dead letter %s: %v", executionID, saveErr)
+		}
+		return nil, err
+	}
+
+	s.logger.Infof("Requeued dead-lettered execution %s as %s", executionID, result.RequestID)
+	return result, nil
+}
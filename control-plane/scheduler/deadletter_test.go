@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+// waitDeadLetters waits until the function has n dead letters and returns them
+func waitDeadLetters(t *testing.T, s *Scheduler, functionID string, n int) []state.Execution {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		executions, err := s.stateManager.ListDeadLetters(functionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(executions) >= n {
+			return executions
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d executions dead-lettered", len(executions), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExhaustedRetriesDeadLettered(t *testing.T) {
+	s, _ := newTestScheduler(t, failTimes(2, "exception"))
+	function := retryFunction(t, s, state.RetryPolicy{MaxAttempts: 2})
+
+	request := asyncRequest(function, "exec-first")
+	request.Input = map[string]interface{}{"n": "1"}
+	request.Tags = map[string]string{"batch": "xyz"}
+	if _, err := s.enqueue(request); err != nil {
+		t.Fatal(err)
+	}
+	deadLetters := waitDeadLetters(t, s, function.ID, 1)
+
+	if len(deadLetters) != 1 || deadLetters[0].Attempt != 2 {
+		t.Fatalf("dead letters = %+v, want the second attempt", deadLetters)
+	}
+	last := deadLetters[0]
+	if last.Status != state.StatusDeadLetter || last.Error != "flaky" {
+		t.Errorf("last attempt has status %q and error %q, want dead_letter keeping its error", last.Status, last.Error)
+	}
+	if status := executionStatus(t, s, "exec-first"); status != state.StatusFailed {
+		t.Errorf("first attempt has status %q, want failed", status)
+	}
+	stored, err := s.stateManager.GetDeadLetter(last.ID)
+	if err != nil {
+		t.Fatalf("GetDeadLetter: %v", err)
+	}
+	if stored.Input["n"] != "1" || stored.Tags["batch"] != "xyz" {
+		t.Errorf("dead letter = %+v, want the request's input and tags", stored)
+	}
+}
+
+func TestRequeueDeadLetter(t *testing.T) {
+	s, daemon := newTestScheduler(t, failTimes(2, "exception"))
+	function := retryFunction(t, s, state.RetryPolicy{MaxAttempts: 2})
+
+	request := asyncRequest(function, "exec-first")
+	request.Input = map[string]interface{}{"n": "1"}
+	request.Tags = map[string]string{"batch": "xyz"}
+	if _, err := s.enqueue(request); err != nil {
+		t.Fatal(err)
+	}
+	deadLetter := waitDeadLetters(t, s, function.ID, 1)[0]
+
+	// Stop the workers so that the requeued request can be inspected in the
+	// queue
+	s.asyncQueue.Close()
+	s.workers.Wait()
+	s.asyncQueue = newFairQueue(100)
+
+	result, err := s.RequeueDeadLetter(deadLetter.ID)
+	if err != nil {
+		t.Fatalf("RequeueDeadLetter: %v", err)
+	}
+	if result.RequestID == deadLetter.ID {
+		t.Errorf("requeue reused execution ID %s", result.RequestID)
+	}
+	requeued := s.asyncQueue.Pop()
+	if requeued == nil || requeued.RequestID != result.RequestID {
+		t.Fatalf("queued request = %+v, want %s", requeued, result.RequestID)
+	}
+	if requeued.Attempt != 1 || requeued.Input["n"] != "1" || requeued.Tags["batch"] != "xyz" {
+		t.Errorf("requeued request = %+v, want a first attempt with the input and tags", requeued)
+	}
+
+	// The dead letter is gone and can't be requeued twice
+	if remaining, err := s.stateManager.ListDeadLetters(function.ID); err != nil || len(remaining) != 0 {
+		t.Errorf("dead letters after the requeue = %+v, %v; want none", remaining, err)
+	}
+	if _, err := s.RequeueDeadLetter(deadLetter.ID); !errors.Is(err, ErrNotDeadLettered) {
+		t.Errorf("second requeue: error = %v, want %v", err, ErrNotDeadLettered)
+	}
+	if status := executionStatus(t, s, deadLetter.ID); status != state.StatusDeadLetter {
+		t.Errorf("requeued execution has status %q, want it kept dead_letter", status)
+	}
+
+	// The daemon now succeeds, so the requeued run completes
+	requeued.VM = testVM
+	s.asyncQueue.Push(requeued)
+	s.workers.Add(1)
+	go s.asyncWorker()
+	deadline := time.Now().Add(5 * time.Second)
+	for executionStatus(t, s, result.RequestID) != state.StatusCompleted {
+		if time.Now().After(deadline) {
+			t.Fatalf("requeued execution has status %q, want completed", executionStatus(t, s, result.RequestID))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if payloads := daemon.Payloads(); len(payloads) != 3 || payloads[2].RequestID != result.RequestID {
+		t.Errorf("daemon received %d requests, want the requeued run last", len(payloads))
+	}
+}
+
+func TestRequeueRejectsOtherExecutions(t *testing.T) {
+	s, _ := newTestScheduler(t, failTimes(0, ""))
+	function := registerTestFunction(t, s)
+	if err := s.stateManager.SaveExecution(&state.Execution{ID: "exec-failed", FunctionID: function.ID, Status: state.StatusFailed}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.RequeueDeadLetter("exec-failed"); !errors.Is(err, ErrNotDeadLettered) {
+		t.Errorf("requeueing a failed execution: error = %v, want %v", err, ErrNotDeadLettered)
+	}
+	if _, err := s.RequeueDeadLetter("exec-missing"); !errors.Is(err, ErrExecutionNotFound) {
+		t.Errorf("requeueing an unknown execution: error = %v, want %v", err, ErrExecutionNotFound)
+	}
+}
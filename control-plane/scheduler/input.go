@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInputTooComplex is returned when an invocation's input nests too deeply
+// or has too many keys
+var ErrInputTooComplex = errors.New("input too complex")
+
+// ValidateInput checks an invocation's input against FAAS_INPUT_MAX_DEPTH
+// and FAAS_INPUT_MAX_KEYS, so pathological inputs are refused before they
+// reach the daemon's JSON parser and the handler
+func ValidateInput(input map[string]interface{}) error {
+	maxDepth, maxKeys := getInputMaxDepth(), getInputMaxKeys()
+	keys := 0
+
+	var check func(value interface{}, depth int) error
+	check = func(value interface{}, depth int) error {
+		var children []interface{}
+		switch value := value.(type) {
+		case map[string]interface{}:
+			keys += len(value)
+			if keys > maxKeys {
+				return fmt.Errorf("%w: more than %d keys", ErrInputTooComplex, maxKeys)
+			}
+			for _, child := range value {
+				children = append(children, child)
+			}
+		case []interface{}:
+			children = value
+		default:
+			return nil
+		}
+
+		depth++
+		if depth > maxDepth {
+			return fmt.Errorf("%w: nested more than %d levels deep", ErrInputTooComplex, maxDepth)
+		}
+		for _, child := range children {
+			if err := check(child, depth); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return check(input, 0)
+}
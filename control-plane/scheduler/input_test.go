@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateInput(t *testing.T) {
+	t.Setenv(EnvInputMaxDepth, "3")
+	t.Setenv(EnvInputMaxKeys, "5")
+
+	tests := []struct {
+		name  string
+		input map[string]interface{}
+		ok    bool
+	}{
+		{"empty", nil, true},
+		{"objects at the depth limit", map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": 1}}}, true},
+		{"objects one level too deep", map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": map[string]interface{}{}}}}, false},
+		{"arrays at the depth limit", map[string]interface{}{"a": []interface{}{[]interface{}{1, 2}}}, true},
+		{"arrays one level too deep", map[string]interface{}{"a": []interface{}{[]interface{}{[]interface{}{1}}}}, false},
+		{"keys at the limit", map[string]interface{}{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}, true},
+		{"one key too many", map[string]interface{}{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6}, false},
+		{"keys counted at all levels", map[string]interface{}{"a": map[string]interface{}{"b": 1, "c": 2}, "d": []interface{}{map[string]interface{}{"e": 1, "f": 2}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateInput(tt.input)
+			if tt.ok && err != nil {
+				t.Errorf("ValidateInput() = %v, want no error", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInputTooComplex) {
+				t.Errorf("ValidateInput() = %v, want %v", err, ErrInputTooComplex)
+			}
+		})
+	}
+}
+
+func TestValidateInputDefaults(t *testing.T) {
+	t.Setenv(EnvInputMaxDepth, "")
+	t.Setenv(EnvInputMaxKeys, "")
+
+	deep := map[string]interface{}{}
+	for level := 1; level < 32; level++ {
+		deep = map[string]interface{}{"next": deep}
+	}
+	if err := ValidateInput(deep); err != nil {
+		t.Errorf("input 32 levels deep: %v, want no error", err)
+	}
+	if err := ValidateInput(map[string]interface{}{"next": deep}); !errors.Is(err, ErrInputTooComplex) {
+		t.Errorf("input 33 levels deep: error = %v, want %v", err, ErrInputTooComplex)
+	}
+}
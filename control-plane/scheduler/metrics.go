@@ -0,0 +1,32 @@
+package scheduler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// payloadBuckets cover sizes from 64 bytes to 16 MiB
+var payloadBuckets = prometheus.ExponentialBuckets(64, 4, 10)
+
+// inputBytes tracks the size of the JSON input sent with each execution
+var inputBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "skyscale_input_bytes",
+	Help:    "Size of the JSON input of each execution in bytes.",
+	Buckets: payloadBuckets,
+}, []string{"function"})
+
+// outputBytes tracks the size of the output reported for each execution
+var outputBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "skyscale_output_bytes",
+	Help:    "Size of the output of each execution in bytes.",
+	Buckets: payloadBuckets,
+}, []string{"function"})
+
+func init() {
+	prometheus.MustRegister(inputBytes, outputBytes)
+}
+
+// ObserveOutputBytes records the size of an execution's output when its
+// result is received
+func ObserveOutputBytes(functionID string, size int) {
+	outputBytes.WithLabelValues(functionID).Observe(float64(size))
+}
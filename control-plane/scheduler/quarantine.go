@@ -0,0 +1,28 @@
+package scheduler
+
+import (
+	"fmt"
+
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+// quarantinedErrorTypes are the failures after which the VM is quarantined,
+// when FAAS_VM_QUARANTINE_SECONDS is set: the function process crashed or
+// ran out of memory, which may say more about the VM than about the code
+var quarantinedErrorTypes = map[string]bool{
+	"exit": true,
+	"oom":  true,
+}
+
+// QuarantineFailed quarantines the VM of a failed execution if the failure
+// warrants it and reports whether it did; the VM is then neither returned
+// to the pool nor terminated until its quarantine is over. Call it before
+// saving the execution's final status, so a synchronous invoke waiting on
+// it doesn't return the VM first.
+func (s *Scheduler) QuarantineFailed(execution *state.Execution) bool {
+	if execution.VMID == "" || execution.Status != state.StatusFailed || !quarantinedErrorTypes[execution.ErrorType] {
+		return false
+	}
+	reason := fmt.Sprintf("execution %s of function %s failed with %s", execution.ID, execution.FunctionID, execution.ErrorType)
+	return s.vmManager.QuarantineVM(execution.VMID, reason)
+}
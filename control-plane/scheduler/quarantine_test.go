@@ -0,0 +1,39 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/bluequbit/faas/control-plane/daemonclient/daemontest"
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/vm"
+)
+
+func TestQuarantineFailedOnlyForCrashes(t *testing.T) {
+	t.Setenv(vm.EnvVMQuarantineSeconds, "60")
+	s, _ := newTestScheduler(t, daemontest.Succeed("{}"))
+	hostVM, err := s.vmManager.CreateTestHostVM()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		execution state.Execution
+	}{
+		{"completed", state.Execution{Status: state.StatusCompleted, VMID: hostVM.ID}},
+		{"handler exception", state.Execution{Status: state.StatusFailed, ErrorType: "exception", VMID: hostVM.ID}},
+		{"timeout", state.Execution{Status: state.StatusTimeout, ErrorType: "timeout", VMID: hostVM.ID}},
+		{"crash without a VM", state.Execution{Status: state.StatusFailed, ErrorType: "exit"}},
+		{"crash on an unknown VM", state.Execution{Status: state.StatusFailed, ErrorType: "oom", VMID: "vm-missing"}},
+		{"crash on the test host VM", state.Execution{Status: state.StatusFailed, ErrorType: "exit", VMID: hostVM.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execution := tt.execution
+			execution.ID = "exec-1"
+			if s.QuarantineFailed(&execution) {
+				t.Error("VM was quarantined")
+			}
+		})
+	}
+}
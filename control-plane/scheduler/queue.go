@@ -0,0 +1,126 @@
+package scheduler
+
+import "sync"
+
+// fairQueue is a bounded queue of asynchronous execution requests that keeps
+// a FIFO per function and serves functions round-robin, so a burst from one
+// function can't starve the others
+type fairQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queues   map[string][]*ExecutionRequest
+	order    []string          // Functions with pending requests, in service order
+	queued   map[string]string // Function ID of each queued request, by request ID
+	size     int
+	capacity int
+	closed   bool // Set by Close to release the workers waiting in Pop
+}
+
+// newFairQueue creates a queue holding at most capacity requests in total
+func newFairQueue(capacity int) *fairQueue {
+	q := &fairQueue{
+		queues:   make(map[string][]*ExecutionRequest),
+		queued:   make(map[string]string),
+		capacity: capacity,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push adds a request to its function's queue. It returns false without
+// blocking if the queue is full or closed.
+func (q *fairQueue) Push(request *ExecutionRequest) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || q.size >= q.capacity {
+		return false
+	}
+
+	pending := q.queues[request.FunctionID]
+	if len(pending) == 0 {
+		q.order = append(q.order, request.FunctionID)
+	}
+	q.queues[request.FunctionID] = append(pending, request)
+	q.queued[request.RequestID] = request.FunctionID
+	q.size++
+	q.cond.Signal()
+	return true
+}
+
+// Pop blocks until a request is available and returns the oldest request of
+// the next function in round-robin order. It returns nil once the queue is
+// closed.
+func (q *fairQueue) Pop() *ExecutionRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.size == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil
+	}
+
+	functionID := q.order[0]
+	q.order = q.order[1:]
+	pending := q.queues[functionID]
+	request := pending[0]
+	pending[0] = nil
+	pending = pending[1:]
+
+	if len(pending) == 0 {
+		delete(q.queues, functionID)
+	} else {
+		// Move the function to the back of the line
+		q.queues[functionID] = pending
+		q.order = append(q.order, functionID)
+	}
+	delete(q.queued, request.RequestID)
+	q.size--
+	return request
+}
+
+// Close stops the queue handing out requests and wakes every blocked Pop
+func (q *fairQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Remove takes a request that hasn't been handed to a worker out of the
+// queue. It returns false if the request isn't queued.
+func (q *fairQueue) Remove(requestID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	functionID, ok := q.queued[requestID]
+	if !ok {
+		return false
+	}
+
+	pending := q.queues[functionID]
+	for i, request := range pending {
+		if request.RequestID == requestID {
+			pending = append(pending[:i], pending[i+1:]...)
+			break
+		}
+	}
+
+	if len(pending) == 0 {
+		delete(q.queues, functionID)
+		for i, id := range q.order {
+			if id == functionID {
+				q.order = append(q.order[:i], q.order[i+1:]...)
+				break
+			}
+		}
+	} else {
+		q.queues[functionID] = pending
+	}
+	delete(q.queued, requestID)
+	q.size--
+	return true
+}
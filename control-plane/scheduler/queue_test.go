@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func queueRequest(functionID string, n int) *ExecutionRequest {
+	return &ExecutionRequest{RequestID: fmt.Sprintf("%s-%d", functionID, n), FunctionID: functionID}
+}
+
+func TestFairQueueBurstDoesNotStarve(t *testing.T) {
+	q := newFairQueue(100)
+	for i := 0; i < 50; i++ {
+		if !q.Push(queueRequest("a", i)) {
+			t.Fatalf("push %d refused", i)
+		}
+	}
+	q.Push(queueRequest("b", 0))
+
+	// B's only request is served right after A's first, not behind the burst
+	if got := q.Pop().RequestID; got != "a-0" {
+		t.Fatalf("first pop = %s, want a-0", got)
+	}
+	if got := q.Pop().RequestID; got != "b-0" {
+		t.Fatalf("second pop = %s, want b-0 ahead of the rest of the burst", got)
+	}
+	// A's requests keep their order
+	for i := 1; i < 50; i++ {
+		if got, want := q.Pop().RequestID, fmt.Sprintf("a-%d", i); got != want {
+			t.Fatalf("pop = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestFairQueueRoundRobin(t *testing.T) {
+	q := newFairQueue(100)
+	for _, request := range []*ExecutionRequest{queueRequest("a", 0), queueRequest("a", 1), queueRequest("b", 0), queueRequest("c", 0), queueRequest("b", 1)} {
+		q.Push(request)
+	}
+
+	want := []string{"a-0", "b-0", "c-0", "a-1", "b-1"}
+	for _, id := range want {
+		if got := q.Pop().RequestID; got != id {
+			t.Fatalf("pop = %s, want %s", got, id)
+		}
+	}
+}
+
+func TestFairQueueCapacity(t *testing.T) {
+	q := newFairQueue(2)
+	q.Push(queueRequest("a", 0))
+	q.Push(queueRequest("b", 0))
+
+	// The capacity is shared across functions
+	if q.Push(queueRequest("c", 0)) {
+		t.Error("push beyond the capacity accepted")
+	}
+	q.Pop()
+	if !q.Push(queueRequest("c", 0)) {
+		t.Error("push refused after a request was taken")
+	}
+}
+
+func TestFairQueueRemove(t *testing.T) {
+	q := newFairQueue(10)
+	q.Push(queueRequest("a", 0))
+	q.Push(queueRequest("b", 0))
+	q.Push(queueRequest("a", 1))
+
+	if !q.Remove("b-0") {
+		t.Fatal("Remove(b-0) = false")
+	}
+	if q.Remove("b-0") {
+		t.Error("removed b-0 twice")
+	}
+	if got := q.Pop().RequestID; got != "a-0" {
+		t.Fatalf("pop = %s, want a-0", got)
+	}
+	if got := q.Pop().RequestID; got != "a-1" {
+		t.Fatalf("pop = %s, want a-1", got)
+	}
+	if q.size != 0 || len(q.order) != 0 {
+		t.Errorf("queue not empty: size %d, order %v", q.size, q.order)
+	}
+}
+
+func TestFairQueuePopWaits(t *testing.T) {
+	q := newFairQueue(10)
+	popped := make(chan *ExecutionRequest)
+	go func() { popped <- q.Pop() }()
+
+	select {
+	case request := <-popped:
+		t.Fatalf("Pop returned %s from an empty queue", request.RequestID)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.Push(queueRequest("a", 0))
+	select {
+	case request := <-popped:
+		if request.RequestID != "a-0" {
+			t.Errorf("pop = %s, want a-0", request.RequestID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pop didn't return after a push")
+	}
+}
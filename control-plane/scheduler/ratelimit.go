@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when an invocation exceeds its function's rate limit
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitError reports a throttled invocation and when to retry it
+type RateLimitError struct {
+	FunctionID string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v for function %s, retry after %v", ErrRateLimited, e.FunctionID, e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// tokenBucket holds up to burst tokens, refilled at rate tokens per second
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter enforces per-function invocation rates across all callers
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from the function's bucket. When none is left it
+// returns false and how long until the next token is available. A rate of
+// zero means the function is not limited.
+func (l *rateLimiter) allow(functionID string, rate float64, burst int, now time.Time) (bool, time.Duration) {
+	if rate <= 0 {
+		return true, 0
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[functionID]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[functionID] = bucket
+	}
+
+	// Refill for the time since the last request; a lowered burst applies at once
+	bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	return false, wait
+}
@@ -0,0 +1,65 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter()
+	start := time.Now()
+
+	// A burst of 2 at 1 invocation per second
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("f", 1, 2, start); !ok {
+			t.Fatalf("invocation %d of the burst was throttled", i+1)
+		}
+	}
+	ok, retryAfter := l.allow("f", 1, 2, start)
+	if ok {
+		t.Fatal("invocation past the burst was allowed")
+	}
+	if retryAfter != time.Second {
+		t.Errorf("retry after = %v, want 1s", retryAfter)
+	}
+
+	// Tokens refill at the rate
+	if ok, _ := l.allow("f", 1, 2, start.Add(time.Second)); !ok {
+		t.Error("invocation a second later was throttled")
+	}
+	if ok, retryAfter := l.allow("f", 1, 2, start.Add(1500*time.Millisecond)); ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("allow() = %v, %v; want throttled for 500ms", ok, retryAfter)
+	}
+
+	// Other functions have their own bucket
+	if ok, _ := l.allow("g", 1, 1, start); !ok {
+		t.Error("another function was throttled")
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.allow("f", 0, 0, now); !ok {
+			t.Fatalf("invocation %d of an unlimited function was throttled", i+1)
+		}
+	}
+}
+
+func TestRateLimiterRefillCappedAtBurst(t *testing.T) {
+	l := newRateLimiter()
+	start := time.Now()
+	l.allow("f", 10, 3, start)
+
+	// An idle hour refills no more than the burst
+	later := start.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("f", 10, 3, later); !ok {
+			t.Fatalf("invocation %d of the refilled burst was throttled", i+1)
+		}
+	}
+	if ok, _ := l.allow("f", 10, 3, later); ok {
+		t.Error("bucket refilled past its burst")
+	}
+}
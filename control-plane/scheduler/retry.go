@@ -0,0 +1,121 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/registry"
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/google/uuid"
+)
+
+// maxRetryBackoff caps the delay before a retry however often it doubled
+const maxRetryBackoff = time.Hour
+
+// retries keeps the requests of async executions whose functions have a
+// retry policy until they finish. Executions don't store their input, so a
+// retry can only be made while the scheduler still has the request.
+type retries struct {
+	mu       sync.Mutex
+	requests map[string]*ExecutionRequest
+}
+
+func newRetries() *retries {
+	return &retries{requests: make(map[string]*ExecutionRequest)}
+}
+
+// track keeps the request until its execution finishes
+func (r *retries) track(request *ExecutionRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests[request.RequestID] = request
+}
+
+// take returns and forgets the request of an execution
+func (r *retries) take(requestID string) (*ExecutionRequest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	request, ok := r.requests[requestID]
+	delete(r.requests, requestID)
+	return request, ok
+}
+
+// retryBackoff returns the delay before the retry that follows the given
+// attempt: the policy's backoff, doubled for each earlier retry
+func retryBackoff(policy *state.RetryPolicy, attempt int) time.Duration {
+	backoff := time.Duration(policy.BackoffSeconds) * time.Second
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
+
+// RetryFailed retries a finished async execution that failed with a
+// retryable error, if its function's retry policy has attempts left, and
+// reports whether it did. The retry is a new execution that shares the
+// first attempt's ID as its ParentID; it is recorded as queued right away
+// and joins the queue once its backoff has passed. An execution that failed
+// on the last allowed attempt is dead-lettered instead. Call it for every
+// finished execution so the scheduler stops keeping its request.
+func (s *Scheduler) RetryFailed(execution *state.Execution) bool {
+	request, ok := s.retries.take(execution.ID)
+	if !ok || (execution.Status != state.StatusFailed && execution.Status != state.StatusTimeout) {
+		return false
+	}
+	function, err := s.functionRegistry.GetFunction(request.FunctionID)
+	if err != nil || function.Retry == nil {
+		return false
+	}
+
+	policy := function.Retry
+	if request.Attempt >= policy.MaxAttempts {
+		s.logger.Warnf("Execution %s failed on attempt %d of %d, dead-lettering it", execution.ID, request.Attempt, policy.MaxAttempts)
+		s.deadLetter(execution, request)
+		return false
+	}
+	if !registry.Retryable(policy, execution.ErrorType) {
+		s.logger.Infof("Execution %s failed with non-retryable error type %q, not retrying", execution.ID, execution.ErrorType)
+		return false
+	}
+
+	backoff := retryBackoff(policy, request.Attempt)
+	retry := *request
+	retry.RequestID = uuid.New().String()
+	retry.ParentID = request.ParentID
+	if retry.ParentID == "" {
+		retry.ParentID = request.RequestID
+	}
+	retry.Attempt = request.Attempt + 1
+	retry.QueuedAt = time.Now().Add(backoff)
+
+	queued := &state.Execution{
+		ID:         retry.RequestID,
+		FunctionID: retry.FunctionID,
+		Version:    retry.Version,
+		UserID:     retry.UserID,
+		Status:     state.StatusQueued,
+		QueuedAt:   retry.QueuedAt,
+		ParentID:   retry.ParentID,
+		Attempt:    retry.Attempt,
+		Tags:       retry.Tags,
+	}
+	if err := s.stateManager.SaveExecution(queued); err != nil {
+		s.logger.Errorf("Failed to save retry of execution %s: %v", execution.ID, err)
+		return false
+	}
+
+	s.logger.Infof("Retrying execution %s as %s (attempt %d of %d) in %v", execution.ID, retry.RequestID, retry.Attempt, policy.MaxAttempts, backoff)
+	time.AfterFunc(backoff, func() {
+		retry.QueuedAt = time.Now()
+		if !s.asyncQueue.Push(&retry) {
+			queued.Status = state.StatusFailed
+			queued.Error = "execution queue is full"
+			queued.EndTime = time.Now()
+			s.finishExecution(queued)
+		}
+	})
+	return true
+}
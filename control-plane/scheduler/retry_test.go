@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/daemonclient/daemontest"
+	"github.com/bluequbit/faas/control-plane/registry"
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/types"
+)
+
+// failTimes returns a RunFunc failing the first n executions with errorType
+// and completing the rest
+func failTimes(n int, errorType string) daemontest.RunFunc {
+	var mu sync.Mutex
+	runs := 0
+	return func(payload *daemontest.Payload) *types.ExecutionResult {
+		mu.Lock()
+		runs++
+		fail := runs <= n
+		mu.Unlock()
+
+		result := &types.ExecutionResult{RequestID: payload.RequestID, FunctionID: payload.FunctionID, StatusCode: http.StatusOK, Output: types.OutputFromString("{}")}
+		if fail {
+			result.StatusCode = http.StatusInternalServerError
+			result.ErrorMessage = "flaky"
+			result.ErrorType = errorType
+		}
+		return result
+	}
+}
+
+// retryFunction registers a function with the retry policy
+func retryFunction(t *testing.T, s *Scheduler, policy state.RetryPolicy) *registry.FunctionMetadata {
+	t.Helper()
+	function := registerTestFunction(t, s)
+	function, err := s.functionRegistry.SetRetryPolicy(function.ID, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return function
+}
+
+// waitFinished waits until n executions of the function have finished and
+// returns all of its executions
+func waitFinished(t *testing.T, s *Scheduler, functionID string, n int) []state.Execution {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		executions, err := s.stateManager.ListExecutions(functionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		finished := 0
+		for _, execution := range executions {
+			if execution.Status.Terminal() {
+				finished++
+			}
+		}
+		if finished >= n {
+			return executions
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d executions finished", finished, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRetryUntilSuccess(t *testing.T) {
+	s, daemon := newTestScheduler(t, failTimes(2, "exception"))
+	function := retryFunction(t, s, state.RetryPolicy{MaxAttempts: 3})
+
+	if _, err := s.enqueue(asyncRequest(function, "exec-first")); err != nil {
+		t.Fatal(err)
+	}
+	executions := waitFinished(t, s, function.ID, 3)
+
+	if n := len(daemon.Payloads()); n != 3 {
+		t.Errorf("daemon received %d requests, want 3", n)
+	}
+	attempts := map[int]state.Execution{}
+	for _, execution := range executions {
+		attempts[execution.Attempt] = execution
+	}
+	if len(executions) != 3 || len(attempts) != 3 {
+		t.Fatalf("stored %d executions over attempts %v, want attempts 1 to 3", len(executions), attempts)
+	}
+	for attempt := 1; attempt <= 3; attempt++ {
+		execution := attempts[attempt]
+		want := state.StatusFailed
+		if attempt == 3 {
+			want = state.StatusCompleted
+		}
+		if execution.Status != want {
+			t.Errorf("attempt %d has status %q, want %q", attempt, execution.Status, want)
+		}
+		if attempt > 1 && execution.ParentID != "exec-first" {
+			t.Errorf("attempt %d has parent %q, want exec-first", attempt, execution.ParentID)
+		}
+	}
+}
+
+func TestNonRetryableFailureNotRetried(t *testing.T) {
+	s, daemon := newTestScheduler(t, failTimes(1, "exception"))
+	function := retryFunction(t, s, state.RetryPolicy{MaxAttempts: 3, RetryOn: []string{"timeout"}})
+
+	if _, err := s.enqueue(asyncRequest(function, "exec-first")); err != nil {
+		t.Fatal(err)
+	}
+	executions := waitFinished(t, s, function.ID, 1)
+
+	// A retry would have been recorded as queued before the failure was
+	// acknowledged
+	if len(executions) != 1 || executions[0].Status != state.StatusFailed {
+		t.Errorf("stored %d executions, want the one failed attempt", len(executions))
+	}
+	if n := len(daemon.Payloads()); n != 1 {
+		t.Errorf("daemon received %d requests, want 1", n)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := &state.RetryPolicy{BackoffSeconds: 2}
+	for attempt, want := range map[int]time.Duration{1: 2 * time.Second, 2: 4 * time.Second, 3: 8 * time.Second, 20: maxRetryBackoff} {
+		if got := retryBackoff(policy, attempt); got != want {
+			t.Errorf("retryBackoff(attempt %d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
@@ -10,7 +10,8 @@
 // It works with the VMManager to allocate resources, the FunctionRegistry to retrieve
 // function metadata and code, and the StateManager to persist execution state.
 //
-// The scheduler implements a worker pool pattern for handling asynchronous requests
+// The scheduler implements a worker pool pattern for handling asynchronous requests,
+// serving per-function queues round-robin so one function cannot starve the others,
 // and includes monitoring capabilities to detect and handle stalled executions.
 
 package scheduler
@@ -20,36 +21,102 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/bluequbit/faas/control-plane/daemonclient"
 	"github.com/bluequbit/faas/control-plane/registry"
 	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/types"
 	"github.com/bluequbit/faas/control-plane/vm"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
+// ErrFunctionNotFound is returned when invoking a function that doesn't exist
+var ErrFunctionNotFound = errors.New("function not found")
+
+// ErrQueueFull is returned when an async invocation can't be queued because
+// the queue is at capacity
+var ErrQueueFull = errors.New("execution queue is full, try again later")
+
+// ErrConcurrencyLimit is returned when an invocation would exceed the
+// executions its function may have in progress at once
+var ErrConcurrencyLimit = errors.New("concurrency limit reached")
+
 // Scheduler manages function execution scheduling
 type Scheduler struct {
 	vmManager        *vm.VMManager
 	functionRegistry *registry.FunctionRegistry
 	stateManager     *state.StateManager
 	logger           *logrus.Logger
-	asyncQueue       chan *ExecutionRequest
+	asyncQueue       *fairQueue
 	mu               sync.Mutex
 	activeExecutions map[string]*ExecutionContext
+	daemon           *daemonclient.Client
+	rateLimiter      *rateLimiter
+	completions      *completions
+	cache            *resultCache
+	streams          *streams
+	retries          *retries
+	stop             chan struct{}  // Closed by Stop to end the execution monitor
+	workers          sync.WaitGroup // Running async workers
 }
 
+// ErrExecutionNotFound is returned when cancelling an execution that doesn't exist
+var ErrExecutionNotFound = errors.New("execution not found")
+
+// ErrExecutionStarted is returned when cancelling an execution that is no
+// longer waiting in the queue
+var ErrExecutionStarted = errors.New("execution is no longer queued")
+
 // ExecutionRequest represents a request to execute a function
 type ExecutionRequest struct {
 	FunctionID   string
 	FunctionName string
 	Input        map[string]interface{}
 	Event        map[string]interface{}
+	Environment  map[string]string
+	Version      string
+	UserID       string
+	Deadline     time.Time
+	Timeout      int // Seconds; overrides the function's timeout when set
 	Sync         bool
 	RequestID    string
+	QueuedAt     time.Time
+	// ParentID is the request ID of the first attempt of a retry, Attempt
+	// the number of this attempt starting at 1
+	ParentID string
+	Attempt  int
+	Tags     map[string]string
+	// VM, if set, runs the execution on this VM without allocating one or
+	// returning it to the pool afterwards, see ExecuteOnVM
+	VM *state.VM
+}
+
+// InvokeOptions holds the optional per-invocation settings of an execution
+type InvokeOptions struct {
+	// Environment overrides the function's stored environment variables
+	Environment map[string]string
+	// Version pins the function version to run; empty means the latest
+	Version string
+	// UserID identifies the caller the execution is attributed to
+	UserID string
+	// Tags are stored on the execution to group it with related ones
+	Tags map[string]string
+	// Deadline, if set, is when a synchronous invoke stops waiting for the
+	// result; the execution itself keeps running
+	Deadline time.Time
+	// Timeout, in seconds, overrides the function's timeout for this
+	// execution; zero means the function's timeout
+	Timeout int
+	// Chunks, if set, receives the items a generator handler yields while a
+	// synchronous execution runs. The scheduler never closes it.
+	Chunks chan<- *types.StreamChunk
 }
 
 // ExecutionContext tracks the context of a function execution
@@ -59,33 +126,92 @@ type ExecutionContext struct {
 	VMID       string
 	StartTime  time.Time
 	Sync       bool
-	Result     chan *ExecutionResult
+	Result     chan *types.ExecutionResult
+}
+
+// maxDaemonErrorBody caps how much of a daemon's response to a rejected
+// execution request ends up in the execution's error
+const maxDaemonErrorBody = 4096
+
+// monitorGracePeriod is added to the platform's maximum function timeout
+// before the monitor considers an execution stalled
+const monitorGracePeriod = 30 * time.Second
+
+// reservedEnvKeys are environment variables owned by the platform that
+// invocations are not allowed to override
+var reservedEnvKeys = map[string]bool{
+	"PATH":       true,
+	"PYTHONPATH": true,
+	"PYTHONHOME": true,
+	"HOME":       true,
+	"VM_ID":      true,
+	"VM_IP":      true,
+}
+
+// reservedEnvPrefixes are environment variable prefixes reserved for the platform
+var reservedEnvPrefixes = []string{"FAAS_", "SKYSCALE_"}
+
+// ValidateEnvironment checks that none of the given environment variables
+// collide with keys reserved by the platform
+func ValidateEnvironment(env map[string]string) error {
+	for key := range env {
+		if key == "" {
+			return errors.New("environment variable name cannot be empty")
+		}
+		if reservedEnvKeys[key] {
+			return fmt.Errorf("environment variable %s is reserved by the platform", key)
+		}
+		for _, prefix := range reservedEnvPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				return fmt.Errorf("environment variable %s uses reserved prefix %s", key, prefix)
+			}
+		}
+	}
+	return nil
 }
 
-// ExecutionResult represents the result of a function execution
-type ExecutionResult struct {
-	RequestID    string                 `json:"request_id"`
-	FunctionID   string                 `json:"function_id"`
-	StatusCode   int                    `json:"status_code"`
-	Output       map[string]interface{} `json:"output,omitempty"`
-	ErrorMessage string                 `json:"error_message,omitempty"`
-	Duration     int64                  `json:"duration_ms"`
-	MemoryUsage  int64                  `json:"memory_usage_kb,omitempty"`
+// mergeEnvironment merges the invocation's environment overrides over the
+// function's stored environment, with the invocation taking precedence
+func mergeEnvironment(base, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
 }
 
 // NewScheduler creates a new function scheduler
 func NewScheduler(vmManager *vm.VMManager, functionRegistry *registry.FunctionRegistry, stateManager *state.StateManager, logger *logrus.Logger) (*Scheduler, error) {
+	daemon, err := daemonclient.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure daemon client: %v", err)
+	}
+
 	scheduler := &Scheduler{
 		vmManager:        vmManager,
 		functionRegistry: functionRegistry,
 		stateManager:     stateManager,
 		logger:           logger,
-		asyncQueue:       make(chan *ExecutionRequest, 100), // Buffer size of 100
+		asyncQueue:       newFairQueue(100), // Up to 100 queued requests across all functions
 		activeExecutions: make(map[string]*ExecutionContext),
+		daemon:           daemon,
+		rateLimiter:      newRateLimiter(),
+		completions:      newCompletions(),
+		cache:            newResultCache(stateManager, logger),
+		streams:          newStreams(),
+		retries:          newRetries(),
+		stop:             make(chan struct{}),
 	}
 
+	// Pre-install dependencies on new warm VMs
+	vmManager.SetWarmup(scheduler.warmupVM)
+
 	// Start the async worker pool
 	for i := 0; i < 5; i++ { // Start 5 worker goroutines
+		scheduler.workers.Add(1)
 		go scheduler.asyncWorker()
 	}
 
@@ -96,165 +222,346 @@ func NewScheduler(vmManager *vm.VMManager, functionRegistry *registry.FunctionRe
 }
 
 // ScheduleExecution schedules a function for execution by ID
-func (s *Scheduler) ScheduleExecution(functionID string, input map[string]interface{}, sync bool) (*ExecutionResult, error) {
-	// Validate function exists
-	_, err := s.functionRegistry.GetFunction(functionID)
+func (s *Scheduler) ScheduleExecution(functionID string, input map[string]interface{}, opts InvokeOptions, sync bool) (*types.ExecutionResult, error) {
+	function, err := s.functionRegistry.GetFunction(functionID)
 	if err != nil {
-		return nil, fmt.Errorf("function not found: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrFunctionNotFound, err)
 	}
+	return s.schedule(function, input, opts, sync)
+}
 
-	// Create execution request
-	requestID := uuid.New().String()
-	request := &ExecutionRequest{
-		FunctionID: functionID,
-		Input:      input,
-		Event:      input, // Use input as event for backward compatibility
-		Sync:       sync,
-		RequestID:  requestID,
+// ScheduleExecutionByName schedules a function for execution by name
+func (s *Scheduler) ScheduleExecutionByName(functionName string, input map[string]interface{}, opts InvokeOptions, sync bool) (*types.ExecutionResult, error) {
+	function, err := s.functionRegistry.GetFunctionByName(functionName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFunctionNotFound, err)
 	}
+	return s.schedule(function, input, opts, sync)
+}
 
-	// Handle based on sync/async mode
-	if sync {
-		// For synchronous requests, execute directly and wait for result
-		return s.executeFunction(request)
-	} else {
-		// For asynchronous requests, queue the execution and return immediately
-		select {
-		case s.asyncQueue <- request:
-			// Successfully queued
-			return &ExecutionResult{
-				RequestID:  requestID,
-				FunctionID: functionID,
-				StatusCode: 202, // Accepted
-			}, nil
-		default:
-			// Queue is full
-			return nil, errors.New("execution queue is full, try again later")
-		}
+// schedule runs or queues an execution of a resolved function
+func (s *Scheduler) schedule(function *registry.FunctionMetadata, input map[string]interface{}, opts InvokeOptions, sync bool) (*types.ExecutionResult, error) {
+	if function.Status == registry.StatusDisabled {
+		return nil, fmt.Errorf("%w: %s", registry.ErrFunctionDisabled, function.Name)
+	}
+	if function.Status == registry.StatusCorrupt {
+		return nil, fmt.Errorf("%w: %s, redeploy it", registry.ErrCorruptStorage, function.Name)
 	}
-}
 
-// ScheduleExecutionByName schedules a function for execution by name
-func (s *Scheduler) ScheduleExecutionByName(functionName string, input map[string]interface{}, sync bool) (*ExecutionResult, error) {
-	// Validate function exists
-	function, err := s.functionRegistry.GetFunctionByName(functionName)
+	if err := s.checkRateLimit(function); err != nil {
+		return nil, err
+	}
+	if err := s.checkConcurrency(function); err != nil {
+		return nil, err
+	}
+
+	// Resolve the version to run so later updates don't change the code
+	version, err := s.resolveVersion(function, opts.Version)
 	if err != nil {
-		return nil, fmt.Errorf("function not found: %v", err)
+		return nil, err
 	}
 
 	// Create execution request
 	requestID := uuid.New().String()
 	request := &ExecutionRequest{
 		FunctionID:   function.ID,
-		FunctionName: functionName,
+		FunctionName: function.Name,
 		Input:        input,
 		Event:        input, // Use input as event for backward compatibility
+		Environment:  opts.Environment,
+		Version:      version,
+		UserID:       opts.UserID,
+		Deadline:     opts.Deadline,
+		Timeout:      opts.Timeout,
 		Sync:         sync,
 		RequestID:    requestID,
+		Attempt:      1,
+		Tags:         opts.Tags,
+	}
+
+	// Forward the chunks of a streaming invoke until it returns
+	if opts.Chunks != nil {
+		s.streams.open(requestID, opts.Chunks)
+		defer s.streams.close(requestID)
+	}
+
+	// Deterministic functions may be served without running them
+	if result := s.cachedExecution(function, request); result != nil {
+		return result, nil
 	}
 
 	// Handle based on sync/async mode
 	if sync {
 		// For synchronous requests, execute directly and wait for result
 		return s.executeFunction(request)
-	} else {
-		// For asynchronous requests, queue the execution and return immediately
-		select {
-		case s.asyncQueue <- request:
-			// Successfully queued
-			return &ExecutionResult{
-				RequestID:  requestID,
-				FunctionID: function.ID,
-				StatusCode: 202, // Accepted
-			}, nil
-		default:
-			// Queue is full
-			return nil, errors.New("execution queue is full, try again later")
-		}
 	}
+	// For asynchronous requests, queue the execution and return immediately
+	return s.enqueue(request)
+}
+
+// checkRateLimit takes an invocation from the function's rate limit,
+// returning a *RateLimitError if it's exhausted
+func (s *Scheduler) checkRateLimit(function *registry.FunctionMetadata) error {
+	ok, retryAfter := s.rateLimiter.allow(function.ID, function.RateLimit, function.RateBurst, time.Now())
+	if !ok {
+		return &RateLimitError{FunctionID: function.ID, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// checkConcurrency returns ErrConcurrencyLimit if the function already has
+// as many executions queued or running as its concurrency limit allows
+func (s *Scheduler) checkConcurrency(function *registry.FunctionMetadata) error {
+	if function.Concurrency <= 0 {
+		return nil
+	}
+	count, err := s.stateManager.CountUnfinishedExecutions(function.ID)
+	if err != nil {
+		return fmt.Errorf("failed to count executions: %w", err)
+	}
+	if count >= int64(function.Concurrency) {
+		return fmt.Errorf("%w: function %s has %d executions in progress", ErrConcurrencyLimit, function.Name, count)
+	}
+	return nil
+}
+
+// splitVersion picks the version for an unpinned invoke given a roll in
+// [0, 100): the canary (latest) version when the roll falls below the canary
+// percentage, otherwise the stable version
+func splitVersion(function *registry.FunctionMetadata, roll int) string {
+	if function.StableVersion == "" || roll < function.CanaryPercent {
+		return function.Version
+	}
+	return function.StableVersion
+}
+
+// resolveVersion returns the version an invocation runs: the pinned version
+// if it exists, otherwise the function's latest version or, during a canary
+// deployment, the version picked by the traffic split
+func (s *Scheduler) resolveVersion(function *registry.FunctionMetadata, pinned string) (string, error) {
+	if pinned == "" {
+		return splitVersion(function, rand.Intn(100)), nil
+	}
+	if pinned == function.Version {
+		return function.Version, nil
+	}
+	if _, err := s.functionRegistry.GetFunctionCodeVersion(function.ID, pinned); err != nil {
+		return "", err
+	}
+	return pinned, nil
+}
+
+// enqueue records an asynchronous request as queued and hands it to the
+// worker pool
+func (s *Scheduler) enqueue(request *ExecutionRequest) (*types.ExecutionResult, error) {
+	request.QueuedAt = time.Now()
+	execution := &state.Execution{
+		ID:         request.RequestID,
+		FunctionID: request.FunctionID,
+		Version:    request.Version,
+		UserID:     request.UserID,
+		Status:     state.StatusQueued,
+		QueuedAt:   request.QueuedAt,
+		ParentID:   request.ParentID,
+		Attempt:    request.Attempt,
+		Tags:       request.Tags,
+	}
+
+	// Record the execution before handing it to a worker so the worker's
+	// status updates are never overwritten
+	if err := s.stateManager.SaveExecution(execution); err != nil {
+		s.logger.Errorf("Failed to save queued execution record: %v", err)
+	}
+
+	if !s.asyncQueue.Push(request) {
+		// Queue is full
+		execution.Status = state.StatusFailed
+		execution.Error = "execution queue is full"
+		execution.EndTime = time.Now()
+		s.finishExecution(execution)
+		return nil, ErrQueueFull
+	}
+
+	return &types.ExecutionResult{
+		RequestID:  request.RequestID,
+		FunctionID: request.FunctionID,
+		StatusCode: 202, // Accepted
+		Status:     string(state.StatusQueued),
+		Version:    request.Version,
+	}, nil
 }
 
-// GetExecutionResult retrieves the result of an asynchronous execution
-func (s *Scheduler) GetExecutionResult(requestID string) (*ExecutionResult, error) {
+// CancelExecution cancels an asynchronous execution that is still waiting in
+// the queue. The request is removed from the queue and the execution is
+// recorded as cancelled, so it never gets a VM. Executions a worker has
+// already picked up can't be cancelled and return ErrExecutionStarted.
+func (s *Scheduler) CancelExecution(requestID string) (*state.Execution, error) {
+	execution, err := s.stateManager.GetExecution(requestID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExecutionNotFound, err)
+	}
+	if execution.Status != state.StatusQueued || !s.asyncQueue.Remove(requestID) {
+		return nil, fmt.Errorf("%w: status is %s", ErrExecutionStarted, execution.Status)
+	}
+
+	s.logger.Infof("Cancelled queued execution %s of function %s", requestID, execution.FunctionID)
+	execution.Status = state.StatusCancelled
+	execution.Error = "Execution cancelled before it started"
+	execution.EndTime = time.Now()
+	if err := s.finishExecution(execution); err != nil {
+		return nil, fmt.Errorf("failed to save cancelled execution: %v", err)
+	}
+	return execution, nil
+}
+
+// GetExecutionResult retrieves the result of an asynchronous execution. For
+// a retried execution it is the result of the latest attempt.
+func (s *Scheduler) GetExecutionResult(requestID string) (*types.ExecutionResult, error) {
+	attemptID := requestID
+	if latest, err := s.stateManager.GetLatestAttempt(requestID); err == nil {
+		attemptID = latest.ID
+	}
+
 	// Check if execution is still active
 	s.mu.Lock()
-	_, active := s.activeExecutions[requestID]
+	_, active := s.activeExecutions[attemptID]
 	s.mu.Unlock()
 
 	if active {
 		// Execution is still in progress
-		return &ExecutionResult{
+		return &types.ExecutionResult{
 			RequestID:  requestID,
 			StatusCode: 102, // Processing
+			Status:     string(state.StatusRunning),
 		}, nil
 	}
 
 	// Check if execution result is in the database
-	execution, err := s.stateManager.GetExecution(requestID)
+	execution, err := s.stateManager.GetExecution(attemptID)
 	if err != nil {
 		return nil, fmt.Errorf("execution not found: %v", err)
 	}
 
-	// Parse the output
-	var output map[string]interface{}
-	if execution.Logs != "" {
-		if err := json.Unmarshal([]byte(execution.Logs), &output); err != nil {
-			// If we can't parse as JSON, use a simple structure
-			output = map[string]interface{}{
-				"result": execution.Logs,
-			}
-			s.logger.Warnf("Failed to parse execution output as JSON, using raw output: %v", err)
-		}
+	// Map the stored status to a status code
+	statusCode := 200
+	switch execution.Status {
+	case state.StatusQueued, state.StatusPending:
+		statusCode = 202 // Accepted, not yet running
+	case state.StatusQueueTimeout:
+		statusCode = 504 // Gateway Timeout
 	}
 
 	// Return the result
-	return &ExecutionResult{
+	result := &types.ExecutionResult{
 		RequestID:    requestID,
 		FunctionID:   execution.FunctionID,
-		StatusCode:   200,
-		Output:       output,
+		StatusCode:   statusCode,
+		Status:       string(execution.Status),
+		Version:      execution.Version,
+		Output:       types.OutputFromString(execution.Logs),
 		ErrorMessage: execution.Error,
+		ErrorType:    execution.ErrorType,
+		ReasonCode:   execution.ReasonCode,
 		Duration:     execution.Duration,
-	}, nil
+		ColdStart:    execution.ColdStart,
+		Cached:       execution.Cached,
+		Attempt:      execution.Attempt,
+	}
+	if execution.Status.Terminal() {
+		result.Source = resultSource(execution)
+	}
+	return result, nil
+}
+
+// resultSource tells whether the outcome of a finished execution is from the
+// function or the platform. The daemon categorizes every failure of the
+// function itself with an error type, so failures without one are the
+// platform's, e.g. a VM that couldn't be allocated or a queue timeout.
+func resultSource(execution *state.Execution) string {
+	if execution.Status == state.StatusCompleted || execution.ErrorType != "" {
+		return types.SourceFunction
+	}
+	return types.SourcePlatform
 }
 
 // executeFunction executes a function on a VM
-func (s *Scheduler) executeFunction(request *ExecutionRequest) (*ExecutionResult, error) {
+func (s *Scheduler) executeFunction(request *ExecutionRequest) (*types.ExecutionResult, error) {
 	// Get function metadata
 	function, err := s.functionRegistry.GetFunction(request.FunctionID)
 	if err != nil {
-		return nil, fmt.Errorf("function not found: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrFunctionNotFound, err)
 	}
 
-	// Get function code
-	code, err := s.functionRegistry.GetFunctionCode(request.FunctionID)
+	// Get function code, for the pinned version if one was requested
+	code, err := s.functionRegistry.GetFunctionCodeVersion(request.FunctionID, request.Version)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get function code: %v", err)
+		return nil, fmt.Errorf("failed to get function code: %w", err)
+	}
+	version := request.Version
+	if version == "" {
+		version = function.Version
+	}
+	timeoutSeconds := function.Timeout
+	if request.Timeout > 0 {
+		timeoutSeconds = request.Timeout
+	}
+
+	// Keep the request of an async execution that may be retried
+	if !request.Sync && function.Retry != nil {
+		s.retries.track(request)
 	}
 
 	// Create execution record
 	execution := &state.Execution{
 		ID:         request.RequestID,
 		FunctionID: request.FunctionID,
-		Status:     "pending",
+		Version:    version,
+		UserID:     request.UserID,
+		Status:     state.StatusPending,
+		QueuedAt:   request.QueuedAt,
 		StartTime:  time.Now(),
+		ParentID:   request.ParentID,
+		Attempt:    request.Attempt,
+		Tags:       request.Tags,
+	}
+	if !request.QueuedAt.IsZero() {
+		execution.QueueWaitMs = execution.StartTime.Sub(request.QueuedAt).Milliseconds()
 	}
 	if err := s.stateManager.SaveExecution(execution); err != nil {
 		s.logger.Errorf("Failed to save execution record: %v", err)
 	}
 
-	// Allocate a VM for execution
-	vmInstance, err := s.vmManager.GetVM()
+	// Resolve secret references; the values only ever travel in the payload
+	secrets, err := s.functionRegistry.SecretValues(function)
 	if err != nil {
-		execution.Status = "failed"
+		execution.Status = state.StatusFailed
+		execution.Error = fmt.Sprintf("Failed to resolve secrets: %v", err)
+		execution.EndTime = time.Now()
+		s.finishExecution(execution)
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+
+	// Allocate a VM for execution, unless the request brings its own
+	allocationStart := time.Now()
+	priority := vm.PriorityAsync
+	if request.Sync {
+		priority = vm.PrioritySync
+	}
+	vmInstance, coldStart := request.VM, false
+	if vmInstance == nil {
+		vmInstance, coldStart, err = s.vmManager.GetVMForFunction(function.Runtime, function.CPU, priority)
+	}
+	execution.VMAllocationMs = time.Since(allocationStart).Milliseconds()
+	if err != nil {
+		execution.Status = state.StatusFailed
 		execution.Error = fmt.Sprintf("Failed to allocate VM: %v", err)
 		execution.EndTime = time.Now()
-		s.stateManager.SaveExecution(execution)
-		return nil, fmt.Errorf("failed to allocate VM: %v", err)
+		s.finishExecution(execution)
+		return nil, fmt.Errorf("failed to allocate VM: %w", err)
 	}
 
 	// Track the execution
-	resultChan := make(chan *ExecutionResult, 1)
+	resultChan := make(chan *types.ExecutionResult, 1)
 	context := &ExecutionContext{
 		RequestID:  request.RequestID,
 		FunctionID: request.FunctionID,
@@ -283,10 +590,21 @@ func (s *Scheduler) executeFunction(request *ExecutionRequest) (*ExecutionResult
 		}()
 
 		// Update execution status
-		execution.Status = "running"
+		execution.Status = state.StatusRunning
 		execution.VMID = vmInstance.ID
+		execution.ColdStart = coldStart
+		if inputJSON, err := json.Marshal(request.Input); err == nil {
+			execution.InputBytes = int64(len(inputJSON))
+			inputBytes.WithLabelValues(request.FunctionID).Observe(float64(len(inputJSON)))
+		}
 		s.stateManager.SaveExecution(execution)
 
+		// Functions registered before entry points were stored have none
+		entryPoint := function.EntryPoint
+		if entryPoint == "" {
+			entryPoint = registry.DefaultEntryPoint
+		}
+
 		// Create payload for daemon
 		payload := map[string]interface{}{
 			"function_id":  request.FunctionID,
@@ -294,21 +612,23 @@ func (s *Scheduler) executeFunction(request *ExecutionRequest) (*ExecutionResult
 			"code":         code.Code,
 			"requirements": code.Requirements,
 			"config":       code.Config,
+			"files":        code.Files,
 			"runtime":      function.Runtime,
-			"entry_point":  "handler.handler", // Default entry point
-			"environment":  map[string]string{},
+			"entry_point":  entryPoint,
+			"environment":  mergeEnvironment(mergeEnvironment(function.Environment, secrets), request.Environment),
 			"request_id":   request.RequestID,
-			"timeout":      function.Timeout,
-			"memory":       function.Memory,
-			"version":      function.Version,
+			"timeout":      timeoutSeconds,
+			"memory":       registry.EffectiveMemory(function.Memory),
+			"version":      version,
+			"scratch":      function.Scratch,
 			"input":        request.Input, // Keep for backward compatibility
 			"event":        request.Event, // Lambda-style event parameter
 			"context": map[string]interface{}{ // Lambda-style context parameter
 				"function_name":     function.Name,
-				"function_version":  function.Version,
-				"memory_limit_mb":   function.Memory,
+				"function_version":  version,
+				"memory_limit_mb":   registry.EffectiveMemory(function.Memory),
 				"request_id":        request.RequestID,
-				"remaining_time_ms": function.Timeout * 1000, // Convert to milliseconds
+				"remaining_time_ms": timeoutSeconds * 1000, // Convert to milliseconds
 			},
 		}
 
@@ -318,61 +638,59 @@ func (s *Scheduler) executeFunction(request *ExecutionRequest) (*ExecutionResult
 			s.logger.Errorf("Failed to marshal function payload: %v", err)
 
 			// Create error result
-			errorResult := &ExecutionResult{
+			errorResult := &types.ExecutionResult{
 				RequestID:    request.RequestID,
 				FunctionID:   request.FunctionID,
 				StatusCode:   500,
 				ErrorMessage: fmt.Sprintf("Failed to marshal function payload: %v", err),
+				Source:       types.SourcePlatform,
 				Duration:     time.Since(context.StartTime).Milliseconds(),
 			}
 
 			// Update execution record
-			execution.Status = "failed"
+			execution.Status = state.StatusFailed
 			execution.Error = errorResult.ErrorMessage
 			execution.EndTime = time.Now()
 			execution.Duration = errorResult.Duration
-			s.stateManager.SaveExecution(execution)
+			s.finishExecution(execution)
 
 			// Return VM to pool
-			if err := s.vmManager.ReturnVM(vmInstance.ID); err != nil {
-				s.logger.Errorf("Failed to return VM to pool: %v", err)
-			}
+			s.returnVM(request, vmInstance.ID)
 
 			// Send result to channel
 			resultChan <- errorResult
 			return
 		}
 
-		// Create HTTP client with timeout
-		client := &http.Client{
-			Timeout: time.Duration(function.Timeout+5) * time.Second, // Add 5 seconds buffer
-		}
-
 		// Construct daemon URL
-		daemonURL := fmt.Sprintf("http://%s:8081/execute", vmInstance.IP)
+		daemonURL := s.daemon.URL(vmInstance.IP, "/execute")
 		s.logger.Infof("Sending execution request to daemon at %s", daemonURL)
 
-		// Send request to daemon
-		resp, err := client.Post(daemonURL, "application/json", bytes.NewBuffer(payloadJSON))
+		// Send request to daemon over the shared connection pool. The daemon
+		// acknowledges the request before running the function, so this only
+		// waits for the dispatch; the function's own timeout applies below.
+		dispatchTimeout := getDispatchTimeout()
+		resp, err := s.daemon.Post(daemonURL, "application/json", bytes.NewBuffer(payloadJSON), dispatchTimeout)
 
 		if err != nil {
 			s.logger.Errorf("Failed to send request to daemon: %v", err)
 
 			// Create error result
-			errorResult := &ExecutionResult{
+			errorResult := &types.ExecutionResult{
 				RequestID:    request.RequestID,
 				FunctionID:   request.FunctionID,
 				StatusCode:   500,
-				ErrorMessage: fmt.Sprintf("Failed to send request to daemon: %v", err),
+				ErrorMessage: fmt.Sprintf("Daemon unreachable: no acknowledgment within %v: %v", dispatchTimeout, err),
+				Source:       types.SourcePlatform,
 				Duration:     time.Since(context.StartTime).Milliseconds(),
 			}
 
 			// Update execution record
-			execution.Status = "failed"
+			execution.Status = state.StatusFailed
 			execution.Error = errorResult.ErrorMessage
 			execution.EndTime = time.Now()
 			execution.Duration = errorResult.Duration
-			s.stateManager.SaveExecution(execution)
+			s.finishExecution(execution)
 
 			// Return VM to pool
 			// if err := s.vmManager.ReturnVM(vmInstance.ID); err != nil {
@@ -385,84 +703,115 @@ func (s *Scheduler) executeFunction(request *ExecutionRequest) (*ExecutionResult
 		}
 		defer resp.Body.Close()
 
+		// A daemon that refused the request, e.g. with 429 because it is
+		// busy, never reports a result, so fail now instead of waiting for
+		// one. The daemon answered, so the VM goes back to the pool.
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxDaemonErrorBody))
+			s.logger.Errorf("Daemon on VM %s rejected execution %s with status %d", vmInstance.ID, request.RequestID, resp.StatusCode)
+
+			// Create error result
+			errorResult := &types.ExecutionResult{
+				RequestID:    request.RequestID,
+				FunctionID:   request.FunctionID,
+				StatusCode:   http.StatusBadGateway,
+				ErrorMessage: fmt.Sprintf("Daemon rejected the execution request with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body))),
+				Source:       types.SourcePlatform,
+				Duration:     time.Since(context.StartTime).Milliseconds(),
+			}
+
+			// Update execution record
+			execution.Status = state.StatusFailed
+			execution.Error = errorResult.ErrorMessage
+			execution.EndTime = time.Now()
+			execution.Duration = errorResult.Duration
+			s.finishExecution(execution)
+
+			// Return VM to pool
+			s.returnVM(request, vmInstance.ID)
+
+			// Send result to channel
+			resultChan <- errorResult
+			return
+		}
+
 		// For synchronous requests, we need to wait for the result
 		if request.Sync {
-			// The daemon will send the result to the control plane via a callback
-			// We need to poll for the result
-			maxRetries := 30 // Maximum number of retries
-			retryInterval := 500 * time.Millisecond
+			// The daemon sends the result to the control plane via a callback;
+			// wait for it until the function's timeout, plus a grace period,
+			// has passed
+			executionTimeout := time.Duration(timeoutSeconds)*time.Second + getExecutionTimeoutGrace()
+			expired := time.NewTimer(executionTimeout)
+			defer expired.Stop()
 
-			for i := 0; i < maxRetries; i++ {
-				// Wait before checking
-				time.Sleep(retryInterval)
+			for waiting := true; waiting; {
+				// Subscribe before checking so a result reported in between isn't missed
+				completed := s.WaitForCompletion(request.FunctionID)
 
 				// Check if execution is complete
 				execResult, err := s.stateManager.GetExecution(request.RequestID)
-				if err != nil {
-					continue
-				}
-
-				if execResult.Status == "completed" || execResult.Status == "failed" {
-					// Execution is complete, parse the result
-					var output map[string]interface{}
-					if execResult.Logs != "" {
-						if err := json.Unmarshal([]byte(execResult.Logs), &output); err != nil {
-							// If we can't parse as JSON, use a simple structure
-							output = map[string]interface{}{
-								"result": execResult.Logs,
-							}
-							s.logger.Warnf("Failed to parse execution output as JSON, using raw output: %v", err)
-						}
-					}
-
-					// Create result
-					result := &ExecutionResult{
+				if err == nil && execResult.Status.Terminal() {
+					// Execution is complete, create result
+					result := &types.ExecutionResult{
 						RequestID:    request.RequestID,
 						FunctionID:   request.FunctionID,
 						StatusCode:   200,
-						Output:       output,
+						Status:       string(execResult.Status),
+						Output:       types.OutputFromString(execResult.Logs),
 						ErrorMessage: execResult.Error,
+						ErrorType:    execResult.ErrorType,
+						ReasonCode:   execResult.ReasonCode,
 						Duration:     execResult.Duration,
+						Source:       resultSource(execResult),
 					}
 
-					if execResult.Status == "failed" {
+					if execResult.Status != state.StatusCompleted {
 						result.StatusCode = 500
 					}
 
 					// Return VM to pool
-					if err := s.vmManager.ReturnVM(vmInstance.ID); err != nil {
-						s.logger.Errorf("Failed to return VM to pool: %v", err)
-					}
+					s.returnVM(request, vmInstance.ID)
 
 					// Send result to channel
 					resultChan <- result
 					return
 				}
+
+				select {
+				case <-completed:
+				case <-expired.C:
+					waiting = false
+				}
 			}
 
-			// If we get here, the execution timed out
-			s.logger.Warnf("Execution timed out after %d retries", maxRetries)
+			// If we get here, the function ran too long. Like other failures
+			// of the function it is reported in the result, not the status.
+			s.logger.Warnf("Execution %s timed out after %v", request.RequestID, executionTimeout)
 
 			// Create timeout result
-			timeoutResult := &ExecutionResult{
+			timeoutResult := &types.ExecutionResult{
 				RequestID:    request.RequestID,
 				FunctionID:   request.FunctionID,
-				StatusCode:   504, // Gateway Timeout
-				ErrorMessage: "Execution timed out waiting for result",
+				StatusCode:   500,
+				Status:       string(state.StatusTimeout),
+				ErrorMessage: fmt.Sprintf("Function too slow: no result within its %ds timeout", timeoutSeconds),
+				ErrorType:    "timeout",
+				ReasonCode:   state.ReasonTimeout,
 				Duration:     time.Since(context.StartTime).Milliseconds(),
+				Source:       types.SourceFunction,
 			}
 
 			// Update execution record
-			execution.Status = "timeout"
+			execution.Status = state.StatusTimeout
 			execution.Error = timeoutResult.ErrorMessage
+			execution.ErrorType = timeoutResult.ErrorType
+			execution.ReasonCode = timeoutResult.ReasonCode
 			execution.EndTime = time.Now()
 			execution.Duration = timeoutResult.Duration
-			s.stateManager.SaveExecution(execution)
+			s.finishExecution(execution)
 
 			// Return VM to pool
-			if err := s.vmManager.ReturnVM(vmInstance.ID); err != nil {
-				s.logger.Errorf("Failed to return VM to pool: %v", err)
-			}
+			s.returnVM(request, vmInstance.ID)
 
 			// Send result to channel
 			resultChan <- timeoutResult
@@ -472,7 +821,7 @@ func (s *Scheduler) executeFunction(request *ExecutionRequest) (*ExecutionResult
 			// The daemon will send the result to the control plane via a callback
 
 			// Create accepted result
-			acceptedResult := &ExecutionResult{
+			acceptedResult := &types.ExecutionResult{
 				RequestID:  request.RequestID,
 				FunctionID: request.FunctionID,
 				StatusCode: 202, // Accepted
@@ -483,23 +832,77 @@ func (s *Scheduler) executeFunction(request *ExecutionRequest) (*ExecutionResult
 		}
 	}()
 
-	// For synchronous requests, wait for the result
+	// For synchronous requests, wait for the result until the deadline
 	if request.Sync {
-		result := <-resultChan
-		return result, nil
+		var deadline <-chan time.Time
+		if !request.Deadline.IsZero() {
+			timer := time.NewTimer(time.Until(request.Deadline))
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
+		select {
+		case result := <-resultChan:
+			result.Version = version
+			result.ColdStart = coldStart
+			return result, nil
+		case <-deadline:
+			// resultChan is buffered, so the execution finishes and records
+			// its result without anyone waiting
+			s.logger.Infof("Invoke deadline passed for execution %s, leaving it running", request.RequestID)
+			return &types.ExecutionResult{
+				RequestID:    request.RequestID,
+				FunctionID:   request.FunctionID,
+				StatusCode:   504, // Gateway Timeout
+				Status:       string(state.StatusRunning),
+				Version:      version,
+				Source:       types.SourcePlatform,
+				ErrorMessage: "invoke deadline exceeded; the execution continues and its result can be fetched later",
+			}, nil
+		}
 	}
 
 	// For asynchronous requests, return immediately
-	return &ExecutionResult{
+	return &types.ExecutionResult{
 		RequestID:  request.RequestID,
 		FunctionID: request.FunctionID,
 		StatusCode: 202, // Accepted
+		Version:    version,
 	}, nil
 }
 
+// returnVM returns the VM an execution ran on to its warm pool, unless the
+// request brought its own VM
+func (s *Scheduler) returnVM(request *ExecutionRequest, vmID string) {
+	if request.VM != nil {
+		return
+	}
+	if err := s.vmManager.ReturnVM(vmID); err != nil {
+		s.logger.Errorf("Failed to return VM to pool: %v", err)
+	}
+}
+
+// Stop stops the execution monitor and the async workers, waiting for the
+// executions the workers are running to finish. Requests still queued aren't
+// run.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.asyncQueue.Close()
+	s.workers.Wait()
+}
+
 // asyncWorker processes asynchronous execution requests
 func (s *Scheduler) asyncWorker() {
-	for request := range s.asyncQueue {
+	defer s.workers.Done()
+	for {
+		request := s.asyncQueue.Pop()
+		if request == nil {
+			return
+		}
+		if s.expireQueued(request) {
+			continue
+		}
+
 		s.logger.Infof("Processing async request %s for function %s", request.RequestID, request.FunctionID)
 		_, err := s.executeFunction(request)
 		if err != nil {
@@ -508,18 +911,78 @@ func (s *Scheduler) asyncWorker() {
 	}
 }
 
+// expireQueued fails a dequeued request that waited longer than the maximum
+// queue age, and reports whether the request should be skipped. Requests the
+// monitor already failed or that were cancelled are skipped as well.
+func (s *Scheduler) expireQueued(request *ExecutionRequest) bool {
+	if execution, err := s.stateManager.GetExecution(request.RequestID); err == nil && execution.Status != state.StatusQueued {
+		s.logger.Warnf("Skipping async request %s with status %s", request.RequestID, execution.Status)
+		return true
+	}
+
+	if time.Since(request.QueuedAt) <= getMaxQueueAge() {
+		return false
+	}
+
+	s.failQueued(&state.Execution{
+		ID:         request.RequestID,
+		FunctionID: request.FunctionID,
+		Version:    request.Version,
+		UserID:     request.UserID,
+		QueuedAt:   request.QueuedAt,
+		ParentID:   request.ParentID,
+		Attempt:    request.Attempt,
+		Tags:       request.Tags,
+	}, time.Now())
+	return true
+}
+
+// failQueued marks a queued execution as timed out in the queue
+func (s *Scheduler) failQueued(execution *state.Execution, now time.Time) {
+	s.logger.Warnf("Execution %s timed out after waiting %s in the queue", execution.ID, now.Sub(execution.QueuedAt).Round(time.Second))
+
+	execution.Status = state.StatusQueueTimeout
+	execution.Error = fmt.Sprintf("Execution timed out in queue after %s", getMaxQueueAge())
+	execution.EndTime = now
+	if err := s.finishExecution(execution); err != nil {
+		s.logger.Errorf("Failed to save execution %s: %v", execution.ID, err)
+	}
+}
+
+// expireQueuedExecutions fails executions that have been queued longer than
+// the maximum queue age, so polling clients see the timeout even while every
+// worker is busy
+func (s *Scheduler) expireQueuedExecutions(now time.Time) {
+	executions, err := s.stateManager.ListQueuedExecutions(now.Add(-getMaxQueueAge()))
+	if err != nil {
+		s.logger.Errorf("Failed to list queued executions: %v", err)
+		return
+	}
+
+	for i := range executions {
+		s.failQueued(&executions[i], now)
+	}
+}
+
 // monitorExecutions monitors active executions for timeouts
 func (s *Scheduler) monitorExecutions() {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
 	for {
-		<-ticker.C
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+		s.expireQueuedExecutions(time.Now())
+
 		s.mu.Lock()
 		now := time.Now()
+		cutoff := registry.MaxTimeout() + monitorGracePeriod
 		for requestID, context := range s.activeExecutions {
-			// Check if execution has been running for too long (more than 5 minutes)
-			if now.Sub(context.StartTime) > 5*time.Minute {
+			// Check if execution has been running longer than any function may run
+			if now.Sub(context.StartTime) > cutoff {
 				s.logger.Warnf("Execution %s has been running for too long, marking as timed out", requestID)
 
 				// Get the execution from the state manager
@@ -530,11 +993,13 @@ func (s *Scheduler) monitorExecutions() {
 				}
 
 				// Update execution status
-				execution.Status = "timeout"
+				execution.Status = state.StatusTimeout
 				execution.Error = "Execution timed out"
+				execution.ErrorType = "timeout"
+				execution.ReasonCode = state.ReasonTimeout
 				execution.EndTime = now
 				execution.Duration = now.Sub(context.StartTime).Milliseconds()
-				s.stateManager.SaveExecution(execution)
+				s.finishExecution(execution)
 
 				// Clean up the VM - since terminateVM is unexported, we'll use ReturnVM instead
 				// This isn't ideal but will work until a proper public termination method is available
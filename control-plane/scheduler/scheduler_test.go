@@ -0,0 +1,777 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/daemonclient"
+	"github.com/bluequbit/faas/control-plane/daemonclient/daemontest"
+	"github.com/bluequbit/faas/control-plane/registry"
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/types"
+	"github.com/bluequbit/faas/control-plane/vm"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// testVM is the VM executions are sent to; the mock daemon stands in for it
+var testVM = &state.VM{ID: "vm-test", IP: "127.0.0.1", Status: "ready"}
+
+// newTestScheduler creates a scheduler backed by a fresh database in a
+// temporary directory whose daemon requests go to a mock daemon running
+// executions with run. Results the daemon reports are recorded the way the
+// API's result handler records them.
+func newTestScheduler(t *testing.T, run daemontest.RunFunc) (*Scheduler, *daemontest.Daemon) {
+	t.Helper()
+
+	// The database is created in the working directory
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv(vm.EnvDataDir, dir)
+
+	daemon := daemontest.NewServer(run)
+	t.Cleanup(daemon.Close)
+	t.Setenv(daemonclient.EnvDaemonURL, daemon.URL)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	stateManager, err := state.NewStateManager(logger)
+	if err != nil {
+		t.Fatalf("failed to create state manager: %v", err)
+	}
+	vmManager, err := vm.NewVMManager(stateManager, logger, true)
+	if err != nil {
+		t.Fatalf("failed to create VM manager: %v", err)
+	}
+	t.Cleanup(vmManager.Cleanup)
+	functionRegistry, err := registry.NewFunctionRegistry(stateManager, logger)
+	if err != nil {
+		t.Fatalf("failed to create function registry: %v", err)
+	}
+	s, err := NewScheduler(vmManager, functionRegistry, stateManager, logger)
+	if err != nil {
+		t.Fatalf("failed to create scheduler: %v", err)
+	}
+	t.Cleanup(s.Stop)
+
+	results := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result types.ExecutionResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		execution, err := stateManager.GetExecution(result.RequestID)
+		if err != nil {
+			http.Error(w, "Execution not found", http.StatusNotFound)
+			return
+		}
+		execution.Status = state.StatusCompleted
+		execution.EndTime = time.Now()
+		if result.StatusCode == http.StatusOK {
+			execution.Logs = string(result.Output)
+		} else {
+			execution.Status = state.StatusFailed
+			execution.Error = result.ErrorMessage
+			execution.ErrorType = result.ErrorType
+		}
+		stateManager.SaveExecution(execution)
+		s.CacheResult(execution)
+		s.RetryFailed(execution)
+		s.NotifyCompletion(execution.FunctionID)
+	}))
+	t.Cleanup(results.Close)
+	daemon.ReportTo(results.URL, "")
+
+	return s, daemon
+}
+
+// registerTestFunction registers a function to execute on the mock daemon
+func registerTestFunction(t *testing.T, s *Scheduler) *registry.FunctionMetadata {
+	t.Helper()
+	function, err := s.functionRegistry.RegisterFunction(registry.FunctionRegistration{
+		Name:    "hello",
+		Runtime: "python3",
+		Memory:  128,
+		Timeout: 30,
+		Code:    registry.FunctionCode{Code: "def handler(event, context):\n    return event\n"},
+	})
+	if err != nil {
+		t.Fatalf("failed to register function: %v", err)
+	}
+	return function
+}
+
+// executionStatus returns the stored status of an execution
+func executionStatus(t *testing.T, s *Scheduler, requestID string) state.ExecutionStatus {
+	t.Helper()
+	execution, err := s.stateManager.GetExecution(requestID)
+	if err != nil {
+		t.Fatalf("execution %s not stored: %v", requestID, err)
+	}
+	return execution.Status
+}
+
+func TestExecuteSyncSuccess(t *testing.T) {
+	s, daemon := newTestScheduler(t, daemontest.Succeed(`{"message":"hi"}`))
+	function := registerTestFunction(t, s)
+
+	result, err := s.ExecuteOnVM(function.ID, map[string]interface{}{"name": "world"}, InvokeOptions{}, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	if result.StatusCode != http.StatusOK || result.Status != string(state.StatusCompleted) {
+		t.Fatalf("got status %d %q, want 200 completed: %s", result.StatusCode, result.Status, result.ErrorMessage)
+	}
+	if string(result.Output) != `{"message":"hi"}` {
+		t.Errorf("output = %s", result.Output)
+	}
+	if result.Source != types.SourceFunction {
+		t.Errorf("source = %q, want %q", result.Source, types.SourceFunction)
+	}
+	if status := executionStatus(t, s, result.RequestID); status != state.StatusCompleted {
+		t.Errorf("stored status = %q, want completed", status)
+	}
+
+	payloads := daemon.Payloads()
+	if len(payloads) != 1 {
+		t.Fatalf("daemon received %d requests, want 1", len(payloads))
+	}
+	if payloads[0].RequestID != result.RequestID || payloads[0].FunctionID != function.ID {
+		t.Errorf("daemon received request %s of function %s", payloads[0].RequestID, payloads[0].FunctionID)
+	}
+	if payloads[0].Event["name"] != "world" {
+		t.Errorf("daemon received event %v", payloads[0].Event)
+	}
+}
+
+func TestExecuteSyncFunctionFailure(t *testing.T) {
+	s, _ := newTestScheduler(t, func(payload *daemontest.Payload) *types.ExecutionResult {
+		return &types.ExecutionResult{
+			RequestID:    payload.RequestID,
+			FunctionID:   payload.FunctionID,
+			StatusCode:   http.StatusInternalServerError,
+			ErrorMessage: "boom",
+			ErrorType:    "runtime",
+		}
+	})
+	function := registerTestFunction(t, s)
+
+	result, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{}, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	if result.Status != string(state.StatusFailed) || result.ErrorMessage != "boom" {
+		t.Fatalf("got status %q error %q, want the function's failure", result.Status, result.ErrorMessage)
+	}
+	if result.Source != types.SourceFunction {
+		t.Errorf("source = %q, want %q", result.Source, types.SourceFunction)
+	}
+}
+
+func TestExecuteDaemonUnreachable(t *testing.T) {
+	s, daemon := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+	daemon.Close()
+
+	result, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{}, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	if result.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status code = %d, want 500", result.StatusCode)
+	}
+	if result.Source != types.SourcePlatform {
+		t.Errorf("source = %q, want %q", result.Source, types.SourcePlatform)
+	}
+	if status := executionStatus(t, s, result.RequestID); status != state.StatusFailed {
+		t.Errorf("stored status = %q, want failed", status)
+	}
+}
+
+func TestExecuteDaemonRejects(t *testing.T) {
+	s, daemon := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+	daemon.Reject(http.StatusTooManyRequests)
+
+	// The rejection fails the execution without waiting for a result
+	start := time.Now()
+	result, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{}, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("rejected execution took %v to fail", elapsed)
+	}
+	if result.StatusCode != http.StatusBadGateway {
+		t.Errorf("status code = %d, want 502", result.StatusCode)
+	}
+	if !strings.Contains(result.ErrorMessage, "status 429") || !strings.Contains(result.ErrorMessage, "Execution rejected") {
+		t.Errorf("error = %q, want the daemon's status and message", result.ErrorMessage)
+	}
+	if result.Source != types.SourcePlatform {
+		t.Errorf("source = %q, want %q", result.Source, types.SourcePlatform)
+	}
+	if status := executionStatus(t, s, result.RequestID); status != state.StatusFailed {
+		t.Errorf("stored status = %q, want failed", status)
+	}
+}
+
+func TestExecuteTimeout(t *testing.T) {
+	t.Setenv(EnvExecutionTimeoutGrace, "0")
+	s, _ := newTestScheduler(t, daemontest.Hang)
+	function := registerTestFunction(t, s)
+
+	result, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{Timeout: 1}, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	if result.Status != string(state.StatusTimeout) || result.ReasonCode != state.ReasonTimeout {
+		t.Fatalf("got status %q reason %q, want a timeout", result.Status, result.ReasonCode)
+	}
+	if result.Source != types.SourceFunction {
+		t.Errorf("source = %q, want %q", result.Source, types.SourceFunction)
+	}
+	if status := executionStatus(t, s, result.RequestID); status != state.StatusTimeout {
+		t.Errorf("stored status = %q, want timeout", status)
+	}
+}
+
+func TestSecretResolvedIntoPayload(t *testing.T) {
+	s, daemon := newTestScheduler(t, daemontest.Succeed("{}"))
+	if _, err := s.functionRegistry.SetSecret("db-password", "hunter2"); err != nil {
+		t.Fatalf("SetSecret: %v", err)
+	}
+	function, err := s.functionRegistry.RegisterFunction(registry.FunctionRegistration{
+		Name:        "hello",
+		Runtime:     "python3",
+		Memory:      128,
+		Timeout:     30,
+		Code:        registry.FunctionCode{Code: "def handler(event, context):\n    return event\n"},
+		Environment: map[string]string{"DB_HOST": "db"},
+		Secrets:     map[string]string{"DB_PASS": "db-password"},
+	})
+	if err != nil {
+		t.Fatalf("RegisterFunction: %v", err)
+	}
+
+	if _, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{}, testVM); err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	payloads := daemon.Payloads()
+	if len(payloads) != 1 {
+		t.Fatalf("daemon received %d payloads, want 1", len(payloads))
+	}
+	if env := payloads[0].Environment; env["DB_PASS"] != "hunter2" || env["DB_HOST"] != "db" {
+		t.Errorf("payload environment = %v, want the secret resolved", env)
+	}
+
+	// Only the reference is stored with the function
+	stored, err := s.stateManager.GetFunction(function.ID)
+	if err != nil {
+		t.Fatalf("GetFunction: %v", err)
+	}
+	if _, ok := stored.Environment["DB_PASS"]; ok || stored.SecretRefs["DB_PASS"] != "db-password" {
+		t.Errorf("stored environment %v with secrets %v, want only the reference", stored.Environment, stored.SecretRefs)
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Errorf("stored function contains the secret value: %s", data)
+	}
+}
+
+func TestTimeoutOverride(t *testing.T) {
+	t.Setenv(EnvExecutionTimeoutGrace, "0")
+	s, daemon := newTestScheduler(t, daemontest.Hang)
+	function := registerTestFunction(t, s)
+
+	// The override, not the function's 30 seconds, bounds the execution
+	start := time.Now()
+	result, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{Timeout: 1}, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("execution timed out after %v, want about 1s", elapsed)
+	}
+	if result.Status != string(state.StatusTimeout) || result.ReasonCode != state.ReasonTimeout {
+		t.Errorf("status = %q with reason code %q, want a timeout", result.Status, result.ReasonCode)
+	}
+	if stored, err := s.stateManager.GetExecution(result.RequestID); err != nil || stored.ReasonCode != state.ReasonTimeout {
+		t.Errorf("stored execution doesn't carry the %s reason code: %v", state.ReasonTimeout, err)
+	}
+	if payloads := daemon.Payloads(); len(payloads) != 1 || payloads[0].Timeout != 1 {
+		t.Errorf("daemon received %+v, want a 1s timeout", payloads)
+	}
+}
+
+func TestDispatchTimeout(t *testing.T) {
+	t.Setenv(EnvDispatchTimeout, "1")
+	s, _ := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+
+	// A daemon that never acknowledges the request
+	release := make(chan struct{})
+	unresponsive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer unresponsive.Close()
+	defer close(release)
+	t.Setenv(daemonclient.EnvDaemonURL, unresponsive.URL)
+	daemon, err := daemonclient.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.daemon = daemon
+
+	start := time.Now()
+	result, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{}, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	// The function's 30 second timeout doesn't apply to the dispatch
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("dispatch failed after %v, want about 1s", elapsed)
+	}
+	if !strings.Contains(result.ErrorMessage, "Daemon unreachable") {
+		t.Errorf("error = %q, want the daemon reported unreachable", result.ErrorMessage)
+	}
+}
+
+func TestExecutionTimeout(t *testing.T) {
+	t.Setenv(EnvExecutionTimeoutGrace, "0")
+	s, _ := newTestScheduler(t, daemontest.Hang)
+	function := registerTestFunction(t, s)
+
+	// The daemon acknowledges the request but never reports a result
+	result, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{Timeout: 1}, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	if result.Status != string(state.StatusTimeout) || !strings.Contains(result.ErrorMessage, "Function too slow") {
+		t.Errorf("result = %q %q, want a timeout with the function reported too slow", result.Status, result.ErrorMessage)
+	}
+}
+
+func TestTimeoutDefaultsToFunction(t *testing.T) {
+	s, daemon := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+
+	if _, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{}, testVM); err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	if payloads := daemon.Payloads(); len(payloads) != 1 || payloads[0].Timeout != function.Timeout {
+		t.Errorf("daemon received %+v, want the function's %ds timeout", payloads, function.Timeout)
+	}
+}
+
+func TestPayloadContext(t *testing.T) {
+	s, daemon := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+
+	result, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{Timeout: 5}, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	payloads := daemon.Payloads()
+	if len(payloads) != 1 {
+		t.Fatalf("daemon received %d payloads, want 1", len(payloads))
+	}
+	want := map[string]interface{}{
+		"request_id":        result.RequestID,
+		"function_name":     function.Name,
+		"function_version":  function.Version,
+		"memory_limit_mb":   float64(function.Memory),
+		"remaining_time_ms": float64(5000),
+	}
+	for key, value := range want {
+		if got := payloads[0].Context[key]; got != value {
+			t.Errorf("context %s = %v, want %v", key, got, value)
+		}
+	}
+}
+
+func TestExecuteDeadlineExceeded(t *testing.T) {
+	s, _ := newTestScheduler(t, daemontest.Hang)
+	function := registerTestFunction(t, s)
+
+	opts := InvokeOptions{Deadline: time.Now().Add(100 * time.Millisecond)}
+	result, err := s.ExecuteOnVM(function.ID, nil, opts, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	if result.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("status code = %d, want 504", result.StatusCode)
+	}
+	if result.Source != types.SourcePlatform {
+		t.Errorf("source = %q, want %q", result.Source, types.SourcePlatform)
+	}
+	// The execution keeps running past the invoke's deadline
+	if status := executionStatus(t, s, result.RequestID); status != state.StatusRunning {
+		t.Errorf("stored status = %q, want running", status)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	s, daemon := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+	if _, err := s.functionRegistry.SetConcurrency(function.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	running := &state.Execution{ID: "exec-running", FunctionID: function.ID, Status: state.StatusRunning, StartTime: time.Now()}
+	if err := s.stateManager.SaveExecution(running); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sync := range []bool{true, false} {
+		if _, err := s.ScheduleExecution(function.ID, nil, InvokeOptions{}, sync); !errors.Is(err, ErrConcurrencyLimit) {
+			t.Errorf("ScheduleExecution(sync=%v) error = %v, want %v", sync, err, ErrConcurrencyLimit)
+		}
+	}
+	if len(daemon.Payloads()) != 0 {
+		t.Error("a rejected execution reached the daemon")
+	}
+
+	// The limit frees up once the running execution ends
+	running.Status = state.StatusCompleted
+	running.EndTime = time.Now()
+	if err := s.stateManager.SaveExecution(running); err != nil {
+		t.Fatal(err)
+	}
+	limited, err := s.functionRegistry.GetFunction(function.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkConcurrency(limited); err != nil {
+		t.Errorf("checkConcurrency() = %v after the execution ended", err)
+	}
+}
+
+// queuedExecution stores an asynchronous execution that has been waiting in
+// the queue since queuedAt, as if every worker were busy
+func queuedExecution(t *testing.T, s *Scheduler, id, functionID string, queuedAt time.Time) {
+	t.Helper()
+	execution := &state.Execution{ID: id, FunctionID: functionID, Status: state.StatusQueued, QueuedAt: queuedAt}
+	if err := s.stateManager.SaveExecution(execution); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// asyncRequest returns the request of an async execution of function that
+// runs on testVM, as the test scheduler can't allocate VMs
+func asyncRequest(function *registry.FunctionMetadata, id string) *ExecutionRequest {
+	return &ExecutionRequest{
+		FunctionID:   function.ID,
+		FunctionName: function.Name,
+		Version:      function.Version,
+		RequestID:    id,
+		Attempt:      1,
+		VM:           testVM,
+	}
+}
+
+func TestQueuedExecutionTimesOut(t *testing.T) {
+	t.Setenv(EnvMaxQueueAge, "60")
+	s, _ := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+	now := time.Now()
+	queuedExecution(t, s, "exec-stale", function.ID, now.Add(-61*time.Second))
+	queuedExecution(t, s, "exec-fresh", function.ID, now.Add(-59*time.Second))
+
+	result, err := s.GetExecutionResult("exec-stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != string(state.StatusQueued) || result.StatusCode != http.StatusAccepted {
+		t.Fatalf("queued execution reported as %d %q, want 202 queued", result.StatusCode, result.Status)
+	}
+
+	s.expireQueuedExecutions(now)
+
+	result, err = s.GetExecutionResult("exec-stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != string(state.StatusQueueTimeout) || result.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("stale execution reported as %d %q, want 504 %s", result.StatusCode, result.Status, state.StatusQueueTimeout)
+	}
+	if status := executionStatus(t, s, "exec-fresh"); status != state.StatusQueued {
+		t.Errorf("execution within the queue age has status %q, want queued", status)
+	}
+}
+
+func TestExpiredRequestSkippedByWorker(t *testing.T) {
+	t.Setenv(EnvMaxQueueAge, "60")
+	s, daemon := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+	queuedAt := time.Now().Add(-2 * time.Minute)
+	queuedExecution(t, s, "exec-stale", function.ID, queuedAt)
+
+	// A worker that frees up after the queue age fails the request instead
+	// of running it
+	if !s.expireQueued(&ExecutionRequest{RequestID: "exec-stale", FunctionID: function.ID, QueuedAt: queuedAt}) {
+		t.Fatal("worker would run a request past the queue age")
+	}
+	if status := executionStatus(t, s, "exec-stale"); status != state.StatusQueueTimeout {
+		t.Errorf("stored status = %q, want %s", status, state.StatusQueueTimeout)
+	}
+	if len(daemon.Payloads()) != 0 {
+		t.Error("an expired request reached the daemon")
+	}
+
+	queuedExecution(t, s, "exec-fresh", function.ID, time.Now())
+	if s.expireQueued(&ExecutionRequest{RequestID: "exec-fresh", FunctionID: function.ID, QueuedAt: time.Now()}) {
+		t.Error("worker would skip a request within the queue age")
+	}
+}
+
+func TestCancelQueuedExecution(t *testing.T) {
+	s, daemon := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+
+	// Stop the workers so that requests wait in the queue as if every worker
+	// were busy
+	s.asyncQueue.Close()
+	s.workers.Wait()
+	s.asyncQueue = newFairQueue(100)
+
+	// More requests than the two workers started below
+	var ids []string
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("exec-%d", i)
+		ids = append(ids, id)
+		if _, err := s.enqueue(asyncRequest(function, id)); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+
+	cancelled := ids[3]
+	execution, err := s.CancelExecution(cancelled)
+	if err != nil {
+		t.Fatalf("CancelExecution: %v", err)
+	}
+	if execution.Status != state.StatusCancelled {
+		t.Errorf("cancelled execution has status %q", execution.Status)
+	}
+	if _, err := s.CancelExecution("exec-missing"); !errors.Is(err, ErrExecutionNotFound) {
+		t.Errorf("cancelling an unknown execution: error = %v, want %v", err, ErrExecutionNotFound)
+	}
+
+	for i := 0; i < 2; i++ {
+		s.workers.Add(1)
+		go s.asyncWorker()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range ids {
+		if id == cancelled {
+			continue
+		}
+		for executionStatus(t, s, id) != state.StatusCompleted {
+			if time.Now().After(deadline) {
+				t.Fatalf("execution %s didn't complete", id)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	payloads := daemon.Payloads()
+	if len(payloads) != len(ids)-1 {
+		t.Errorf("daemon received %d requests, want %d", len(payloads), len(ids)-1)
+	}
+	for _, payload := range payloads {
+		if payload.RequestID == cancelled {
+			t.Error("a cancelled execution reached the daemon")
+		}
+	}
+	if status := executionStatus(t, s, cancelled); status != state.StatusCancelled {
+		t.Errorf("stored status = %q, want %s", status, state.StatusCancelled)
+	}
+	if _, err := s.CancelExecution(ids[0]); !errors.Is(err, ErrExecutionStarted) {
+		t.Errorf("cancelling a completed execution: error = %v, want %v", err, ErrExecutionStarted)
+	}
+}
+
+func TestExecutePinnedVersion(t *testing.T) {
+	s, daemon := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+	const newCode = "def handler(event, context):\n    return {'v': 2}\n"
+	updated, err := s.functionRegistry.UpdateFunction(function.ID, 0, newCode, "", "", 0)
+	if err != nil {
+		t.Fatalf("UpdateFunction: %v", err)
+	}
+
+	result, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{Version: function.Version}, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	if result.Version != function.Version {
+		t.Errorf("served version = %s, want %s", result.Version, function.Version)
+	}
+	execution, err := s.stateManager.GetExecution(result.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if execution.Version != function.Version {
+		t.Errorf("stored version = %s, want %s", execution.Version, function.Version)
+	}
+
+	// Without a pin the latest version runs
+	if _, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{}, testVM); err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	payloads := daemon.Payloads()
+	if len(payloads) != 2 {
+		t.Fatalf("daemon received %d requests, want 2", len(payloads))
+	}
+	if payloads[0].Version != function.Version || payloads[0].Code == newCode {
+		t.Errorf("pinned execution ran version %s with code %q", payloads[0].Version, payloads[0].Code)
+	}
+	if payloads[1].Version != updated.Version || payloads[1].Code != newCode {
+		t.Errorf("unpinned execution ran version %s with code %q", payloads[1].Version, payloads[1].Code)
+	}
+
+	if _, err := s.ExecuteOnVM(function.ID, nil, InvokeOptions{Version: "9.9.9"}, testVM); !errors.Is(err, registry.ErrVersionNotFound) {
+		t.Errorf("ExecuteOnVM(9.9.9) error = %v, want %v", err, registry.ErrVersionNotFound)
+	}
+}
+
+func TestCanaryTrafficSplit(t *testing.T) {
+	s, _ := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+	const newCode = "def handler(event, context):\n    return {'v': 2}\n"
+	canary, err := s.functionRegistry.UpdateFunction(function.ID, 0, newCode, "", "", 20)
+	if err != nil {
+		t.Fatalf("UpdateFunction: %v", err)
+	}
+
+	const invokes = 10000
+	counts := make(map[string]int)
+	for i := 0; i < invokes; i++ {
+		version, err := s.resolveVersion(canary, "")
+		if err != nil {
+			t.Fatalf("resolveVersion: %v", err)
+		}
+		counts[version]++
+	}
+	if len(counts) != 2 || counts[canary.Version]+counts[function.Version] != invokes {
+		t.Fatalf("invokes went to %v, want only %s and %s", counts, canary.Version, function.Version)
+	}
+	if share := 100 * counts[canary.Version] / invokes; share < 17 || share > 23 {
+		t.Errorf("canary received %d%% of invokes, want about 20%%", share)
+	}
+
+	// A pinned invoke ignores the split
+	if version, _ := s.resolveVersion(canary, function.Version); version != function.Version {
+		t.Errorf("pinned invoke ran version %s, want %s", version, function.Version)
+	}
+}
+
+func TestSplitVersion(t *testing.T) {
+	canary := &registry.FunctionMetadata{Version: "1.0.1", StableVersion: "1.0.0", CanaryPercent: 20}
+	tests := []struct {
+		name     string
+		function *registry.FunctionMetadata
+		roll     int
+		want     string
+	}{
+		{"no canary", &registry.FunctionMetadata{Version: "1.0.0"}, 0, "1.0.0"},
+		{"roll below the split", canary, 0, "1.0.1"},
+		{"last roll to the canary", canary, 19, "1.0.1"},
+		{"roll at the split", canary, 20, "1.0.0"},
+		{"highest roll", canary, 99, "1.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitVersion(tt.function, tt.roll); got != tt.want {
+				t.Errorf("splitVersion() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// payloadSamples returns the number of sizes a payload histogram observed
+// for a function
+func payloadSamples(t *testing.T, histogram *prometheus.HistogramVec, functionID string) uint64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := histogram.WithLabelValues(functionID).(prometheus.Histogram).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestInputBytesRecorded(t *testing.T) {
+	s, _ := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+
+	input := map[string]interface{}{"name": "world", "count": 3}
+	result, err := s.ExecuteOnVM(function.ID, input, InvokeOptions{}, testVM)
+	if err != nil {
+		t.Fatalf("ExecuteOnVM: %v", err)
+	}
+	data, _ := json.Marshal(input)
+	execution, err := s.stateManager.GetExecution(result.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if execution.InputBytes != int64(len(data)) {
+		t.Errorf("input bytes = %d, want %d", execution.InputBytes, len(data))
+	}
+	if samples := payloadSamples(t, inputBytes, function.ID); samples != 1 {
+		t.Errorf("input histogram observed %d sizes, want 1", samples)
+	}
+}
+
+func TestExecutionResultSource(t *testing.T) {
+	s, _ := newTestScheduler(t, daemontest.Succeed("{}"))
+	function := registerTestFunction(t, s)
+
+	tests := []struct {
+		execution state.Execution
+		want      string
+	}{
+		{state.Execution{ID: "exec-completed", Status: state.StatusCompleted}, types.SourceFunction},
+		{state.Execution{ID: "exec-exception", Status: state.StatusFailed, Error: "division by zero", ErrorType: "exception"}, types.SourceFunction},
+		{state.Execution{ID: "exec-timeout", Status: state.StatusTimeout, Error: "Function too slow", ErrorType: "timeout"}, types.SourceFunction},
+		{state.Execution{ID: "exec-no-vm", Status: state.StatusFailed, Error: "failed to allocate VM"}, types.SourcePlatform},
+		{state.Execution{ID: "exec-stale", Status: state.StatusQueueTimeout}, types.SourcePlatform},
+		// Unfinished executions have no source yet
+		{state.Execution{ID: "exec-running", Status: state.StatusRunning}, ""},
+	}
+	for _, tt := range tests {
+		execution := tt.execution
+		execution.FunctionID = function.ID
+		if err := s.stateManager.SaveExecution(&execution); err != nil {
+			t.Fatal(err)
+		}
+		result, err := s.GetExecutionResult(execution.ID)
+		if err != nil {
+			t.Fatalf("GetExecutionResult(%s): %v", execution.ID, err)
+		}
+		if result.Source != tt.want {
+			t.Errorf("%s has source %q, want %q", execution.ID, result.Source, tt.want)
+		}
+	}
+}
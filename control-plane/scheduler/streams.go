@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"sync"
+
+	"github.com/bluequbit/faas/control-plane/types"
+)
+
+// streams forwards the chunks reported for an execution to the caller of a
+// streaming invoke, by request ID
+type streams struct {
+	mu          sync.Mutex
+	subscribers map[string]*stream
+}
+
+// stream is the subscriber of one execution's chunks
+type stream struct {
+	mu     sync.Mutex
+	ch     chan<- *types.StreamChunk
+	closed bool
+}
+
+func newStreams() *streams {
+	return &streams{subscribers: make(map[string]*stream)}
+}
+
+// open starts forwarding the execution's chunks to ch
+func (s *streams) open(requestID string, ch chan<- *types.StreamChunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[requestID] = &stream{ch: ch}
+}
+
+// close stops forwarding the execution's chunks. Once it returns no more
+// chunks are sent, so the caller may close the channel.
+func (s *streams) close(requestID string) {
+	s.mu.Lock()
+	sub, ok := s.subscribers[requestID]
+	delete(s.subscribers, requestID)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	sub.mu.Lock()
+	sub.closed = true
+	sub.mu.Unlock()
+}
+
+// publish sends a chunk to the execution's subscriber, blocking until it is
+// received, and reports whether there was one
+func (s *streams) publish(chunk *types.StreamChunk) bool {
+	s.mu.Lock()
+	sub, ok := s.subscribers[chunk.RequestID]
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return false
+	}
+	sub.ch <- chunk
+	return true
+}
+
+// PublishChunk forwards a chunk reported by a daemon to the streaming invoke
+// waiting for it, and reports whether one was. Chunks of executions nobody
+// streams are dropped; their items are still part of the result.
+func (s *Scheduler) PublishChunk(chunk *types.StreamChunk) bool {
+	return s.streams.publish(chunk)
+}
@@ -0,0 +1,32 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/bluequbit/faas/control-plane/types"
+)
+
+func TestPublishChunk(t *testing.T) {
+	s := &Scheduler{streams: newStreams()}
+	chunks := make(chan *types.StreamChunk, 1)
+	s.streams.open("req-1", chunks)
+
+	if !s.PublishChunk(&types.StreamChunk{RequestID: "req-1", Seq: 0, Data: []byte(`"hi"`)}) {
+		t.Fatal("chunk wasn't forwarded to the streaming invoke")
+	}
+	if chunk := <-chunks; chunk.Seq != 0 || string(chunk.Data) != `"hi"` {
+		t.Errorf("received chunk %d %s", chunk.Seq, chunk.Data)
+	}
+
+	// Chunks of executions nobody streams are dropped
+	if s.PublishChunk(&types.StreamChunk{RequestID: "req-2"}) {
+		t.Error("chunk of an execution nobody streams was forwarded")
+	}
+
+	// Nothing is sent once the stream is closed, so the channel may be closed
+	s.streams.close("req-1")
+	close(chunks)
+	if s.PublishChunk(&types.StreamChunk{RequestID: "req-1", Seq: 1}) {
+		t.Error("chunk forwarded after the stream closed")
+	}
+}
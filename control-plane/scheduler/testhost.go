@@ -0,0 +1,57 @@
+package scheduler
+
+import (
+	"fmt"
+
+	"github.com/bluequbit/faas/control-plane/registry"
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/bluequbit/faas/control-plane/types"
+	"github.com/google/uuid"
+)
+
+// ExecuteOnVM runs a function synchronously on the given VM, such as the
+// test host VM of test mode, skipping VM allocation and the warm pools. The
+// VM is left as it is afterwards. Results are never served from the cache
+// and rate and concurrency limits don't apply, so every call runs the latest handler.
+func (s *Scheduler) ExecuteOnVM(functionID string, input map[string]interface{}, opts InvokeOptions, vmInstance *state.VM) (*types.ExecutionResult, error) {
+	function, err := s.functionRegistry.GetFunction(functionID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFunctionNotFound, err)
+	}
+	if function.Status == registry.StatusDisabled {
+		return nil, fmt.Errorf("%w: %s", registry.ErrFunctionDisabled, function.Name)
+	}
+	if function.Status == registry.StatusCorrupt {
+		return nil, fmt.Errorf("%w: %s, redeploy it", registry.ErrCorruptStorage, function.Name)
+	}
+
+	version, err := s.resolveVersion(function, opts.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	// Forward the chunks of a streaming invoke until it returns
+	requestID := uuid.New().String()
+	if opts.Chunks != nil {
+		s.streams.open(requestID, opts.Chunks)
+		defer s.streams.close(requestID)
+	}
+
+	s.logger.Infof("Executing function %s directly on VM %s", function.Name, vmInstance.ID)
+	return s.executeFunction(&ExecutionRequest{
+		FunctionID:   function.ID,
+		FunctionName: function.Name,
+		Input:        input,
+		Event:        input, // Use input as event for backward compatibility
+		Environment:  opts.Environment,
+		Version:      version,
+		UserID:       opts.UserID,
+		Deadline:     opts.Deadline,
+		Timeout:      opts.Timeout,
+		Sync:         true,
+		RequestID:    requestID,
+		Attempt:      1,
+		Tags:         opts.Tags,
+		VM:           vmInstance,
+	})
+}
@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/registry"
+	"github.com/bluequbit/faas/control-plane/vm"
+)
+
+// ErrInvalidEntryPoint is returned when a function's entry point doesn't name
+// a callable accepting (event, context)
+var ErrInvalidEntryPoint = errors.New("invalid entry point")
+
+// validateTimeout bounds a daemon entry point probe, including VM allocation
+const validateTimeout = 15 * time.Second
+
+// ValidateEntryPoint asks a daemon to check that the entry point exists in the
+// given code and accepts (event, context). It returns an error wrapping
+// ErrInvalidEntryPoint if the check fails; any other error means the probe
+// itself could not be run.
+func (s *Scheduler) ValidateEntryPoint(runtime, entryPoint string, code *registry.FunctionCode) error {
+	payloadJSON, err := json.Marshal(map[string]interface{}{
+		"runtime":      runtime,
+		"entry_point":  entryPoint,
+		"code":         code.Code,
+		"requirements": code.Requirements,
+		"config":       code.Config,
+		"files":        code.Files,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal validation payload: %v", err)
+	}
+
+	vmInstance, _, err := s.vmManager.GetVMForFunction(runtime, 0, vm.PrioritySync)
+	if err != nil {
+		return fmt.Errorf("failed to allocate VM: %v", err)
+	}
+	defer func() {
+		if err := s.vmManager.ReturnVM(vmInstance.ID); err != nil {
+			s.logger.Errorf("Failed to return VM to pool: %v", err)
+		}
+	}()
+
+	resp, err := s.daemon.Post(s.daemon.URL(vmInstance.IP, "/validate"), "application/json", bytes.NewBuffer(payloadJSON), validateTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to send validation request to daemon: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("daemon validation failed with status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Valid bool   `json:"valid"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to parse validation response: %v", err)
+	}
+	if !result.Valid {
+		return fmt.Errorf("%w: %s", ErrInvalidEntryPoint, result.Error)
+	}
+	return nil
+}
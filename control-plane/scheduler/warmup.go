@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/registry"
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+// maxWarmupFunctions is how many functions' requirements are pre-installed
+// on each new warm VM, starting with the most recently updated
+const maxWarmupFunctions = 3
+
+// warmupTimeout bounds a daemon's install of one function's requirements
+const warmupTimeout = 5 * time.Minute
+
+// warmupVM pre-installs the requirements of recently updated functions on a
+// new warm VM so their first execution there skips pip
+func (s *Scheduler) warmupVM(vmInstance *state.VM) {
+	functions, err := s.functionRegistry.ListFunctions()
+	if err != nil {
+		s.logger.Errorf("Failed to list functions for warmup: %v", err)
+		return
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].UpdatedAt.After(functions[j].UpdatedAt)
+	})
+
+	warmed := 0
+	for i := range functions {
+		if warmed == maxWarmupFunctions {
+			return
+		}
+		function := &functions[i]
+		if function.Status == registry.StatusDisabled || function.Status == registry.StatusCorrupt {
+			continue
+		}
+		// The VM only runs functions whose runtime boots from its image
+		if s.vmManager.PoolRuntime(function.Runtime) != vmInstance.Runtime {
+			continue
+		}
+		code, err := s.functionRegistry.GetFunctionCode(function.ID)
+		if err != nil || code.Requirements == "" {
+			continue
+		}
+
+		warmed++
+		duration, err := s.warmupFunction(vmInstance, function, code)
+		if err != nil {
+			s.logger.Warnf("Failed to warm up function %s on VM %s: %v", function.Name, vmInstance.ID, err)
+			continue
+		}
+		s.logger.Infof("Warmed up function %s on VM %s in %v", function.Name, vmInstance.ID, duration)
+	}
+}
+
+// warmupFunction asks the daemon to install a function's requirements and
+// returns how long the install took
+func (s *Scheduler) warmupFunction(vmInstance *state.VM, function *registry.FunctionMetadata, code *registry.FunctionCode) (time.Duration, error) {
+	payloadJSON, err := json.Marshal(map[string]interface{}{
+		"function_id":  function.ID,
+		"name":         function.Name,
+		"runtime":      function.Runtime,
+		"requirements": code.Requirements,
+		"environment":  function.Environment,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal warmup payload: %v", err)
+	}
+
+	resp, err := s.daemon.Post(s.daemon.URL(vmInstance.IP, "/warmup"), "application/json", bytes.NewBuffer(payloadJSON), warmupTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send warmup request to daemon: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return 0, fmt.Errorf("daemon warmup failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Duration int64 `json:"duration_ms"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to parse warmup response: %v", err)
+	}
+	return time.Duration(result.Duration) * time.Millisecond, nil
+}
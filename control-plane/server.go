@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// HTTP server environment variables. Deploy routes get longer read and write
+// deadlines, see api.EnvHTTPDeployTimeout, and streaming responses have no
+// write deadline.
+const (
+	EnvHTTPReadTimeout    = "FAAS_HTTP_READ_TIMEOUT_SECONDS"  // Reading a whole request, body included
+	EnvHTTPWriteTimeout   = "FAAS_HTTP_WRITE_TIMEOUT_SECONDS" // From the end of the request headers until the response is written
+	EnvHTTPIdleTimeout    = "FAAS_HTTP_IDLE_TIMEOUT_SECONDS"  // Keep-alive connections between requests
+	EnvHTTPMaxHeaderBytes = "FAAS_HTTP_MAX_HEADER_BYTES"
+)
+
+// newServer creates the control plane's HTTP server with the timeouts and
+// header limit set in the environment
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           addr,
+		Handler:        handler,
+		ReadTimeout:    secondsEnv(EnvHTTPReadTimeout, 10*time.Second),
+		WriteTimeout:   secondsEnv(EnvHTTPWriteTimeout, 10*time.Second),
+		IdleTimeout:    secondsEnv(EnvHTTPIdleTimeout, 120*time.Second),
+		MaxHeaderBytes: intEnv(EnvHTTPMaxHeaderBytes, http.DefaultMaxHeaderBytes),
+	}
+}
+
+// secondsEnv returns the positive number of seconds in the environment
+// variable name, or fallback when it is unset or invalid
+func secondsEnv(name string, fallback time.Duration) time.Duration {
+	return time.Duration(intEnv(name, int(fallback/time.Second))) * time.Second
+}
+
+// intEnv returns the positive integer in the environment variable name, or
+// fallback when it is unset or invalid
+func intEnv(name string, fallback int) int {
+	if val, err := strconv.Atoi(os.Getenv(name)); err == nil && val > 0 {
+		return val
+	}
+	return fallback
+}
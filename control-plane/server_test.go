@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewServerDefaults(t *testing.T) {
+	for _, name := range []string{EnvHTTPReadTimeout, EnvHTTPWriteTimeout, EnvHTTPIdleTimeout, EnvHTTPMaxHeaderBytes} {
+		t.Setenv(name, "")
+	}
+	srv := newServer(":8080", http.NotFoundHandler())
+	if srv.ReadTimeout != 10*time.Second || srv.WriteTimeout != 10*time.Second || srv.IdleTimeout != 120*time.Second {
+		t.Errorf("timeouts = %v read, %v write, %v idle; want 10s, 10s and 2m", srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+	if srv.MaxHeaderBytes != http.DefaultMaxHeaderBytes {
+		t.Errorf("header limit = %d, want %d", srv.MaxHeaderBytes, http.DefaultMaxHeaderBytes)
+	}
+}
+
+func TestNewServerFromEnvironment(t *testing.T) {
+	t.Setenv(EnvHTTPReadTimeout, "60")
+	t.Setenv(EnvHTTPWriteTimeout, "90")
+	t.Setenv(EnvHTTPIdleTimeout, "300")
+	t.Setenv(EnvHTTPMaxHeaderBytes, "4096")
+	srv := newServer(":8080", http.NotFoundHandler())
+	if srv.ReadTimeout != time.Minute || srv.WriteTimeout != 90*time.Second || srv.IdleTimeout != 5*time.Minute {
+		t.Errorf("timeouts = %v read, %v write, %v idle; want 1m, 1m30s and 5m", srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+	if srv.MaxHeaderBytes != 4096 {
+		t.Errorf("header limit = %d, want 4096", srv.MaxHeaderBytes)
+	}
+
+	// Invalid values fall back to the defaults
+	t.Setenv(EnvHTTPReadTimeout, "-1")
+	t.Setenv(EnvHTTPIdleTimeout, "forever")
+	srv = newServer(":8080", http.NotFoundHandler())
+	if srv.ReadTimeout != 10*time.Second || srv.IdleTimeout != 120*time.Second {
+		t.Errorf("timeouts with invalid values = %v read, %v idle; want the defaults", srv.ReadTimeout, srv.IdleTimeout)
+	}
+}
@@ -0,0 +1,51 @@
+package state
+
+import (
+	"time"
+)
+
+// AuditEvent records a privileged operation and who performed it
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    string    `gorm:"index" json:"user_id"` // Empty when the request was not authenticated
+	Action    string    `gorm:"index" json:"action"`  // e.g. "function.delete"
+	Target    string    `json:"target"`
+	Result    string    `json:"result"` // "success" or "failure"
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `gorm:"index" json:"timestamp"`
+}
+
+// AuditFilter selects audit events. Empty fields and zero times are not
+// filtered on.
+type AuditFilter struct {
+	UserID string
+	Action string
+	Since  time.Time
+	Until  time.Time
+}
+
+// SaveAuditEvent appends an event to the audit log
+func (s *StateManager) SaveAuditEvent(event *AuditEvent) error {
+	return s.db.Create(event).Error
+}
+
+// ListAuditEvents retrieves the audit events matching the filter, newest first
+func (s *StateManager) ListAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	query := s.db.Order("timestamp DESC, id DESC")
+	if filter.UserID != "" {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("timestamp >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("timestamp < ?", filter.Until)
+	}
+
+	var events []AuditEvent
+	err := query.Find(&events).Error
+	return events, err
+}
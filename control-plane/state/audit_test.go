@@ -0,0 +1,52 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListAuditEvents(t *testing.T) {
+	s := newTestStateManager(t)
+	now := time.Now()
+	for _, event := range []*AuditEvent{
+		{UserID: "alice", Action: "function.create", Target: "f1", Result: "success", Timestamp: now.Add(-2 * time.Hour)},
+		{UserID: "alice", Action: "function.delete", Target: "f1", Result: "success", Timestamp: now.Add(-time.Hour)},
+		{UserID: "bob", Action: "function.delete", Target: "f2", Result: "failure", Timestamp: now},
+	} {
+		if err := s.SaveAuditEvent(event); err != nil {
+			t.Fatalf("SaveAuditEvent: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   []string // Targets and actions, newest first
+	}{
+		{"all", AuditFilter{}, []string{"f2 function.delete", "f1 function.delete", "f1 function.create"}},
+		{"by actor", AuditFilter{UserID: "alice"}, []string{"f1 function.delete", "f1 function.create"}},
+		{"by action", AuditFilter{Action: "function.delete"}, []string{"f2 function.delete", "f1 function.delete"}},
+		{"since", AuditFilter{Since: now.Add(-90 * time.Minute)}, []string{"f2 function.delete", "f1 function.delete"}},
+		{"until", AuditFilter{Until: now.Add(-90 * time.Minute)}, []string{"f1 function.create"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := s.ListAuditEvents(tt.filter)
+			if err != nil {
+				t.Fatalf("ListAuditEvents: %v", err)
+			}
+			var got []string
+			for _, event := range events {
+				got = append(got, event.Target+" "+event.Action)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package state
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// compressLogs gzip-compresses the logs of an execution into CompressedLogs
+// when they exceed threshold bytes. A threshold of zero disables compression.
+func compressLogs(execution *Execution, threshold int) error {
+	execution.LogsCompressed = false
+	execution.CompressedLogs = nil
+	if threshold <= 0 || len(execution.Logs) <= threshold {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(execution.Logs)); err != nil {
+		return fmt.Errorf("failed to compress execution output: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress execution output: %v", err)
+	}
+
+	execution.LogsCompressed = true
+	execution.CompressedLogs = buf.Bytes()
+	execution.Logs = ""
+	return nil
+}
+
+// decompressLogs restores the plain logs of an execution loaded from the database
+func decompressLogs(execution *Execution) error {
+	if !execution.LogsCompressed {
+		return nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(execution.CompressedLogs))
+	if err != nil {
+		return fmt.Errorf("failed to decompress output of execution %s: %v", execution.ID, err)
+	}
+	defer zr.Close()
+
+	logs, err := io.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("failed to decompress output of execution %s: %v", execution.ID, err)
+	}
+
+	execution.Logs = string(logs)
+	execution.LogsCompressed = false
+	execution.CompressedLogs = nil
+	return nil
+}
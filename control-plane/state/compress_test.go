@@ -0,0 +1,77 @@
+package state
+
+import (
+	"strings"
+	"testing"
+)
+
+// storedLogs returns the logs of an execution as stored in the database
+func storedLogs(t *testing.T, s *StateManager, id string) (string, []byte, bool) {
+	t.Helper()
+	var row struct {
+		Logs           string
+		CompressedLogs []byte
+		LogsCompressed bool
+	}
+	if err := s.db.Model(&Execution{}).Select("logs, compressed_logs, logs_compressed").Where("id = ?", id).Scan(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+	return row.Logs, row.CompressedLogs, row.LogsCompressed
+}
+
+func TestLargeOutputRoundTrips(t *testing.T) {
+	s := newTestStateManager(t)
+	output := `{"items": [` + strings.Repeat(`{"name": "item", "value": 42},`, 2000) + `{}]}`
+	execution := &Execution{ID: "exec-1", FunctionID: "fn-1", Status: StatusCompleted, Logs: output}
+	if err := s.SaveExecution(execution); err != nil {
+		t.Fatalf("SaveExecution: %v", err)
+	}
+	if execution.Logs != output {
+		t.Error("saving changed the caller's execution")
+	}
+
+	logs, compressed, flagged := storedLogs(t, s, "exec-1")
+	if !flagged || logs != "" {
+		t.Fatalf("output isn't stored compressed: flag %v, %d plain bytes", flagged, len(logs))
+	}
+	if len(compressed) >= len(output) {
+		t.Errorf("stored %d bytes for a %d byte output", len(compressed), len(output))
+	}
+
+	loaded, err := s.GetExecution("exec-1")
+	if err != nil {
+		t.Fatalf("GetExecution: %v", err)
+	}
+	if loaded.Logs != output {
+		t.Errorf("output didn't round-trip: got %d bytes, want %d", len(loaded.Logs), len(output))
+	}
+	listed, err := s.ListExecutions("fn-1")
+	if err != nil {
+		t.Fatalf("ListExecutions: %v", err)
+	}
+	if len(listed) != 1 || listed[0].Logs != output {
+		t.Error("listed execution output didn't round-trip")
+	}
+}
+
+func TestSmallOutputStoredPlain(t *testing.T) {
+	s := newTestStateManager(t)
+	if err := s.SaveExecution(&Execution{ID: "exec-1", Logs: `{"ok": true}`}); err != nil {
+		t.Fatalf("SaveExecution: %v", err)
+	}
+	if logs, _, flagged := storedLogs(t, s, "exec-1"); flagged || logs != `{"ok": true}` {
+		t.Errorf("small output stored as %q, compressed %v", logs, flagged)
+	}
+}
+
+func TestCompressionDisabled(t *testing.T) {
+	t.Setenv(EnvOutputCompressThreshold, "0")
+	s := newTestStateManager(t)
+	output := strings.Repeat("x", 64*1024)
+	if err := s.SaveExecution(&Execution{ID: "exec-1", Logs: output}); err != nil {
+		t.Fatalf("SaveExecution: %v", err)
+	}
+	if _, _, flagged := storedLogs(t, s, "exec-1"); flagged {
+		t.Error("output compressed with compression disabled")
+	}
+}
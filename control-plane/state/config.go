@@ -0,0 +1,24 @@
+package state
+
+import (
+	"os"
+	"strconv"
+)
+
+// Environment variable names
+const (
+	EnvOutputCompressThreshold = "FAAS_OUTPUT_COMPRESS_THRESHOLD"
+)
+
+// getCompressThreshold returns the size in bytes above which execution
+// outputs are stored compressed; zero disables compression
+func getCompressThreshold() int {
+	// Check environment variable first
+	if threshold := os.Getenv(EnvOutputCompressThreshold); threshold != "" {
+		if val, err := strconv.Atoi(threshold); err == nil && val >= 0 {
+			return val
+		}
+	}
+	// Default to 4KB
+	return 4 * 1024
+}
@@ -0,0 +1,32 @@
+package state
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Counter is a named, persistent sequence
+type Counter struct {
+	Name  string `gorm:"primaryKey"`
+	Value int64
+}
+
+// NextCounter increments the named counter and returns its new value. The
+// first call for a name returns 1.
+func (s *StateManager) NextCounter(name string) (int64, error) {
+	var counter Counter
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Increment in SQL so concurrent callers never read the same value
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Counter{Name: name}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&Counter{}).Where("name = ?", name).
+			Update("value", gorm.Expr("value + 1")).Error
+		if err != nil {
+			return err
+		}
+		return tx.First(&counter, "name = ?", name).Error
+	})
+	return counter.Value, err
+}
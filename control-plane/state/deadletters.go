@@ -0,0 +1,71 @@
+package state
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeadLetter keeps the request of an async execution that failed on the
+// last attempt its function's retry policy allows, so it can be requeued by
+// hand. Executions don't store their input otherwise.
+type DeadLetter struct {
+	ExecutionID string `gorm:"primaryKey"`
+	FunctionID  string `gorm:"index"`
+	Version     string
+	UserID      string
+	Input       map[string]interface{} `gorm:"serializer:json"`
+	Environment map[string]string      `gorm:"serializer:json"`
+	Tags        map[string]string      `gorm:"serializer:json"`
+	Timeout     int
+	CreatedAt   time.Time
+}
+
+// SaveDeadLetter saves the request of a dead-lettered execution
+func (s *StateManager) SaveDeadLetter(deadLetter *DeadLetter) error {
+	return s.db.Save(deadLetter).Error
+}
+
+// GetDeadLetter retrieves the request of a dead-lettered execution
+func (s *StateManager) GetDeadLetter(executionID string) (*DeadLetter, error) {
+	var deadLetter DeadLetter
+	if err := s.db.First(&deadLetter, "execution_id = ?", executionID).Error; err != nil {
+		return nil, err
+	}
+	return &deadLetter, nil
+}
+
+// DeleteDeadLetter deletes the request of a dead-lettered execution,
+// reporting gorm.ErrRecordNotFound if there is none. Only one of several
+// concurrent calls for the same execution succeeds.
+func (s *StateManager) DeleteDeadLetter(executionID string) error {
+	result := s.db.Delete(&DeadLetter{}, "execution_id = ?", executionID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListDeadLetters retrieves the dead-lettered executions that haven't been
+// requeued, oldest first, optionally only those of one function
+func (s *StateManager) ListDeadLetters(functionID string) ([]Execution, error) {
+	query := s.db.Order("end_time, id").
+		Where("status = ? AND id IN (?)", StatusDeadLetter, s.db.Model(&DeadLetter{}).Select("execution_id"))
+	if functionID != "" {
+		query = query.Where("function_id = ?", functionID)
+	}
+
+	var executions []Execution
+	if err := query.Find(&executions).Error; err != nil {
+		return nil, err
+	}
+	for i := range executions {
+		if err := decompressLogs(&executions[i]); err != nil {
+			return nil, err
+		}
+	}
+	return executions, nil
+}
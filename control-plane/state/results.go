@@ -0,0 +1,53 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// resultKeyPrefix namespaces cached function results in Redis
+const resultKeyPrefix = "skyscale:result:"
+
+// HasResultCache reports whether Redis is available to cache function results
+func (s *StateManager) HasResultCache() bool {
+	return s.cache != nil
+}
+
+// GetCachedResult returns the function result cached under key. ok is false
+// if there is none or it has expired.
+func (s *StateManager) GetCachedResult(key string) (value string, ok bool, err error) {
+	value, err = s.cache.Get(context.Background(), resultKeyPrefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// SetCachedResult caches a function result under key for ttl
+func (s *StateManager) SetCachedResult(key, value string, ttl time.Duration) error {
+	return s.cache.Set(context.Background(), resultKeyPrefix+key, value, ttl).Err()
+}
+
+// DeleteCachedResults removes the cached function results whose keys start
+// with prefix
+func (s *StateManager) DeleteCachedResults(prefix string) error {
+	ctx := context.Background()
+	iter := s.cache.Scan(ctx, 0, resultKeyPrefix+prefix+"*", 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.cache.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,50 @@
+package state
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Secret is a named value functions reference from their environment. The
+// value is stored in plaintext; it is never returned by the API.
+type Secret struct {
+	Name      string    `gorm:"primaryKey" json:"name"`
+	Value     string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SaveSecret creates or replaces a secret
+func (s *StateManager) SaveSecret(secret *Secret) error {
+	return s.db.Save(secret).Error
+}
+
+// GetSecret retrieves a secret by name
+func (s *StateManager) GetSecret(name string) (*Secret, error) {
+	var secret Secret
+	if err := s.db.First(&secret, "name = ?", name).Error; err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// ListSecrets retrieves all secrets ordered by name
+func (s *StateManager) ListSecrets() ([]Secret, error) {
+	var secrets []Secret
+	err := s.db.Order("name").Find(&secrets).Error
+	return secrets, err
+}
+
+// DeleteSecret deletes a secret, reporting gorm.ErrRecordNotFound if it
+// doesn't exist
+func (s *StateManager) DeleteSecret(name string) error {
+	result := s.db.Delete(&Secret{}, "name = ?", name)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
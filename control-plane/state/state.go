@@ -28,53 +28,151 @@ type StateManager struct {
 
 // Function represents a serverless function
 type Function struct {
-	ID        string `gorm:"primaryKey"`
-	Name      string `gorm:"uniqueIndex"`
-	Runtime   string
-	Memory    int
-	Timeout   int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Status    string
-	Version   string
-	Code      string
+	ID         string `gorm:"primaryKey"`
+	Name       string `gorm:"uniqueIndex"`
+	Runtime    string
+	Memory     int
+	Timeout    int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Status     string
+	Version    string
+	Code       string
+	EntryPoint string
+	// StableVersion and CanaryPercent describe a canary deployment: Version
+	// receives CanaryPercent of unpinned invokes, StableVersion the rest
+	StableVersion string
+	CanaryPercent int
+	// RateLimit caps invocations per second across all callers, allowing
+	// bursts of up to RateBurst; zero means unlimited
+	RateLimit float64
+	RateBurst int
+	// Concurrency caps the executions queued or running at once; zero means
+	// unlimited
+	Concurrency int
+	Environment map[string]string `gorm:"serializer:json"`
+	Labels      map[string]string `gorm:"serializer:json"`
+	// SecretRefs maps environment variable names to the secrets whose values
+	// they receive at invoke time
+	SecretRefs map[string]string `gorm:"serializer:json"`
+	// Scratch gives executions a scratch directory kept between executions
+	// on the same VM
+	Scratch bool
+	// Cacheable marks a deterministic function whose results are cached for
+	// CacheTTL seconds, or the default TTL when zero
+	Cacheable bool
+	CacheTTL  int
+	// LogDestination is where the stderr of the function's executions is
+	// forwarded: an http(s) URL or a file:// path; empty means nowhere
+	LogDestination string
+	// Retry says how failed asynchronous executions are retried
+	Retry RetryPolicy `gorm:"serializer:json"`
+	// CPU is the number of vCPUs of the VMs executions run on; zero means
+	// the default count of the warm VMs
+	CPU int
+}
+
+// RetryPolicy says how failed asynchronous executions of a function are
+// retried. Each retry is a new execution linked to the first by ParentID.
+type RetryPolicy struct {
+	MaxAttempts    int      `json:"max_attempts"`       // Attempts including the first; 1 or less means no retries
+	BackoffSeconds int      `json:"backoff_seconds"`    // Delay before the first retry, doubled for each one after it
+	RetryOn        []string `json:"retry_on,omitempty"` // Error types that are retried; empty means the default set
 }
 
 // Execution represents a function execution
 type Execution struct {
-	ID         string `gorm:"primaryKey"`
-	FunctionID string
-	Status     string
-	StartTime  time.Time
-	EndTime    time.Time
-	Duration   int64
-	VMID       string
-	Logs       string
-	Error      string
+	ID          string `gorm:"primaryKey"`
+	FunctionID  string
+	Version     string
+	UserID      string `gorm:"index"` // Caller the execution is attributed to, if authenticated
+	ParentID    string `gorm:"index"` // ID of the first attempt when this execution is a retry
+	Attempt     int    // 1 for the first attempt, counting up with each retry; 0 on older records
+	Status      ExecutionStatus
+	QueuedAt    time.Time
+	StartTime   time.Time
+	EndTime     time.Time
+	Duration    int64
+	VMID        string
+	Logs        string
+	Stderr      string // The function's stderr, as reported by the daemon
+	Error       string
+	ErrorType   string // Failure category reported by the daemon, see types.ExecutionResult
+	ReasonCode  string // How the function process ended, one of the Reason* codes
+	ColdStart   bool   // Whether the VM was booted for this execution rather than taken from the warm pool
+	InputBytes  int64  // Size of the JSON input
+	OutputBytes int64  // Size of the output reported by the daemon
+	Cached      bool   // Whether the output was served from the result cache without running the function
+	// Phase timings in milliseconds: time spent waiting in the async queue,
+	// allocating the VM (a boot when ColdStart is set), and, as reported by
+	// the daemon, installing dependencies and running the handler
+	QueueWaitMs    int64
+	VMAllocationMs int64
+	SetupMs        int64
+	HandlerMs      int64
+	// Tags are caller-chosen key/value pairs grouping related executions
+	Tags map[string]string `gorm:"serializer:json"`
+	// LogsCompressed reports whether Logs is stored gzip-compressed in
+	// CompressedLogs; both are internal to the state manager
+	LogsCompressed bool   `json:"-"`
+	CompressedLogs []byte `json:"-"`
 }
 
 // VM represents a Firecracker micro-VM
 type VM struct {
-	ID        string `gorm:"primaryKey"`
-	Status    string
-	IP        string
-	CreatedAt time.Time
-	LastUsed  time.Time
-	Memory    int
-	CPU       int
-	IsWarm    bool
+	ID           string `gorm:"primaryKey"`
+	Status       string
+	IP           string
+	CreatedAt    time.Time
+	LastUsed     time.Time
+	Memory       int
+	CPU          int
+	IsWarm       bool
+	BootDuration int64  // Time in milliseconds from creation start until the daemon was healthy
+	HostID       string // Host agent that runs the VM; empty for the control plane's own host
+	Runtime      string // Runtime whose rootfs image the VM booted from; empty for the default image
+}
+
+// Artifact is a named file produced by an execution
+type Artifact struct {
+	ExecutionID string    `gorm:"primaryKey" json:"execution_id"`
+	Name        string    `gorm:"primaryKey" json:"name"`
+	Size        int64     `json:"size"`
+	Data        []byte    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Host represents a host agent that runs Firecracker VMs for the control plane
+type Host struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	Address   string    `json:"address"`  // Base URL of the host agent API
+	Capacity  int       `json:"capacity"` // Maximum number of VMs the host runs at once
+	LastSeen  time.Time `json:"last_seen"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Schedule represents a cron trigger attached to a function
+type Schedule struct {
+	FunctionID string                 `gorm:"primaryKey" json:"function_id"`
+	Expression string                 `json:"expression"`
+	Input      map[string]interface{} `gorm:"serializer:json" json:"input,omitempty"`
+	NextRun    time.Time              `json:"next_run"`
+	LastRun    time.Time              `json:"last_run"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`
 }
 
 // NewStateManager creates a new state manager
 func NewStateManager(logger *logrus.Logger) (*StateManager, error) {
-	// Initialize SQLite database
-	db, err := gorm.Open(sqlite.Open("skyscale.db"), &gorm.Config{})
+	// Initialize SQLite database. Constraint violations are translated to
+	// gorm errors such as gorm.ErrDuplicatedKey.
+	db, err := gorm.Open(sqlite.Open("skyscale.db"), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
 
 	// Auto migrate the schema
-	err = db.AutoMigrate(&Function{}, &Execution{}, &VM{})
+	err = db.AutoMigrate(&Function{}, &Execution{}, &VM{}, &Schedule{}, &Host{}, &Artifact{}, &AuditEvent{}, &Secret{}, &Counter{}, &DeadLetter{})
 	if err != nil {
 		return nil, err
 	}
@@ -101,6 +199,22 @@ func NewStateManager(logger *logrus.Logger) (*StateManager, error) {
 	}, nil
 }
 
+// CreateFunction inserts a new function, and its schedule unless that is
+// nil, in one transaction. It fails with gorm.ErrDuplicatedKey if a function
+// with the same name exists, including one created concurrently, since names
+// are unique in the database.
+func (s *StateManager) CreateFunction(function *Function, schedule *Schedule) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(function).Error; err != nil {
+			return err
+		}
+		if schedule == nil {
+			return nil
+		}
+		return tx.Create(schedule).Error
+	})
+}
+
 // SaveFunction saves a function to the database
 func (s *StateManager) SaveFunction(function *Function) error {
 	return s.db.Save(function).Error
@@ -140,7 +254,12 @@ func (s *StateManager) DeleteFunction(id string) error {
 
 // SaveExecution saves an execution to the database
 func (s *StateManager) SaveExecution(execution *Execution) error {
-	return s.db.Save(execution).Error
+	// Compress large outputs on a copy so the caller keeps the plain logs
+	stored := *execution
+	if err := compressLogs(&stored, getCompressThreshold()); err != nil {
+		return err
+	}
+	return s.db.Save(&stored).Error
 }
 
 // GetExecution retrieves an execution by ID
@@ -150,16 +269,163 @@ func (s *StateManager) GetExecution(id string) (*Execution, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := decompressLogs(&execution); err != nil {
+		return nil, err
+	}
 	return &execution, nil
 }
 
+// ExecutionFilter selects executions. Empty fields and zero times are not
+// filtered on; the time window applies to the start time.
+type ExecutionFilter struct {
+	FunctionID string
+	Since      time.Time
+	Until      time.Time
+	Tags       map[string]string // Executions must carry every one of these tags
+}
+
 // ListExecutions retrieves all executions for a function
 func (s *StateManager) ListExecutions(functionID string) ([]Execution, error) {
+	return s.QueryExecutions(ExecutionFilter{FunctionID: functionID})
+}
+
+// QueryExecutions retrieves the executions matching the filter, oldest first
+func (s *StateManager) QueryExecutions(filter ExecutionFilter) ([]Execution, error) {
+	query := s.db.Order("start_time, id")
+	if filter.FunctionID != "" {
+		query = query.Where("function_id = ?", filter.FunctionID)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("start_time >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("start_time < ?", filter.Until)
+	}
+	for key, value := range filter.Tags {
+		query = query.Where("json_extract(tags, ?) = ?", tagPath(key), value)
+	}
+
+	var executions []Execution
+	if err := query.Find(&executions).Error; err != nil {
+		return nil, err
+	}
+	for i := range executions {
+		if err := decompressLogs(&executions[i]); err != nil {
+			return nil, err
+		}
+	}
+	return executions, nil
+}
+
+// tagPath returns the JSON path of a tag in the stored tags. The key is
+// quoted since tag keys may contain dots and slashes.
+func tagPath(key string) string {
+	return `$."` + key + `"`
+}
+
+// ListFinishedExecutions retrieves the executions of a function that finished
+// after the given time, in the order they finished
+func (s *StateManager) ListFinishedExecutions(functionID string, after time.Time) ([]Execution, error) {
+	var executions []Execution
+	err := s.db.Order("end_time, id").
+		Where("function_id = ? AND status NOT IN ? AND end_time > ?", functionID, unfinishedStatuses, after).
+		Find(&executions).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range executions {
+		if err := decompressLogs(&executions[i]); err != nil {
+			return nil, err
+		}
+	}
+	return executions, nil
+}
+
+// GetLatestAttempt returns the most recent retry of the execution with the
+// given ID, or gorm.ErrRecordNotFound if it was never retried. Most
+// executions aren't, so the miss isn't logged as an error.
+func (s *StateManager) GetLatestAttempt(parentID string) (*Execution, error) {
+	var executions []Execution
+	err := s.db.Where("parent_id = ?", parentID).Order("attempt desc").Limit(1).Find(&executions).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(executions) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	execution := executions[0]
+	if err := decompressLogs(&execution); err != nil {
+		return nil, err
+	}
+	return &execution, nil
+}
+
+// GetLatestExecution returns the execution of a function that started most
+// recently, or gorm.ErrRecordNotFound if it was never invoked. Executions
+// still waiting in the queue haven't started and come last.
+func (s *StateManager) GetLatestExecution(functionID string) (*Execution, error) {
 	var executions []Execution
-	err := s.db.Find(&executions, "function_id = ?", functionID).Error
+	err := s.db.Where("function_id = ?", functionID).Order("start_time desc, id desc").Limit(1).Find(&executions).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(executions) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	execution := executions[0]
+	if err := decompressLogs(&execution); err != nil {
+		return nil, err
+	}
+	return &execution, nil
+}
+
+// CountUnfinishedExecutions counts the executions of a function that are
+// queued or running
+func (s *StateManager) CountUnfinishedExecutions(functionID string) (int64, error) {
+	var count int64
+	err := s.db.Model(&Execution{}).Where("function_id = ? AND status IN ?", functionID, unfinishedStatuses).Count(&count).Error
+	return count, err
+}
+
+// ListQueuedExecutions retrieves executions still waiting in the queue that
+// were queued before t
+func (s *StateManager) ListQueuedExecutions(before time.Time) ([]Execution, error) {
+	var executions []Execution
+	err := s.db.Find(&executions, "status = ? AND queued_at < ?", StatusQueued, before).Error
 	return executions, err
 }
 
+// SaveArtifacts stores the artifacts of an execution keyed by name
+func (s *StateManager) SaveArtifacts(executionID string, artifacts map[string][]byte) error {
+	now := time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for name, data := range artifacts {
+			artifact := &Artifact{ExecutionID: executionID, Name: name, Size: int64(len(data)), Data: data, CreatedAt: now}
+			if err := tx.Save(artifact).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListArtifacts retrieves the artifacts of an execution without their contents
+func (s *StateManager) ListArtifacts(executionID string) ([]Artifact, error) {
+	var artifacts []Artifact
+	err := s.db.Omit("data").Order("name").Find(&artifacts, "execution_id = ?", executionID).Error
+	return artifacts, err
+}
+
+// GetArtifact retrieves an artifact of an execution by name
+func (s *StateManager) GetArtifact(executionID, name string) (*Artifact, error) {
+	var artifact Artifact
+	err := s.db.First(&artifact, "execution_id = ? AND name = ?", executionID, name).Error
+	if err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
+
 // SaveVM saves a VM to the database
 func (s *StateManager) SaveVM(vm *VM) error {
 	return s.db.Save(vm).Error
@@ -194,6 +460,110 @@ func (s *StateManager) DeleteVM(id string) error {
 	return s.db.Delete(&VM{}, "id = ?", id).Error
 }
 
+// PurgeCounts reports how many records Purge deleted, by kind
+type PurgeCounts struct {
+	Functions   int64 `json:"functions"`
+	Executions  int64 `json:"executions"`
+	VMs         int64 `json:"vms"`
+	Schedules   int64 `json:"schedules"`
+	Artifacts   int64 `json:"artifacts"`
+	DeadLetters int64 `json:"dead_letters"`
+}
+
+// Purge deletes every function, execution, dead letter, VM, schedule and
+// artifact. Secrets, hosts, counters and the audit log are kept.
+func (s *StateManager) Purge() (*PurgeCounts, error) {
+	counts := &PurgeCounts{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		tx = tx.Session(&gorm.Session{AllowGlobalUpdate: true})
+		for _, table := range []struct {
+			model any
+			count *int64
+		}{
+			{&Artifact{}, &counts.Artifacts},
+			{&DeadLetter{}, &counts.DeadLetters},
+			{&Schedule{}, &counts.Schedules},
+			{&Execution{}, &counts.Executions},
+			{&VM{}, &counts.VMs},
+			{&Function{}, &counts.Functions},
+		} {
+			result := tx.Delete(table.model)
+			if result.Error != nil {
+				return result.Error
+			}
+			*table.count = result.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// SaveHost saves a host agent to the database
+func (s *StateManager) SaveHost(host *Host) error {
+	return s.db.Save(host).Error
+}
+
+// GetHost retrieves a host agent by ID
+func (s *StateManager) GetHost(id string) (*Host, error) {
+	var host Host
+	err := s.db.First(&host, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &host, nil
+}
+
+// ListHosts retrieves all registered host agents
+func (s *StateManager) ListHosts() ([]Host, error) {
+	var hosts []Host
+	err := s.db.Find(&hosts).Error
+	return hosts, err
+}
+
+// SaveSchedule saves a function schedule to the database
+func (s *StateManager) SaveSchedule(schedule *Schedule) error {
+	return s.db.Save(schedule).Error
+}
+
+// GetSchedule retrieves the schedule for a function
+func (s *StateManager) GetSchedule(functionID string) (*Schedule, error) {
+	var schedule Schedule
+	err := s.db.First(&schedule, "function_id = ?", functionID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// ListDueSchedules retrieves all schedules whose next run is at or before t
+func (s *StateManager) ListDueSchedules(t time.Time) ([]Schedule, error) {
+	var schedules []Schedule
+	err := s.db.Find(&schedules, "next_run <= ?", t).Error
+	return schedules, err
+}
+
+// ClaimScheduleRun advances a schedule from expectedNext to nextRun, recording
+// firedAt as its last run. The update only applies if the stored next run still
+// equals expectedNext, so a run is claimed at most once; it reports whether
+// this caller won the claim.
+func (s *StateManager) ClaimScheduleRun(functionID string, expectedNext, nextRun, firedAt time.Time) (bool, error) {
+	result := s.db.Model(&Schedule{}).
+		Where("function_id = ? AND next_run = ?", functionID, expectedNext).
+		Updates(map[string]interface{}{"next_run": nextRun, "last_run": firedAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteSchedule deletes the schedule for a function
+func (s *StateManager) DeleteSchedule(functionID string) error {
+	return s.db.Delete(&Schedule{}, "function_id = ?", functionID).Error
+}
+
 // TrackActiveExecution adds an execution to the active executions map
 func (s *StateManager) TrackActiveExecution(executionID string, vmID string) {
 	s.activeExecs.Store(executionID, vmID)
@@ -214,6 +584,15 @@ func (s *StateManager) GetActiveExecutions() map[string]string {
 	return result
 }
 
+// Ping checks that the database can be reached
+func (s *StateManager) Ping() error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Ping()
+}
+
 // Close closes the state manager
 func (s *StateManager) Close() {
 	if s.cache != nil {
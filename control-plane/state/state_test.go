@@ -0,0 +1,166 @@
+package state
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// newTestStateManager creates a state manager backed by a fresh database in
+// a temporary directory
+func newTestStateManager(t *testing.T) *StateManager {
+	t.Helper()
+
+	// The database is created in the working directory
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	s, err := NewStateManager(logger)
+	if err != nil {
+		t.Fatalf("failed to create state manager: %v", err)
+	}
+	return s
+}
+
+func TestQueryExecutionsTimeWindow(t *testing.T) {
+	s := newTestStateManager(t)
+	base := time.Now().Truncate(time.Second)
+	for _, execution := range []*Execution{
+		{ID: "early", FunctionID: "f1", Status: StatusCompleted, StartTime: base.Add(-time.Hour)},
+		{ID: "at-since", FunctionID: "f1", Status: StatusCompleted, StartTime: base},
+		{ID: "at-until", FunctionID: "f1", Status: StatusCompleted, StartTime: base.Add(time.Hour)},
+		{ID: "other", FunctionID: "f2", Status: StatusCompleted, StartTime: base},
+	} {
+		if err := s.SaveExecution(execution); err != nil {
+			t.Fatalf("SaveExecution: %v", err)
+		}
+	}
+
+	// The window includes its start and excludes its end
+	tests := []struct {
+		name   string
+		filter ExecutionFilter
+		want   []string // IDs, oldest first
+	}{
+		{"all of a function", ExecutionFilter{FunctionID: "f1"}, []string{"early", "at-since", "at-until"}},
+		{"since", ExecutionFilter{FunctionID: "f1", Since: base}, []string{"at-since", "at-until"}},
+		{"until", ExecutionFilter{FunctionID: "f1", Until: base.Add(time.Hour)}, []string{"early", "at-since"}},
+		{"window", ExecutionFilter{FunctionID: "f1", Since: base, Until: base.Add(time.Hour)}, []string{"at-since"}},
+		{"just after the start", ExecutionFilter{FunctionID: "f1", Since: base.Add(time.Second)}, []string{"at-until"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executions, err := s.QueryExecutions(tt.filter)
+			if err != nil {
+				t.Fatalf("QueryExecutions: %v", err)
+			}
+			var got []string
+			for _, execution := range executions {
+				got = append(got, execution.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryExecutionsByTag(t *testing.T) {
+	s := newTestStateManager(t)
+	base := time.Now().Truncate(time.Second)
+	for i, execution := range []*Execution{
+		{ID: "a", FunctionID: "f1", Tags: map[string]string{"batch": "xyz", "example.com/step": "resize"}},
+		{ID: "b", FunctionID: "f2", Tags: map[string]string{"batch": "xyz"}},
+		{ID: "c", FunctionID: "f1", Tags: map[string]string{"batch": "abc"}},
+		{ID: "d", FunctionID: "f1"},
+	} {
+		execution.Status = StatusCompleted
+		execution.StartTime = base.Add(time.Duration(i) * time.Second)
+		if err := s.SaveExecution(execution); err != nil {
+			t.Fatalf("SaveExecution: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter ExecutionFilter
+		want   []string // IDs, oldest first
+	}{
+		{"one tag", ExecutionFilter{Tags: map[string]string{"batch": "xyz"}}, []string{"a", "b"}},
+		{"all tags must match", ExecutionFilter{Tags: map[string]string{"batch": "xyz", "example.com/step": "resize"}}, []string{"a"}},
+		{"tag and function", ExecutionFilter{FunctionID: "f2", Tags: map[string]string{"batch": "xyz"}}, []string{"b"}},
+		{"no match", ExecutionFilter{Tags: map[string]string{"batch": "none"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executions, err := s.QueryExecutions(tt.filter)
+			if err != nil {
+				t.Fatalf("QueryExecutions: %v", err)
+			}
+			var got []string
+			for _, execution := range executions {
+				got = append(got, execution.ID)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	stored, err := s.GetExecution("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Tags["example.com/step"] != "resize" {
+		t.Errorf("stored tags = %v", stored.Tags)
+	}
+}
+
+func TestGetLatestExecution(t *testing.T) {
+	s := newTestStateManager(t)
+	if _, err := s.GetLatestExecution("f1"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("GetLatestExecution of a function never invoked: error = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	base := time.Now().Truncate(time.Second)
+	for _, execution := range []*Execution{
+		{ID: "first", FunctionID: "f1", Status: StatusCompleted, StartTime: base, Logs: `{"n":1}`},
+		{ID: "second", FunctionID: "f1", Status: StatusCompleted, StartTime: base.Add(time.Second), Logs: `{"n":2}`},
+		// Neither a queued execution nor another function's takes its place
+		{ID: "queued", FunctionID: "f1", Status: StatusQueued},
+		{ID: "other", FunctionID: "f2", Status: StatusCompleted, StartTime: base.Add(time.Minute)},
+	} {
+		if err := s.SaveExecution(execution); err != nil {
+			t.Fatalf("SaveExecution: %v", err)
+		}
+	}
+
+	latest, err := s.GetLatestExecution("f1")
+	if err != nil {
+		t.Fatalf("GetLatestExecution: %v", err)
+	}
+	if latest.ID != "second" || latest.Logs != `{"n":2}` {
+		t.Errorf("latest execution = %s with output %q, want second with its output", latest.ID, latest.Logs)
+	}
+}
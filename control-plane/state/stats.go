@@ -0,0 +1,73 @@
+package state
+
+import (
+	"math"
+	"sort"
+)
+
+// Percentiles summarizes a distribution of sizes in bytes
+type Percentiles struct {
+	P50 int64 `json:"p50"`
+	P90 int64 `json:"p90"`
+	P99 int64 `json:"p99"`
+	Max int64 `json:"max"`
+}
+
+// PayloadStats describes the input and output sizes of a function's
+// finished executions
+type PayloadStats struct {
+	Executions  int64       `json:"executions"`
+	InputBytes  Percentiles `json:"input_bytes"`
+	OutputBytes Percentiles `json:"output_bytes"`
+}
+
+// GetPayloadStats computes input and output size percentiles over the
+// finished executions of a function
+func (s *StateManager) GetPayloadStats(functionID string) (*PayloadStats, error) {
+	var rows []struct {
+		InputBytes  int64
+		OutputBytes int64
+	}
+	err := s.db.Model(&Execution{}).
+		Select("input_bytes, output_bytes").
+		Where("function_id = ? AND status NOT IN ?", functionID, unfinishedStatuses).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make([]int64, len(rows))
+	outputs := make([]int64, len(rows))
+	for i, row := range rows {
+		inputs[i] = row.InputBytes
+		outputs[i] = row.OutputBytes
+	}
+
+	return &PayloadStats{
+		Executions:  int64(len(rows)),
+		InputBytes:  percentiles(inputs),
+		OutputBytes: percentiles(outputs),
+	}, nil
+}
+
+// percentiles returns the nearest-rank percentiles of values
+func percentiles(values []int64) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	rank := func(p float64) int64 {
+		i := int(math.Ceil(p/100*float64(len(values)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return values[i]
+	}
+	return Percentiles{
+		P50: rank(50),
+		P90: rank(90),
+		P99: rank(99),
+		Max: values[len(values)-1],
+	}
+}
@@ -0,0 +1,64 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []int64
+		want   Percentiles
+	}{
+		{"none", nil, Percentiles{}},
+		{"one", []int64{42}, Percentiles{P50: 42, P90: 42, P99: 42, Max: 42}},
+		{"unsorted", []int64{5, 1, 4, 2, 3}, Percentiles{P50: 3, P90: 5, P99: 5, Max: 5}},
+		{"hundred", hundred(), Percentiles{P50: 50, P90: 90, P99: 99, Max: 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentiles(tt.values); got != tt.want {
+				t.Errorf("percentiles() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// hundred returns the values 1 to 100
+func hundred() []int64 {
+	values := make([]int64, 100)
+	for i := range values {
+		values[i] = int64(i + 1)
+	}
+	return values
+}
+
+func TestGetPayloadStats(t *testing.T) {
+	s := newTestStateManager(t)
+	now := time.Now()
+	for _, execution := range []*Execution{
+		{ID: "e1", FunctionID: "fn", Status: StatusCompleted, StartTime: now, InputBytes: 10, OutputBytes: 100},
+		{ID: "e2", FunctionID: "fn", Status: StatusFailed, StartTime: now, InputBytes: 30, OutputBytes: 0},
+		// Unfinished executions and other functions aren't counted
+		{ID: "e3", FunctionID: "fn", Status: StatusRunning, StartTime: now, InputBytes: 1000},
+		{ID: "e4", FunctionID: "other", Status: StatusCompleted, StartTime: now, InputBytes: 1000},
+	} {
+		if err := s.SaveExecution(execution); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := s.GetPayloadStats("fn")
+	if err != nil {
+		t.Fatalf("GetPayloadStats: %v", err)
+	}
+	want := PayloadStats{
+		Executions:  2,
+		InputBytes:  Percentiles{P50: 10, P90: 30, P99: 30, Max: 30},
+		OutputBytes: Percentiles{P50: 0, P90: 100, P99: 100, Max: 100},
+	}
+	if *stats != want {
+		t.Errorf("stats = %+v, want %+v", *stats, want)
+	}
+}
@@ -0,0 +1,42 @@
+package state
+
+// ExecutionStatus is the lifecycle state of an execution
+type ExecutionStatus string
+
+// Execution statuses. An execution moves from queued (async only) to pending
+// once it is dequeued, running once a VM is allocated, and ends in one of the
+// terminal statuses. A queued execution that is cancelled before a worker
+// picks it up ends as cancelled. An async execution that fails on the last
+// attempt its function's retry policy allows ends as dead_letter.
+const (
+	StatusQueued       ExecutionStatus = "queued"
+	StatusPending      ExecutionStatus = "pending"
+	StatusRunning      ExecutionStatus = "running"
+	StatusCompleted    ExecutionStatus = "completed"
+	StatusFailed       ExecutionStatus = "failed"
+	StatusTimeout      ExecutionStatus = "timeout"
+	StatusQueueTimeout ExecutionStatus = "queue_timeout"
+	StatusCancelled    ExecutionStatus = "cancelled"
+	StatusDeadLetter   ExecutionStatus = "dead_letter"
+)
+
+// Reason codes of failed executions, telling how the function process ended.
+// They are reported by the daemon, except for timeouts the control plane
+// detects itself.
+const (
+	ReasonTimeout     = "TIMEOUT"
+	ReasonOOM         = "OOM"
+	ReasonExitNonzero = "EXIT_NONZERO"
+)
+
+// unfinishedStatuses lists the statuses of executions that haven't ended
+var unfinishedStatuses = []string{string(StatusQueued), string(StatusPending), string(StatusRunning)}
+
+// Terminal reports whether the execution has ended
+func (s ExecutionStatus) Terminal() bool {
+	switch s {
+	case StatusCompleted, StatusFailed, StatusTimeout, StatusQueueTimeout, StatusCancelled, StatusDeadLetter:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package state
+
+import (
+	"time"
+)
+
+// UsageFilter selects the executions a usage report covers. Empty fields and
+// zero times are not filtered on.
+type UsageFilter struct {
+	UserID     string
+	FunctionID string
+	Since      time.Time
+	Until      time.Time
+}
+
+// Usage aggregates the finished executions matched by a UsageFilter
+type Usage struct {
+	Invocations int64   `json:"invocations"`
+	Errors      int64   `json:"errors"`
+	ErrorRate   float64 `json:"error_rate"`
+	// ColdStarts counts executions that waited for a VM to boot
+	ColdStarts     int64   `json:"cold_starts"`
+	ColdStartRatio float64 `json:"cold_start_ratio"`
+	DurationMS     int64   `json:"duration_ms"`
+	GBSeconds      float64 `json:"gb_seconds"`
+}
+
+// usageRow is the raw result of the usage aggregate query
+type usageRow struct {
+	Invocations int64
+	Errors      int64
+	ColdStarts  int64
+	DurationMS  int64
+	MBMillis    int64 // Sum of memory (MB) × duration (ms)
+}
+
+// GBSeconds converts a sum of memory in MB × duration in milliseconds to
+// GB-seconds
+func GBSeconds(mbMillis int64) float64 {
+	return float64(mbMillis) / 1024 / 1000
+}
+
+// GetUsage computes invocation counts, total duration, GB-seconds and error
+// rate for finished executions started within the filter's window. Memory is
+// taken from the execution's function; executions of deleted functions count
+// with no memory.
+func (s *StateManager) GetUsage(filter UsageFilter) (*Usage, error) {
+	query := s.db.Table("executions").
+		Select(`COUNT(*) AS invocations,
+			COALESCE(SUM(CASE WHEN executions.status <> 'completed' THEN 1 ELSE 0 END), 0) AS errors,
+			COALESCE(SUM(CASE WHEN executions.cold_start THEN 1 ELSE 0 END), 0) AS cold_starts,
+			COALESCE(SUM(executions.duration), 0) AS duration_ms,
+			COALESCE(SUM(executions.duration * COALESCE(functions.memory, 0)), 0) AS mb_millis`).
+		Joins("LEFT JOIN functions ON functions.id = executions.function_id").
+		Where("executions.status NOT IN ?", unfinishedStatuses)
+
+	if filter.UserID != "" {
+		query = query.Where("executions.user_id = ?", filter.UserID)
+	}
+	if filter.FunctionID != "" {
+		query = query.Where("executions.function_id = ?", filter.FunctionID)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("executions.start_time >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("executions.start_time < ?", filter.Until)
+	}
+
+	var row usageRow
+	if err := query.Scan(&row).Error; err != nil {
+		return nil, err
+	}
+
+	usage := &Usage{
+		Invocations: row.Invocations,
+		Errors:      row.Errors,
+		ColdStarts:  row.ColdStarts,
+		DurationMS:  row.DurationMS,
+		GBSeconds:   GBSeconds(row.MBMillis),
+	}
+	if row.Invocations > 0 {
+		usage.ErrorRate = float64(row.Errors) / float64(row.Invocations)
+		usage.ColdStartRatio = float64(row.ColdStarts) / float64(row.Invocations)
+	}
+	return usage, nil
+}
@@ -0,0 +1,104 @@
+package state
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGBSeconds(t *testing.T) {
+	tests := []struct {
+		mbMillis int64
+		want     float64
+	}{
+		{0, 0},
+		{1024 * 1000, 1},    // 1GB for 1s
+		{128 * 1000, 0.125}, // 128MB for 1s
+		{512 * 250, 0.125},  // 512MB for 250ms
+		{1024 * 3600 * 1000, 3600},
+	}
+	for _, tt := range tests {
+		if got := GBSeconds(tt.mbMillis); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("GBSeconds(%d) = %v, want %v", tt.mbMillis, got, tt.want)
+		}
+	}
+}
+
+func TestGetUsage(t *testing.T) {
+	s := newTestStateManager(t)
+	for _, function := range []*Function{
+		{ID: "fn-large", Name: "large", Memory: 1024},
+		{ID: "fn-small", Name: "small", Memory: 128},
+	} {
+		if err := s.SaveFunction(function); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	for _, execution := range []*Execution{
+		// 1GB for 2s
+		{ID: "e1", FunctionID: "fn-large", UserID: "alice", Status: StatusCompleted, StartTime: now, Duration: 2000, ColdStart: true},
+		// 128MB for 8s
+		{ID: "e2", FunctionID: "fn-small", UserID: "bob", Status: StatusFailed, StartTime: now, Duration: 8000},
+		// 128MB for 500ms
+		{ID: "e3", FunctionID: "fn-small", UserID: "alice", Status: StatusTimeout, StartTime: now, Duration: 500},
+		// A deleted function's memory is unknown
+		{ID: "e4", FunctionID: "fn-deleted", UserID: "alice", Status: StatusCompleted, StartTime: now, Duration: 1000},
+		// Unfinished executions aren't counted
+		{ID: "e5", FunctionID: "fn-large", UserID: "alice", Status: StatusRunning, StartTime: now, Duration: 9000},
+		// Outside the window
+		{ID: "e6", FunctionID: "fn-large", UserID: "alice", Status: StatusCompleted, StartTime: now.Add(-48 * time.Hour), Duration: 9000},
+	} {
+		if err := s.SaveExecution(execution); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	since := now.Add(-time.Hour)
+	tests := []struct {
+		name   string
+		filter UsageFilter
+		want   Usage
+	}{
+		{
+			name:   "all",
+			filter: UsageFilter{Since: since},
+			want:   Usage{Invocations: 4, Errors: 2, ErrorRate: 0.5, ColdStarts: 1, ColdStartRatio: 0.25, DurationMS: 11500, GBSeconds: 2 + 1 + 0.0625},
+		},
+		{
+			name:   "user",
+			filter: UsageFilter{UserID: "alice", Since: since},
+			want:   Usage{Invocations: 3, Errors: 1, ErrorRate: 1.0 / 3, ColdStarts: 1, ColdStartRatio: 1.0 / 3, DurationMS: 3500, GBSeconds: 2.0625},
+		},
+		{
+			name:   "function",
+			filter: UsageFilter{FunctionID: "fn-small", Since: since},
+			want:   Usage{Invocations: 2, Errors: 2, ErrorRate: 1, DurationMS: 8500, GBSeconds: 1.0625},
+		},
+		{
+			name:   "window",
+			filter: UsageFilter{FunctionID: "fn-large", Until: since},
+			want:   Usage{Invocations: 1, DurationMS: 9000, GBSeconds: 9},
+		},
+		{
+			name:   "no executions",
+			filter: UsageFilter{UserID: "carol"},
+			want:   Usage{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage, err := s.GetUsage(tt.filter)
+			if err != nil {
+				t.Fatalf("GetUsage: %v", err)
+			}
+			if usage.Invocations != tt.want.Invocations || usage.Errors != tt.want.Errors || usage.ColdStarts != tt.want.ColdStarts || usage.DurationMS != tt.want.DurationMS {
+				t.Errorf("usage = %+v, want %+v", usage, tt.want)
+			}
+			if math.Abs(usage.GBSeconds-tt.want.GBSeconds) > 1e-9 || math.Abs(usage.ErrorRate-tt.want.ErrorRate) > 1e-9 || math.Abs(usage.ColdStartRatio-tt.want.ColdStartRatio) > 1e-9 {
+				t.Errorf("usage = %+v, want %+v", usage, tt.want)
+			}
+		})
+	}
+}
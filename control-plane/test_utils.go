@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 
+	"github.com/bluequbit/faas/control-plane/daemonclient"
 	"github.com/bluequbit/faas/control-plane/vm"
 	"github.com/sirupsen/logrus"
 )
@@ -79,6 +80,10 @@ func startDaemon(logger *logrus.Logger) error {
 		"VM_IP=127.0.0.1",
 		"PATH=" + os.Getenv("PATH"),
 	}
+	// The host daemon has no MMDS to read the result secret from
+	if secret := daemonclient.ResultSecret(); secret != "" {
+		env = append(env, daemonclient.EnvResultSecret+"="+secret)
+	}
 
 	// Start the daemon process
 	cmd := exec.Command(daemonPath)
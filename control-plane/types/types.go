@@ -0,0 +1,144 @@
+// Package types defines the data types shared between the control plane
+// components and the daemon running inside each VM.
+
+package types
+
+import (
+	"encoding/json"
+)
+
+// ExecutionResult represents the result of a function execution. It is the
+// payload the daemon reports to /api/results, the value the scheduler returns
+// for sync and async invocations, and what API clients receive.
+//
+// The daemon is built as a separate module and keeps a mirror of this type;
+// the JSON field names and semantics must stay in sync with it.
+type ExecutionResult struct {
+	RequestID  string `json:"request_id"`
+	FunctionID string `json:"function_id"`
+	StatusCode int    `json:"status_code"`
+	// Status is the execution status as tracked by the control plane (e.g.
+	// "queued", "running", "completed"); the daemon leaves it empty
+	Status string `json:"status,omitempty"`
+	// Version is the function version that served the execution; it is
+	// set by the control plane
+	Version string `json:"version,omitempty"`
+	// ResultURL is where an asynchronous execution's result can be fetched;
+	// it is set by the control plane on 202 responses
+	ResultURL string `json:"result_url,omitempty"`
+	// Output is the JSON value returned by the function. Non-JSON output is
+	// wrapped as {"result": "<output>"} (see OutputFromString).
+	Output       json.RawMessage `json:"output,omitempty"`
+	ErrorMessage string          `json:"error_message,omitempty"`
+	// ErrorType categorizes a failure: setup_error, import_error,
+	// exception, serialization_error, oom, timeout or exit
+	ErrorType string `json:"error_type,omitempty"`
+	// ReasonCode tells how the function process ended: TIMEOUT, OOM or
+	// EXIT_NONZERO
+	ReasonCode  string `json:"reason_code,omitempty"`
+	Duration    int64  `json:"duration_ms"`
+	MemoryUsage int64  `json:"memory_usage_kb,omitempty"`
+	// SetupMs and HandlerMs split Duration, as measured by the daemon, into
+	// writing the code and installing dependencies and running the handler
+	SetupMs   int64 `json:"setup_ms,omitempty"`
+	HandlerMs int64 `json:"handler_ms,omitempty"`
+	// Logs is the function's stderr, reported by the daemon
+	Logs      string `json:"logs,omitempty"`
+	Truncated bool   `json:"output_truncated,omitempty"`
+	// ColdStart is set by the control plane when the execution had to wait
+	// for a new VM to boot instead of reusing a warm one
+	ColdStart bool `json:"cold_start,omitempty"`
+	// Cached is set by the control plane when the output was served from
+	// the result cache of a cacheable function instead of running it
+	Cached bool `json:"cached,omitempty"`
+	// Attempt is the number of the attempt the result is from, starting at
+	// 1; it is above 1 when the function's retry policy retried the execution
+	Attempt int `json:"attempt,omitempty"`
+	// Source tells who the outcome of a finished execution is from,
+	// SourceFunction or SourcePlatform; it is set by the control plane and
+	// empty while the execution is queued or running
+	Source string `json:"source,omitempty"`
+	// Artifacts holds files the handler wrote to its output directory,
+	// keyed by relative path; the control plane stores them separately
+	Artifacts          map[string][]byte `json:"artifacts,omitempty"`
+	ArtifactsTruncated bool              `json:"artifacts_truncated,omitempty"`
+}
+
+// Sources of an execution's outcome
+const (
+	// SourceFunction means the function ran; it completed or failed itself
+	SourceFunction = "function"
+	// SourcePlatform means the platform failed or refused to run the
+	// function, e.g. because no VM was available or the daemon was unreachable
+	SourcePlatform = "platform"
+)
+
+// StreamChunk is an item yielded by a generator handler, which the daemon
+// reports to /api/results/chunks while the handler runs. The daemon keeps a
+// mirror of this type.
+type StreamChunk struct {
+	RequestID  string          `json:"request_id"`
+	FunctionID string          `json:"function_id"`
+	Seq        int             `json:"seq"` // Position of the item, from 0
+	Data       json.RawMessage `json:"data"`
+}
+
+// OutputFromString converts raw function output into an ExecutionResult
+// output value. Valid JSON is kept as is; anything else is wrapped in a
+// {"result": ...} object. Empty output yields a nil value.
+func OutputFromString(output string) json.RawMessage {
+	if output == "" {
+		return nil
+	}
+	if json.Valid([]byte(output)) {
+		return json.RawMessage(output)
+	}
+	wrapped, _ := json.Marshal(map[string]string{"result": output})
+	return wrapped
+}
+
+// HTTPResponse is an API Gateway-style response returned by a function that
+// wants to control the HTTP status code and headers of its invocation
+type HTTPResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       json.RawMessage   `json:"body,omitempty"`
+}
+
+// ParseHTTPResponse reports whether a function output has the shape
+// {"statusCode": ..., "headers": {...}, "body": ...} and returns it if so.
+// Only statusCode is required and it must be a valid HTTP status code.
+func ParseHTTPResponse(output json.RawMessage) (*HTTPResponse, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(output, &fields); err != nil {
+		return nil, false
+	}
+	if _, ok := fields["statusCode"]; !ok {
+		return nil, false
+	}
+	for key := range fields {
+		if key != "statusCode" && key != "headers" && key != "body" {
+			return nil, false
+		}
+	}
+
+	var response HTTPResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, false
+	}
+	if response.StatusCode < 100 || response.StatusCode > 599 {
+		return nil, false
+	}
+	return &response, true
+}
+
+// Payload returns the response body to write along with the content type to
+// use when the function did not set one. String bodies are written verbatim
+// as text; any other JSON value is written as JSON.
+func (r *HTTPResponse) Payload() ([]byte, string) {
+	var body string
+	if err := json.Unmarshal(r.Body, &body); err == nil {
+		return []byte(body), "text/plain; charset=utf-8"
+	}
+	return r.Body, "application/json"
+}
@@ -0,0 +1,116 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOutputFromString(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"", ""},
+		{`{"message": "hi"}`, `{"message": "hi"}`},
+		{`[1, 2]`, `[1, 2]`},
+		{`42`, `42`},
+		{"plain text", `{"result":"plain text"}`},
+		{`{"unterminated": `, `{"result":"{\"unterminated\": "}`},
+	}
+	for _, tt := range tests {
+		if got := OutputFromString(tt.output); string(got) != tt.want {
+			t.Errorf("OutputFromString(%q) = %s, want %s", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestExecutionResultWireFormat(t *testing.T) {
+	// A result as the daemon reports it
+	report := `{
+		"request_id": "req-1",
+		"function_id": "fn-1",
+		"status_code": 500,
+		"output": {"partial": true},
+		"error_message": "boom",
+		"error_type": "exception",
+		"reason_code": "EXIT_NONZERO",
+		"duration_ms": 120,
+		"setup_ms": 20,
+		"handler_ms": 100,
+		"logs": "Traceback",
+		"output_truncated": true,
+		"artifacts": {"plot.png": "iVBORw0K"}
+	}`
+
+	var result ExecutionResult
+	if err := json.Unmarshal([]byte(report), &result); err != nil {
+		t.Fatalf("failed to decode the daemon's report: %v", err)
+	}
+	if result.RequestID != "req-1" || result.FunctionID != "fn-1" || result.StatusCode != 500 {
+		t.Errorf("identity = %s %s %d", result.RequestID, result.FunctionID, result.StatusCode)
+	}
+	if string(result.Output) != `{"partial": true}` || result.ErrorMessage != "boom" || result.ErrorType != "exception" || result.ReasonCode != "EXIT_NONZERO" {
+		t.Errorf("outcome = %s %q %q %q", result.Output, result.ErrorMessage, result.ErrorType, result.ReasonCode)
+	}
+	if result.Duration != 120 || result.SetupMs != 20 || result.HandlerMs != 100 {
+		t.Errorf("timings = %d %d %d", result.Duration, result.SetupMs, result.HandlerMs)
+	}
+	if result.Logs != "Traceback" || !result.Truncated || len(result.Artifacts["plot.png"]) == 0 {
+		t.Errorf("logs %q, truncated %v, artifacts %v", result.Logs, result.Truncated, result.Artifacts)
+	}
+
+	// Fields set by the control plane are left out until they are set
+	data, err := json.Marshal(&ExecutionResult{RequestID: "req-1", FunctionID: "fn-1", StatusCode: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	for _, name := range []string{"status", "version", "result_url", "cold_start", "cached", "attempt", "source"} {
+		if _, ok := fields[name]; ok {
+			t.Errorf("unset field %s is encoded", name)
+		}
+	}
+}
+
+func TestParseHTTPResponse(t *testing.T) {
+	tests := []struct {
+		output string
+		ok     bool
+	}{
+		{`{"statusCode": 201, "headers": {"Location": "/items/1"}, "body": {"id": 1}}`, true},
+		{`{"statusCode": 204}`, true},
+		{`{"statusCode": 200, "body": "hello"}`, true},
+		{`{"message": "hi"}`, false},
+		{`{"statusCode": 200, "body": "hi", "extra": true}`, false},
+		{`{"statusCode": 42}`, false},
+		{`{"statusCode": "200"}`, false},
+		{`{"statusCode": 200, "headers": ["Location"]}`, false},
+		{`[1, 2]`, false},
+		{`"plain"`, false},
+	}
+	for _, tt := range tests {
+		if _, ok := ParseHTTPResponse(json.RawMessage(tt.output)); ok != tt.ok {
+			t.Errorf("ParseHTTPResponse(%s) ok = %v, want %v", tt.output, ok, tt.ok)
+		}
+	}
+}
+
+func TestHTTPResponsePayload(t *testing.T) {
+	tests := []struct {
+		body        string
+		want        string
+		contentType string
+	}{
+		{`"hello"`, "hello", "text/plain; charset=utf-8"},
+		{`{"id": 1}`, `{"id": 1}`, "application/json"},
+		{`[1, 2]`, `[1, 2]`, "application/json"},
+	}
+	for _, tt := range tests {
+		response := &HTTPResponse{StatusCode: 200, Body: json.RawMessage(tt.body)}
+		body, contentType := response.Payload()
+		if string(body) != tt.want || contentType != tt.contentType {
+			t.Errorf("Payload() of %s = %s, %q; want %s, %q", tt.body, body, contentType, tt.want, tt.contentType)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// agentVMsPath is the host agent endpoint for creating and terminating VMs
+const agentVMsPath = "/agent/vms"
+
+// agentCreateRequest is the body of a VM creation request to a host agent
+type agentCreateRequest struct {
+	IsWarm bool `json:"is_warm"`
+	// Runtime picks the rootfs image, see state.VM.Runtime
+	Runtime string `json:"runtime,omitempty"`
+	// CPU is the number of vCPUs; zero means the agent's default
+	CPU int `json:"cpu,omitempty"`
+}
+
+// AgentHandler serves the host agent API, which lets a control plane create
+// and terminate VMs on this host
+type AgentHandler struct {
+	manager *VMManager
+}
+
+// NewAgentHandler creates a host agent API handler backed by manager
+func NewAgentHandler(manager *VMManager) *AgentHandler {
+	return &AgentHandler{manager: manager}
+}
+
+// RegisterRoutes registers the host agent routes
+func (h *AgentHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc(agentVMsPath, h.createVMHandler).Methods("POST")
+	router.HandleFunc(agentVMsPath+"/{id}", h.terminateVMHandler).Methods("DELETE")
+	router.HandleFunc(agentVMsPath+"/{id}/console", h.consoleHandler).Methods("GET")
+}
+
+// createVMHandler boots a VM on this host and returns it
+func (h *AgentHandler) createVMHandler(w http.ResponseWriter, r *http.Request) {
+	var req agentCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	vm, err := h.manager.createVM(req.IsWarm, req.Runtime, req.CPU)
+	if errors.Is(err, ErrCapacityExceeded) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		h.manager.logger.Errorf("Failed to create VM: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(vm)
+}
+
+// consoleHandler returns the console output of a VM on this host
+func (h *AgentHandler) consoleHandler(w http.ResponseWriter, r *http.Request) {
+	lines, err := strconv.Atoi(r.URL.Query().Get("lines"))
+	if err != nil || lines <= 0 {
+		lines = DefaultConsoleLines
+	}
+
+	output, err := h.manager.ConsoleOutput(mux.Vars(r)["id"], lines)
+	if errors.Is(err, ErrNoConsole) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(output)
+}
+
+// terminateVMHandler stops a VM running on this host
+func (h *AgentHandler) terminateVMHandler(w http.ResponseWriter, r *http.Request) {
+	if err := h.manager.terminateVM(mux.Vars(r)["id"]); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+func TestNoVMsCreatedAfterCleanup(t *testing.T) {
+	m := newTestVMManager(t)
+	agent := newTestAgent(t, m, 10)
+	resizePool(m, 3, 3)
+
+	stopped := make(chan struct{})
+	go func() {
+		m.manageWarmPool()
+		close(stopped)
+	}()
+	m.Cleanup()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("manageWarmPool kept running after Cleanup")
+	}
+	created := len(agent.requests())
+
+	if _, err := m.createVM(true, "", 0); !errors.Is(err, ErrManagerStopped) {
+		t.Errorf("createVM after Cleanup: error = %v, want %v", err, ErrManagerStopped)
+	}
+	if _, _, err := m.GetVMForFunction("", 0, PrioritySync); !errors.Is(err, ErrManagerStopped) {
+		t.Errorf("GetVMForFunction after Cleanup: error = %v, want %v", err, ErrManagerStopped)
+	}
+	m.ReconcileWarmPool()
+	m.replenishWarmPool()
+	if requests := agent.requests(); len(requests) != created {
+		t.Errorf("%d VMs created after Cleanup, want none", len(requests)-created)
+	}
+
+	// A second Cleanup is harmless
+	m.Cleanup()
+}
+
+func TestCleanupTerminatesVMsStillBooting(t *testing.T) {
+	m := newTestVMManager(t)
+	// An agent whose VMs boot once release is closed
+	booting := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var deleted atomic.Int32
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			booting <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(state.VM{ID: "vm-remote-1", IP: "172.16.1.2", Status: "ready"})
+		case http.MethodDelete:
+			deleted.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer agent.Close()
+	m.maxVMs = 0
+	if err := m.RegisterHost(&state.Host{ID: "host-2", Address: agent.URL, Capacity: 1}); err != nil {
+		t.Fatalf("RegisterHost: %v", err)
+	}
+
+	go m.GetVMForFunction("", 0, PrioritySync)
+	select {
+	case <-booting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no VM started booting")
+	}
+	done := make(chan struct{})
+	go func() {
+		m.Cleanup()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Cleanup returned before the booting VM was created")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cleanup didn't return once the VM was created")
+	}
+	if deleted.Load() != 1 {
+		t.Errorf("agent received %d terminations, want the VM that was booting", deleted.Load())
+	}
+	if len(m.vms) != 0 {
+		t.Errorf("%d VMs left after Cleanup, want none", len(m.vms))
+	}
+}
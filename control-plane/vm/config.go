@@ -1,9 +1,12 @@
 package vm
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Environment variable names
@@ -12,8 +15,119 @@ const (
 	EnvVMRootFSPath = "FAAS_VM_ROOTFS_PATH"
 	EnvVMMemoryMB   = "FAAS_VM_MEMORY_MB"
 	EnvVMCPUCount   = "FAAS_VM_CPU_COUNT"
+	EnvVMKernelArgs = "FAAS_VM_KERNEL_ARGS"
+
+	EnvFirecrackerBin = "FAAS_FIRECRACKER_BIN"
+
+	EnvVMRuntimeRootFS = "FAAS_VM_RUNTIME_ROOTFS"
+	EnvVMDataDrives    = "FAAS_VM_DATA_DRIVES"
+
+	EnvVMSlowBootMS = "FAAS_VM_SLOW_BOOT_MS"
+	EnvVMMaxVMs     = "FAAS_VM_MAX_VMS"
+
+	EnvVMSyncReserve = "FAAS_VM_SYNC_RESERVE"
+
+	EnvVMQuarantineSeconds = "FAAS_VM_QUARANTINE_SECONDS"
+	EnvVMQuarantineMax     = "FAAS_VM_QUARANTINE_MAX"
+
+	EnvWarmPoolStrategy     = "FAAS_WARM_POOL_STRATEGY"
+	EnvWarmPoolLowWatermark = "FAAS_WARM_POOL_LOW_WATERMARK"
+	EnvWarmPoolSizes        = "FAAS_WARM_POOL_SIZES"
+
+	EnvVMIDScheme = "FAAS_VM_ID_SCHEME"
+	EnvVMIDPrefix = "FAAS_VM_ID_PREFIX"
+
+	EnvDataDir      = "FAAS_DATA_DIR"
+	EnvVMStorageDir = "FAAS_VM_STORAGE_DIR"
+
+	EnvHostToken = "FAAS_HOST_TOKEN"
+)
+
+// defaultDataDir holds the control plane's storage unless FAAS_DATA_DIR is set
+const defaultDataDir = "/var/lib/skyscale"
+
+// VM ID schemes
+const (
+	// VMIDUUID names VMs with random UUIDs
+	VMIDUUID = "uuid"
+	// VMIDSequential names VMs with a prefix and a persistent counter, e.g. vm-0001
+	VMIDSequential = "sequential"
+)
+
+// defaultVMIDPrefix prefixes sequential VM IDs unless FAAS_VM_ID_PREFIX is set
+const defaultVMIDPrefix = "vm-"
+
+// vmIDCounter is the state counter numbering sequential VM IDs
+const vmIDCounter = "vm_id"
+
+// Warm pool replenishment strategies
+const (
+	// WarmPoolLazy adds one warm VM per pool tick
+	WarmPoolLazy = "lazy"
+	// WarmPoolEager refills the pool to its target as soon as it drops below
+	// the low watermark, booting the missing VMs concurrently
+	WarmPoolEager = "eager"
+)
+
+// defaultWarmPoolSize is the number of warm VMs each pool is kept at unless
+// FAAS_WARM_POOL_SIZES sets it
+const defaultWarmPoolSize = 5
+
+// defaultPoolName names the pool of VMs booted from the default rootfs image
+// in FAAS_WARM_POOL_SIZES and logs
+const defaultPoolName = "default"
+
+// defaultSyncReserve is the share of VM capacity kept for synchronous
+// invokes unless FAAS_VM_SYNC_RESERVE is set
+const defaultSyncReserve = 0.2
+
+// defaultQuarantineMax caps the number of quarantined VMs unless
+// FAAS_VM_QUARANTINE_MAX is set
+const defaultQuarantineMax = 2
+
+// defaultFirecrackerBin is the path of the Firecracker binary used to launch
+// VMs unless FAAS_FIRECRACKER_BIN is set
+const defaultFirecrackerBin = "/usr/local/bin/firecracker"
+
+// defaultKernelArgs is the kernel command line VMs boot with unless
+// FAAS_VM_KERNEL_ARGS is set
+const defaultKernelArgs = "console=ttyS0 reboot=k panic=1 pci=off"
+
+const (
+	// bootTimeout is how long createVM waits for a new VM's daemon to become healthy
+	bootTimeout = 30 * time.Second
+	// capacityWaitTimeout is how long GetVMForFunction waits for a VM to be
+	// returned when the VM cap has been reached
+	capacityWaitTimeout = 10 * time.Second
+	// asyncAdmissionTimeout is how long an async execution waits for VM
+	// capacity outside the share reserved for sync invokes
+	asyncAdmissionTimeout = time.Minute
+	// agentRequestTimeout bounds a VM creation request to a host agent
+	agentRequestTimeout = bootTimeout + 10*time.Second
+	// daemonInfoTimeout bounds a request for a daemon's version and capabilities
+	daemonInfoTimeout = 5 * time.Second
+	// daemonProbeTimeout bounds a readiness probe of a daemon's health endpoint
+	daemonProbeTimeout = 2 * time.Second
+	// hostStaleAfter is how long a host agent may go without a heartbeat
+	// before no new VMs are placed on it
+	hostStaleAfter = 90 * time.Second
+	// warmPoolInterval is how often the warm pool is checked for missing VMs
+	warmPoolInterval = 10 * time.Second
+	// storageSweepInterval is how often orphaned VM storage directories are removed
+	storageSweepInterval = 5 * time.Minute
+	// quarantineSweepInterval is how often quarantined VMs past their window
+	// are terminated
+	quarantineSweepInterval = 30 * time.Second
 )
 
+// getFirecrackerBin returns the path of the Firecracker binary
+func getFirecrackerBin() string {
+	if bin := os.Getenv(EnvFirecrackerBin); bin != "" {
+		return bin
+	}
+	return defaultFirecrackerBin
+}
+
 // getDefaultKernelPath returns the default kernel path
 func getDefaultKernelPath() string {
 	// Check environment variable first
@@ -24,6 +138,41 @@ func getDefaultKernelPath() string {
 	return filepath.Join("/home", "bluequbit", "Dev", "faas", "assets", "vmlinux-5.10.225")
 }
 
+// DataDir returns the directory under which the control plane keeps its
+// storage directories unless they are configured individually
+func DataDir() string {
+	// Check environment variable first
+	if dir := os.Getenv(EnvDataDir); dir != "" {
+		return dir
+	}
+	return defaultDataDir
+}
+
+// getVMStorageDir returns the directory holding each VM's socket, logs and
+// console output
+func getVMStorageDir() string {
+	// Check environment variable first
+	if dir := os.Getenv(EnvVMStorageDir); dir != "" {
+		return dir
+	}
+	return filepath.Join(DataDir(), "vm-storage")
+}
+
+// PrepareStorageDir creates a storage directory if needed and checks that it
+// is writable, so a misconfigured path fails at startup rather than on the
+// first write
+func PrepareStorageDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create storage directory %s: %v", dir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".write-check-")
+	if err != nil {
+		return fmt.Errorf("storage directory %s is not writable: %v", dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
 // getDefaultRootFSPath returns the default rootfs path
 func getDefaultRootFSPath() string {
 	// Check environment variable first
@@ -34,8 +183,175 @@ func getDefaultRootFSPath() string {
 	return filepath.Join("/home", "bluequbit", "Dev", "faas", "scripts", "rootfs.ext4")
 }
 
-// getDefaultMemoryMB returns the default memory in MB
-func getDefaultMemoryMB() int {
+// parseAssignments parses a comma-separated list of name=value pairs from
+// the given environment variable
+func parseAssignments(env string) (map[string]string, error) {
+	assignments := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(env), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("%s must be a comma-separated list of name=value pairs, got %q", env, pair)
+		}
+		assignments[name] = value
+	}
+	return assignments, nil
+}
+
+// getRuntimeRootFS returns the rootfs images of runtimes that don't boot
+// from the default image, keyed by runtime
+func getRuntimeRootFS() (map[string]string, error) {
+	images, err := parseAssignments(EnvVMRuntimeRootFS)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := images[defaultPoolName]; ok {
+		return nil, fmt.Errorf("%s can't name a runtime %q; set %s for the default image", EnvVMRuntimeRootFS, defaultPoolName, EnvVMRootFSPath)
+	}
+	return images, nil
+}
+
+// getWarmPoolSizes returns the target size of each warm pool, keyed by the
+// runtime with its own image or defaultPoolName. Pools not listed are kept
+// at defaultWarmPoolSize.
+func getWarmPoolSizes(runtimeImages map[string]string) (map[string]int, error) {
+	assignments, err := parseAssignments(EnvWarmPoolSizes)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int)
+	for name, value := range assignments {
+		if _, ok := runtimeImages[name]; !ok && name != defaultPoolName {
+			return nil, fmt.Errorf("%s names %q, which is neither %q nor a runtime in %s", EnvWarmPoolSizes, name, defaultPoolName, EnvVMRuntimeRootFS)
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%s must give each pool a size of 0 or more, got %q for %q", EnvWarmPoolSizes, value, name)
+		}
+		sizes[name] = size
+	}
+	return sizes, nil
+}
+
+// getDataDrives returns the read-only data drive images attached to VMs,
+// keyed by the runtime with its own image or defaultPoolName. Pools not
+// listed boot without one.
+func getDataDrives(runtimeImages map[string]string) (map[string]string, error) {
+	drives, err := parseAssignments(EnvVMDataDrives)
+	if err != nil {
+		return nil, err
+	}
+	for name := range drives {
+		if _, ok := runtimeImages[name]; !ok && name != defaultPoolName {
+			return nil, fmt.Errorf("%s names %q, which is neither %q nor a runtime in %s", EnvVMDataDrives, name, defaultPoolName, EnvVMRuntimeRootFS)
+		}
+	}
+	return drives, nil
+}
+
+// getKernelArgs returns the kernel command line for new VMs. An explicitly
+// set but blank value is an error rather than a silent fallback, since
+// booting with no console or init arguments is never what was meant.
+func getKernelArgs() (string, error) {
+	args, ok := os.LookupEnv(EnvVMKernelArgs)
+	if !ok {
+		return defaultKernelArgs, nil
+	}
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return "", fmt.Errorf("%s is set but empty", EnvVMKernelArgs)
+	}
+	return args, nil
+}
+
+// getVMIDScheme returns the scheme new VM IDs follow
+func getVMIDScheme() (string, error) {
+	switch scheme := os.Getenv(EnvVMIDScheme); scheme {
+	case "", VMIDUUID:
+		return VMIDUUID, nil
+	case VMIDSequential:
+		return VMIDSequential, nil
+	default:
+		return "", fmt.Errorf("%s must be %q or %q, got %q", EnvVMIDScheme, VMIDUUID, VMIDSequential, scheme)
+	}
+}
+
+// getVMIDPrefix returns the prefix of sequential VM IDs
+func getVMIDPrefix() string {
+	if prefix := os.Getenv(EnvVMIDPrefix); prefix != "" {
+		return prefix
+	}
+	return defaultVMIDPrefix
+}
+
+// getWarmPoolStrategy returns the warm pool replenishment strategy
+func getWarmPoolStrategy() (string, error) {
+	switch strategy := os.Getenv(EnvWarmPoolStrategy); strategy {
+	case "", WarmPoolLazy:
+		return WarmPoolLazy, nil
+	case WarmPoolEager:
+		return WarmPoolEager, nil
+	default:
+		return "", fmt.Errorf("%s must be %q or %q, got %q", EnvWarmPoolStrategy, WarmPoolLazy, WarmPoolEager, strategy)
+	}
+}
+
+// getSyncReserve returns the share of VM capacity async executions may not
+// use, from 0 up to but excluding 1
+func getSyncReserve() (float64, error) {
+	value := os.Getenv(EnvVMSyncReserve)
+	if value == "" {
+		return defaultSyncReserve, nil
+	}
+	reserve, err := strconv.ParseFloat(value, 64)
+	if err != nil || reserve < 0 || reserve >= 1 {
+		return 0, fmt.Errorf("%s must be a ratio from 0 up to 1, got %q", EnvVMSyncReserve, value)
+	}
+	return reserve, nil
+}
+
+// getQuarantineWindow returns how long VMs are quarantined for; zero, the
+// default, turns quarantine off
+func getQuarantineWindow() time.Duration {
+	// Check environment variable first
+	if seconds := os.Getenv(EnvVMQuarantineSeconds); seconds != "" {
+		if val, err := strconv.Atoi(seconds); err == nil && val > 0 {
+			return time.Duration(val) * time.Second
+		}
+	}
+	// Default to recycling VMs right away
+	return 0
+}
+
+// getQuarantineMax returns the maximum number of VMs quarantined at once
+func getQuarantineMax() int {
+	// Check environment variable first
+	if max := os.Getenv(EnvVMQuarantineMax); max != "" {
+		if val, err := strconv.Atoi(max); err == nil && val > 0 {
+			return val
+		}
+	}
+	return defaultQuarantineMax
+}
+
+// getWarmPoolLowWatermark returns the pool size below which the eager
+// strategy refills the pool, capped at the pool size
+func getWarmPoolLowWatermark(poolSize int) int {
+	// Check environment variable first
+	if mark := os.Getenv(EnvWarmPoolLowWatermark); mark != "" {
+		if val, err := strconv.Atoi(mark); err == nil && val > 0 && val <= poolSize {
+			return val
+		}
+	}
+	// Default to refilling whenever a VM is missing
+	return poolSize
+}
+
+// DefaultMemoryMB returns the memory in MB given to each VM
+func DefaultMemoryMB() int {
 	// Check environment variable first
 	if mem := os.Getenv(EnvVMMemoryMB); mem != "" {
 		if val, err := strconv.Atoi(mem); err == nil && val > 0 {
@@ -46,8 +362,9 @@ func getDefaultMemoryMB() int {
 	return 128
 }
 
-// getDefaultCPUCount returns the default CPU count
-func getDefaultCPUCount() int {
+// DefaultCPUCount returns the number of vCPUs VMs boot with, and those of
+// the warm pools, unless a function asks for another count
+func DefaultCPUCount() int {
 	// Check environment variable first
 	if cpu := os.Getenv(EnvVMCPUCount); cpu != "" {
 		if val, err := strconv.Atoi(cpu); err == nil && val > 0 {
@@ -57,3 +374,34 @@ func getDefaultCPUCount() int {
 	// Default to 1 CPU
 	return 1
 }
+
+// getSlowBootThreshold returns the boot duration above which a warning is logged
+func getSlowBootThreshold() time.Duration {
+	// Check environment variable first
+	if ms := os.Getenv(EnvVMSlowBootMS); ms != "" {
+		if val, err := strconv.Atoi(ms); err == nil && val > 0 {
+			return time.Duration(val) * time.Millisecond
+		}
+	}
+	// Default to 5 seconds
+	return 5 * time.Second
+}
+
+// getMaxVMs returns the maximum number of VMs (pooled, checked out and
+// being created) the manager may have at once
+func getMaxVMs() int {
+	// Check environment variable first
+	if max := os.Getenv(EnvVMMaxVMs); max != "" {
+		if val, err := strconv.Atoi(max); err == nil && val > 0 {
+			return val
+		}
+	}
+	// Default to 20 VMs
+	return 20
+}
+
+// HostToken returns the shared token host agents register with, or "" if
+// host agents are disabled
+func HostToken() string {
+	return os.Getenv(EnvHostToken)
+}
@@ -0,0 +1,101 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// consoleFile is the file in a VM's directory receiving Firecracker's output,
+// including the guest serial console
+const consoleFile = "console.log"
+
+// DefaultConsoleLines is the number of console lines returned when none is requested
+const DefaultConsoleLines = 100
+
+// maxConsoleTail bounds how much of the end of a console log is read
+const maxConsoleTail = 1 << 20
+
+// ErrNoConsole is returned when no console output was captured for a VM,
+// e.g. for the test host VM or a VM whose directory was removed
+var ErrNoConsole = errors.New("no console output for VM")
+
+// ConsoleOutput returns the last lines of a VM's serial console. It also
+// works for VMs that failed to boot, whose directory is kept for diagnosis.
+func (m *VMManager) ConsoleOutput(id string, lines int) ([]byte, error) {
+	if id == "" || filepath.Base(id) != id || id == "." || id == ".." {
+		return nil, fmt.Errorf("%w %q", ErrNoConsole, id)
+	}
+
+	m.mu.Lock()
+	vmInstance, exists := m.vms[id]
+	m.mu.Unlock()
+	if exists && vmInstance.HostID != "" {
+		return m.remoteConsoleOutput(vmInstance, lines)
+	}
+
+	return tailFile(filepath.Join(m.vmDir, id, consoleFile), lines)
+}
+
+// remoteConsoleOutput fetches the console of a VM from the host agent running it
+func (m *VMManager) remoteConsoleOutput(vmInstance *VMInstance, lines int) ([]byte, error) {
+	url := vmInstance.HostAddress + agentVMsPath + "/" + vmInstance.ID + "/console?lines=" + strconv.Itoa(lines)
+	resp, err := m.agentClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach host %s: %v", vmInstance.HostID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w %s", ErrNoConsole, vmInstance.ID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("host %s failed to return console: status %d", vmInstance.HostID, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxConsoleTail))
+}
+
+// tailFile returns the last n lines of a file, reading at most maxConsoleTail bytes
+func tailFile(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w %s", ErrNoConsole, filepath.Base(filepath.Dir(path)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - maxConsoleTail
+	if offset < 0 {
+		offset = 0
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop a trailing newline so it doesn't count as an empty last line
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	start := end
+	for count := 0; start > 0; start-- {
+		if data[start-1] == '\n' {
+			count++
+			if count == n {
+				break
+			}
+		}
+	}
+	return data[start:], nil
+}
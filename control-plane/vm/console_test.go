@@ -0,0 +1,72 @@
+package vm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConsoleOutputTestHostVM(t *testing.T) {
+	m := newTestVMManager(t)
+	vm, err := m.CreateTestHostVM()
+	if err != nil {
+		t.Fatalf("CreateTestHostVM: %v", err)
+	}
+
+	// The test host VM has no Firecracker process and so no console
+	if _, err := m.ConsoleOutput(vm.ID, DefaultConsoleLines); !errors.Is(err, ErrNoConsole) {
+		t.Fatalf("ConsoleOutput() error = %v, want %v", err, ErrNoConsole)
+	}
+
+	// Output captured in the VM's directory is returned
+	dir := filepath.Join(m.vmDir, vm.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, consoleFile), []byte("booting\nmounting rootfs\nkernel panic\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output, err := m.ConsoleOutput(vm.ID, 2)
+	if err != nil {
+		t.Fatalf("ConsoleOutput: %v", err)
+	}
+	if string(output) != "mounting rootfs\nkernel panic\n" {
+		t.Errorf("console = %q, want the last 2 lines", output)
+	}
+}
+
+func TestConsoleOutputRejectsPaths(t *testing.T) {
+	m := newTestVMManager(t)
+	for _, id := range []string{"", ".", "..", "../etc", "a/b"} {
+		if _, err := m.ConsoleOutput(id, 1); !errors.Is(err, ErrNoConsole) {
+			t.Errorf("ConsoleOutput(%q) error = %v, want %v", id, err, ErrNoConsole)
+		}
+	}
+}
+
+func TestTailFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), consoleFile)
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		lines int
+		want  string
+	}{
+		{1, "three\n"},
+		{2, "two\nthree\n"},
+		{3, "one\ntwo\nthree\n"},
+		{10, "one\ntwo\nthree\n"},
+	}
+	for _, tt := range tests {
+		got, err := tailFile(path, tt.lines)
+		if err != nil {
+			t.Fatalf("tailFile: %v", err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("tailFile(%d) = %q, want %q", tt.lines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package vm
+
+import (
+	firecracker "github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+)
+
+// The data drive is attached after the root drive, so the guest sees it as
+// the second virtio block device. The daemon finds it in the kernel
+// parameter and mounts it read-only.
+const (
+	dataDeviceParam = "FAAS_DATA_DEVICE"
+	dataDevice      = "/dev/vdb"
+)
+
+// vmDrives returns the drives a VM boots with: its root filesystem and, if
+// the pool has one, the read-only data drive
+func vmDrives(config VMConfig) []models.Drive {
+	drives := []models.Drive{
+		{
+			DriveID:      firecracker.String("1"),
+			PathOnHost:   firecracker.String(config.RootFS),
+			IsRootDevice: firecracker.Bool(true),
+			IsReadOnly:   firecracker.Bool(false),
+		},
+	}
+	if config.DataDrive != "" {
+		drives = append(drives, models.Drive{
+			DriveID:      firecracker.String("data"),
+			PathOnHost:   firecracker.String(config.DataDrive),
+			IsRootDevice: firecracker.Bool(false),
+			IsReadOnly:   firecracker.Bool(true),
+		})
+	}
+	return drives
+}
@@ -0,0 +1,44 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDataDriveInFirecrackerConfig(t *testing.T) {
+	t.Setenv(EnvVMRuntimeRootFS, "nodejs18=/images/node.ext4")
+	t.Setenv(EnvVMDataDrives, "default=/images/models.ext4")
+	m := newTestVMManager(t)
+
+	config := m.vmConfig(m.poolFor("python3"), 0)
+	drives := firecrackerConfig("vm-1", t.TempDir(), config).Drives
+	if len(drives) != 2 {
+		t.Fatalf("VM has %d drives, want the root and data drives", len(drives))
+	}
+	root, data := drives[0], drives[1]
+	if *root.PathOnHost != config.RootFS || !*root.IsRootDevice {
+		t.Errorf("first drive is %s, want the root filesystem %s", *root.PathOnHost, config.RootFS)
+	}
+	if *data.DriveID != "data" || *data.PathOnHost != "/images/models.ext4" || !*data.IsReadOnly || *data.IsRootDevice {
+		t.Errorf("data drive = %s at %s (read-only %v, root %v), want the read-only image", *data.DriveID, *data.PathOnHost, *data.IsReadOnly, *data.IsRootDevice)
+	}
+	if !strings.Contains(config.KernelArgs, dataDeviceParam+"="+dataDevice) {
+		t.Errorf("kernel arguments %q don't pass the data device", config.KernelArgs)
+	}
+
+	// The Node pool isn't listed, so its VMs boot without a data drive
+	config = m.vmConfig(m.poolFor("nodejs18"), 0)
+	if drives := firecrackerConfig("vm-2", t.TempDir(), config).Drives; len(drives) != 1 {
+		t.Errorf("Node VM has %d drives, want only its root filesystem", len(drives))
+	}
+	if strings.Contains(config.KernelArgs, dataDeviceParam) {
+		t.Errorf("kernel arguments %q pass a data device without a drive", config.KernelArgs)
+	}
+}
+
+func TestDataDrivesRejectUnknownPool(t *testing.T) {
+	t.Setenv(EnvVMDataDrives, "go1.22=/images/models.ext4")
+	if _, err := newWarmPools(); err == nil {
+		t.Error("a data drive was accepted for a runtime without an image")
+	}
+}
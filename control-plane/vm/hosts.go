@@ -0,0 +1,205 @@
+package vm
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+// hostLoad is a placement candidate: a host and the number of VMs it runs,
+// including those still being created. The control plane's own host has an
+// empty ID.
+type hostLoad struct {
+	ID       string
+	Address  string
+	Capacity int
+	VMs      int
+}
+
+// pickHost returns the least-loaded host with spare capacity. Load is the
+// fraction of a host's capacity in use; ties go to the host running fewer
+// VMs, then to the lowest ID, so placement is deterministic.
+func pickHost(hosts []hostLoad) (hostLoad, bool) {
+	var best hostLoad
+	found := false
+	for _, host := range hosts {
+		if host.VMs >= host.Capacity {
+			continue
+		}
+		if !found || lessLoaded(host, best) {
+			best, found = host, true
+		}
+	}
+	return best, found
+}
+
+// lessLoaded reports whether a is less loaded than b
+func lessLoaded(a, b hostLoad) bool {
+	// Compare a.VMs/a.Capacity with b.VMs/b.Capacity without dividing
+	if la, lb := a.VMs*b.Capacity, b.VMs*a.Capacity; la != lb {
+		return la < lb
+	}
+	if a.VMs != b.VMs {
+		return a.VMs < b.VMs
+	}
+	return a.ID < b.ID
+}
+
+// HostTokenHeader carries the host token on host agent registrations
+const HostTokenHeader = "X-Host-Token"
+
+// VerifyHostToken reports whether r carries the given host token. Without a
+// token no request is accepted.
+func VerifyHostToken(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(HostTokenHeader)), []byte(token)) == 1
+}
+
+// ErrInvalidHost is returned when a host agent registration is malformed
+var ErrInvalidHost = errors.New("invalid host")
+
+// RegisterHost records a host agent heartbeat so VMs can be placed on it
+func (m *VMManager) RegisterHost(host *state.Host) error {
+	if host.ID == "" || host.Address == "" {
+		return fmt.Errorf("%w: id and address are required", ErrInvalidHost)
+	}
+	if host.Capacity <= 0 {
+		return fmt.Errorf("%w: capacity must be positive", ErrInvalidHost)
+	}
+
+	host.Address = strings.TrimRight(host.Address, "/")
+	host.LastSeen = time.Now()
+	host.CreatedAt = host.LastSeen
+	if existing, err := m.stateManager.GetHost(host.ID); err == nil {
+		host.CreatedAt = existing.CreatedAt
+	}
+	return m.stateManager.SaveHost(host)
+}
+
+// ListHosts lists all registered host agents
+func (m *VMManager) ListHosts() ([]state.Host, error) {
+	return m.stateManager.ListHosts()
+}
+
+// liveHosts returns the registered host agents that have sent a heartbeat
+// recently enough to receive VMs
+func (m *VMManager) liveHosts() []state.Host {
+	hosts, err := m.stateManager.ListHosts()
+	if err != nil {
+		m.logger.Errorf("Failed to list hosts: %v", err)
+		return nil
+	}
+
+	live := hosts[:0]
+	for _, host := range hosts {
+		if host.Capacity > 0 && time.Since(host.LastSeen) < hostStaleAfter {
+			live = append(live, host)
+		}
+	}
+	return live
+}
+
+// reserveHost picks the least-loaded host and reserves capacity on it for a
+// new VM, returning false if every host is at capacity
+func (m *VMManager) reserveHost() (hostLoad, bool) {
+	hosts := m.liveHosts()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	running := make(map[string]int)
+	for _, vmInstance := range m.vms {
+		running[vmInstance.HostID]++
+	}
+
+	candidates := []hostLoad{{Capacity: m.maxVMs}}
+	for _, host := range hosts {
+		candidates = append(candidates, hostLoad{ID: host.ID, Address: host.Address, Capacity: host.Capacity})
+	}
+	for i := range candidates {
+		candidates[i].VMs = running[candidates[i].ID] + m.creating[candidates[i].ID]
+	}
+
+	host, ok := pickHost(candidates)
+	if ok {
+		m.creating[host.ID]++
+	}
+	return host, ok
+}
+
+// createRemoteVM asks a host agent to boot a VM and tracks it locally
+func (m *VMManager) createRemoteVM(host hostLoad, isWarm bool, runtime string, cpu int) (*state.VM, error) {
+	body, err := json.Marshal(agentCreateRequest{IsWarm: isWarm, Runtime: runtime, CPU: cpu})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.agentClient.Post(host.Address+agentVMsPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach host %s: %v", host.ID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("host %s failed to create VM: %s", host.ID, strings.TrimSpace(string(msg)))
+	}
+
+	var vm state.VM
+	if err := json.NewDecoder(resp.Body).Decode(&vm); err != nil {
+		return nil, fmt.Errorf("invalid response from host %s: %v", host.ID, err)
+	}
+	vm.HostID = host.ID
+
+	m.mu.Lock()
+	m.vms[vm.ID] = &VMInstance{
+		ID:           vm.ID,
+		IP:           vm.IP,
+		Status:       vm.Status,
+		CreatedAt:    vm.CreatedAt,
+		LastUsed:     vm.LastUsed,
+		Memory:       vm.Memory,
+		CPU:          vm.CPU,
+		IsWarm:       vm.IsWarm,
+		BootDuration: time.Duration(vm.BootDuration) * time.Millisecond,
+		HostID:       host.ID,
+		HostAddress:  host.Address,
+	}
+	m.mu.Unlock()
+
+	if err := m.stateManager.SaveVM(&vm); err != nil {
+		m.logger.Errorf("Failed to save VM to state manager: %v", err)
+	}
+
+	m.logger.Infof("Created VM %s on host %s", vm.ID, host.ID)
+	return &vm, nil
+}
+
+// terminateRemoteVM asks the host agent running a VM to stop it
+func (m *VMManager) terminateRemoteVM(vmInstance *VMInstance) error {
+	req, err := http.NewRequest(http.MethodDelete, vmInstance.HostAddress+agentVMsPath+"/"+vmInstance.ID, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.agentClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach host %s: %v", vmInstance.HostID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("host %s failed to terminate VM: status %d", vmInstance.HostID, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestVMManager creates a VM manager, without the warm pool, backed by a
+// fresh database in a temporary directory
+func newTestVMManager(t *testing.T) *VMManager {
+	t.Helper()
+
+	// The database is created in the working directory
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv(EnvDataDir, dir)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	stateManager, err := state.NewStateManager(logger)
+	if err != nil {
+		t.Fatalf("failed to create state manager: %v", err)
+	}
+	m, err := newVMManager(stateManager, logger, true)
+	if err != nil {
+		t.Fatalf("failed to create VM manager: %v", err)
+	}
+	t.Cleanup(m.Cleanup)
+	return m
+}
+
+// testAgent is a mock host agent that boots VMs instantly
+type testAgent struct {
+	*httptest.Server
+	mu      sync.Mutex
+	created []agentCreateRequest
+}
+
+// newTestAgent starts a mock host agent and registers it with m as the only
+// host with room for VMs, so that VMs can be created without Firecracker
+func newTestAgent(t *testing.T, m *VMManager, capacity int) *testAgent {
+	t.Helper()
+	agent := &testAgent{}
+	agent.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == agentVMsPath:
+			var request agentCreateRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			agent.mu.Lock()
+			agent.created = append(agent.created, request)
+			id := fmt.Sprintf("vm-remote-%d", len(agent.created))
+			agent.mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(state.VM{ID: id, IP: "172.16.1.2", Status: "ready", Runtime: request.Runtime, CPU: request.CPU})
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(agent.Close)
+
+	m.maxVMs = 0
+	if err := m.RegisterHost(&state.Host{ID: "host-2", Address: agent.URL, Capacity: capacity}); err != nil {
+		t.Fatalf("RegisterHost: %v", err)
+	}
+	return agent
+}
+
+// requests returns the create requests the agent received
+func (a *testAgent) requests() []agentCreateRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]agentCreateRequest(nil), a.created...)
+}
+
+func TestPickHost(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []hostLoad
+		want  string
+		ok    bool
+	}{
+		{
+			name: "no hosts",
+		},
+		{
+			name:  "all at capacity",
+			hosts: []hostLoad{{ID: "", Capacity: 2, VMs: 2}, {ID: "a", Capacity: 1, VMs: 1}},
+		},
+		{
+			name:  "least loaded relative to capacity",
+			hosts: []hostLoad{{ID: "", Capacity: 4, VMs: 3}, {ID: "a", Capacity: 10, VMs: 5}},
+			want:  "a",
+			ok:    true,
+		},
+		{
+			name:  "skips full hosts",
+			hosts: []hostLoad{{ID: "", Capacity: 2, VMs: 2}, {ID: "a", Capacity: 8, VMs: 7}},
+			want:  "a",
+			ok:    true,
+		},
+		{
+			name:  "equal load goes to fewer VMs",
+			hosts: []hostLoad{{ID: "a", Capacity: 10, VMs: 5}, {ID: "b", Capacity: 2, VMs: 1}},
+			want:  "b",
+			ok:    true,
+		},
+		{
+			name:  "equal load and VMs goes to the lowest ID",
+			hosts: []hostLoad{{ID: "b", Capacity: 4, VMs: 1}, {ID: "a", Capacity: 4, VMs: 1}},
+			want:  "a",
+			ok:    true,
+		},
+		{
+			name:  "idle hosts before busy ones",
+			hosts: []hostLoad{{ID: "", Capacity: 4, VMs: 1}, {ID: "a", Capacity: 1, VMs: 0}},
+			want:  "a",
+			ok:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, ok := pickHost(tt.hosts)
+			if ok != tt.ok || host.ID != tt.want {
+				t.Errorf("pickHost() = %q, %v; want %q, %v", host.ID, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestReserveHostSpreadsVMs(t *testing.T) {
+	m := newTestVMManager(t)
+	m.maxVMs = 2
+	if err := m.RegisterHost(&state.Host{ID: "host-2", Address: "http://10.0.0.2:8080", Capacity: 2}); err != nil {
+		t.Fatalf("RegisterHost: %v", err)
+	}
+
+	// Reservations alternate between the two equally sized hosts until both
+	// are full
+	var placed []string
+	for i := 0; i < 4; i++ {
+		host, ok := m.reserveHost()
+		if !ok {
+			t.Fatalf("reservation %d found no host", i+1)
+		}
+		placed = append(placed, host.ID)
+	}
+	want := []string{"", "host-2", "", "host-2"}
+	for i := range want {
+		if placed[i] != want[i] {
+			t.Fatalf("placed VMs on %q, want %q", placed, want)
+		}
+	}
+	if _, ok := m.reserveHost(); ok {
+		t.Error("reserved a host beyond the capacity of both")
+	}
+}
+
+func TestReserveHostCountsRunningVMs(t *testing.T) {
+	m := newTestVMManager(t)
+	m.maxVMs = 4
+	if err := m.RegisterHost(&state.Host{ID: "host-2", Address: "http://10.0.0.2:8080", Capacity: 4}); err != nil {
+		t.Fatalf("RegisterHost: %v", err)
+	}
+	m.vms["local-1"] = &VMInstance{ID: "local-1"}
+	m.vms["local-2"] = &VMInstance{ID: "local-2"}
+	m.vms["remote-1"] = &VMInstance{ID: "remote-1", HostID: "host-2"}
+
+	host, ok := m.reserveHost()
+	if !ok || host.ID != "host-2" {
+		t.Errorf("reserveHost() = %q, %v; want the less loaded host-2", host.ID, ok)
+	}
+}
+
+func TestReserveHostSkipsStaleHosts(t *testing.T) {
+	m := newTestVMManager(t)
+	m.maxVMs = 1
+	m.vms["local-1"] = &VMInstance{ID: "local-1"}
+	stale := &state.Host{ID: "host-2", Address: "http://10.0.0.2:8080", Capacity: 4}
+	if err := m.RegisterHost(stale); err != nil {
+		t.Fatalf("RegisterHost: %v", err)
+	}
+	stale.LastSeen = time.Now().Add(-2 * hostStaleAfter)
+	if err := m.stateManager.SaveHost(stale); err != nil {
+		t.Fatal(err)
+	}
+
+	if host, ok := m.reserveHost(); ok {
+		t.Errorf("reserved host %q, want none with the only agent stale", host.ID)
+	}
+}
+
+func TestCreateRemoteVM(t *testing.T) {
+	m := newTestVMManager(t)
+
+	var request agentCreateRequest
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != agentVMsPath {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(state.VM{ID: "vm-remote", IP: "172.16.1.2", Status: "busy", CPU: request.CPU})
+	}))
+	defer agent.Close()
+
+	vm, err := m.createRemoteVM(hostLoad{ID: "host-2", Address: agent.URL, Capacity: 4}, false, "python3.10", 2)
+	if err != nil {
+		t.Fatalf("createRemoteVM: %v", err)
+	}
+	if request.Runtime != "python3.10" || request.CPU != 2 || request.IsWarm {
+		t.Errorf("agent received %+v", request)
+	}
+	if vm.ID != "vm-remote" || vm.HostID != "host-2" {
+		t.Errorf("created VM %s on host %q, want vm-remote on host-2", vm.ID, vm.HostID)
+	}
+	if instance := m.vms["vm-remote"]; instance == nil || instance.HostAddress != agent.URL {
+		t.Errorf("remote VM isn't tracked with its host's address")
+	}
+	if stored, err := m.stateManager.GetVM("vm-remote"); err != nil || stored.HostID != "host-2" {
+		t.Errorf("remote VM isn't stored with its host: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	firecracker "github.com/firecracker-microvm/firecracker-go-sdk"
+)
+
+// Kernel parameters carrying a VM's identity to its daemon, which reads them
+// from /proc/cmdline
+const (
+	identityParamID = "VM_ID"
+	identityParamIP = "VM_IP"
+)
+
+// identityHandlerName names the handler that adds the identity parameters
+const identityHandlerName = "skyscale.SetIdentity"
+
+// withIdentity makes machine boot with its VM ID and IP on the kernel command
+// line. The IP is only known once CNI has configured the network, so the
+// parameters are added right after the SDK has built the kernel arguments
+// from it and before the boot source is sent to Firecracker.
+func withIdentity(machine *firecracker.Machine, id string) {
+	machine.Handlers.FcInit = machine.Handlers.FcInit.AppendAfter(firecracker.SetupKernelArgsHandlerName, firecracker.Handler{
+		Name: identityHandlerName,
+		Fn: func(ctx context.Context, m *firecracker.Machine) error {
+			var ip net.IP
+			if len(m.Cfg.NetworkInterfaces) > 0 {
+				if static := m.Cfg.NetworkInterfaces[0].StaticConfiguration; static != nil && static.IPConfiguration != nil {
+					ip = static.IPConfiguration.IPAddr.IP
+				}
+			}
+			if ip == nil {
+				return fmt.Errorf("no IP address was assigned to VM %s", id)
+			}
+			m.Cfg.KernelArgs += fmt.Sprintf(" %s=%s %s=%s", identityParamID, id, identityParamIP, ip)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	firecracker "github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/sirupsen/logrus"
+)
+
+// identityMachine returns a machine whose FcInit handlers are only the
+// kernel argument setup, a no-op, and the identity handler
+func identityMachine(t *testing.T, cfg firecracker.Config) *firecracker.Machine {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg.SocketPath = filepath.Join(t.TempDir(), "firecracker.sock")
+	machine, err := firecracker.NewMachine(context.Background(), cfg, firecracker.WithLogger(logrus.NewEntry(logger)))
+	if err != nil {
+		t.Fatalf("NewMachine: %v", err)
+	}
+	machine.Handlers.FcInit = firecracker.HandlerList{}.Append(firecracker.Handler{
+		Name: firecracker.SetupKernelArgsHandlerName,
+		Fn:   func(context.Context, *firecracker.Machine) error { return nil },
+	})
+	withIdentity(machine, "vm-0001")
+	return machine
+}
+
+func TestWithIdentity(t *testing.T) {
+	machine := identityMachine(t, firecracker.Config{
+		KernelArgs: "console=ttyS0 reboot=k",
+		NetworkInterfaces: firecracker.NetworkInterfaces{{
+			StaticConfiguration: &firecracker.StaticNetworkConfiguration{
+				IPConfiguration: &firecracker.IPConfiguration{
+					IPAddr: net.IPNet{IP: net.ParseIP("172.16.0.2"), Mask: net.CIDRMask(24, 32)},
+				},
+			},
+		}},
+	})
+	if !machine.Handlers.FcInit.Has(identityHandlerName) {
+		t.Fatal("identity handler wasn't added")
+	}
+	if err := machine.Handlers.FcInit.Run(context.Background(), machine); err != nil {
+		t.Fatalf("FcInit: %v", err)
+	}
+	if want := "console=ttyS0 reboot=k VM_ID=vm-0001 VM_IP=172.16.0.2"; machine.Cfg.KernelArgs != want {
+		t.Errorf("kernel args = %q, want %q", machine.Cfg.KernelArgs, want)
+	}
+}
+
+func TestWithIdentityWithoutIP(t *testing.T) {
+	machine := identityMachine(t, firecracker.Config{KernelArgs: "console=ttyS0"})
+	err := machine.Handlers.FcInit.Run(context.Background(), machine)
+	if err == nil || !strings.Contains(err.Error(), "vm-0001") {
+		t.Errorf("FcInit error = %v, want the missing IP of vm-0001 reported", err)
+	}
+}
@@ -0,0 +1,37 @@
+package vm
+
+import (
+	"context"
+
+	firecracker "github.com/firecracker-microvm/firecracker-go-sdk"
+)
+
+// resultSecretMetadata is the metadata a VM boots with when result reports
+// are authenticated. The daemon reads it from the metadata service (MMDS)
+// at /skyscale before it answers health checks.
+type resultSecretMetadata struct {
+	Skyscale struct {
+		ResultSecret string `json:"result_secret"`
+	} `json:"skyscale"`
+}
+
+// withResultSecret makes machine boot with the result secret in its MMDS.
+// Unlike the kernel command line, the MMDS can be emptied once the daemon
+// has read it, so function code never sees the secret.
+func withResultSecret(machine *firecracker.Machine, secret string) {
+	if secret == "" {
+		return
+	}
+	var metadata resultSecretMetadata
+	metadata.Skyscale.ResultSecret = secret
+	machine.Handlers.FcInit = machine.Handlers.FcInit.Append(firecracker.NewSetMetadataHandler(metadata))
+}
+
+// clearResultSecret empties the MMDS of a machine booted with the result
+// secret
+func clearResultSecret(ctx context.Context, machine *firecracker.Machine, secret string) error {
+	if secret == "" {
+		return nil
+	}
+	return machine.SetMetadata(ctx, map[string]interface{}{})
+}
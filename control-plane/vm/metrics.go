@@ -0,0 +1,16 @@
+package vm
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// vmBootSeconds tracks how long VMs take from createVM to a healthy daemon
+var vmBootSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "skyscale_vm_boot_seconds",
+	Help:    "Time from VM creation start until the VM's daemon passes its health check.",
+	Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 20, 30},
+})
+
+func init() {
+	prometheus.MustRegister(vmBootSeconds)
+}
@@ -0,0 +1,64 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// bootSamples returns the number of boots the boot time histogram observed
+func bootSamples(t *testing.T) uint64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := vmBootSeconds.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestBootTimeRecorded(t *testing.T) {
+	m := newTestVMManager(t)
+	before := bootSamples(t)
+
+	vm, err := m.CreateTestHostVM()
+	if err != nil {
+		t.Fatalf("CreateTestHostVM: %v", err)
+	}
+	if got := bootSamples(t); got != before+1 {
+		t.Errorf("histogram observed %d boots, want %d", got-before, 1)
+	}
+
+	stored, err := m.stateManager.GetVM(vm.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.BootDuration < 0 || stored.BootDuration > time.Minute.Milliseconds() {
+		t.Errorf("stored boot duration = %dms", stored.BootDuration)
+	}
+	if m.vms[vm.ID].BootDuration <= 0 {
+		t.Errorf("VM instance has no boot duration")
+	}
+}
+
+func TestSlowBootWarning(t *testing.T) {
+	t.Setenv(EnvVMSlowBootMS, "10")
+	m := newTestVMManager(t)
+	hook := logtest.NewLocal(m.logger)
+
+	m.recordBoot("vm-fast", time.Now())
+	m.recordBoot("vm-slow", time.Now().Add(-time.Second))
+
+	var warnings []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel {
+			warnings = append(warnings, entry.Message)
+		}
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "vm-slow") {
+		t.Errorf("warnings = %q, want one about vm-slow", warnings)
+	}
+}
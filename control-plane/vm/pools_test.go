@@ -0,0 +1,92 @@
+package vm
+
+import "testing"
+
+// newRuntimePoolsManager creates a VM manager with a pool for nodejs18 besides
+// the default pool, booting VMs on a mock host agent
+func newRuntimePoolsManager(t *testing.T) (*VMManager, *testAgent) {
+	t.Helper()
+	t.Setenv(EnvVMRuntimeRootFS, "nodejs18=/images/node.ext4")
+	t.Setenv(EnvWarmPoolSizes, "default=1,nodejs18=2")
+	m := newTestVMManager(t)
+	return m, newTestAgent(t, m, 10)
+}
+
+func TestPoolPerRuntimeImage(t *testing.T) {
+	m, _ := newRuntimePoolsManager(t)
+
+	pools := m.WarmPools()
+	if len(pools) != 2 || pools[0].Name != defaultPoolName || pools[0].Target != 1 || pools[1].Name != "nodejs18" || pools[1].Target != 2 {
+		t.Errorf("warm pools = %+v, want default of 1 and nodejs18 of 2", pools)
+	}
+	if rootFS := m.poolFor("nodejs18").rootFS; rootFS != "/images/node.ext4" {
+		t.Errorf("nodejs18 pool boots %s, want its own image", rootFS)
+	}
+	// Runtimes without an image of their own share the default pool
+	if pool := m.poolFor("python3"); pool != m.pools[""] {
+		t.Errorf("python3 is served by pool %s, want the default pool", pool.name())
+	}
+	if runtime := m.PoolRuntime("python3"); runtime != "" {
+		t.Errorf("PoolRuntime(python3) = %q, want the default image", runtime)
+	}
+}
+
+func TestPythonInvokeNeverGetsNodeVM(t *testing.T) {
+	m, agent := newRuntimePoolsManager(t)
+	node := m.pools["nodejs18"]
+	m.addWarmVMs(node, 2)
+	if len(node.vms) != 2 {
+		t.Fatalf("nodejs18 pool has %d VMs, want 2", len(node.vms))
+	}
+
+	// The default pool is empty, so the Python invoke boots a VM from the
+	// default image rather than taking a warm Node VM
+	vm, coldStart, err := m.GetVMForFunction("python3", 0, PrioritySync)
+	if err != nil {
+		t.Fatalf("GetVMForFunction(python3): %v", err)
+	}
+	if vm.Runtime != "" || !coldStart {
+		t.Errorf("python3 got a VM of runtime %q, cold start %v; want a new VM from the default image", vm.Runtime, coldStart)
+	}
+	if len(node.vms) != 2 {
+		t.Errorf("nodejs18 pool has %d VMs after a Python invoke, want 2", len(node.vms))
+	}
+	requests := agent.requests()
+	if last := requests[len(requests)-1]; last.Runtime != "" {
+		t.Errorf("Python VM was booted for runtime %q", last.Runtime)
+	}
+
+	// A Node invoke takes a VM from its own pool
+	vm, coldStart, err = m.GetVMForFunction("nodejs18", 0, PrioritySync)
+	if err != nil {
+		t.Fatalf("GetVMForFunction(nodejs18): %v", err)
+	}
+	if vm.Runtime != "nodejs18" || coldStart {
+		t.Errorf("nodejs18 got a VM of runtime %q, cold start %v; want a warm Node VM", vm.Runtime, coldStart)
+	}
+	if len(node.vms) != 1 {
+		t.Errorf("nodejs18 pool has %d VMs, want 1 after the Node invoke", len(node.vms))
+	}
+}
+
+func TestReturnedVMJoinsItsRuntimePool(t *testing.T) {
+	m, _ := newRuntimePoolsManager(t)
+	vm, _, err := m.GetVMForFunction("nodejs18", 0, PrioritySync)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ReturnVM(vm.ID); err != nil {
+		t.Fatalf("ReturnVM: %v", err)
+	}
+	if len(m.pools["nodejs18"].vms) != 1 || len(m.pools[""].vms) != 0 {
+		t.Errorf("returned Node VM joined the wrong pool")
+	}
+}
+
+func TestWarmPoolSizesRejectUnknownRuntime(t *testing.T) {
+	t.Setenv(EnvVMRuntimeRootFS, "nodejs18=/images/node.ext4")
+	t.Setenv(EnvWarmPoolSizes, "go1.22=2")
+	if _, err := newWarmPools(); err == nil {
+		t.Error("a pool size was accepted for a runtime without an image")
+	}
+}
@@ -0,0 +1,56 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+)
+
+// preflight verifies that the host has everything needed to boot Firecracker
+// VMs, so that misconfiguration is reported at startup instead of on the
+// first createVM call
+func preflight(firecrackerBin, kernelPath string, pools map[string]*warmPool) error {
+	info, err := os.Stat(firecrackerBin)
+	if err != nil {
+		return fmt.Errorf("firecracker binary not found at %s: install Firecracker, set %s to its path or run the control plane with -test: %v", firecrackerBin, EnvFirecrackerBin, err)
+	}
+	if info.IsDir() || info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("firecracker binary at %s is not executable: run chmod +x %s", firecrackerBin, firecrackerBin)
+	}
+
+	if err := checkReadable(kernelPath); err != nil {
+		return fmt.Errorf("kernel image is not usable: %v (set %s to a valid vmlinux path)", err, EnvVMKernelPath)
+	}
+
+	for _, pool := range pools {
+		if err := checkReadable(pool.rootFS); err != nil {
+			if pool.runtime != "" {
+				return fmt.Errorf("root filesystem of runtime %s is not usable: %v (set it in %s to a valid rootfs image path)", pool.runtime, err, EnvVMRuntimeRootFS)
+			}
+			return fmt.Errorf("root filesystem is not usable: %v (set %s to a valid rootfs image path)", err, EnvVMRootFSPath)
+		}
+		if pool.dataDrive != "" {
+			if err := checkReadable(pool.dataDrive); err != nil {
+				return fmt.Errorf("data drive of the %s pool is not usable: %v (set it in %s to a valid ext4 image path)", pool.name(), err, EnvVMDataDrives)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkReadable checks that path is a regular file that can be opened for reading
+func checkReadable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,92 @@
+package vm
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, mode os.FileMode) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x"), mode); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	firecracker := write("firecracker", 0755)
+	notExecutable := write("firecracker.txt", 0644)
+	kernel := write("vmlinux", 0644)
+	rootFS := write("rootfs.ext4", 0644)
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name        string
+		firecracker string
+		kernel      string
+		pool        warmPool
+		want        string // Part of the error; empty for none
+	}{
+		{"usable host", firecracker, kernel, warmPool{rootFS: rootFS}, ""},
+		{"missing firecracker", missing, kernel, warmPool{rootFS: rootFS}, EnvFirecrackerBin},
+		{"firecracker not executable", notExecutable, kernel, warmPool{rootFS: rootFS}, "not executable"},
+		{"missing kernel", firecracker, missing, warmPool{rootFS: rootFS}, EnvVMKernelPath},
+		{"kernel is a directory", firecracker, dir, warmPool{rootFS: rootFS}, "is a directory"},
+		{"missing rootfs", firecracker, kernel, warmPool{rootFS: missing}, EnvVMRootFSPath},
+		{"missing runtime rootfs", firecracker, kernel, warmPool{runtime: "node18", rootFS: missing}, EnvVMRuntimeRootFS},
+		{"missing data drive", firecracker, kernel, warmPool{rootFS: rootFS, dataDrive: missing}, EnvVMDataDrives},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := tt.pool
+			err := preflight(tt.firecracker, tt.kernel, map[string]*warmPool{pool.name(): &pool})
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("preflight() = %v, want no error", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("preflight() = %v, want an error mentioning %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewVMManagerRunsPreflight(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(EnvDataDir, dir)
+	t.Setenv(EnvFirecrackerBin, filepath.Join(dir, "firecracker"))
+	t.Setenv(EnvVMKernelPath, filepath.Join(dir, "vmlinux"))
+	t.Setenv(EnvVMRootFSPath, filepath.Join(dir, "rootfs.ext4"))
+
+	_, err := newVMManager(nil, nil, false)
+	if err == nil || !strings.Contains(err.Error(), "firecracker binary not found") {
+		t.Fatalf("newVMManager() = %v, want the missing firecracker binary reported", err)
+	}
+}
+
+func TestFirecrackerBinConfigured(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "firecracker")
+	t.Setenv(EnvFirecrackerBin, bin)
+	m := newTestVMManager(t)
+	if m.firecrackerBin != bin {
+		t.Fatalf("firecracker binary = %s, want %s", m.firecrackerBin, bin)
+	}
+
+	cmd := m.firecrackerCommand(context.Background(), "/tmp/vm.sock", io.Discard)
+	if cmd.Path != bin {
+		t.Errorf("command runs %s, want the configured %s", cmd.Path, bin)
+	}
+	if args := strings.Join(cmd.Args[1:], " "); args != "--api-sock /tmp/vm.sock" {
+		t.Errorf("command arguments = %q, want the API socket", args)
+	}
+}
+
+func TestFirecrackerBinDefault(t *testing.T) {
+	t.Setenv(EnvFirecrackerBin, "")
+	if bin := getFirecrackerBin(); bin != defaultFirecrackerBin {
+		t.Errorf("firecracker binary = %s, want %s", bin, defaultFirecrackerBin)
+	}
+}
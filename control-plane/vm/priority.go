@@ -0,0 +1,126 @@
+package vm
+
+import (
+	"math"
+	"time"
+)
+
+// Priority is the class of request a VM is allocated for
+type Priority int
+
+const (
+	// PriorityAsync allocates for queued executions, which may wait
+	PriorityAsync Priority = iota
+	// PrioritySync allocates for a caller waiting on the result
+	PrioritySync
+)
+
+// String returns the name of the priority used in logs
+func (p Priority) String() string {
+	if p == PrioritySync {
+		return "sync"
+	}
+	return "async"
+}
+
+// asyncLimit returns how many VMs async executions may hold at once: the
+// capacity of this host and the live host agents, less the share reserved
+// for sync invokes
+func (m *VMManager) asyncLimit() int {
+	capacity := m.maxVMs
+	for _, host := range m.liveHosts() {
+		capacity += host.Capacity
+	}
+	return capacity - int(math.Floor(m.syncReserve*float64(capacity)))
+}
+
+// admitAsync takes one of the async executions' share of VMs, waiting while
+// the share is used up or a sync invoke is waiting for a VM. The slot is
+// held until the VM allocated with it is returned or terminated, or handed
+// back with releaseAdmission if allocation fails.
+func (m *VMManager) admitAsync() error {
+	timeout := time.NewTimer(asyncAdmissionTimeout)
+	defer timeout.Stop()
+
+	for {
+		limit := m.asyncLimit()
+
+		m.mu.Lock()
+		if m.asyncHeld < limit && m.syncWaiting == 0 {
+			m.asyncHeld++
+			m.mu.Unlock()
+			return nil
+		}
+		changed := m.allocationChanged
+		m.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return ErrCapacityExceeded
+		}
+	}
+}
+
+// holdAsync records that an admitted async execution got the VM id
+func (m *VMManager) holdAsync(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.asyncVMs[id] = true
+}
+
+// releaseAdmission hands back a slot taken by admitAsync whose allocation
+// failed
+func (m *VMManager) releaseAdmission() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.asyncHeld--
+	m.notifyAllocation()
+}
+
+// releaseAsync frees the async slot held by the VM id, if any
+func (m *VMManager) releaseAsync(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.asyncVMs[id] {
+		delete(m.asyncVMs, id)
+		m.asyncHeld--
+		m.notifyAllocation()
+	}
+}
+
+// waitingSync marks a sync invoke as waiting for a VM, holding back async
+// executions until the returned function is called
+func (m *VMManager) waitingSync() func() {
+	m.mu.Lock()
+	m.syncWaiting++
+	m.mu.Unlock()
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.syncWaiting--
+		m.notifyAllocation()
+	}
+}
+
+// notifyAllocation wakes async executions waiting in admitAsync. It must be
+// called with mu held.
+func (m *VMManager) notifyAllocation() {
+	close(m.allocationChanged)
+	m.allocationChanged = make(chan struct{})
+}
+
+// ReturnAsyncVM returns the VM id to its warm pool if an async execution
+// holds it, and does nothing otherwise. Sync invokes return their VMs
+// themselves; the VMs of async executions are returned once their result is
+// reported.
+func (m *VMManager) ReturnAsyncVM(id string) error {
+	m.mu.Lock()
+	held := m.asyncVMs[id]
+	m.mu.Unlock()
+	if !held {
+		return nil
+	}
+	return m.ReturnVM(id)
+}
@@ -0,0 +1,107 @@
+package vm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+// allocation is the outcome of a GetVMForFunction call
+type allocation struct {
+	vm  *state.VM
+	err error
+}
+
+// allocateAsync requests a VM for an async execution in the background
+func allocateAsync(m *VMManager, results chan<- allocation) {
+	go func() {
+		vm, _, err := m.GetVMForFunction("python3", 0, PriorityAsync)
+		results <- allocation{vm, err}
+	}()
+}
+
+func TestSyncInvokeGetsVMWhileAsyncSaturated(t *testing.T) {
+	m := newTestVMManager(t)
+	newTestAgent(t, m, 5)
+	m.syncReserve = 0.4 // Async executions may hold 3 of the 5 VMs
+
+	results := make(chan allocation, 4)
+	for i := 0; i < 4; i++ {
+		allocateAsync(m, results)
+	}
+	var held []*state.VM
+	for len(held) < 3 {
+		select {
+		case result := <-results:
+			if result.err != nil {
+				t.Fatalf("async allocation failed: %v", result.err)
+			}
+			held = append(held, result.vm)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("async executions got %d VMs, want 3", len(held))
+		}
+	}
+	select {
+	case result := <-results:
+		t.Fatalf("async execution got VM %v beyond its share of capacity", result.vm)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	start := time.Now()
+	if _, _, err := m.GetVMForFunction("python3", 0, PrioritySync); err != nil {
+		t.Fatalf("sync invoke got no VM with async executions saturating their share: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("sync invoke waited %v for a VM", elapsed)
+	}
+
+	// Returning an async execution's VM admits the waiting one
+	if err := m.ReturnAsyncVM(held[0].ID); err != nil {
+		t.Fatalf("ReturnAsyncVM: %v", err)
+	}
+	select {
+	case result := <-results:
+		if result.err != nil {
+			t.Fatalf("waiting async allocation failed: %v", result.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting async execution wasn't admitted once a VM was returned")
+	}
+}
+
+func TestAsyncLimit(t *testing.T) {
+	tests := []struct {
+		reserve float64
+		want    int
+	}{
+		{reserve: 0, want: 10},
+		{reserve: 0.2, want: 8},
+		{reserve: 0.25, want: 8}, // The reserve is rounded down
+		{reserve: 0.9, want: 1},
+	}
+	for _, tt := range tests {
+		m := newTestVMManager(t)
+		m.maxVMs = 4
+		if err := m.RegisterHost(&state.Host{ID: "host-2", Address: "http://10.0.0.2:8080", Capacity: 6}); err != nil {
+			t.Fatalf("RegisterHost: %v", err)
+		}
+		m.syncReserve = tt.reserve
+		if got := m.asyncLimit(); got != tt.want {
+			t.Errorf("asyncLimit() with a %v reserve = %d, want %d", tt.reserve, got, tt.want)
+		}
+	}
+}
+
+func TestSyncReserveValidated(t *testing.T) {
+	for _, value := range []string{"-0.1", "1", "half"} {
+		t.Setenv(EnvVMSyncReserve, value)
+		if _, err := getSyncReserve(); err == nil {
+			t.Errorf("%s=%s accepted", EnvVMSyncReserve, value)
+		}
+	}
+	t.Setenv(EnvVMSyncReserve, "")
+	if reserve, err := getSyncReserve(); err != nil || reserve != defaultSyncReserve {
+		t.Errorf("getSyncReserve() = %v, %v; want the default %v", reserve, err, defaultSyncReserve)
+	}
+}
@@ -0,0 +1,91 @@
+package vm
+
+import "time"
+
+// StatusQuarantined is the status of a VM held for inspection after a
+// failed execution
+const StatusQuarantined = "quarantined"
+
+// QuarantineVM holds the VM id for FAAS_VM_QUARANTINE_SECONDS instead of
+// reusing it, keeping its directory with the console and Firecracker logs,
+// and reports whether it did. It does nothing when quarantine is off, when
+// FAAS_VM_QUARANTINE_MAX VMs are already quarantined, and for the simulated
+// test VM, which has nothing to inspect. Quarantined VMs still count
+// towards the VM cap. reason is logged.
+func (m *VMManager) QuarantineVM(id, reason string) bool {
+	if m.quarantineWindow <= 0 {
+		return false
+	}
+
+	m.mu.Lock()
+	vmInstance, exists := m.vms[id]
+	if _, held := m.quarantined[id]; held || !exists || (vmInstance.Machine == nil && vmInstance.HostID == "") {
+		m.mu.Unlock()
+		return held
+	}
+	if len(m.quarantined) >= m.quarantineMax {
+		m.mu.Unlock()
+		m.logger.Warnf("Not quarantining VM %s after %s: %d VMs are already quarantined", id, reason, m.quarantineMax)
+		return false
+	}
+	until := time.Now().Add(m.quarantineWindow)
+	m.quarantined[id] = until
+	vmInstance.Status = StatusQuarantined
+	m.mu.Unlock()
+
+	if vm, err := m.stateManager.GetVM(id); err == nil {
+		vm.Status = StatusQuarantined
+		vm.IsWarm = false
+		if err := m.stateManager.SaveVM(vm); err != nil {
+			m.logger.Errorf("Failed to save quarantined VM %s: %v", id, err)
+		}
+	}
+	m.releaseAsync(id)
+
+	m.logger.Warnf("Quarantined VM %s after %s until %s; see GET /api/vms/%s/console", id, reason, until.Format(time.RFC3339), id)
+	return true
+}
+
+// isQuarantined reports whether the VM id is quarantined
+func (m *VMManager) isQuarantined(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, held := m.quarantined[id]
+	return held
+}
+
+// reapQuarantined periodically terminates quarantined VMs whose window has
+// passed, until Cleanup is called
+func (m *VMManager) reapQuarantined() {
+	ticker := time.NewTicker(quarantineSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.reapExpiredQuarantine(time.Now())
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// reapExpiredQuarantine terminates the quarantined VMs whose window ended
+// before now
+func (m *VMManager) reapExpiredQuarantine(now time.Time) {
+	m.mu.Lock()
+	var expired []string
+	for id, until := range m.quarantined {
+		if now.After(until) {
+			expired = append(expired, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, id := range expired {
+		m.logger.Infof("Quarantine of VM %s is over, terminating it", id)
+		if err := m.terminateVM(id); err != nil {
+			m.logger.Errorf("Failed to terminate quarantined VM %s: %v", id, err)
+		}
+	}
+}
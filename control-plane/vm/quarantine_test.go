@@ -0,0 +1,88 @@
+package vm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCrashedVMQuarantined(t *testing.T) {
+	t.Setenv(EnvVMQuarantineSeconds, "60")
+	t.Setenv(EnvVMQuarantineMax, "1")
+	m := newTestVMManager(t)
+	newTestAgent(t, m, 10)
+	pool := resizePool(m, 2, 2)
+
+	crashed, _, err := m.GetVMForFunction("", 0, PrioritySync)
+	if err != nil {
+		t.Fatalf("GetVMForFunction: %v", err)
+	}
+	if !m.QuarantineVM(crashed.ID, "crash") {
+		t.Fatal("crashed VM wasn't quarantined")
+	}
+
+	// Returning the VM leaves it out of the pool, and it isn't terminated
+	if err := m.ReturnVM(crashed.ID); err != nil {
+		t.Fatalf("ReturnVM: %v", err)
+	}
+	if len(pool.vms) != 0 {
+		t.Error("quarantined VM was returned to the pool")
+	}
+	if _, ok := m.vms[crashed.ID]; !ok {
+		t.Error("quarantined VM was terminated")
+	}
+	if stored, err := m.stateManager.GetVM(crashed.ID); err != nil || stored.Status != StatusQuarantined {
+		t.Errorf("stored VM = %+v, %v; want status %q", stored, err, StatusQuarantined)
+	}
+
+	// With the cap reached, the next crashed VM is recycled as before
+	next, _, err := m.GetVMForFunction("", 0, PrioritySync)
+	if err != nil {
+		t.Fatalf("GetVMForFunction: %v", err)
+	}
+	if next.ID == crashed.ID {
+		t.Fatal("quarantined VM was allocated again")
+	}
+	if m.QuarantineVM(next.ID, "crash") {
+		t.Error("quarantined a VM beyond the cap of 1")
+	}
+
+	// The VM is terminated once its window passes, freeing its slot
+	m.reapExpiredQuarantine(time.Now().Add(30 * time.Second))
+	if _, ok := m.vms[crashed.ID]; !ok {
+		t.Fatal("quarantined VM was terminated before its window ended")
+	}
+	m.reapExpiredQuarantine(time.Now().Add(2 * time.Minute))
+	if _, ok := m.vms[crashed.ID]; ok {
+		t.Error("quarantined VM wasn't terminated after its window")
+	}
+	if !m.QuarantineVM(next.ID, "crash") {
+		t.Error("reaping didn't free a quarantine slot")
+	}
+}
+
+func TestQuarantineOffByDefault(t *testing.T) {
+	t.Setenv(EnvVMQuarantineSeconds, "")
+	m := newTestVMManager(t)
+	newTestAgent(t, m, 10)
+
+	vm, _, err := m.GetVMForFunction("", 0, PrioritySync)
+	if err != nil {
+		t.Fatalf("GetVMForFunction: %v", err)
+	}
+	if m.QuarantineVM(vm.ID, "crash") {
+		t.Error("quarantined a VM with quarantine off")
+	}
+}
+
+func TestTestHostVMNeverQuarantined(t *testing.T) {
+	t.Setenv(EnvVMQuarantineSeconds, "60")
+	m := newTestVMManager(t)
+
+	vm, err := m.CreateTestHostVM()
+	if err != nil {
+		t.Fatalf("CreateTestHostVM: %v", err)
+	}
+	if m.QuarantineVM(vm.ID, "crash") {
+		t.Error("quarantined the simulated test host VM")
+	}
+}
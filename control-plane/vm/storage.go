@@ -0,0 +1,67 @@
+package vm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// reconcileStorage periodically removes orphaned VM storage directories
+// until Cleanup is called
+func (m *VMManager) reconcileStorage() {
+	ticker := time.NewTicker(storageSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.sweepOrphanedStorage()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// sweepOrphanedStorage removes directories under the VM storage directory
+// that belong to neither a known VM nor one that is still booting. They are
+// left behind when the control plane crashes or a boot fails part way.
+func (m *VMManager) sweepOrphanedStorage() {
+	entries, err := os.ReadDir(m.vmDir)
+	if err != nil {
+		m.logger.Errorf("Failed to read VM storage directory: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		id := entry.Name()
+
+		m.mu.Lock()
+		_, running := m.vms[id]
+		booting := m.booting[id]
+		m.mu.Unlock()
+		if running || booting {
+			continue
+		}
+
+		_, err := m.stateManager.GetVM(id)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			m.logger.Errorf("Failed to look up VM %s for storage cleanup: %v", id, err)
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(m.vmDir, id)); err != nil {
+			m.logger.Errorf("Failed to remove orphaned storage for VM %s: %v", id, err)
+			continue
+		}
+		m.logger.Infof("Removed orphaned storage for VM %s", id)
+	}
+}
@@ -0,0 +1,113 @@
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+// storageDirs creates a storage directory for each VM ID
+func storageDirs(t *testing.T, m *VMManager, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if err := os.MkdirAll(filepath.Join(m.vmDir, id), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// hasStorage reports whether a VM's storage directory exists
+func hasStorage(m *VMManager, id string) bool {
+	_, err := os.Stat(filepath.Join(m.vmDir, id))
+	return err == nil
+}
+
+func TestSweepOrphanedStorage(t *testing.T) {
+	m := newTestVMManager(t)
+	if err := m.stateManager.SaveVM(&state.VM{ID: "vm-stored", Status: "ready"}); err != nil {
+		t.Fatal(err)
+	}
+	m.vms["vm-running"] = &VMInstance{ID: "vm-running"}
+	m.booting["vm-booting"] = true
+	storageDirs(t, m, "vm-orphan", "vm-stored", "vm-running", "vm-booting")
+
+	m.sweepOrphanedStorage()
+	if hasStorage(m, "vm-orphan") {
+		t.Error("orphaned storage wasn't removed")
+	}
+	for _, id := range []string{"vm-stored", "vm-running", "vm-booting"} {
+		if !hasStorage(m, id) {
+			t.Errorf("storage of %s was removed", id)
+		}
+	}
+}
+
+func TestStartupSweepsOrphanedStorage(t *testing.T) {
+	m := newTestVMManager(t)
+	storageDirs(t, m, "vm-orphan")
+
+	// A control plane restarting over the same storage cleans it up
+	restarted, err := newVMManager(m.stateManager, m.logger, true)
+	if err != nil {
+		t.Fatalf("failed to create VM manager: %v", err)
+	}
+	defer restarted.Cleanup()
+	if hasStorage(m, "vm-orphan") {
+		t.Error("orphaned storage wasn't removed at startup")
+	}
+}
+
+func TestVMStorageDirConfigured(t *testing.T) {
+	storage := filepath.Join(t.TempDir(), "vms")
+	t.Setenv(EnvVMStorageDir, storage)
+	t.Setenv(EnvVMIDScheme, VMIDSequential)
+	m := newTestVMManager(t)
+	if m.vmDir != storage {
+		t.Fatalf("VM storage in %s, want %s", m.vmDir, storage)
+	}
+	if info, err := os.Stat(storage); err != nil || !info.IsDir() {
+		t.Fatalf("storage directory wasn't created: %v", err)
+	}
+
+	// Firecracker isn't available, so the boot fails once the VM's directory
+	// and console log are created
+	m.firecrackerBin = filepath.Join(t.TempDir(), "firecracker")
+	if _, err := m.bootVM(false, "", 0); err == nil {
+		t.Fatal("booted a VM without Firecracker")
+	}
+	if _, err := os.Stat(filepath.Join(storage, "vm-0001", consoleFile)); err != nil {
+		t.Errorf("VM directory isn't under the configured storage: %v", err)
+	}
+	config := firecrackerConfig("vm-0001", filepath.Join(m.vmDir, "vm-0001"), m.vmConfig(m.poolFor(""), 0))
+	if filepath.Dir(config.SocketPath) != filepath.Join(storage, "vm-0001") {
+		t.Errorf("socket at %s, want it under %s", config.SocketPath, storage)
+	}
+}
+
+func TestVMStorageDirDefault(t *testing.T) {
+	m := newTestVMManager(t)
+	if want := filepath.Join(DataDir(), "vm-storage"); m.vmDir != want {
+		t.Errorf("VM storage in %s, want %s under the data directory", m.vmDir, want)
+	}
+}
+
+func TestPrepareStorageDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	if err := PrepareStorageDir(dir); err != nil {
+		t.Fatalf("PrepareStorageDir: %v", err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("storage directory has %d entries after the check, want none: %v", len(entries), err)
+	}
+
+	// A path through a file can't be created
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := PrepareStorageDir(filepath.Join(file, "storage")); err == nil {
+		t.Error("PrepareStorageDir accepted a path through a file")
+	}
+}
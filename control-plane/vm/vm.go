@@ -9,13 +9,19 @@ package vm
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/bluequbit/faas/control-plane/daemonclient"
 	"github.com/bluequbit/faas/control-plane/state"
 	firecracker "github.com/firecracker-microvm/firecracker-go-sdk"
 	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
@@ -25,120 +31,617 @@ import (
 
 // VMManager manages the lifecycle of Firecracker micro-VMs
 type VMManager struct {
-	stateManager *state.StateManager
-	logger       *logrus.Logger
-	vmDir        string
-	warmPoolSize int
-	warmPool     chan *state.VM
-	mu           sync.Mutex
-	vms          map[string]*VMInstance
+	stateManager      *state.StateManager
+	logger            *logrus.Logger
+	vmDir             string
+	pools             map[string]*warmPool // Warm pools keyed by the runtime with its own image, "" for the default image
+	poolStrategy      string               // Warm pool replenishment strategy, WarmPoolLazy or WarmPoolEager
+	idScheme          string               // VM ID scheme, VMIDUUID or VMIDSequential
+	idPrefix          string               // Prefix of sequential VM IDs
+	refill            chan struct{}        // Signals the pool manager that a warm VM was taken
+	poolMu            sync.Mutex           // Serializes warm pool refills so they don't overshoot
+	mu                sync.Mutex
+	vms               map[string]*VMInstance
+	kernelArgs        string
+	resultSecret      string          // Result secret handed to each VM's daemon through its MMDS
+	firecrackerBin    string          // Firecracker binary VMs are launched with
+	maxVMs            int             // Cap on VMs on this host, including those being created
+	creating          map[string]int  // Number of VMs currently being created, by host ID
+	booting           map[string]bool // IDs of VMs booting on this host, whose storage must be kept
+	syncReserve       float64         // Share of VM capacity async executions may not use
+	asyncHeld         int             // VMs held or being allocated for async executions, guarded by mu
+	asyncVMs          map[string]bool // IDs of VMs held for async executions, guarded by mu
+	syncWaiting       int             // Sync invokes waiting for a VM at capacity, guarded by mu
+	allocationChanged chan struct{}   // Closed and replaced when the above change, guarded by mu
+	daemon            *daemonclient.Client
+	agentClient       *http.Client    // Client for host agent APIs
+	warmup            func(*state.VM) // Prepares new warm VMs before they join the pool, guarded by mu
+	stop              chan struct{}   // Closed by Cleanup to stop the background loops and VM creation, guarded by mu
+	creations         sync.WaitGroup  // VMs being created, which Cleanup waits for
+
+	// Quarantined VMs are kept, neither reused nor terminated, until the
+	// time they map to, so the failure that put them there can be inspected
+	quarantineWindow time.Duration
+	quarantineMax    int
+	quarantined      map[string]time.Time // Guarded by mu
 }
 
+// ErrDaemonUnreachable is returned when a VM's daemon can't be reached or
+// returns an unusable response
+var ErrDaemonUnreachable = errors.New("daemon unreachable")
+
+// ErrNoRunningVMs is returned when there is no running VM whose daemon can
+// be probed
+var ErrNoRunningVMs = errors.New("no running VMs")
+
+// ErrCapacityExceeded is returned when no VM can be allocated because the
+// manager already has the maximum number of VMs
+var ErrCapacityExceeded = errors.New("VM capacity exceeded")
+
+// ErrManagerStopped is returned when a VM is to be created after Cleanup
+// began
+var ErrManagerStopped = errors.New("VM manager is shutting down")
+
 // VMInstance represents a running Firecracker VM instance
 type VMInstance struct {
-	ID        string
-	IP        string
-	Machine   *firecracker.Machine
-	Status    string
-	CreatedAt time.Time
-	LastUsed  time.Time
-	Memory    int
-	CPU       int
-	IsWarm    bool
+	ID           string
+	IP           string
+	Machine      *firecracker.Machine
+	Status       string
+	CreatedAt    time.Time
+	LastUsed     time.Time
+	Memory       int
+	CPU          int
+	IsWarm       bool
+	BootDuration time.Duration
+	HostID       string // Host agent running the VM; empty for this host
+	HostAddress  string
+}
+
+// warmPool holds the warm VMs booted from one rootfs image. Runtimes with an
+// image of their own in FAAS_VM_RUNTIME_ROOTFS get a pool each; all others
+// share the pool of the default image.
+type warmPool struct {
+	runtime      string // Runtime the image is for; empty for the default image
+	rootFS       string
+	dataDrive    string // Read-only data drive image; empty for none
+	size         int    // Number of warm VMs the pool is kept at
+	lowWatermark int    // Pool size below which the eager strategy refills
+	vms          chan *state.VM
+}
+
+// name returns the pool's name in configuration and logs
+func (p *warmPool) name() string {
+	if p.runtime == "" {
+		return defaultPoolName
+	}
+	return p.runtime
+}
+
+// newWarmPools creates the default pool and one pool per runtime image
+func newWarmPools() (map[string]*warmPool, error) {
+	images, err := getRuntimeRootFS()
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := getWarmPoolSizes(images)
+	if err != nil {
+		return nil, err
+	}
+	drives, err := getDataDrives(images)
+	if err != nil {
+		return nil, err
+	}
+
+	images[""] = getDefaultRootFSPath()
+	pools := make(map[string]*warmPool, len(images))
+	for runtime, rootFS := range images {
+		pool := &warmPool{runtime: runtime, rootFS: rootFS, size: defaultWarmPoolSize}
+		pool.dataDrive = drives[pool.name()]
+		if size, ok := sizes[pool.name()]; ok {
+			pool.size = size
+		}
+		pool.lowWatermark = getWarmPoolLowWatermark(pool.size)
+		pool.vms = make(chan *state.VM, pool.size)
+		pools[runtime] = pool
+	}
+	return pools, nil
 }
 
 // VMConfig represents the configuration for a VM
 type VMConfig struct {
-	Memory int
-	CPU    int
-	Kernel string
-	RootFS string
+	Memory     int
+	CPU        int
+	Kernel     string
+	KernelArgs string
+	RootFS     string
+	DataDrive  string
+}
+
+// NewVMManager creates a new VM manager. In test mode the host preflight
+// checks are skipped since no Firecracker VMs are booted.
+func NewVMManager(stateManager *state.StateManager, logger *logrus.Logger, testMode bool) (*VMManager, error) {
+	manager, err := newVMManager(stateManager, logger, testMode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start warm pool manager
+	go manager.manageWarmPool()
+	if manager.quarantineWindow > 0 {
+		go manager.reapQuarantined()
+	}
+
+	return manager, nil
+}
+
+// NewAgentVMManager creates a VM manager for a host agent. It only boots VMs
+// on request; the warm pool is kept by the control plane.
+func NewAgentVMManager(stateManager *state.StateManager, logger *logrus.Logger) (*VMManager, error) {
+	return newVMManager(stateManager, logger, false)
 }
 
-// NewVMManager creates a new VM manager
-func NewVMManager(stateManager *state.StateManager, logger *logrus.Logger) (*VMManager, error) {
+// newVMManager creates a VM manager without starting the warm pool
+func newVMManager(stateManager *state.StateManager, logger *logrus.Logger, testMode bool) (*VMManager, error) {
+	pools, err := newWarmPools()
+	if err != nil {
+		return nil, err
+	}
+	firecrackerBin := getFirecrackerBin()
+	if !testMode {
+		if err := preflight(firecrackerBin, getDefaultKernelPath(), pools); err != nil {
+			return nil, fmt.Errorf("preflight check failed: %v", err)
+		}
+	}
+
+	kernelArgs, err := getKernelArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	poolStrategy, err := getWarmPoolStrategy()
+	if err != nil {
+		return nil, err
+	}
+	syncReserve, err := getSyncReserve()
+	if err != nil {
+		return nil, err
+	}
+	idScheme, err := getVMIDScheme()
+	if err != nil {
+		return nil, err
+	}
+
 	// Create VM directory if it doesn't exist
-	vmDir := "vm-storage"
-	if err := os.MkdirAll(vmDir, 0755); err != nil {
+	vmDir := getVMStorageDir()
+	if err := PrepareStorageDir(vmDir); err != nil {
 		return nil, err
 	}
 
+	daemon, err := daemonclient.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure daemon client: %v", err)
+	}
+
 	manager := &VMManager{
-		stateManager: stateManager,
-		logger:       logger,
-		vmDir:        vmDir,
-		warmPoolSize: 5, // Default warm pool size
-		warmPool:     make(chan *state.VM, 5),
-		vms:          make(map[string]*VMInstance),
+		stateManager:      stateManager,
+		logger:            logger,
+		vmDir:             vmDir,
+		pools:             pools,
+		poolStrategy:      poolStrategy,
+		idScheme:          idScheme,
+		idPrefix:          getVMIDPrefix(),
+		refill:            make(chan struct{}, 1),
+		vms:               make(map[string]*VMInstance),
+		kernelArgs:        kernelArgs,
+		resultSecret:      daemonclient.ResultSecret(),
+		firecrackerBin:    firecrackerBin,
+		maxVMs:            getMaxVMs(),
+		creating:          make(map[string]int),
+		booting:           make(map[string]bool),
+		syncReserve:       syncReserve,
+		asyncVMs:          make(map[string]bool),
+		allocationChanged: make(chan struct{}),
+		daemon:            daemon,
+		agentClient:       &http.Client{Timeout: agentRequestTimeout},
+		quarantineWindow:  getQuarantineWindow(),
+		quarantineMax:     getQuarantineMax(),
+		quarantined:       make(map[string]time.Time),
+		stop:              make(chan struct{}),
 	}
 
-	// Start warm pool manager
-	go manager.manageWarmPool()
+	// Remove storage left behind by VMs from previous runs
+	manager.sweepOrphanedStorage()
+	go manager.reconcileStorage()
 
 	return manager, nil
 }
 
-// manageWarmPool maintains a pool of pre-warmed VMs
+// manageWarmPool maintains a pool of pre-warmed VMs until Cleanup is called.
+// It checks the pool on every tick and, with the eager strategy, whenever a
+// warm VM is taken.
 func (m *VMManager) manageWarmPool() {
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(warmPoolInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			m.mu.Lock()
-			currentSize := len(m.warmPool)
-			m.mu.Unlock()
-
-			if currentSize < m.warmPoolSize {
-				m.logger.Infof("Warm pool size: %d/%d, creating new warm VM", currentSize, m.warmPoolSize)
-				vm, err := m.createVM(true)
-				if err != nil {
-					m.logger.Errorf("Failed to create warm VM: %v", err)
-					continue
-				}
-
-				select {
-				case m.warmPool <- vm:
-					m.logger.Infof("Added VM %s to warm pool", vm.ID)
-				default:
-					// Pool is full, clean up the VM
-					m.logger.Warnf("Warm pool is full, cleaning up VM %s", vm.ID)
-					m.terminateVM(vm.ID)
-				}
-			} else {
-				m.logger.Infof("Warm pool size: %d/%d, no need to create new warm VM", currentSize, m.warmPoolSize)
-			}
+		case <-m.refill:
+		case <-m.stop:
+			return
 		}
+		m.replenishWarmPool()
 	}
 }
 
-// GetVM gets a VM from the warm pool or creates a new one
-func (m *VMManager) GetVM() (*state.VM, error) {
-	// Try to get a VM from the warm pool
+// replenishWarmPool adds missing VMs to each warm pool: one at a time, or
+// with the eager strategy all of them at once when the pool is below its low
+// watermark
+func (m *VMManager) replenishWarmPool() {
+	m.poolMu.Lock()
+	defer m.poolMu.Unlock()
+
+	for _, pool := range m.pools {
+		currentSize := len(pool.vms)
+		if currentSize >= pool.size {
+			m.logger.Infof("Warm pool %s size: %d/%d, no need to create new warm VM", pool.name(), currentSize, pool.size)
+			continue
+		}
+
+		missing := 1
+		if m.poolStrategy == WarmPoolEager && currentSize < pool.lowWatermark {
+			missing = pool.size - currentSize
+		}
+		m.logger.Infof("Warm pool %s size: %d/%d, creating %d new warm VM(s)", pool.name(), currentSize, pool.size, missing)
+		m.addWarmVMs(pool, missing)
+	}
+}
+
+// ReconcileWarmPool immediately boots every VM missing from the warm pools,
+// whatever the pool strategy, and returns the resulting number of warm VMs.
+// VMs that fail to boot or don't fit under the VM cap are left missing.
+func (m *VMManager) ReconcileWarmPool() int {
+	m.poolMu.Lock()
+	defer m.poolMu.Unlock()
+
+	for _, pool := range m.pools {
+		if missing := pool.size - len(pool.vms); missing > 0 {
+			m.logger.Infof("Reconciling warm pool %s: %d/%d, creating %d new warm VM(s)", pool.name(), len(pool.vms), pool.size, missing)
+			m.addWarmVMs(pool, missing)
+		}
+	}
+	return m.WarmPoolSize()
+}
+
+// WarmPoolSize returns the number of VMs waiting in the warm pools
+func (m *VMManager) WarmPoolSize() int {
+	size := 0
+	for _, pool := range m.pools {
+		size += len(pool.vms)
+	}
+	return size
+}
+
+// WarmPoolTarget returns the number of VMs the warm pools are kept at
+func (m *VMManager) WarmPoolTarget() int {
+	target := 0
+	for _, pool := range m.pools {
+		target += pool.size
+	}
+	return target
+}
+
+// WarmPoolStatus describes one warm pool
+type WarmPoolStatus struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	Target int    `json:"target"`
+}
+
+// WarmPools returns the size and target of each warm pool, sorted by name
+func (m *VMManager) WarmPools() []WarmPoolStatus {
+	pools := make([]WarmPoolStatus, 0, len(m.pools))
+	for _, pool := range m.pools {
+		pools = append(pools, WarmPoolStatus{Name: pool.name(), Size: len(pool.vms), Target: pool.size})
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+	return pools
+}
+
+// vmConfig returns the configuration of a VM booted from the pool's image
+// with the given number of vCPUs, or the default count when zero
+func (m *VMManager) vmConfig(pool *warmPool, cpu int) VMConfig {
+	if cpu <= 0 {
+		cpu = DefaultCPUCount()
+	}
+	config := VMConfig{
+		Memory:     DefaultMemoryMB(),
+		CPU:        cpu,
+		Kernel:     getDefaultKernelPath(),
+		KernelArgs: m.kernelArgs,
+		RootFS:     pool.rootFS,
+		DataDrive:  pool.dataDrive,
+	}
+	if config.DataDrive != "" {
+		config.KernelArgs += " " + dataDeviceParam + "=" + dataDevice
+	}
+	return config
+}
+
+// poolFor returns the pool serving a runtime: its own if it has an image,
+// the default pool otherwise
+func (m *VMManager) poolFor(runtime string) *warmPool {
+	if pool, ok := m.pools[runtime]; ok {
+		return pool
+	}
+	return m.pools[""]
+}
+
+// PoolRuntime returns the runtime whose image VMs for the given runtime boot
+// from, as recorded in state.VM.Runtime: the runtime itself if it has an
+// image of its own, empty for the default image
+func (m *VMManager) PoolRuntime(runtime string) string {
+	return m.poolFor(runtime).runtime
+}
+
+// addWarmVMs boots n warm VMs for a pool in parallel and waits for them to
+// join it
+func (m *VMManager) addWarmVMs(pool *warmPool, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.addWarmVM(pool)
+		}()
+	}
+	wg.Wait()
+}
+
+// SetWarmup registers fn to prepare each new warm VM, e.g. by installing
+// dependencies, before the VM is added to the pool
+func (m *VMManager) SetWarmup(fn func(vm *state.VM)) {
+	m.mu.Lock()
+	m.warmup = fn
+	m.mu.Unlock()
+}
+
+// addWarmVM boots a VM from a pool's image and adds it to the pool
+func (m *VMManager) addWarmVM(pool *warmPool) {
+	vm, err := m.createVM(true, pool.runtime, 0)
+	if errors.Is(err, ErrManagerStopped) {
+		return
+	}
+	if errors.Is(err, ErrCapacityExceeded) {
+		m.logger.Infof("VM capacity of %d reached, not creating warm VM", m.maxVMs)
+		return
+	}
+	if err != nil {
+		m.logger.Errorf("Failed to create warm VM: %v", err)
+		return
+	}
+
+	m.mu.Lock()
+	warmup := m.warmup
+	m.mu.Unlock()
+	if warmup != nil {
+		warmup(vm)
+	}
+
 	select {
-	case vm := <-m.warmPool:
-		m.logger.Infof("Using warm VM %s from pool", vm.ID)
-
-		// Update VM status
-		vm.Status = "busy"
-		vm.LastUsed = time.Now()
-		if err := m.stateManager.SaveVM(vm); err != nil {
-			m.logger.Errorf("Failed to update VM status: %v", err)
+	case pool.vms <- vm:
+		m.logger.Infof("Added VM %s to warm pool %s", vm.ID, pool.name())
+	default:
+		// Pool is full, clean up the VM
+		m.logger.Warnf("Warm pool is full, cleaning up VM %s", vm.ID)
+		m.terminateVM(vm.ID)
+	}
+}
+
+// takeWarmVM marks a VM taken from the warm pool as busy and, with the eager
+// strategy, wakes the pool manager to replace it
+func (m *VMManager) takeWarmVM(vm *state.VM) *state.VM {
+	vm.Status = "busy"
+	vm.LastUsed = time.Now()
+	if err := m.stateManager.SaveVM(vm); err != nil {
+		m.logger.Errorf("Failed to update VM status: %v", err)
+	}
+
+	if m.poolStrategy == WarmPoolEager {
+		select {
+		case m.refill <- struct{}{}:
+		default:
+			// A refill is already pending
 		}
+	}
+	return vm
+}
 
-		return vm, nil
+// GetVMForFunction gets a VM for a function of the given runtime from the
+// warm pool of the runtime's image, or boots one from that image. A runtime
+// never gets a VM booted from another runtime's image. coldStart reports
+// whether the VM was booted for this request.
+//
+// Warm VMs have DefaultCPUCount vCPUs. A function that asks for another
+// count, with cpu, always gets a VM booted for it, see allocateSizedVM; zero
+// means the default.
+//
+// Sync invokes take precedence over async executions: async executions may
+// only hold the capacity outside the share set by FAAS_VM_SYNC_RESERVE and
+// wait for a VM while a sync invoke is waiting for one.
+func (m *VMManager) GetVMForFunction(runtime string, cpu int, priority Priority) (vm *state.VM, coldStart bool, err error) {
+	if priority == PriorityAsync {
+		if err := m.admitAsync(); err != nil {
+			m.logger.Warnf("No VM capacity outside the sync reserve freed up within %v", asyncAdmissionTimeout)
+			return nil, false, err
+		}
+		defer func() {
+			if err != nil {
+				m.releaseAdmission()
+				return
+			}
+			m.holdAsync(vm.ID)
+		}()
+	}
+	if cpu > 0 && cpu != DefaultCPUCount() {
+		return m.allocateSizedVM(runtime, cpu, priority)
+	}
+	return m.allocateVM(runtime, priority)
+}
+
+// allocateVM takes a VM from the warm pool of the runtime, boots one or, at
+// capacity, waits for one to be returned
+func (m *VMManager) allocateVM(runtime string, priority Priority) (*state.VM, bool, error) {
+	pool := m.poolFor(runtime)
+
+	// Try to get a VM from the warm pool
+	select {
+	case vm := <-pool.vms:
+		m.logger.Infof("Using warm VM %s from pool %s", vm.ID, pool.name())
+		return m.takeWarmVM(vm), false, nil
 	default:
 		// No warm VM available, create a new one
-		m.logger.Info("No warm VM available, creating new VM")
-		return m.createVM(false)
+		m.logger.Infof("No warm VM available in pool %s, creating new VM", pool.name())
+		vm, err := m.createVM(false, pool.runtime, 0)
+		if !errors.Is(err, ErrCapacityExceeded) {
+			return vm, err == nil, err
+		}
+	}
+
+	// At capacity, wait for a VM to be returned to the pool
+	m.logger.Warnf("VM capacity of %d reached, waiting for a VM to be returned to pool %s", m.maxVMs, pool.name())
+	if priority == PrioritySync {
+		done := m.waitingSync()
+		defer done()
+	}
+	select {
+	case vm := <-pool.vms:
+		m.logger.Infof("Using returned VM %s from pool", vm.ID)
+		return m.takeWarmVM(vm), false, nil
+	case <-time.After(capacityWaitTimeout):
+		return nil, false, ErrCapacityExceeded
+	}
+}
+
+// allocateSizedVM boots a VM with cpu vCPUs for a function that doesn't fit
+// the warm VMs. At capacity, it waits for a VM in the runtime's pool and
+// terminates it to make room, since idle warm VMs count towards the cap.
+func (m *VMManager) allocateSizedVM(runtime string, cpu int, priority Priority) (*state.VM, bool, error) {
+	pool := m.poolFor(runtime)
+	m.logger.Infof("Creating VM with %d vCPUs from pool %s", cpu, pool.name())
+	vm, err := m.createVM(false, pool.runtime, cpu)
+	if !errors.Is(err, ErrCapacityExceeded) {
+		return vm, err == nil, err
+	}
+
+	m.logger.Warnf("VM capacity of %d reached, waiting for a VM in pool %s to make room for %d vCPUs", m.maxVMs, pool.name(), cpu)
+	if priority == PrioritySync {
+		done := m.waitingSync()
+		defer done()
 	}
+	select {
+	case idle := <-pool.vms:
+		m.logger.Infof("Terminating warm VM %s to make room for a VM with %d vCPUs", idle.ID, cpu)
+		if err := m.terminateVM(idle.ID); err != nil {
+			m.logger.Errorf("Failed to terminate VM %s: %v", idle.ID, err)
+		}
+	case <-time.After(capacityWaitTimeout):
+		return nil, false, ErrCapacityExceeded
+	}
+
+	vm, err = m.createVM(false, pool.runtime, cpu)
+	return vm, err == nil, err
 }
 
-// createVM creates a new Firecracker VM using the Go SDK
-func (m *VMManager) createVM(isWarm bool) (*state.VM, error) {
+// releaseSlot releases capacity reserved on a host by reserveHost
+func (m *VMManager) releaseSlot(hostID string) {
+	m.mu.Lock()
+	m.creating[hostID]--
+	m.mu.Unlock()
+}
+
+// Capacity returns the maximum number of VMs this host runs at once
+func (m *VMManager) Capacity() int {
+	return m.maxVMs
+}
+
+// createVM places a new VM on the least-loaded host and boots it there from
+// the image of the pool of the given runtime, with cpu vCPUs or the default
+// count when zero
+func (m *VMManager) createVM(isWarm bool, runtime string, cpu int) (*state.VM, error) {
+	if err := m.startCreation(); err != nil {
+		return nil, err
+	}
+	defer m.creations.Done()
+
+	// Bound the number of VMs on each host, including those still booting
+	host, ok := m.reserveHost()
+	if !ok {
+		return nil, ErrCapacityExceeded
+	}
+	defer m.releaseSlot(host.ID)
+
+	if host.ID != "" {
+		return m.createRemoteVM(host, isWarm, runtime, cpu)
+	}
+	return m.bootVM(isWarm, runtime, cpu)
+}
+
+// startCreation registers a VM creation with Cleanup, failing once it has
+// begun. The caller calls creations.Done when the VM is created or failed.
+func (m *VMManager) startCreation() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.stop:
+		return ErrManagerStopped
+	default:
+	}
+	m.creations.Add(1)
+	return nil
+}
+
+// newVMID returns an ID for a new VM following the configured scheme.
+// Sequential IDs come from a counter in the state database, so they stay
+// unique across restarts.
+func (m *VMManager) newVMID() (string, error) {
+	if m.idScheme != VMIDSequential {
+		return uuid.New().String(), nil
+	}
+	n, err := m.stateManager.NextCounter(vmIDCounter)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%04d", m.idPrefix, n), nil
+}
+
+// bootVM boots a new Firecracker VM on this host using the Go SDK, from the
+// image of the pool of the given runtime, with cpu vCPUs or the default
+// count when zero
+func (m *VMManager) bootVM(isWarm bool, runtime string, cpu int) (*state.VM, error) {
+	// Host agents must have the pool too, or the VM would boot another image
+	pool, ok := m.pools[runtime]
+	if !ok {
+		return nil, fmt.Errorf("no rootfs image is configured for runtime %s (set %s)", runtime, EnvVMRuntimeRootFS)
+	}
+	bootStart := time.Now()
+
 	// Generate VM ID
-	id := uuid.New().String()
+	id, err := m.newVMID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate VM ID: %v", err)
+	}
+
+	// Keep the storage sweep away from the VM until it's registered
+	m.mu.Lock()
+	m.booting[id] = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.booting, id)
+		m.mu.Unlock()
+	}()
 
 	// Create VM directory
 	vmDir := filepath.Join(m.vmDir, id)
@@ -147,59 +650,24 @@ func (m *VMManager) createVM(isWarm bool) (*state.VM, error) {
 	}
 
 	// Create VM configuration
-	config := VMConfig{
-		Memory: getDefaultMemoryMB(),
-		CPU:    getDefaultCPUCount(),
-		Kernel: getDefaultKernelPath(),
-		RootFS: getDefaultRootFSPath(),
-	}
+	config := m.vmConfig(pool, cpu)
 
 	// Create context for VM operations
 	ctx := context.Background()
 
-	// Socket path for Firecracker
-	socketPath := filepath.Join(vmDir, "firecracker.sock")
-
 	// Create Firecracker machine configuration
-	fcCfg := firecracker.Config{
-		SocketPath:      socketPath,
-		KernelImagePath: config.Kernel,
-		KernelArgs:      "console=ttyS0 reboot=k panic=1 pci=off",
-		Drives: []models.Drive{
-			{
-				DriveID:      firecracker.String("1"),
-				PathOnHost:   firecracker.String(config.RootFS),
-				IsRootDevice: firecracker.Bool(true),
-				IsReadOnly:   firecracker.Bool(false),
-			},
-		},
-		MachineCfg: models.MachineConfiguration{
-			VcpuCount:  firecracker.Int64(int64(config.CPU)),
-			MemSizeMib: firecracker.Int64(int64(config.Memory)),
-		},
-		NetworkInterfaces: firecracker.NetworkInterfaces{
-			firecracker.NetworkInterface{
-				// finds the CNI configuration in /etc/cni/conf.d by default
-				CNIConfiguration: &firecracker.CNIConfiguration{
-					NetworkName: "fcnet", // matches the name in your CNI config file
-					IfName:      "veth0", // changed from tap0 to veth0 for ptp plugin
-				},
-				AllowMMDS: true,
-			},
-		},
-		VMID:        id,
-		LogLevel:    "Debug",
-		LogFifo:     filepath.Join(vmDir, "firecracker.log"),
-		MetricsFifo: filepath.Join(vmDir, "firecracker.metrics"),
+	fcCfg := firecrackerConfig(id, vmDir, config)
+
+	// Capture Firecracker's output, including the serial console, per VM.
+	// The process keeps its own descriptor, so ours is closed once it started.
+	console, err := os.OpenFile(filepath.Join(vmDir, consoleFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create console log: %v", err)
 	}
+	defer console.Close()
 
 	// Create command for Firecracker
-	cmd := firecracker.VMCommandBuilder{}.
-		WithBin("/usr/local/bin/firecracker").
-		WithSocketPath(socketPath).
-		WithStdout(os.Stdout).
-		WithStderr(os.Stderr).
-		Build(ctx)
+	cmd := m.firecrackerCommand(ctx, fcCfg.SocketPath, console)
 
 	// Create machine options
 	machineOpts := []firecracker.Opt{
@@ -212,6 +680,8 @@ func (m *VMManager) createVM(isWarm bool) (*state.VM, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create machine: %v", err)
 	}
+	withIdentity(machine, id)
+	withResultSecret(machine, m.resultSecret)
 
 	// Start the machine
 	if err := machine.Start(ctx); err != nil {
@@ -223,6 +693,21 @@ func (m *VMManager) createVM(isWarm bool) (*state.VM, error) {
 
 	m.logger.WithField("ip", ipAddress).Info("machine started")
 
+	// Wait for the daemon inside the VM to become ready
+	if err := m.waitForDaemon(ipAddress, bootTimeout); err != nil {
+		machine.StopVMM()
+		return nil, fmt.Errorf("VM %s did not become ready: %v", id, err)
+	}
+
+	// The daemon has read the result secret, so remove it before any
+	// function runs
+	if err := clearResultSecret(ctx, machine, m.resultSecret); err != nil {
+		machine.StopVMM()
+		return nil, fmt.Errorf("failed to clear the metadata of VM %s: %v", id, err)
+	}
+
+	bootDuration := m.recordBoot(id, bootStart)
+
 	// Create VM instance
 	vmInstance := &VMInstance{
 		ID:      id,
@@ -234,11 +719,12 @@ func (m *VMManager) createVM(isWarm bool) (*state.VM, error) {
 			}
 			return "busy"
 		}(),
-		CreatedAt: time.Now(),
-		LastUsed:  time.Now(),
-		Memory:    config.Memory,
-		CPU:       config.CPU,
-		IsWarm:    isWarm,
+		CreatedAt:    time.Now(),
+		LastUsed:     time.Now(),
+		Memory:       config.Memory,
+		CPU:          config.CPU,
+		IsWarm:       isWarm,
+		BootDuration: bootDuration,
 	}
 
 	// Store VM instance
@@ -248,14 +734,16 @@ func (m *VMManager) createVM(isWarm bool) (*state.VM, error) {
 
 	// Create VM in state manager
 	vm := &state.VM{
-		ID:        id,
-		Status:    vmInstance.Status,
-		IP:        vmInstance.IP,
-		CreatedAt: vmInstance.CreatedAt,
-		LastUsed:  vmInstance.LastUsed,
-		Memory:    config.Memory,
-		CPU:       config.CPU,
-		IsWarm:    isWarm,
+		ID:           id,
+		Status:       vmInstance.Status,
+		IP:           vmInstance.IP,
+		CreatedAt:    vmInstance.CreatedAt,
+		LastUsed:     vmInstance.LastUsed,
+		Memory:       config.Memory,
+		CPU:          config.CPU,
+		IsWarm:       isWarm,
+		BootDuration: bootDuration.Milliseconds(),
+		Runtime:      runtime,
 	}
 
 	if err := m.stateManager.SaveVM(vm); err != nil {
@@ -265,14 +753,104 @@ func (m *VMManager) createVM(isWarm bool) (*state.VM, error) {
 	return vm, nil
 }
 
+// firecrackerCommand returns the command launching the configured
+// Firecracker binary with its API on socketPath and its output sent to out
+func (m *VMManager) firecrackerCommand(ctx context.Context, socketPath string, out io.Writer) *exec.Cmd {
+	return firecracker.VMCommandBuilder{}.
+		WithBin(m.firecrackerBin).
+		WithSocketPath(socketPath).
+		WithStdout(out).
+		WithStderr(out).
+		Build(ctx)
+}
+
+// firecrackerConfig returns the Firecracker machine configuration of the VM
+// with the given ID, keeping its socket and logs in vmDir
+func firecrackerConfig(id, vmDir string, config VMConfig) firecracker.Config {
+	return firecracker.Config{
+		SocketPath:      filepath.Join(vmDir, "firecracker.sock"),
+		KernelImagePath: config.Kernel,
+		KernelArgs:      config.KernelArgs,
+		Drives:          vmDrives(config),
+		MachineCfg: models.MachineConfiguration{
+			VcpuCount:  firecracker.Int64(int64(config.CPU)),
+			MemSizeMib: firecracker.Int64(int64(config.Memory)),
+		},
+		NetworkInterfaces: firecracker.NetworkInterfaces{
+			firecracker.NetworkInterface{
+				// finds the CNI configuration in /etc/cni/conf.d by default
+				CNIConfiguration: &firecracker.CNIConfiguration{
+					NetworkName: "fcnet", // matches the name in your CNI config file
+					IfName:      "veth0", // changed from tap0 to veth0 for ptp plugin
+				},
+				AllowMMDS: true,
+			},
+		},
+		VMID:        id,
+		LogLevel:    "Debug",
+		LogFifo:     filepath.Join(vmDir, "firecracker.log"),
+		MetricsFifo: filepath.Join(vmDir, "firecracker.metrics"),
+	}
+}
+
+// recordBoot records the boot time of a VM whose creation started at start
+// and warns if it was slow
+func (m *VMManager) recordBoot(id string, start time.Time) time.Duration {
+	bootDuration := time.Since(start)
+	vmBootSeconds.Observe(bootDuration.Seconds())
+	if threshold := getSlowBootThreshold(); bootDuration > threshold {
+		m.logger.Warnf("VM %s took %v to boot (threshold %v), the host may be overloaded", id, bootDuration, threshold)
+	}
+	return bootDuration
+}
+
+// waitForDaemon polls the daemon health endpoint at ip until it responds
+// with 200 OK or the timeout elapses
+func (m *VMManager) waitForDaemon(ip string, timeout time.Duration) error {
+	url := m.daemon.URL(ip, "/health")
+	deadline := time.Now().Add(timeout)
+
+	for {
+		resp, err := m.daemon.Get(url, time.Second)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("health check timed out after %v: %v", timeout, err)
+			}
+			return fmt.Errorf("health check timed out after %v: status %d", timeout, resp.StatusCode)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 // ReturnVM returns a VM to the warm pool
 func (m *VMManager) ReturnVM(id string) error {
+	// Quarantined VMs stay out of the pool until they are reaped
+	if m.isQuarantined(id) {
+		m.releaseAsync(id)
+		return nil
+	}
+
 	// Get VM from state manager
 	vm, err := m.stateManager.GetVM(id)
 	if err != nil {
 		return err
 	}
 
+	// VMs booted sized for a function would hand their vCPUs to any
+	// function taking them from the pool
+	if !vm.IsWarm && vm.CPU > 0 && vm.CPU != DefaultCPUCount() {
+		m.releaseAsync(id)
+		m.logger.Infof("Terminating VM %s, which has %d vCPUs rather than the warm pool's %d", id, vm.CPU, DefaultCPUCount())
+		return m.terminateVM(id)
+	}
+
 	// Update VM status
 	vm.Status = "ready"
 	vm.LastUsed = time.Now()
@@ -281,10 +859,12 @@ func (m *VMManager) ReturnVM(id string) error {
 		return err
 	}
 
-	// Add VM to warm pool
+	// Add VM to the warm pool of its image
+	m.releaseAsync(id)
+	pool := m.poolFor(vm.Runtime)
 	select {
-	case m.warmPool <- vm:
-		m.logger.Infof("Returned VM %s to warm pool", id)
+	case pool.vms <- vm:
+		m.logger.Infof("Returned VM %s to warm pool %s", id, pool.name())
 	default:
 		// Pool is full, terminate the VM
 		m.logger.Warnf("Warm pool is full, terminating VM %s", id)
@@ -304,15 +884,22 @@ func (m *VMManager) terminateVM(id string) error {
 		return errors.New("VM not found")
 	}
 
-	// Stop the VM
-	if err := vmInstance.Machine.StopVMM(); err != nil {
-		m.logger.Errorf("Failed to stop VM: %v", err)
-	}
+	if vmInstance.HostID != "" {
+		// Stop the VM on the host agent running it
+		if err := m.terminateRemoteVM(vmInstance); err != nil {
+			m.logger.Errorf("Failed to stop VM: %v", err)
+		}
+	} else {
+		// Stop the VM
+		if err := vmInstance.Machine.StopVMM(); err != nil {
+			m.logger.Errorf("Failed to stop VM: %v", err)
+		}
 
-	// Remove VM directory
-	vmDir := filepath.Join(m.vmDir, id)
-	if err := os.RemoveAll(vmDir); err != nil {
-		m.logger.Errorf("Failed to remove VM directory: %v", err)
+		// Remove VM directory
+		vmDir := filepath.Join(m.vmDir, id)
+		if err := os.RemoveAll(vmDir); err != nil {
+			m.logger.Errorf("Failed to remove VM directory: %v", err)
+		}
 	}
 
 	// Remove VM from state manager
@@ -323,7 +910,9 @@ func (m *VMManager) terminateVM(id string) error {
 	// Remove VM from map
 	m.mu.Lock()
 	delete(m.vms, id)
+	delete(m.quarantined, id)
 	m.mu.Unlock()
+	m.releaseAsync(id)
 
 	m.logger.Infof("Terminated VM %s", id)
 	return nil
@@ -336,12 +925,37 @@ func (m *VMManager) assignIP() (string, error) {
 	return "172.16.0.2", nil
 }
 
-// Cleanup cleans up all VMs
+// Cleanup stops the warm pool manager and the background sweeps, refuses
+// to create further VMs and terminates all VMs, including those that were
+// still booting when it was called. Simulated test VMs are dropped without
+// being stopped.
 func (m *VMManager) Cleanup() {
+	m.mu.Lock()
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+	m.mu.Unlock()
+
+	// VMs already booting would otherwise be registered after the loop
+	// below and left running
+	m.creations.Wait()
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	for id, vmInstance := range m.vms {
+		if vmInstance.Machine == nil && vmInstance.HostID == "" {
+			continue
+		}
+		if vmInstance.HostID != "" {
+			if err := m.terminateRemoteVM(vmInstance); err != nil {
+				m.logger.Errorf("Failed to stop VM: %v", err)
+			}
+			m.logger.Infof("Terminated VM %s during cleanup", id)
+			continue
+		}
 		if err := vmInstance.Machine.StopVMM(); err != nil {
 			m.logger.Errorf("Failed to stop VM: %v", err)
 		}
@@ -351,6 +965,44 @@ func (m *VMManager) Cleanup() {
 	m.vms = make(map[string]*VMInstance)
 }
 
+// Purge terminates every VM, empties the warm pool and removes all VM
+// storage, returning the number of VMs terminated. Simulated test VMs are
+// dropped without being stopped.
+func (m *VMManager) Purge() (int, error) {
+	// Empty the pools first so no VM is handed out while it is terminated
+	for _, pool := range m.pools {
+		for drained := false; !drained; {
+			select {
+			case <-pool.vms:
+			default:
+				drained = true
+			}
+		}
+	}
+
+	m.mu.Lock()
+	ids := make([]string, 0, len(m.vms))
+	for id, vmInstance := range m.vms {
+		if vmInstance.Machine == nil && vmInstance.HostID == "" {
+			delete(m.vms, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		if err := m.terminateVM(id); err != nil {
+			m.logger.Errorf("Failed to terminate VM %s: %v", id, err)
+		}
+	}
+
+	if err := os.RemoveAll(m.vmDir); err != nil {
+		return len(ids), fmt.Errorf("failed to remove VM storage: %v", err)
+	}
+	return len(ids), os.MkdirAll(m.vmDir, 0755)
+}
+
 // GetVMStatus gets the status of a VM
 func (m *VMManager) GetVMStatus(id string) (string, error) {
 	vm, err := m.stateManager.GetVM(id)
@@ -370,9 +1022,67 @@ func (m *VMManager) GetVMByID(id string) (*state.VM, error) {
 	return m.stateManager.GetVM(id)
 }
 
+// GetDaemonInfo fetches the version, supported runtimes and limits reported
+// by the daemon running in vm
+func (m *VMManager) GetDaemonInfo(vm *state.VM) (json.RawMessage, error) {
+	resp, err := m.daemon.Get(m.daemon.URL(vm.IP, "/info"), daemonInfoTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDaemonUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrDaemonUnreachable, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDaemonUnreachable, err)
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("%w: invalid response", ErrDaemonUnreachable)
+	}
+	return body, nil
+}
+
+// ProbeDaemon checks the health endpoint of the daemon in one running VM,
+// preferring a warm one, and returns the VM it probed. In test mode that is
+// the simulated host VM. Only one VM is probed so a stale VM record delays
+// the caller by at most one probe timeout.
+func (m *VMManager) ProbeDaemon() (*state.VM, error) {
+	vms, err := m.stateManager.ListVMs()
+	if err != nil {
+		return nil, err
+	}
+
+	var sample *state.VM
+	for i := range vms {
+		if vms[i].Status != "ready" && vms[i].Status != "busy" {
+			continue
+		}
+		if sample == nil || (vms[i].IsWarm && !sample.IsWarm) {
+			sample = &vms[i]
+		}
+	}
+	if sample == nil {
+		return nil, ErrNoRunningVMs
+	}
+
+	resp, err := m.daemon.Get(m.daemon.URL(sample.IP, "/health"), daemonProbeTimeout)
+	if err != nil {
+		return sample, fmt.Errorf("%w: %v", ErrDaemonUnreachable, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return sample, fmt.Errorf("%w: status %d", ErrDaemonUnreachable, resp.StatusCode)
+	}
+	return sample, nil
+}
+
 // CreateTestHostVM creates a test VM that represents the host machine for testing
 func (m *VMManager) CreateTestHostVM() (*state.VM, error) {
 	m.logger.Info("Creating test host VM for testing")
+	bootStart := time.Now()
 
 	// Generate VM ID
 	id := "host-vm-test"
@@ -380,16 +1090,20 @@ func (m *VMManager) CreateTestHostVM() (*state.VM, error) {
 	// Use the host machine's IP (localhost)
 	ip := "127.0.0.1"
 
+	// The host's daemon is already running, so the VM is up right away
+	bootDuration := m.recordBoot(id, bootStart)
+
 	// Create VM in state manager
 	vm := &state.VM{
-		ID:        id,
-		Status:    "ready",
-		IP:        ip,
-		CreatedAt: time.Now(),
-		LastUsed:  time.Now(),
-		Memory:    1024, // 1GB
-		CPU:       2,    // 2 cores
-		IsWarm:    true,
+		ID:           id,
+		Status:       "ready",
+		IP:           ip,
+		CreatedAt:    time.Now(),
+		LastUsed:     time.Now(),
+		Memory:       1024, // 1GB
+		CPU:          2,    // 2 cores
+		IsWarm:       true,
+		BootDuration: bootDuration.Milliseconds(),
 	}
 
 	if err := m.stateManager.SaveVM(vm); err != nil {
@@ -398,15 +1112,16 @@ func (m *VMManager) CreateTestHostVM() (*state.VM, error) {
 
 	// Create VM instance (without actual Firecracker machine)
 	vmInstance := &VMInstance{
-		ID:        id,
-		IP:        ip,
-		Machine:   nil, // No actual Firecracker machine
-		Status:    "ready",
-		CreatedAt: vm.CreatedAt,
-		LastUsed:  vm.LastUsed,
-		Memory:    vm.Memory,
-		CPU:       vm.CPU,
-		IsWarm:    true,
+		ID:           id,
+		IP:           ip,
+		Machine:      nil, // No actual Firecracker machine
+		Status:       "ready",
+		CreatedAt:    vm.CreatedAt,
+		LastUsed:     vm.LastUsed,
+		Memory:       vm.Memory,
+		CPU:          vm.CPU,
+		IsWarm:       true,
+		BootDuration: bootDuration,
 	}
 
 	// Store VM instance
@@ -0,0 +1,322 @@
+package vm
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluequbit/faas/control-plane/state"
+)
+
+func TestReserveHostCapHoldsConcurrently(t *testing.T) {
+	m := newTestVMManager(t)
+	m.maxVMs = 5
+	m.vms["running-1"] = &VMInstance{ID: "running-1"}
+	m.vms["running-2"] = &VMInstance{ID: "running-2"}
+
+	// Creations beyond the room left by the running VMs are refused, however
+	// many start at once
+	var reserved atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, ok := m.reserveHost(); ok {
+				reserved.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := reserved.Load(); got != 3 {
+		t.Fatalf("reserved %d creations, want the 3 the cap leaves room for", got)
+	}
+	if m.creating[""] != 3 {
+		t.Errorf("%d creations are in flight, want 3", m.creating[""])
+	}
+
+	// Finished creations free their slots
+	for i := 0; i < 3; i++ {
+		m.releaseSlot("")
+	}
+	if _, ok := m.reserveHost(); !ok {
+		t.Error("no slot after the creations finished")
+	}
+}
+
+func TestKernelArgsReachFirecrackerConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string // Unset when empty
+		want string
+	}{
+		{"default", "", defaultKernelArgs},
+		{"configured", "console=ttyS0 reboot=k panic=1 pci=off init=/sbin/init ip=dhcp", "console=ttyS0 reboot=k panic=1 pci=off init=/sbin/init ip=dhcp"},
+		{"surrounding space trimmed", "  console=ttyS0 root=/dev/vda  ", "console=ttyS0 root=/dev/vda"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv(EnvVMKernelArgs, tt.env)
+			}
+			m := newTestVMManager(t)
+			config := firecrackerConfig("vm-1", t.TempDir(), m.vmConfig(m.poolFor(""), 0))
+			if config.KernelArgs != tt.want {
+				t.Errorf("kernel args = %q, want %q", config.KernelArgs, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlankKernelArgsRejected(t *testing.T) {
+	t.Setenv(EnvVMKernelArgs, "   ")
+	if _, err := getKernelArgs(); err == nil {
+		t.Error("getKernelArgs accepted blank kernel args")
+	}
+}
+
+// resizePool sets the size and low watermark of the default warm pool,
+// emptying it
+func resizePool(m *VMManager, size, lowWatermark int) *warmPool {
+	pool := m.pools[""]
+	pool.size = size
+	pool.lowWatermark = lowWatermark
+	pool.vms = make(chan *state.VM, size)
+	return pool
+}
+
+// drainPool takes every VM from a warm pool as an invoke would
+func drainPool(m *VMManager, pool *warmPool) {
+	for len(pool.vms) > 0 {
+		m.takeWarmVM(<-pool.vms)
+	}
+}
+
+func TestEagerPoolRefillsWithinOneInterval(t *testing.T) {
+	m := newTestVMManager(t)
+	newTestAgent(t, m, 10)
+	m.poolStrategy = WarmPoolEager
+	pool := resizePool(m, 3, 3)
+	m.replenishWarmPool()
+	if len(pool.vms) != 3 {
+		t.Fatalf("pool has %d VMs after filling, want 3", len(pool.vms))
+	}
+
+	go m.manageWarmPool()
+	drainPool(m, pool)
+
+	// Taking VMs wakes the pool manager well before its next tick
+	deadline := time.Now().Add(warmPoolInterval / 2)
+	for len(pool.vms) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(pool.vms) != 3 {
+		t.Errorf("pool has %d VMs, want it refilled to 3 within one interval", len(pool.vms))
+	}
+}
+
+func TestReplenishWarmPool(t *testing.T) {
+	tests := []struct {
+		name         string
+		strategy     string
+		lowWatermark int
+		warm         int // VMs in the pool before the tick
+		want         int
+	}{
+		{"lazy adds one VM per tick", WarmPoolLazy, 4, 0, 1},
+		{"eager refills below the watermark", WarmPoolEager, 4, 0, 4},
+		{"eager at the watermark adds one VM", WarmPoolEager, 1, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestVMManager(t)
+			newTestAgent(t, m, 10)
+			m.poolStrategy = tt.strategy
+			pool := resizePool(m, 4, tt.lowWatermark)
+			m.addWarmVMs(pool, tt.warm)
+
+			m.replenishWarmPool()
+			if len(pool.vms) != tt.want {
+				t.Errorf("pool has %d VMs after a tick, want %d", len(pool.vms), tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileFillsEmptyPool(t *testing.T) {
+	m := newTestVMManager(t)
+	agent := newTestAgent(t, m, 10)
+	// The lazy strategy alone would add a single VM per pass
+	m.poolStrategy = WarmPoolLazy
+	pool := resizePool(m, 4, 4)
+
+	if size := m.ReconcileWarmPool(); size != 4 {
+		t.Errorf("ReconcileWarmPool() = %d, want 4", size)
+	}
+	if len(pool.vms) != 4 || len(agent.requests()) != 4 {
+		t.Errorf("pool has %d VMs from %d creations, want 4", len(pool.vms), len(agent.requests()))
+	}
+
+	// A full pool is left alone
+	if size := m.ReconcileWarmPool(); size != 4 || len(agent.requests()) != 4 {
+		t.Errorf("second ReconcileWarmPool() = %d after %d creations, want 4 without new VMs", size, len(agent.requests()))
+	}
+}
+
+func TestReconcileStopsAtCapacity(t *testing.T) {
+	m := newTestVMManager(t)
+	newTestAgent(t, m, 2)
+	resizePool(m, 4, 4)
+
+	if size := m.ReconcileWarmPool(); size != 2 {
+		t.Errorf("ReconcileWarmPool() = %d, want 2 with room for only 2 VMs", size)
+	}
+}
+
+func TestGetVMColdAndWarmStarts(t *testing.T) {
+	m := newTestVMManager(t)
+	newTestAgent(t, m, 10)
+	resizePool(m, 2, 2)
+
+	// With the pool empty the VM is booted for the request
+	first, cold, err := m.GetVMForFunction("", 0, PrioritySync)
+	if err != nil {
+		t.Fatalf("GetVMForFunction: %v", err)
+	}
+	if !cold {
+		t.Error("first VM from an empty pool wasn't a cold start")
+	}
+
+	// The returned VM is reused warm
+	if err := m.ReturnVM(first.ID); err != nil {
+		t.Fatalf("ReturnVM: %v", err)
+	}
+	second, cold, err := m.GetVMForFunction("", 0, PrioritySync)
+	if err != nil {
+		t.Fatalf("GetVMForFunction: %v", err)
+	}
+	if cold || second.ID != first.ID {
+		t.Errorf("got VM %s, cold start %v; want %s reused warm", second.ID, cold, first.ID)
+	}
+}
+
+func TestCreateVMCapacityExceeded(t *testing.T) {
+	m := newTestVMManager(t)
+	agent := newTestAgent(t, m, 1)
+
+	if _, err := m.createVM(false, "", 0); err != nil {
+		t.Fatalf("first createVM: %v", err)
+	}
+	if _, err := m.createVM(false, "", 0); !errors.Is(err, ErrCapacityExceeded) {
+		t.Fatalf("second createVM() error = %v, want %v", err, ErrCapacityExceeded)
+	}
+	if n := len(agent.requests()); n != 1 {
+		t.Errorf("agent received %d create requests, want 1", n)
+	}
+}
+
+func TestSequentialVMIDs(t *testing.T) {
+	t.Setenv(EnvVMIDScheme, VMIDSequential)
+	t.Setenv(EnvVMIDPrefix, "fc-")
+	m := newTestVMManager(t)
+
+	// IDs stay unique however many VMs are created at once
+	ids := make(chan string, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(ids); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := m.newVMID()
+			if err != nil {
+				t.Errorf("newVMID: %v", err)
+				return
+			}
+			ids <- id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	scheme := regexp.MustCompile(`^fc-\d{4}$`)
+	seen := make(map[string]bool)
+	for id := range ids {
+		if !scheme.MatchString(id) {
+			t.Errorf("ID %q doesn't follow the fc-NNNN scheme", id)
+		}
+		if seen[id] {
+			t.Errorf("ID %q was handed out twice", id)
+		}
+		seen[id] = true
+	}
+
+	// The counter survives a restart
+	restarted, err := newVMManager(m.stateManager, m.logger, true)
+	if err != nil {
+		t.Fatalf("failed to create VM manager: %v", err)
+	}
+	defer restarted.Cleanup()
+	if id, err := restarted.newVMID(); err != nil || id != "fc-0021" {
+		t.Errorf("newVMID() after a restart = %q, %v; want fc-0021", id, err)
+	}
+}
+
+func TestUUIDVMIDsByDefault(t *testing.T) {
+	m := newTestVMManager(t)
+	id, err := m.newVMID()
+	if err != nil {
+		t.Fatalf("newVMID: %v", err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}(-[0-9a-f]{4}){3}-[0-9a-f]{12}$`).MatchString(id) {
+		t.Errorf("ID %q isn't a UUID", id)
+	}
+}
+
+func TestInvalidVMIDScheme(t *testing.T) {
+	t.Setenv(EnvVMIDScheme, "random")
+	if _, err := getVMIDScheme(); err == nil {
+		t.Error("getVMIDScheme accepted an unknown scheme")
+	}
+}
+
+func TestSizedVMForFunction(t *testing.T) {
+	m := newTestVMManager(t)
+	agent := newTestAgent(t, m, 10)
+	pool := resizePool(m, 2, 2)
+
+	vm, cold, err := m.GetVMForFunction("", 2, PrioritySync)
+	if err != nil {
+		t.Fatalf("GetVMForFunction: %v", err)
+	}
+	if !cold || vm.CPU != 2 {
+		t.Errorf("got VM with %d vCPUs, cold start %v; want a VM booted with 2", vm.CPU, cold)
+	}
+	if requests := agent.requests(); len(requests) != 1 || requests[0].CPU != 2 {
+		t.Errorf("agent received %+v, want one VM with 2 vCPUs", requests)
+	}
+
+	// The VM isn't warm-pool sized, so it's terminated when returned
+	if err := m.ReturnVM(vm.ID); err != nil {
+		t.Fatalf("ReturnVM: %v", err)
+	}
+	if len(pool.vms) != 0 {
+		t.Error("VM with 2 vCPUs joined the warm pool")
+	}
+}
+
+func TestVMConfigCPU(t *testing.T) {
+	m := newTestVMManager(t)
+	for cpu, want := range map[int]int{0: DefaultCPUCount(), 2: 2} {
+		config := firecrackerConfig("vm-1", t.TempDir(), m.vmConfig(m.poolFor(""), cpu))
+		if got := *config.MachineCfg.VcpuCount; got != int64(want) {
+			t.Errorf("VM config for %d vCPUs has %d, want %d", cpu, got, want)
+		}
+	}
+}